@@ -31,7 +31,12 @@ func InitBuiltin() error {
 		{"admin/routes", HandleAdminRoutes},
 		{"admin/settings", HandleAdminSettings},
 		{"admin/metrics", HandleAdminMetrics},
+		{"admin/cache", HandleAdminCache},
 		{"admin/throttle", HandleAdminThrottle},
+		{"admin/connections", HandleAdminConnections},
+		{"admin/logging", HandleAdminLogging},
+		{"admin/cluster", HandleAdminCluster},
+		{"admin/backup", HandleAdminBackup},
 		{"auth/sessions", HandleUserSessions},
 		{"auth/extend", HandleExtendSession},
 	} {
@@ -17,12 +17,43 @@ import (
 var OnRouteUpdate func()
 
 // OnRouteRegister registers (or re-registers) a route in the live proxy.
-var OnRouteRegister func(url, target, routeType string, tls bool, cert, key string) error
+var OnRouteRegister func(r storage.Route) error
+
+// Listeners reports the live HTTP listeners and their effective timeouts for
+// the routes view (proxy.Proxy.GetListeners).
+var Listeners func() any
 
 // OnRouteValidate is called before persisting a new route to check for
 // conflicts with the live proxy state (port conflicts, invalid format).
 var OnRouteValidate func(url, routeType string) error
 
+// OnHeaderRulesValidate checks a route's header rewrite rules before they are
+// stored, so a typo is reported instead of breaking the route's handler.
+var OnHeaderRulesValidate func(rules string) error
+
+// OnSecurityValidate checks a route's security-header profile and overrides
+// before they are stored.
+var OnSecurityValidate func(sec storage.RouteSecurity) error
+
+// SecurityProfiles lists the security-header profiles a route may select.
+var SecurityProfiles func() []string
+
+// OnRedirectValidate checks a redirect route's target template, status code and
+// match pattern before they are stored.
+var OnRedirectValidate func(target string, rd storage.RouteRedirect) error
+
+// OnMaintenanceValidate checks a route's maintenance window and page before
+// they are stored.
+var OnMaintenanceValidate func(m storage.RouteMaintenance) error
+
+// OnErrorPagesValidate checks a route's error-page directory before it is
+// stored.
+var OnErrorPagesValidate func(dir string) error
+
+// OnRewriteValidate checks a proxy route's upstream rewrite rules before they
+// are stored.
+var OnRewriteValidate func(rules string) error
+
 // DefaultCert and DefaultKey are the fallback TLS certificate paths used when
 // creating UI routes with TLS enabled. Set from the web host config in main.go.
 var DefaultCert, DefaultKey string
@@ -165,7 +196,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
 	user, err := store.Authenticate(r.Context(), body.Username, body.Password)
 	if err != nil {
-		slog.Warn("login failed", "username", body.Username)
+		slog.WarnContext(r.Context(), "login failed", "username", body.Username)
 		store.LogAuthFailure(r.Context(), clientIP, body.Username)
 		fail(w, http.StatusUnauthorized, "invalid credentials")
 		return
@@ -202,6 +233,10 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 		fail(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
+	// New accounts would be deleted again by the file's next reload.
+	if policyLocked(w, "users") {
+		return
+	}
 	var body struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -352,6 +387,9 @@ func HandleAdminUsers(w http.ResponseWriter, r *http.Request) {
 	if requireAdmin(w, r) == nil {
 		return
 	}
+	if r.Method != http.MethodGet && policyLocked(w, "users") {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		users, err := store.GetAllUsers(r.Context())
@@ -396,6 +434,9 @@ func HandleAdminUserGroups(w http.ResponseWriter, r *http.Request) {
 	if requireAdmin(w, r) == nil {
 		return
 	}
+	if r.Method != http.MethodGet && policyLocked(w, "users") {
+		return
+	}
 	switch r.Method {
 	case http.MethodPost:
 		var body struct {
@@ -436,6 +477,9 @@ func HandleAdminGroups(w http.ResponseWriter, r *http.Request) {
 	if requireAdmin(w, r) == nil {
 		return
 	}
+	if r.Method != http.MethodGet && policyLocked(w, "groups") {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		groups, err := store.GetAllGroups(r.Context())
@@ -542,6 +586,9 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 	if requireAdmin(w, r) == nil {
 		return
 	}
+	if r.Method != http.MethodGet && policyLocked(w, "routes") {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		routes, err := store.GetAllRoutes(r.Context())
@@ -552,7 +599,15 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 		if routes == nil {
 			routes = []storage.Route{}
 		}
-		ok(w, map[string]any{"routes": routes})
+		var listeners any = []any{}
+		if Listeners != nil {
+			listeners = Listeners()
+		}
+		var profiles []string
+		if SecurityProfiles != nil {
+			profiles = SecurityProfiles()
+		}
+		ok(w, map[string]any{"routes": routes, "listeners": listeners, "security_profiles": profiles})
 
 	case http.MethodPost:
 		var body struct {
@@ -584,6 +639,19 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 			fail(w, http.StatusBadRequest, "invalid url: expected host:port")
 			return
 		}
+		// A redirect route's target is a URL template, not a backend address.
+		if body.Type == "redirect" {
+			if body.RangeEnd > startPort {
+				fail(w, http.StatusBadRequest, "port ranges are not supported for redirect routes")
+				return
+			}
+			if OnRedirectValidate != nil {
+				if err := OnRedirectValidate(body.Target, storage.RouteRedirect{}); err != nil {
+					fail(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		}
 		// Port-range route: expand into one row + listener per port, all sharing a
 		// range_group so the admin UI can manage them as a single logical route.
 		if body.RangeEnd > startPort {
@@ -605,8 +673,8 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 		}
 		var regErr string
 		if OnRouteRegister != nil {
-			if err := OnRouteRegister(body.URL, body.Target, body.Type, body.Tls, cert, key); err != nil {
-				slog.Warn("route saved but not live", "url", body.URL, "error", err)
+			if err := OnRouteRegister(*route); err != nil {
+				slog.WarnContext(r.Context(), "route saved but not live", "url", body.URL, "error", err)
 				regErr = err.Error()
 			}
 		}
@@ -623,6 +691,21 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 			PersistentLogin bool   `json:"persistent_login"`
 			RequireLogin    bool   `json:"require_login"`
 			Target          string `json:"target"`
+
+			Transport *storage.RouteTransport `json:"transport"` // nil = leave unchanged
+			Retry     *storage.RouteRetry     `json:"retry"`     // nil = leave unchanged
+			Breaker   *storage.RouteBreaker   `json:"breaker"`   // nil = leave unchanged
+			Cache     *storage.RouteCache     `json:"cache"`     // nil = leave unchanged
+			Compress  *storage.RouteCompress  `json:"compress"`  // nil = leave unchanged
+			Headers   *storage.RouteHeaders   `json:"headers"`   // nil = leave unchanged
+			Security  *storage.RouteSecurity  `json:"security"`  // nil = leave unchanged
+
+			RedirectHosts *string                `json:"redirect_hosts"` // nil = leave unchanged
+			Redirect      *storage.RouteRedirect `json:"redirect"`       // nil = leave unchanged
+			Rewrite       *string                `json:"rewrite"`        // nil = leave unchanged
+			ErrorPages    *string                `json:"error_pages"`    // nil = leave unchanged
+
+			Maintenance *storage.RouteMaintenance `json:"maintenance"` // nil = leave unchanged; any route, ranges too
 		}
 		// A port-range route is edited as a whole via ?group=. Access-control
 		// fields are identical across every port; target edits are not supported
@@ -632,6 +715,12 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 				fail(w, http.StatusBadRequest, "invalid request")
 				return
 			}
+			if body.Maintenance != nil && OnMaintenanceValidate != nil {
+				if err := OnMaintenanceValidate(*body.Maintenance); err != nil {
+					fail(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
 			if body.AllowedGroups != "" {
 				if rt, err := store.GetRouteByGroup(r.Context(), group); err == nil && isRawType(rt.Type) {
 					body.IPAuth = true
@@ -641,6 +730,12 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 				fail(w, http.StatusNotFound, "range group not found")
 				return
 			}
+			if body.Maintenance != nil {
+				if _, err := store.UpdateRouteMaintenanceByGroup(r.Context(), group, *body.Maintenance); err != nil {
+					fail(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+			}
 			if OnRouteUpdate != nil {
 				OnRouteUpdate()
 			}
@@ -656,6 +751,55 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 			fail(w, http.StatusBadRequest, "invalid request")
 			return
 		}
+		if body.Headers != nil && OnHeaderRulesValidate != nil {
+			for _, rules := range []string{body.Headers.Request, body.Headers.Response} {
+				if err := OnHeaderRulesValidate(rules); err != nil {
+					fail(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		}
+		if body.Security != nil && OnSecurityValidate != nil {
+			if err := OnSecurityValidate(*body.Security); err != nil {
+				fail(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if body.Maintenance != nil && OnMaintenanceValidate != nil {
+			if err := OnMaintenanceValidate(*body.Maintenance); err != nil {
+				fail(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if body.ErrorPages != nil && OnErrorPagesValidate != nil {
+			if err := OnErrorPagesValidate(*body.ErrorPages); err != nil {
+				fail(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if body.Rewrite != nil && OnRewriteValidate != nil {
+			if err := OnRewriteValidate(*body.Rewrite); err != nil {
+				fail(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		// A redirect route is checked as a whole: the new target against the
+		// stored match pattern, or the new pattern against the stored target.
+		if (body.Redirect != nil || body.Target != "") && OnRedirectValidate != nil {
+			if rt, err := store.GetRouteByID(r.Context(), id); err == nil && rt.Type == "redirect" {
+				target, rd := rt.Target, rt.Redirect
+				if body.Target != "" {
+					target = body.Target
+				}
+				if body.Redirect != nil {
+					rd = *body.Redirect
+				}
+				if err := OnRedirectValidate(target, rd); err != nil {
+					fail(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		}
 		// Raw (tcp/udp) routes have no cookie/HTTP login, so IP session auth is the
 		// only way to enforce group membership. Selecting allowed groups implies
 		// ip_auth — persist it so stored state and admin UI reflect what is enforced.
@@ -668,11 +812,60 @@ func HandleAdminRoutes(w http.ResponseWriter, r *http.Request) {
 			fail(w, http.StatusNotFound, "route not found")
 			return
 		}
-		// Update backend target for UI-sourced routes only.
-		if body.Target != "" && OnRouteRegister != nil {
+		// Maintenance is read from the route cache refreshed below; no rebuild.
+		if body.Maintenance != nil {
+			if err := store.UpdateRouteMaintenance(r.Context(), id, *body.Maintenance); err != nil {
+				fail(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		// Update backend target and the per-route proxy policies for UI-sourced
+		// routes only, then rebuild the live handler from the stored row.
+		if (body.Target != "" || body.Transport != nil || body.Retry != nil || body.Breaker != nil ||
+			body.Cache != nil || body.Compress != nil || body.Headers != nil || body.Security != nil ||
+			body.RedirectHosts != nil || body.Redirect != nil || body.Rewrite != nil || body.ErrorPages != nil) && OnRouteRegister != nil {
 			if rt, err := store.GetRouteByID(r.Context(), id); err == nil && rt.Source == "ui" {
-				if err := store.UpdateRouteEndpoint(r.Context(), id, body.Target); err == nil {
-					OnRouteRegister(rt.Url, body.Target, rt.Type, rt.Tls, rt.Cert, rt.Key)
+				changed := false
+				if body.Target != "" && store.UpdateRouteEndpoint(r.Context(), id, body.Target) == nil {
+					changed = true
+				}
+				if body.Transport != nil && store.UpdateRouteTransport(r.Context(), id, *body.Transport) == nil {
+					changed = true
+				}
+				if body.Retry != nil && store.UpdateRouteRetry(r.Context(), id, *body.Retry) == nil {
+					changed = true
+				}
+				if body.Breaker != nil && store.UpdateRouteBreaker(r.Context(), id, *body.Breaker) == nil {
+					changed = true
+				}
+				if body.Cache != nil && store.UpdateRouteCache(r.Context(), id, *body.Cache) == nil {
+					changed = true
+				}
+				if body.Compress != nil && store.UpdateRouteCompress(r.Context(), id, *body.Compress) == nil {
+					changed = true
+				}
+				if body.Headers != nil && store.UpdateRouteHeaders(r.Context(), id, *body.Headers) == nil {
+					changed = true
+				}
+				if body.Security != nil && store.UpdateRouteSecurity(r.Context(), id, *body.Security) == nil {
+					changed = true
+				}
+				if body.RedirectHosts != nil && store.UpdateRouteRedirectHosts(r.Context(), id, *body.RedirectHosts) == nil {
+					changed = true
+				}
+				if body.Redirect != nil && store.UpdateRouteRedirect(r.Context(), id, *body.Redirect) == nil {
+					changed = true
+				}
+				if body.Rewrite != nil && store.UpdateRouteRewrite(r.Context(), id, *body.Rewrite) == nil {
+					changed = true
+				}
+				if body.ErrorPages != nil && store.UpdateRouteErrorPages(r.Context(), id, *body.ErrorPages) == nil {
+					changed = true
+				}
+				if changed {
+					if rt, err := store.GetRouteByID(r.Context(), id); err == nil {
+						OnRouteRegister(*rt)
+					}
 				}
 			}
 		}
@@ -783,7 +976,8 @@ func createRouteRange(w http.ResponseWriter, r *http.Request, routeType, host st
 		if offset {
 			tgt = net.JoinHostPort(targetHost, strconv.Itoa(targetPort+(p-startPort)))
 		}
-		if _, err := store.CreateRoute(r.Context(), u, tgt, routeType, tls, cert, key, rangeGroup); err != nil {
+		route, err := store.CreateRoute(r.Context(), u, tgt, routeType, tls, cert, key, rangeGroup)
+		if err != nil {
 			// Roll back ports already created so we never leave a partial range.
 			if urls, derr := store.DeleteRouteGroup(r.Context(), rangeGroup); derr == nil && OnRouteDelete != nil {
 				for _, du := range urls {
@@ -794,8 +988,8 @@ func createRouteRange(w http.ResponseWriter, r *http.Request, routeType, host st
 			return
 		}
 		if OnRouteRegister != nil {
-			if err := OnRouteRegister(u, tgt, routeType, tls, cert, key); err != nil {
-				slog.Warn("range route saved but not live", "url", u, "error", err)
+			if err := OnRouteRegister(*route); err != nil {
+				slog.WarnContext(r.Context(), "range route saved but not live", "url", u, "error", err)
 				regErr = err.Error()
 			}
 		}
@@ -812,6 +1006,9 @@ func HandleAdminSettings(w http.ResponseWriter, r *http.Request) {
 	if requireAdmin(w, r) == nil {
 		return
 	}
+	if r.Method != http.MethodGet && policyLocked(w, "settings") {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		s, err := store.GetSettings(r.Context())
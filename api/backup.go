@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"reMazarin/storage"
+	"strconv"
+)
+
+// BackupDir / BackupNow are wired from main.go: the [backup] directory, and a
+// snapshot into it followed by pruning.
+var (
+	BackupDir string
+	BackupNow func(ctx context.Context) (any, error)
+)
+
+// HandleAdminBackup lists, takes and downloads database snapshots.
+//
+//	GET                  → { backups: [{ name, size, created_at }] }, newest first
+//	GET ?download=<name> → the snapshot file
+//	POST                 → take a snapshot now → { backup }
+func HandleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	if requireAdmin(w, r) == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		files, err := storage.ListBackups(BackupDir)
+		if err != nil {
+			fail(w, http.StatusInternalServerError, "cannot read backup directory")
+			return
+		}
+		if name := r.URL.Query().Get("download"); name != "" {
+			// Only names from the listing, so the parameter cannot reach
+			// outside the backup directory.
+			for _, f := range files {
+				if f.Name == name {
+					w.Header().Set("Content-Type", "application/vnd.sqlite3")
+					w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(f.Name))
+					http.ServeFile(w, r, f.Path)
+					return
+				}
+			}
+			fail(w, http.StatusNotFound, "backup not found")
+			return
+		}
+		if files == nil {
+			files = []storage.BackupFile{}
+		}
+		ok(w, map[string]any{"backups": files})
+
+	case http.MethodPost:
+		if BackupNow == nil {
+			fail(w, http.StatusInternalServerError, "backup unavailable")
+			return
+		}
+		f, err := BackupNow(r.Context())
+		if errors.Is(err, storage.ErrBackupUnsupported) {
+			fail(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "backup failed", "error", err)
+			fail(w, http.StatusInternalServerError, "backup failed")
+			return
+		}
+		ok(w, map[string]any{"backup": f})
+
+	default:
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
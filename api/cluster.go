@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+)
+
+// ClusterStatus is wired from main.go to proxy.GetClusterStatus.
+var ClusterStatus func() any
+
+// HandleAdminCluster reports this node's cluster membership.
+//
+//	GET → { enabled, node_id, peers: [{ url, queued, last_ok, last_error }] }
+func HandleAdminCluster(w http.ResponseWriter, r *http.Request) {
+	if requireAdmin(w, r) == nil {
+		return
+	}
+	if r.Method != http.MethodGet {
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if ClusterStatus == nil {
+		ok(w, map[string]any{"enabled": false, "peers": []any{}})
+		return
+	}
+	ok(w, ClusterStatus())
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+// LiveConns / KillConns are wired from main.go to the proxy's live connection
+// registry.
+var (
+	LiveConns func() any                                         // proxy.LiveConns
+	KillConns func(ctx context.Context, id, ip, user string) int // proxy.KillConns
+)
+
+// HandleAdminConnections lists and terminates live connections: open TCP/UDP
+// flows and in-flight HTTP requests.
+//
+//	GET                         → { connections }
+//	DELETE ?id=<request id>     → kill one connection
+//	DELETE ?ip=<ip>             → kill every connection from an IP
+//	DELETE ?user=<username>     → kill every connection of a user
+//
+// DELETE filters combine; the response is { killed: n }.
+func HandleAdminConnections(w http.ResponseWriter, r *http.Request) {
+	if requireAdmin(w, r) == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		var conns any = []any{}
+		if LiveConns != nil {
+			conns = LiveConns()
+		}
+		ok(w, map[string]any{"connections": conns})
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		id, ip, user := q.Get("id"), q.Get("ip"), q.Get("user")
+		if id == "" && ip == "" && user == "" {
+			fail(w, http.StatusBadRequest, "id, ip or user required")
+			return
+		}
+		killed := 0
+		if KillConns != nil {
+			killed = KillConns(r.Context(), id, ip, user)
+		}
+		ok(w, map[string]int{"killed": killed})
+
+	default:
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -8,7 +8,7 @@ import (
 
 // HandleExample is a simple example API handler
 func HandleExample(w http.ResponseWriter, r *http.Request) {
-	slog.Info("example api called",
+	slog.InfoContext(r.Context(), "example api called",
 		"method", r.Method,
 		"path", r.URL.Path,
 	)
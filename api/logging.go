@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+)
+
+// LogLevels / SetLogLevels are wired from main.go to the proxy's log handler.
+var (
+	LogLevels    func() any                                             // proxy.GetLogLevels
+	SetLogLevels func(level string, subsystems map[string]string) error // proxy.SetLogLevels
+)
+
+// HandleAdminLogging reads and changes log levels at runtime. Changes are not
+// written back to the config file and last until the next restart.
+//
+//	GET  → { level, subsystems }
+//	PUT  → { level, subsystems } — an empty level keeps the base level; a
+//	       subsystem set to "" drops its override
+func HandleAdminLogging(w http.ResponseWriter, r *http.Request) {
+	if requireAdmin(w, r) == nil {
+		return
+	}
+	if LogLevels == nil || SetLogLevels == nil {
+		fail(w, http.StatusInternalServerError, "logging unavailable")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		ok(w, LogLevels())
+
+	case http.MethodPut:
+		var body struct {
+			Level      string            `json:"level"`
+			Subsystems map[string]string `json:"subsystems"`
+		}
+		if !decode(r, &body) {
+			fail(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+		if err := SetLogLevels(body.Level, body.Subsystems); err != nil {
+			fail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ok(w, LogLevels())
+
+	default:
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
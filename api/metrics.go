@@ -13,6 +13,9 @@ var (
 	RouteStats   func() map[string]int64   // proxy.GetRouteStats
 	EventStats   func() map[string]int64   // proxy.GetEventStats
 	RecentEvents func() any                // proxy.GetRecentEvents
+	Breakers     func() any                // proxy.GetBreakers
+	CacheStats   func() any                // proxy.GetCacheStats
+	CachePurge   func(route string) int    // proxy.PurgeCache
 	ActiveBans   func() []storage.BannedIP // proxy.GetActiveBans
 )
 
@@ -53,6 +56,22 @@ func HandleAdminMetrics(w http.ResponseWriter, r *http.Request) {
 		if accessLog == nil {
 			accessLog = []storage.AccessEvent{}
 		}
+		flows, err := store.GetRecentFlows(r.Context(), 2000)
+		if err != nil {
+			fail(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if flows == nil {
+			flows = []storage.Flow{}
+		}
+		flowTotals, err := store.GetFlowTotals(r.Context())
+		if err != nil {
+			fail(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if flowTotals == nil {
+			flowTotals = []storage.FlowTotal{}
+		}
 		var eventStats map[string]int64
 		if EventStats != nil {
 			eventStats = EventStats()
@@ -66,6 +85,10 @@ func HandleAdminMetrics(w http.ResponseWriter, r *http.Request) {
 				recentEvents = re
 			}
 		}
+		var breakers any = []any{}
+		if Breakers != nil {
+			breakers = Breakers()
+		}
 		var bans []storage.BannedIP
 		if ActiveBans != nil {
 			bans = ActiveBans()
@@ -78,9 +101,12 @@ func HandleAdminMetrics(w http.ResponseWriter, r *http.Request) {
 			"route_stats":   stats,
 			"auth_failures": failures,
 			"access_log":    accessLog,
+			"flows":         flows,
+			"flow_totals":   flowTotals,
 			"event_stats":   eventStats,
 			"recent_events": recentEvents,
 			"banned_ips":    bans,
+			"breakers":      breakers,
 		})
 
 	case http.MethodDelete:
@@ -89,11 +115,42 @@ func HandleAdminMetrics(w http.ResponseWriter, r *http.Request) {
 			fail(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		if err := store.AdminDeleteSession(r.Context(), id); err != nil {
+		username, err := store.AdminDeleteSession(r.Context(), id)
+		if err != nil {
 			fail(w, http.StatusInternalServerError, "db error")
 			return
 		}
-		ok(w, map[string]bool{"ok": true})
+		killed := 0
+		if r.URL.Query().Get("kill") == "1" && username != "" && KillConns != nil {
+			killed = KillConns(r.Context(), "", "", username)
+		}
+		ok(w, map[string]any{"ok": true, "killed": killed})
+
+	default:
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// HandleAdminCache reports response-cache statistics (GET) and purges cached
+// responses (DELETE), for one route with ?route=<url> or for every route.
+func HandleAdminCache(w http.ResponseWriter, r *http.Request) {
+	if requireAdmin(w, r) == nil {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		var stats any = map[string]any{}
+		if CacheStats != nil {
+			stats = CacheStats()
+		}
+		ok(w, stats)
+
+	case http.MethodDelete:
+		n := 0
+		if CachePurge != nil {
+			n = CachePurge(r.URL.Query().Get("route"))
+		}
+		ok(w, map[string]any{"ok": true, "purged": n})
 
 	default:
 		fail(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -0,0 +1,21 @@
+package api
+
+import "net/http"
+
+// PolicyOwner is wired from main.go when a [policy] file manages the admin
+// state. It returns the file owning a section — "users", "groups", "routes",
+// "throttle" or "settings" — or "" while the admin panel may edit it.
+var PolicyOwner func(section string) string
+
+// policyLocked answers 409 and returns true if section is owned by a policy
+// file, whose next reload would undo any edit made here.
+func policyLocked(w http.ResponseWriter, section string) bool {
+	if PolicyOwner == nil {
+		return false
+	}
+	if file := PolicyOwner(section); file != "" {
+		fail(w, http.StatusConflict, section+" are managed by "+file+"; change them there")
+		return true
+	}
+	return false
+}
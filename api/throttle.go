@@ -18,7 +18,7 @@ var (
 //
 //	GET                      → { policies, bans }
 //	PUT                      → upsert a tier policy (body = ThrottlePolicy)
-//	POST                     → manually ban an IP { ip, duration_sec, reason }
+//	POST                     → manually ban an IP { ip, duration_sec, reason, kill }
 //	DELETE ?ip=<ip>          → unban an IP
 //	DELETE ?tier=group:<id>  → delete a per-group policy override
 func HandleAdminThrottle(w http.ResponseWriter, r *http.Request) {
@@ -46,6 +46,9 @@ func HandleAdminThrottle(w http.ResponseWriter, r *http.Request) {
 		ok(w, map[string]any{"policies": policies, "bans": bans})
 
 	case http.MethodPut:
+		if policyLocked(w, "throttle") {
+			return
+		}
 		var p storage.ThrottlePolicy
 		if !decode(r, &p) || strings.TrimSpace(p.Tier) == "" {
 			fail(w, http.StatusBadRequest, "tier required")
@@ -70,6 +73,7 @@ func HandleAdminThrottle(w http.ResponseWriter, r *http.Request) {
 		var body struct {
 			IP          string `json:"ip"`
 			DurationSec int    `json:"duration_sec"`
+			Kill        bool   `json:"kill"` // also terminate the IP's live connections
 		}
 		if !decode(r, &body) || strings.TrimSpace(body.IP) == "" {
 			fail(w, http.StatusBadRequest, "ip required")
@@ -79,8 +83,13 @@ func HandleAdminThrottle(w http.ResponseWriter, r *http.Request) {
 			fail(w, http.StatusInternalServerError, "ban unavailable")
 			return
 		}
-		BanIP(strings.TrimSpace(body.IP), body.DurationSec)
-		ok(w, map[string]bool{"ok": true})
+		ip := strings.TrimSpace(body.IP)
+		BanIP(ip, body.DurationSec)
+		killed := 0
+		if body.Kill && KillConns != nil {
+			killed = KillConns(r.Context(), "", ip, "")
+		}
+		ok(w, map[string]any{"ok": true, "killed": killed})
 
 	case http.MethodDelete:
 		if ip := r.URL.Query().Get("ip"); ip != "" {
@@ -91,6 +100,9 @@ func HandleAdminThrottle(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if tier := r.URL.Query().Get("tier"); tier != "" {
+			if policyLocked(w, "throttle") {
+				return
+			}
 			if err := store.DeleteThrottlePolicy(r.Context(), tier); err != nil {
 				fail(w, http.StatusBadRequest, err.Error())
 				return
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reMazarin/storage"
+	"syscall"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// takeBackup snapshots the database into the backup directory and prunes it
+// to the configured number of snapshots. The schedule, the admin API and the
+// backup command all go through here.
+func takeBackup(ctx context.Context, store *storage.Storage, b BackupConfig) (*storage.BackupFile, error) {
+	f, err := store.Snapshot(ctx, b.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := storage.PruneBackups(b.Dir, b.Keep); err != nil {
+		slog.Error("prune backups failed", "dir", b.Dir, "error", err)
+	} else if n > 0 {
+		slog.Info("old backups pruned", "dir", b.Dir, "deleted", n)
+	}
+	return f, nil
+}
+
+// scheduleBackups takes a snapshot every interval_hours until ctx is done.
+func scheduleBackups(ctx context.Context, store *storage.Storage, b BackupConfig) {
+	t := time.NewTicker(time.Duration(b.IntervalHours) * time.Hour)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			_, err := takeBackup(ctx, store, b)
+			if errors.Is(err, storage.ErrBackupUnsupported) {
+				slog.Warn("scheduled backups disabled", "error", err)
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				slog.Error("scheduled backup failed", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cmdBackup is `remazarin backup`: an online snapshot, safe while the proxy
+// runs.
+func cmdBackup(args []string) error {
+	fs, configPath := cliFlags("backup", "")
+	out := fs.String("out", "", "write the snapshot to this file instead of the [backup] directory (no pruning)")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return xerrors.Newf("load config: %w", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := storage.New(cfg.Database)
+	if err != nil {
+		return xerrors.Newf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	if *out != "" {
+		if err := store.Backup(ctx, *out); err != nil {
+			return err
+		}
+		fmt.Println(*out)
+		return nil
+	}
+	f, err := takeBackup(ctx, store, cfg.Backup)
+	if err != nil {
+		return err
+	}
+	fmt.Println(f.Path)
+	return nil
+}
+
+// cmdRestore is `remazarin restore <file>`. The proxy must be stopped.
+func cmdRestore(args []string) error {
+	fs, configPath := cliFlags("restore", "<backup file>")
+	check := fs.Bool("check", false, "only check the backup; change nothing")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	file := pos[0]
+	ctx := context.Background()
+
+	if *check {
+		version, err := storage.CheckBackup(ctx, file)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: ok, schema version %d (this build: %d)\n", file, version, storage.LatestMigration())
+		return nil
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return xerrors.Newf("load config: %w", err)
+	}
+	saved, err := storage.Restore(ctx, file, cfg.Database)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s into %s\n", file, cfg.Database)
+	if saved != "" {
+		fmt.Printf("previous database saved as %s\n", saved)
+	}
+	return nil
+}
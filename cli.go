@@ -0,0 +1,982 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"reMazarin/proxy"
+	"reMazarin/storage"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// The command line. With no arguments reMazarin serves, as it always has;
+// everything else is a one-shot command working directly on the configured
+// database, so an operator locked out of the admin panel can still get in.
+// Commands that change state the running proxy caches in memory (bans) take
+// effect there at its next cache refresh, within 5 minutes.
+
+const usage = `usage: remazarin [command] [flags]
+
+  serve            run the proxy (the default)
+  user add|passwd|delete|list
+  group add|list
+  invite create
+  route list
+  ban add|remove|list
+  sessions revoke
+  migrate status|up
+  config check     validate the config file (-offline: no DNS, no database)
+  export           write users, groups, routes and policies to a file
+  import           apply such a file (-dry-run shows the changes)
+  backup           take an online database snapshot
+  restore          restore a snapshot (proxy stopped)
+
+Every command takes -config <path> (default $REMAZARIN_CONFIG, else config.toml).
+Run "remazarin <command> -h" for its flags.`
+
+// cliCommand is a command or, with subs, a group of subcommands.
+type cliCommand struct {
+	run  func(args []string) error
+	subs map[string]func(args []string) error
+}
+
+var commands = map[string]cliCommand{
+	"serve":   {run: cmdServe},
+	"backup":  {run: cmdBackup},
+	"restore": {run: cmdRestore},
+	"export":  {run: cmdExport},
+	"import":  {run: cmdImport},
+	"user": {subs: map[string]func([]string) error{
+		"add": cmdUserAdd, "passwd": cmdUserPasswd, "delete": cmdUserDelete, "list": cmdUserList,
+	}},
+	"group":    {subs: map[string]func([]string) error{"add": cmdGroupAdd, "list": cmdGroupList}},
+	"invite":   {subs: map[string]func([]string) error{"create": cmdInviteCreate}},
+	"route":    {subs: map[string]func([]string) error{"list": cmdRouteList}},
+	"ban":      {subs: map[string]func([]string) error{"add": cmdBanAdd, "remove": cmdBanRemove, "list": cmdBanList}},
+	"sessions": {subs: map[string]func([]string) error{"revoke": cmdSessionsRevoke}},
+	"migrate":  {subs: map[string]func([]string) error{"status": cmdMigrateStatus, "up": cmdMigrateUp}},
+	"config":   {subs: map[string]func([]string) error{"check": cmdConfigCheck, "validate": cmdConfigCheck}},
+}
+
+// runCLI dispatches the command line. Flags with no command mean serve.
+func runCLI(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help" {
+		return cmdServe(args)
+	}
+	name, args := args[0], args[1:]
+	switch name {
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		return xerrors.Newf("unknown command %q\n\n%s", name, usage)
+	}
+	if name != "serve" {
+		setupCLILogging()
+	}
+	if cmd.run != nil {
+		return cmd.run(args)
+	}
+	names := make([]string, 0, len(cmd.subs))
+	for sub := range cmd.subs {
+		names = append(names, sub)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		return xerrors.Newf("%s needs a subcommand: %s", name, strings.Join(names, ", "))
+	}
+	sub, ok := cmd.subs[args[0]]
+	if !ok {
+		return xerrors.Newf("unknown command %q; %s subcommands: %s", name+" "+args[0], name, strings.Join(names, ", "))
+	}
+	return sub(args[1:])
+}
+
+// cliFlags is a command's flag set with the shared -config flag. args names
+// the positional arguments for the usage line.
+func cliFlags(name, args string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: remazarin %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs, configPath
+}
+
+// defaultConfigPath is $REMAZARIN_CONFIG, else config.toml.
+func defaultConfigPath() string {
+	if p := os.Getenv(envPrefix + "CONFIG"); p != "" {
+		return p
+	}
+	return "config.toml"
+}
+
+// parseArgs parses flags, which may come before or after the positional
+// arguments, and checks the number of positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(pos) != n {
+		fs.Usage()
+		return nil, xerrors.Newf("%s takes %d argument(s), got %d", fs.Name(), n, len(pos))
+	}
+	return pos, nil
+}
+
+// openStore loads the config and opens its database, applying any pending
+// migrations.
+func openStore(configPath string) (*Config, *storage.Storage, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, nil, xerrors.Newf("load config: %w", err)
+	}
+	store, err := storage.New(cfg.Database)
+	if err != nil {
+		return nil, nil, xerrors.Newf("open storage: %w", err)
+	}
+	return cfg, store, nil
+}
+
+// openSyncedStore is openStore followed by what the server does at startup
+// with the routes of config.toml: sync them into the database and protect the
+// admin panel's route, so they can be exported and referred to on import.
+func openSyncedStore(configPath string) (*Config, *storage.Storage, error) {
+	cfg, store, err := openStore(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := store.SyncRoutes(cfg.configRoutes()); err != nil {
+		store.Close()
+		return nil, nil, xerrors.Newf("sync routes: %w", err)
+	}
+	if cfg.Admin.Enabled {
+		if err := store.EnsureRouteGroup(context.Background(), cfg.Admin.Url, "admin"); err != nil {
+			store.Close()
+			return nil, nil, err
+		}
+	}
+	return cfg, store, nil
+}
+
+func cmdServe(args []string) error {
+	fs, configPath := cliFlags("serve", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	return run(*configPath)
+}
+
+// readPassword takes the password from the flag, else from stdin when it is
+// piped, else generates one, which is printed.
+func readPassword(flagValue string) (password string, generated bool, err error) {
+	if flagValue != "" {
+		return flagValue, false, nil
+	}
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return "", false, xerrors.Newf("no password on stdin: %w", err)
+		}
+		return line, false, nil
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b), true, nil
+}
+
+func cmdUserAdd(args []string) error {
+	fs, configPath := cliFlags("user add", "<username>")
+	password := fs.String("password", "", "password; default: read from piped stdin, or generated and printed")
+	var groups stringList
+	fs.Var(&groups, "group", "add the user to this group (repeatable)")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	// Resolve the groups first so a typo doesn't leave a half-made user.
+	ids, err := groupIDs(ctx, store, groups)
+	if err != nil {
+		return err
+	}
+	pw, generated, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	u, err := store.CreateUser(ctx, pos[0], pw)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := store.AddUserToGroup(ctx, u.ID, id); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("created user %s (id %d)\n", u.Username, u.ID)
+	if generated {
+		fmt.Printf("password: %s\n", pw)
+	}
+	return nil
+}
+
+func cmdUserPasswd(args []string) error {
+	fs, configPath := cliFlags("user passwd", "<username>")
+	password := fs.String("password", "", "new password; default: read from piped stdin, or generated and printed")
+	revoke := fs.Bool("revoke", false, "also revoke the user's sessions")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	pw, generated, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	u, err := store.GetUserByUsername(ctx, pos[0])
+	if err != nil {
+		return xerrors.Newf("no user %q", pos[0])
+	}
+	if err := store.SetPassword(ctx, u.ID, pw); err != nil {
+		return err
+	}
+	fmt.Printf("password of %s changed\n", u.Username)
+	if generated {
+		fmt.Printf("password: %s\n", pw)
+	}
+	if *revoke {
+		n, err := store.DeleteUserSessions(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d session(s) revoked\n", n)
+	}
+	return nil
+}
+
+func cmdUserDelete(args []string) error {
+	fs, configPath := cliFlags("user delete", "<username>")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	u, err := store.GetUserByUsername(ctx, pos[0])
+	if err != nil {
+		return xerrors.Newf("no user %q", pos[0])
+	}
+	if err := store.DeleteUser(ctx, u.ID); err != nil {
+		return err
+	}
+	fmt.Printf("deleted user %s and their sessions\n", u.Username)
+	return nil
+}
+
+func cmdUserList(args []string) error {
+	fs, configPath := cliFlags("user list", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	users, err := store.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tGROUPS\tCREATED")
+	for _, u := range users {
+		groups, _ := store.GetUserGroups(ctx, u.ID)
+		names := make([]string, len(groups))
+		for i, g := range groups {
+			names[i] = g.Name
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", u.ID, u.Username, strings.Join(names, ","), u.CreatedAt.Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+func cmdGroupAdd(args []string) error {
+	fs, configPath := cliFlags("group add", "<name>")
+	description := fs.String("description", "", "group description")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	g, err := store.CreateGroup(context.Background(), pos[0], *description)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created group %s (id %d)\n", g.Name, g.ID)
+	return nil
+}
+
+func cmdGroupList(args []string) error {
+	fs, configPath := cliFlags("group list", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	groups, err := store.GetAllGroups(context.Background())
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tDESCRIPTION")
+	for _, g := range groups {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", g.ID, g.Name, g.Description)
+	}
+	return w.Flush()
+}
+
+// groupIDs resolves group names to IDs.
+func groupIDs(ctx context.Context, store *storage.Storage, names []string) ([]int, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	groups, err := store.GetAllGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]int, len(groups))
+	for _, g := range groups {
+		byName[g.Name] = g.ID
+	}
+	ids := make([]int, len(names))
+	for i, n := range names {
+		id, ok := byName[n]
+		if !ok {
+			return nil, xerrors.Newf("no group %q", n)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func cmdInviteCreate(args []string) error {
+	fs, configPath := cliFlags("invite create", "")
+	description := fs.String("description", "", "what the invite is for")
+	hours := fs.Int("hours", 24, "hours until the invite expires")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if *hours <= 0 {
+		return xerrors.Newf("-hours must be positive")
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	code, inv, err := store.CreateInvite(context.Background(), *description, time.Duration(*hours)*time.Hour)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("invite %d, expires %s\ncode: %s\n", inv.ID, inv.ExpiresAt.Format(time.DateTime), code)
+	return nil
+}
+
+func cmdRouteList(args []string) error {
+	fs, configPath := cliFlags("route list", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	routes, err := store.GetAllRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	groups, err := store.GetAllGroups(ctx)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(groups))
+	for _, g := range groups {
+		names[strconv.Itoa(g.ID)] = g.Name
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "URL\tTYPE\tTARGET\tTLS\tSOURCE\tGROUPS\tIPS")
+	for _, r := range routes {
+		typ := r.Type
+		if typ == "" {
+			typ = "proxy"
+		}
+		// allowed_groups holds IDs; show names.
+		var allowed []string
+		for _, id := range strings.Split(r.AllowedGroups, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				allowed = append(allowed, cmp.Or(names[id], id))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\t%s\t%s\n", r.Url, typ, r.Target, r.Tls, r.Source, dash(strings.Join(allowed, ",")), dash(r.AllowedIPs))
+	}
+	return w.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func cmdBanAdd(args []string) error {
+	fs, configPath := cliFlags("ban add", "<ip>")
+	duration := fs.Duration("duration", 0, "ban length, e.g. 24h; 0 = until removed")
+	reason := fs.String("reason", "manual", "reason shown in the admin panel")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var exp *time.Time
+	if *duration > 0 {
+		t := time.Now().Add(*duration)
+		exp = &t
+	}
+	if err := store.InsertBan(context.Background(), pos[0], *reason, "manual", exp); err != nil {
+		return err
+	}
+	fmt.Printf("banned %s\n", pos[0])
+	fmt.Println(banNote)
+	return nil
+}
+
+// banNote is printed with every ban change: the running proxy holds bans in
+// memory, and the command line has no way to tell it or the cluster.
+const banNote = "note: not published to the cluster; a running proxy picks this up at its next cache refresh, within 5 minutes"
+
+func cmdBanRemove(args []string) error {
+	fs, configPath := cliFlags("ban remove", "<ip>")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.DeleteBan(context.Background(), pos[0]); err != nil {
+		return err
+	}
+	fmt.Printf("unbanned %s\n", pos[0])
+	fmt.Println(banNote)
+	return nil
+}
+
+func cmdBanList(args []string) error {
+	fs, configPath := cliFlags("ban list", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	bans, err := store.GetActiveBans(context.Background())
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tREASON\tTIER\tEXPIRES")
+	for _, b := range bans {
+		exp := "never"
+		if b.ExpiresAt != nil {
+			exp = b.ExpiresAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.IP, b.Reason, b.Tier, exp)
+	}
+	return w.Flush()
+}
+
+func cmdSessionsRevoke(args []string) error {
+	fs, configPath := cliFlags("sessions revoke", "")
+	user := fs.String("user", "", "revoke this user's sessions")
+	all := fs.Bool("all", false, "revoke every session")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if (*user == "") == !*all {
+		return xerrors.Newf("sessions revoke needs exactly one of -user or -all")
+	}
+	_, store, err := openStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	var n int
+	if *all {
+		n, err = store.DeleteAllSessions(ctx)
+	} else {
+		u, uerr := store.GetUserByUsername(ctx, *user)
+		if uerr != nil {
+			return xerrors.Newf("no user %q", *user)
+		}
+		n, err = store.DeleteUserSessions(ctx, u.ID)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d session(s) revoked\n", n)
+	return nil
+}
+
+// openUnmigrated opens the configured database without applying migrations.
+func openUnmigrated(configPath string) (*storage.Storage, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, xerrors.Newf("load config: %w", err)
+	}
+	store, err := storage.Open(cfg.Database)
+	if err != nil {
+		return nil, xerrors.Newf("open storage: %w", err)
+	}
+	return store, nil
+}
+
+func cmdMigrateStatus(args []string) error {
+	fs, configPath := cliFlags("migrate status", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	store, err := openUnmigrated(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ms, err := store.MigrationStatus(context.Background())
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	pending := 0
+	for _, m := range ms {
+		applied := "pending"
+		switch {
+		case m.Unknown:
+			applied = m.AppliedAt.Local().Format(time.DateTime) + " (not in this build)"
+		case m.AppliedAt != nil:
+			applied = m.AppliedAt.Local().Format(time.DateTime)
+		default:
+			pending++
+		}
+		fmt.Fprintf(w, "%03d\t%s\t%s\n", m.Version, m.Name, applied)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d pending\n", pending)
+	return nil
+}
+
+func cmdMigrateUp(args []string) error {
+	fs, configPath := cliFlags("migrate up", "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	store, err := openUnmigrated(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	before, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if err := store.Migrate(); err != nil {
+		return err
+	}
+	after, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for i, m := range after {
+		if m.AppliedAt != nil && i < len(before) && before[i].AppliedAt == nil {
+			fmt.Printf("applied %s\n", m.Name)
+			n++
+		}
+	}
+	fmt.Printf("%d migration(s) applied\n", n)
+	return nil
+}
+
+func cmdConfigCheck(args []string) error {
+	fs, configPath := cliFlags("config check", "")
+	offline := fs.Bool("offline", false, "don't resolve targets or read the routes added in the admin panel")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var ui []storage.Route
+	lookup := lookupHost
+	if *offline {
+		lookup = nil
+	} else if ui, err = readUIRoutes(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "note: routes added in the admin panel not checked: %v\n", err)
+	}
+	errs := checkConfig(ctx, cfg, ui, lookup)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return xerrors.Newf("%s: %d problem(s)", *configPath, len(errs))
+	}
+	fmt.Printf("%s: ok (%d routes)\n", *configPath, len(cfg.Routes))
+	return nil
+}
+
+// readUIRoutes reads the routes of the configured database without
+// migrating it, or creating it when it doesn't exist yet.
+func readUIRoutes(ctx context.Context, cfg *Config) ([]storage.Route, error) {
+	if !strings.Contains(cfg.Database, "://") {
+		if _, err := os.Stat(cfg.Database); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+	store, err := storage.Open(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.GetAllRoutes(ctx)
+}
+
+func cmdExport(args []string) error {
+	fs, configPath := cliFlags("export", "")
+	out := fs.String("o", "", "write to this file instead of stdout")
+	format := fs.String("format", "", "yaml, toml or json; default: from the -o extension, else yaml")
+	passwords := fs.Bool("passwords", false, "include password hashes, so users keep their passwords elsewhere")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	f, err := policyFormat(*out, *format)
+	if err != nil {
+		return err
+	}
+	_, store, err := openSyncedStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	doc, err := store.ExportPolicy(context.Background(), *passwords)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return encodePolicy(os.Stdout, doc, f)
+	}
+	// Password hashes, if any, should not be world-readable.
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := encodePolicy(file, doc, f); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func cmdImport(args []string) error {
+	fs, configPath := cliFlags("import", "<file>")
+	dryRun := fs.Bool("dry-run", false, "only show what would change")
+	prune := fs.Bool("prune", false, "also delete what the file's sections leave out")
+	format := fs.String("format", "", "yaml, toml or json; default: from the file extension")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	doc, err := readPolicyFile(pos[0], *format)
+	if err != nil {
+		return err
+	}
+	cfg, store, err := openSyncedStore(*configPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	var changes []storage.PolicyChange
+	if *dryRun {
+		changes, err = store.PlanPolicy(ctx, doc, *prune)
+	} else {
+		changes, err = store.ApplyPolicy(ctx, doc, *prune)
+	}
+	if err != nil {
+		return err
+	}
+	restart := false
+	for _, c := range changes {
+		fmt.Println(c)
+		restart = restart || c.Kind == "route" && (c.Reregister || c.Action == "delete")
+	}
+	switch {
+	case len(changes) == 0:
+		fmt.Println("no changes")
+		return nil
+	case *dryRun:
+		fmt.Printf("%d change(s) to apply; run again without -dry-run to apply them\n", len(changes))
+		return nil
+	}
+	if cfg.Admin.Enabled {
+		if err := store.EnsureRouteGroup(ctx, cfg.Admin.Url, "admin"); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d change(s) applied\n", len(changes))
+	if restart {
+		fmt.Println("routes were added, changed or removed: restart the proxy to listen accordingly")
+	}
+	return nil
+}
+
+// checkConfig runs the checks the server would run at startup, and the
+// stricter ones of checkRoutes, without opening any listener. It returns
+// every problem found, each located in the config file.
+func checkConfig(ctx context.Context, cfg *Config, ui []storage.Route, lookup func(context.Context, string) error) []error {
+	var errs []error
+	add := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, cfg.problem(key, err))
+		}
+	}
+	if _, err := proxy.ConfigurePrometheus(cfg.prometheus()); err != nil {
+		add("prometheus", err)
+	}
+	add("security_profiles", proxy.ConfigureSecurityProfiles(cfg.securityProfiles()))
+	add("error_pages", proxy.ConfigureErrorPages(cfg.errorPages()))
+	add("request_id", proxy.ConfigureRequestIDs(cfg.requestID()))
+	add("cluster", proxy.ConfigureCluster(cfg.cluster()))
+	addProblems := func(section string, ps []proxy.Problem) {
+		for _, p := range ps {
+			add(section+"."+p.Field, p.Err)
+		}
+	}
+	addProblems("log", proxy.CheckLogging(cfg.log()))
+	if cfg.Otel.Enabled {
+		_, serr := newSampler(cfg.Otel)
+		_, cerr := otlpCredentials(cfg.Otel)
+		for _, err := range []error{serr, cerr} {
+			key := "otel"
+			if p := (proxy.Problem{}); errors.As(err, &p) {
+				key += "." + p.Field
+			}
+			add(key, err)
+		}
+	}
+	addProblems("access_log", proxy.CheckAccessLog(cfg.accessLog()))
+	addProblems("cache", proxy.CheckCache(cfg.cache()))
+	if cfg.Policy.File != "" {
+		if _, err := readPolicyFile(cfg.Policy.File, ""); err != nil {
+			add("policy.file", err)
+		}
+	}
+
+	ports := make(map[int]int)
+	for i, l := range cfg.Listeners {
+		key := fmt.Sprintf("listeners[%d].port", i)
+		if l.Port < 1 || l.Port > 65535 {
+			add(key, xerrors.Newf("%d is not a port", l.Port))
+		} else if j, ok := ports[l.Port]; ok {
+			add(key, xerrors.Newf("port %d is also set by listeners[%d]", l.Port, j))
+		}
+		ports[l.Port] = i
+	}
+
+	for i, r := range cfg.Routes {
+		key := cfg.routeKey(i)
+		h := r.headers()
+		add(key+".request_headers", proxy.ValidateHeaderRules(h.Request))
+		add(key+".response_headers", proxy.ValidateHeaderRules(h.Response))
+		add(key+".security_profile", proxy.ValidateRouteSecurity(r.security()))
+		if r.Type == "redirect" {
+			add(key+".target", proxy.ValidateRedirect(r.Target, r.redirect()))
+		}
+		add(key+".rewrite", proxy.ValidateRewriteRules(r.rewrite()))
+		if r.ErrorPages != "" {
+			add(key+".error_pages", proxy.ValidateErrorPages(r.ErrorPages))
+		}
+	}
+	for _, url := range replacedUIRoutes(cfg, ui) {
+		add(cfg.routeKey(slices.IndexFunc(cfg.Routes, func(r Route) bool { return r.Url == url }))+".url",
+			xerrors.Newf("a route for %s was added in the admin panel; this one replaces it at startup", url))
+	}
+	return append(errs, checkRoutes(ctx, cfg, ui, lookup)...)
+}
+
+// checkRoutes checks the routes of the config file together with those
+// added in the admin panel (ui, which may be nil), which share their ports:
+// see proxy.CheckRoutes. lookup resolves target hosts; nil skips that.
+func checkRoutes(ctx context.Context, cfg *Config, ui []storage.Route, lookup func(context.Context, string) error) []error {
+	var routes []proxy.ProxyRoute
+	var keys []string // where each route is set; "" = the admin panel
+	configured := make(map[string]bool)
+	for i, r := range cfg.Routes {
+		routes = append(routes, proxy.ProxyRoute{
+			Url: r.Url, Target: r.Target, Type: r.Type,
+			Tls: r.Tls, Cert: r.Cert, Key: r.Key, Breaker: r.breaker(),
+		})
+		keys = append(keys, cfg.routeKey(i))
+		configured[r.Url] = true
+	}
+	for _, r := range ui {
+		if r.Source != "ui" || configured[r.Url] {
+			continue
+		}
+		routes = append(routes, proxy.ProxyRoute{
+			Url: r.Url, Target: r.Target, Type: r.Type,
+			Tls: r.Tls, Cert: r.Cert, Key: r.Key, Breaker: r.Breaker,
+		})
+		keys = append(keys, "")
+	}
+
+	var errs []error
+	reserved := make(map[string]string)
+	if _, port, err := net.SplitHostPort(cfg.Prometheus.Listen); err == nil && cfg.Prometheus.Enabled {
+		reserved[port] = "prometheus.listen"
+	}
+	if _, port, err := net.SplitHostPort(cfg.Cluster.Listen); err == nil && cfg.Cluster.Enabled {
+		if other, ok := reserved[port]; ok {
+			errs = append(errs, cfg.problem("cluster.listen", xerrors.Newf("port %s is also %s", port, other)))
+		}
+		reserved[port] = "cluster.listen"
+	}
+
+	name := func(i int) string {
+		if keys[i] == "" {
+			return fmt.Sprintf("admin panel route %s", routes[i].Url)
+		}
+		return cfg.lines.position(cfg.path, cfg.lines.line(keys[i])) + " " + keys[i]
+	}
+	// Hosts are resolved concurrently, all within one deadline.
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	for _, p := range proxy.CheckRoutes(ctx, routes, proxy.CheckOptions{Lookup: lookup, Reserved: reserved, Redirect: cfg.redirect()}) {
+		err := p.Err
+		if p.Other >= 0 {
+			err = xerrors.Newf("%w (see %s)", err, name(p.Other))
+		}
+		if keys[p.Route] == "" {
+			errs = append(errs, xerrors.Newf("%s: %s: %w", name(p.Route), p.Field, err))
+			continue
+		}
+		errs = append(errs, cfg.problem(keys[p.Route]+"."+p.Field, err))
+	}
+	return errs
+}
+
+// replacedUIRoutes lists the URLs of routes added in the admin panel that
+// config routes of the same URL take over at startup.
+func replacedUIRoutes(cfg *Config, ui []storage.Route) []string {
+	var urls []string
+	for _, r := range ui {
+		if r.Source == "ui" && slices.ContainsFunc(cfg.Routes, func(c Route) bool { return c.Url == r.Url }) {
+			urls = append(urls, r.Url)
+		}
+	}
+	return urls
+}
+
+// lookupTimeout is how long checkRoutes waits for target hosts to resolve.
+const lookupTimeout = 5 * time.Second
+
+// lookupHost resolves a route target's host.
+func lookupHost(ctx context.Context, host string) error {
+	_, err := net.DefaultResolver.LookupHost(ctx, host)
+	return err
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reMazarin/storage"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		n    int
+		want []string
+		err  string
+	}{
+		{"none", nil, 0, nil, ""},
+		{"positional", []string{"alice"}, 1, []string{"alice"}, ""},
+		{"flags first", []string{"-v", "alice"}, 1, []string{"alice"}, ""},
+		{"flags after", []string{"alice", "-v", "-config", "x.toml"}, 1, []string{"alice"}, ""},
+		{"flags between", []string{"a", "-v", "b"}, 2, []string{"a", "b"}, ""},
+		{"after --", []string{"--", "-v"}, 1, []string{"-v"}, ""},
+		{"missing", nil, 1, nil, "test takes 1 argument(s), got 0"},
+		{"extra", []string{"a", "b"}, 1, nil, "test takes 1 argument(s), got 2"},
+		{"unknown flag", []string{"-nope"}, 0, nil, "flag provided but not defined: -nope"},
+		{"bad value", []string{"-n", "x"}, 0, nil, "invalid value"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, _ := cliFlags("test", "")
+			fs.SetOutput(io.Discard)
+			fs.Bool("v", false, "")
+			fs.Int("n", 0, "")
+			got, err := parseArgs(fs, tc.args, tc.n)
+			switch {
+			case tc.err != "":
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want %q", err, tc.err)
+				}
+			case err != nil:
+				t.Errorf("error = %v", err)
+			case !reflect.DeepEqual(got, tc.want):
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseArgsHelp(t *testing.T) {
+	fs, _ := cliFlags("test", "")
+	fs.SetOutput(io.Discard)
+	if _, err := parseArgs(fs, []string{"-h"}, 1); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("-h: error = %v, want flag.ErrHelp", err)
+	}
+}
+
+// -config wins over $REMAZARIN_CONFIG, which wins over config.toml.
+func TestConfigFlagPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		name, env string
+		args      []string
+		want      string
+	}{
+		{"default", "", nil, "config.toml"},
+		{"environment", "/etc/remazarin/env.toml", nil, "/etc/remazarin/env.toml"},
+		{"flag", "", []string{"-config", "flag.toml"}, "flag.toml"},
+		{"flag over environment", "/etc/remazarin/env.toml", []string{"-config", "flag.toml"}, "flag.toml"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("REMAZARIN_CONFIG", tc.env)
+			fs, configPath := cliFlags("test", "")
+			if _, err := parseArgs(fs, tc.args, 0); err != nil {
+				t.Fatal(err)
+			}
+			if *configPath != tc.want {
+				t.Errorf("config = %q, want %q", *configPath, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunCLIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"frob"}, `unknown command "frob"`},
+		{[]string{"user"}, "user needs a subcommand: add, delete, list, passwd"},
+		{[]string{"user", "frob"}, `unknown command "user frob"; user subcommands: add, delete, list, passwd`},
+		{[]string{"user", "add"}, "user add takes 1 argument(s), got 0"},
+		{[]string{"ban", "add", "1.2.3.4", "5.6.7.8"}, "ban add takes 1 argument(s), got 2"},
+		{[]string{"restore"}, "restore takes 1 argument(s), got 0"},
+		{[]string{"sessions", "revoke"}, "needs exactly one of -user or -all"},
+		{[]string{"user", "list", "-config", "/nonexistent/config.toml"}, "load config"},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			_, err := cli(t, tc.args...)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+	if _, err := cli(t, "ban", "add", "-h"); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("ban add -h: error = %v, want flag.ErrHelp", err)
+	}
+}
+
+// cli runs a command line and returns what it printed, stdout and stderr
+// together.
+func cli(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = f, f
+	err = runCLI(args)
+	os.Stdout, os.Stderr = stdout, stderr
+	out, rerr := os.ReadFile(f.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return string(out), err
+}
+
+// cliEnv writes a config with its database and backups in a temp dir, and
+// returns a runner for commands against it.
+func cliEnv(t *testing.T) (dir string, run func(args ...string) string) {
+	t.Helper()
+	dir = t.TempDir()
+	path := writeConfig(t, dir, `database = "DIR/state.db"
+
+[backup]
+dir = "DIR/backups"
+
+[[routes]]
+url = "files.example.com:80"
+type = "static"
+target = "DIR"
+`)
+	return dir, func(args ...string) string {
+		t.Helper()
+		out, err := cli(t, append(args, "-config", path)...)
+		if err != nil {
+			t.Fatalf("remazarin %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return out
+	}
+}
+
+// wantOutput fails unless out contains every one of want.
+func wantOutput(t *testing.T, out string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("output lacks %q:\n%s", w, out)
+		}
+	}
+}
+
+func TestCLIUser(t *testing.T) {
+	dir, run := cliEnv(t)
+	wantOutput(t, run("group", "add", "ops", "-description", "operators"), "created group ops")
+	wantOutput(t, run("user", "add", "alice", "-password", "pw", "-group", "ops"), "created user alice")
+	wantOutput(t, run("user", "add", "bob", "-password", "pw"), "created user bob")
+	wantOutput(t, run("user", "list"), "alice", "ops", "bob")
+	wantOutput(t, run("user", "passwd", "alice", "-password", "pw2", "-revoke"), "password of alice changed", "0 session(s) revoked")
+	wantOutput(t, run("user", "delete", "bob"), "deleted user bob")
+	if out := run("user", "list"); strings.Contains(out, "bob") {
+		t.Errorf("bob still listed:\n%s", out)
+	}
+	if _, err := cli(t, "user", "add", "carol", "-password", "pw", "-group", "nope", "-config", filepath.Join(dir, "config.toml")); err == nil {
+		t.Error("user add with an unknown group succeeded")
+	}
+	if out := run("user", "list"); strings.Contains(out, "carol") {
+		t.Errorf("a failed user add left the user:\n%s", out)
+	}
+	wantOutput(t, run("sessions", "revoke", "-all"), "0 session(s) revoked")
+}
+
+func TestCLIBan(t *testing.T) {
+	_, run := cliEnv(t)
+	wantOutput(t, run("ban", "add", "203.0.113.9", "-duration", "1h", "-reason", "abuse"),
+		"banned 203.0.113.9", "not published to the cluster", "next cache refresh")
+	wantOutput(t, run("ban", "list"), "203.0.113.9", "abuse")
+	wantOutput(t, run("ban", "remove", "203.0.113.9"), "unbanned 203.0.113.9", "not published to the cluster")
+	if out := run("ban", "list"); strings.Contains(out, "203.0.113.9") {
+		t.Errorf("ban still listed:\n%s", out)
+	}
+}
+
+// A route imported from a policy file is listed, and exporting gives back
+// a document that imports as no change.
+func TestCLIPolicyAndRoutes(t *testing.T) {
+	dir, run := cliEnv(t)
+	policy := filepath.Join(dir, "policy.json")
+	os.WriteFile(policy, []byte(`{"version": 1,
+		"groups": [{"name": "ops"}],
+		"routes": [{"url": "app.example.com:80", "source": "ui", "type": "proxy", "target": "10.0.0.5:80", "allowed_groups": ["ops"]}]}`), 0o600)
+
+	wantOutput(t, run("import", policy, "-dry-run"), "+ group ops", "+ route app.example.com:80", "2 change(s) to apply")
+	if out := run("route", "list"); strings.Contains(out, "app.example.com") {
+		t.Errorf("dry run created the route:\n%s", out)
+	}
+	wantOutput(t, run("import", policy), "2 change(s) applied", "restart the proxy")
+	wantOutput(t, run("route", "list"), "app.example.com:80", "10.0.0.5:80", "ui", "ops", "files.example.com:80", "config")
+
+	exported := filepath.Join(dir, "exported.yaml")
+	run("export", "-o", exported)
+	data, err := os.ReadFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutput(t, string(data), "app.example.com:80", "files.example.com:80")
+	wantOutput(t, run("import", exported), "no changes")
+}
+
+func TestCLIBackupAndRestore(t *testing.T) {
+	dir, run := cliEnv(t)
+	run("user", "add", "alice", "-password", "pw")
+	out := run("backup")
+	snapshot := strings.TrimSpace(out)
+	if filepath.Dir(snapshot) != filepath.Join(dir, "backups") {
+		t.Fatalf("backup went to %q", snapshot)
+	}
+	wantOutput(t, run("restore", "-check", snapshot), "ok, schema version")
+
+	run("user", "delete", "alice")
+	wantOutput(t, run("restore", snapshot), "restored "+snapshot, "previous database saved as")
+	wantOutput(t, run("user", "list"), "alice")
+
+	other := filepath.Join(dir, "copy.db")
+	if got := strings.TrimSpace(run("backup", "-out", other)); got != other {
+		t.Errorf("backup -out printed %q", got)
+	}
+	if _, err := cli(t, "restore", filepath.Join(dir, "policy.json"), "-config", filepath.Join(dir, "config.toml")); err == nil {
+		t.Error("restored from a file that isn't there")
+	}
+}
+
+func TestCLIConfigCheck(t *testing.T) {
+	_, run := cliEnv(t)
+	wantOutput(t, run("config", "check", "-offline"), "ok (1 routes)")
+	wantOutput(t, run("config", "validate"), "ok (1 routes)")
+
+	bad := writeConfig(t, t.TempDir(), "database = \"DIR/state.db\"\n\n[[routes]]\nurl = \"a.example.com\"\ntarget = \"x:80\"\n")
+	out, err := cli(t, "config", "check", "-offline", "-config", bad)
+	if err == nil || !strings.Contains(err.Error(), bad+": 1 problem(s)") {
+		t.Errorf("bad config: %v", err)
+	}
+	wantOutput(t, out, bad+":4: routes[0].url: invalid url")
+}
+
+// writeConfig writes config.toml into dir, replacing DIR with dir.
+func writeConfig(t *testing.T, dir, config string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(config, "DIR", dir)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Every problem is reported, each at the line of the config file that sets
+// it; routes added in the admin panel are named by their URL.
+func TestCheckConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `database = "DIR/state.db"
+
+[web]
+enabled = true
+url = "localhost:8080"
+target = "DIR"
+
+[admin]
+enabled = true
+url = "localhost:8081"
+target = "DIR"
+tls = true
+
+[prometheus]
+enabled = true
+listen = ":9464"
+
+[[listeners]]
+port = 0
+
+[[routes]]
+url = "a.example.com:80"
+target = "gone.invalid:80"
+
+[[routes]]
+url = "b.example.com:80"
+target = "backend:80"
+response_headers = ["bogus rule"]
+
+[[routes]]
+url = ":9464"
+type = "tcp"
+target = "db:5432"
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := []storage.Route{
+		{Url: "a.example.com:80", Target: "old:80", Source: "ui"},
+		{Url: "c.example.com:8080", Target: "c:80", Source: "ui", Tls: true},
+		{Url: "d.example.com:80", Target: "d:80", Source: "config"}, // a config route of an earlier run
+	}
+	lookup := func(_ context.Context, host string) error {
+		if strings.HasSuffix(host, ".invalid") {
+			return errors.New("no such host")
+		}
+		return nil
+	}
+
+	want := []string{
+		path + ":19: listeners[0].port: 0 is not a port",
+		path + ":28: routes[1].response_headers: ",
+		path + ":22: routes[0].url: a route for a.example.com:80 was added in the admin panel",
+		path + ":23: routes[0].target: no such host",
+		path + ":31: routes[2].url: port 9464 is also used by prometheus.listen",
+		path + ":8: admin.cert: tls is on but no cert is given",
+		"admin panel route c.example.com:8080: tls: port 8080 has routes with tls on and off; one listener can't serve both (see " + path + ":3 web)",
+		"admin panel route c.example.com:8080: cert: tls is on but no cert is given",
+	}
+	errs := checkConfig(context.Background(), cfg, ui, lookup)
+	if len(errs) != len(want) {
+		t.Errorf("%d problems, want %d", len(errs), len(want))
+	}
+	for i, err := range errs {
+		if i >= len(want) || !strings.HasPrefix(err.Error(), want[i]) {
+			t.Errorf("problem %d: %v", i, err)
+		}
+	}
+
+	// Offline, nothing is resolved and only the config file is checked.
+	for _, err := range checkConfig(context.Background(), cfg, nil, nil) {
+		if strings.Contains(err.Error(), "routes[0].target") || strings.Contains(err.Error(), "admin panel") {
+			t.Errorf("offline check reported %v", err)
+		}
+	}
+}
+
+// The [log], [otel], [access_log] and [cache] settings serve would refuse are
+// reported at their keys, without opening any log output.
+func TestCheckConfigSections(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `database = "DIR/state.db"
+
+[log]
+level = "loud"
+format = "xml"
+rotate = "weekly"
+outputs = ["file", "kafka"]
+file = "DIR/logs/app.log"
+
+[log.subsystems]
+auth = "chatty"
+
+[otel]
+enabled = true
+sampler = "bogus"
+ca_file = "DIR/ca.pem"
+
+[access_log]
+stdout = "nope"
+db_retention_days = -1
+
+[cache]
+dir = "DIR/cache"
+`)
+	if err := os.WriteFile(filepath.Join(dir, "cache"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		path + `:4: log.level: log level "loud"`,
+		path + `:11: log.subsystems.auth: log level "chatty" for auth`,
+		path + `:5: log.format: log format "xml"`,
+		path + `:6: log.rotate: log rotate "weekly"`,
+		path + `:7: log.outputs: log output "kafka"`,
+		path + `:15: otel.sampler: otel sampler "bogus"`,
+		path + `:13: otel.tls: otel ca_file, cert_file and key_file need tls = true`,
+		path + `:20: access_log.db_retention_days: access_log db_retention_days must not be negative`,
+		path + `:19: access_log.stdout: access_log stdout "nope"`,
+		path + `:23: cache.dir: cache dir ` + filepath.Join(dir, "cache") + `: not a directory`,
+	}
+	errs := checkConfig(context.Background(), cfg, nil, nil)
+	if len(errs) != len(want) {
+		t.Errorf("%d problems, want %d", len(errs), len(want))
+	}
+	for i, err := range errs {
+		if i >= len(want) || !strings.HasPrefix(err.Error(), want[i]) {
+			t.Errorf("problem %d: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logs")); err == nil {
+		t.Error("config check opened a log file")
+	}
+}
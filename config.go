@@ -1,16 +1,43 @@
 package main
 
 import (
-	"github.com/BurntSushi/toml"
+	"fmt"
+	"os"
+	"reMazarin/proxy"
+	"reMazarin/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/mdobak/go-xerrors"
 )
 
 type Config struct {
-	Web      WebConfig   `toml:"web"`
-	Database string      `toml:"database"`
-	Admin    AdminConfig `toml:"admin"`
-	Otel     OtelConfig  `toml:"otel"`
-	Routes   []Route     `toml:"routes"`
+	Web          WebConfig        `toml:"web"`
+	Database     string           `toml:"database"`
+	DatabaseFile string           `toml:"database_file"` // read database from this file instead
+	Admin        AdminConfig      `toml:"admin"`
+	Otel         OtelConfig       `toml:"otel"`
+	Cache        CacheConfig      `toml:"cache"`
+	Redirect     RedirectConfig   `toml:"redirect"`
+	Listeners    []ListenerConfig `toml:"listeners"`
+	Routes       []Route          `toml:"routes"`
+
+	SecurityProfiles map[string]SecurityProfileConfig `toml:"security_profiles"`
+	ErrorPages       ErrorPagesConfig                 `toml:"error_pages"`
+	RequestID        RequestIDConfig                  `toml:"request_id"`
+	AccessLog        AccessLogConfig                  `toml:"access_log"`
+	Prometheus       PrometheusConfig                 `toml:"prometheus"`
+	Log              LogConfig                        `toml:"log"`
+	Cluster          ClusterConfig                    `toml:"cluster"`
+	Backup           BackupConfig                     `toml:"backup"`
+	Policy           PolicyConfig                     `toml:"policy"`
+
+	// Where the settings came from, for pointing errors at them.
+	path       string
+	lines      *configLines
+	fileRoutes int // cfg.Routes past this many are [web] and [admin]
 }
 
 type WebConfig struct {
@@ -20,6 +47,8 @@ type WebConfig struct {
 	Tls     bool   `toml:"tls"`
 	Cert    string `toml:"cert"`
 	Key     string `toml:"key"`
+
+	SecurityProfile string `toml:"security_profile"` // default "strict"
 }
 
 type AdminConfig struct {
@@ -29,6 +58,8 @@ type AdminConfig struct {
 	Tls     bool   `toml:"tls"`
 	Cert    string `toml:"cert"`
 	Key     string `toml:"key"`
+
+	SecurityProfile string `toml:"security_profile"` // default "strict"
 }
 
 type OtelConfig struct {
@@ -37,6 +68,128 @@ type OtelConfig struct {
 	ServiceName     string `toml:"service_name"`     // OTel service.name resource attribute (default "remazarin")
 	Interval        int    `toml:"interval"`         // metric export interval, seconds (default 15)
 	RuntimeInterval int    `toml:"runtime_interval"` // Go runtime memstats read interval, seconds (default 30)
+
+	Sampler     string  `toml:"sampler"`      // always_on, always_off, traceidratio or parentbased_* (default "traceidratio")
+	SampleRatio float64 `toml:"sample_ratio"` // ratio of the traceidratio samplers (default 0.1)
+
+	// The OTLP connection is plain gRPC unless tls is set.
+	TLS      bool              `toml:"tls"`
+	CAFile   string            `toml:"ca_file"`   // PEM roots for the collector; "" = system roots
+	CertFile string            `toml:"cert_file"` // client certificate for mutual TLS
+	KeyFile  string            `toml:"key_file"`
+	Headers  map[string]string `toml:"headers"` // sent with every export, e.g. Authorization
+
+	HeadersFile string `toml:"headers_file"` // read headers from this file instead, one key=value per line
+}
+
+// CacheConfig sizes the response cache shared by every route with cache = true.
+type CacheConfig struct {
+	MemoryMB    int    `toml:"memory_mb"`     // in-memory LRU bound (default 64)
+	Dir         string `toml:"dir"`           // on-disk tier; "" = memory only
+	DiskMB      int    `toml:"disk_mb"`       // on-disk tier bound (default 1024)
+	MaxObjectKB int    `toml:"max_object_kb"` // larger responses are not cached (default 1024)
+}
+
+// RedirectConfig enables the plain-HTTP listener that redirects every host with
+// a TLS route to HTTPS and serves ACME HTTP-01 challenges.
+type RedirectConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Port    int    `toml:"port"`     // default 80
+	Code    int    `toml:"code"`     // 301, 302, 307 or 308; default 308 (also used by redirect_hosts)
+	ACMEDir string `toml:"acme_dir"` // webroot with .well-known/acme-challenge/; "" = none
+}
+
+// ErrorPagesConfig replaces the proxy's plain-text error bodies with templated
+// HTML pages (JSON for clients that prefer it).
+type ErrorPagesConfig struct {
+	Dir      string `toml:"dir"`       // <code>.html and default.html templates; "" = built-in page
+	LoginURL string `toml:"login_url"` // linked from auth errors; default the [web] host
+}
+
+// RequestIDConfig lists the peers whose X-Request-Id or traceparent is reused
+// as the request ID instead of a generated one.
+type RequestIDConfig struct {
+	TrustedIPs []string `toml:"trusted_ips"` // IPs and CIDRs; default none
+}
+
+// AccessLogConfig selects where the access log is written besides the admin
+// panel's DB table, and how long the table keeps it.
+type AccessLogConfig struct {
+	File            string `toml:"file"`              // JSON lines; "" = none
+	MaxSizeMB       int    `toml:"max_size_mb"`       // rotate past this size (default 100)
+	MaxBackups      int    `toml:"max_backups"`       // rotated files kept (default 5)
+	Stdout          string `toml:"stdout"`            // "common" or "combined"; "" = none
+	DBMaxRows       int    `toml:"db_max_rows"`       // default 5000; -1 = don't log to the DB
+	DBRetentionDays int    `toml:"db_retention_days"` // default none
+}
+
+// PrometheusConfig enables the Prometheus scrape endpoint, which exposes the
+// same metrics as the OTLP exporter without needing a collector.
+type PrometheusConfig struct {
+	Enabled         bool     `toml:"enabled"`
+	Listen          string   `toml:"listen"`            // e.g. ":9464"; "" = serve on the [admin] host
+	Path            string   `toml:"path"`              // default "/metrics"
+	AllowedIPs      []string `toml:"allowed_ips"`       // IPs and CIDRs; default anyone
+	BearerToken     string   `toml:"bearer_token"`      // required from scrapers if set
+	BearerTokenFile string   `toml:"bearer_token_file"` // read bearer_token from this file instead
+}
+
+// LogConfig configures the application log: level, format, where it goes and
+// per-subsystem level overrides.
+type LogConfig struct {
+	Level      string            `toml:"level"`       // debug, info, warn, error; default "info"
+	Format     string            `toml:"format"`      // "json" or "text"; default "json"
+	Outputs    []string          `toml:"outputs"`     // stdout, file, syslog, journald; default ["stdout"]
+	File       string            `toml:"file"`        // path for the "file" output
+	MaxSizeMB  int               `toml:"max_size_mb"` // rotate past this size (default 100)
+	MaxBackups int               `toml:"max_backups"` // rotated files kept (default 5)
+	Rotate     string            `toml:"rotate"`      // also rotate "hourly" or "daily"; "" = by size only
+	Syslog     string            `toml:"syslog"`      // e.g. "udp://logs:514"; "" = local daemon
+	Subsystems map[string]string `toml:"subsystems"`  // auth, limiter, tcp, udp, storage -> level
+}
+
+// ClusterConfig joins instances that share one database into a cluster that
+// exchanges bans, connection kills, route changes and rate-limit usage.
+type ClusterConfig struct {
+	Enabled        bool     `toml:"enabled"`
+	NodeID         string   `toml:"node_id"`          // default <hostname><listen>
+	Listen         string   `toml:"listen"`           // cluster listener, e.g. ":7946"
+	Peers          []string `toml:"peers"`            // the other nodes' listeners, e.g. "10.0.0.2:7946"
+	Secret         string   `toml:"secret"`           // shared by every node; signs the events
+	SecretFile     string   `toml:"secret_file"`      // read secret from this file instead
+	SyncIntervalMs int      `toml:"sync_interval_ms"` // rate-limit usage exchange (default 1000)
+}
+
+// BackupConfig places and schedules online snapshots of the SQLite database.
+type BackupConfig struct {
+	Dir           string `toml:"dir"`            // default "./backups"
+	IntervalHours int    `toml:"interval_hours"` // 0 = only on demand
+	Keep          int    `toml:"keep"`           // newest snapshots kept (default 7; -1 = all)
+}
+
+// PolicyConfig hands the admin state to a policy document (see remazarin
+// export): every section the file has is applied at startup, re-applied when
+// the file changes, and read-only in the admin panel.
+type PolicyConfig struct {
+	File      string `toml:"file"`       // "" = the admin panel owns everything
+	ReloadSec int    `toml:"reload_sec"` // how often the file is checked for changes (default 30)
+}
+
+// SecurityProfileConfig defines a named security-header profile. Headers maps
+// header names to values; an empty value drops a header inherited from Extends.
+type SecurityProfileConfig struct {
+	Extends string            `toml:"extends"` // "strict", "basic", "none" or another profile
+	Headers map[string]string `toml:"headers"`
+}
+
+// ListenerConfig overrides the HTTP server timeouts of one listening port.
+// Timeouts are seconds: 0 keeps the built-in default, -1 disables the limit.
+type ListenerConfig struct {
+	Port              int `toml:"port"`
+	ReadHeaderTimeout int `toml:"read_header_timeout"` // default 10
+	ReadTimeout       int `toml:"read_timeout"`        // default none
+	WriteTimeout      int `toml:"write_timeout"`       // default none
+	IdleTimeout       int `toml:"idle_timeout"`        // default 120
 }
 
 type Route struct {
@@ -46,15 +199,318 @@ type Route struct {
 	Tls    bool   `toml:"tls"`
 	Cert   string `toml:"cert"`
 	Key    string `toml:"key"`
+
+	// Backend transport tuning for proxy routes. Seconds / connection counts:
+	// 0 keeps the built-in default, -1 disables the limit.
+	DialTimeout           int `toml:"dial_timeout"`            // default 10
+	TLSHandshakeTimeout   int `toml:"tls_handshake_timeout"`   // default 10
+	ResponseHeaderTimeout int `toml:"response_header_timeout"` // default 60
+	RequestTimeout        int `toml:"request_timeout"`         // default none
+	IdleConnTimeout       int `toml:"idle_conn_timeout"`       // default 90
+	MaxIdleConns          int `toml:"max_idle_conns"`          // default 100
+	MaxIdleConnsPerHost   int `toml:"max_idle_conns_per_host"` // default 10
+
+	// Retry/failover policy for proxy routes. target may list several backends
+	// ("a:80,b:80"); each retry moves on to the next one.
+	RetryAttempts      int    `toml:"retry_attempts"`       // total tries; 0/1 = no retries
+	RetryOn            string `toml:"retry_on"`             // default "connect,502,503,504"
+	RetryBackoffMs     int    `toml:"retry_backoff_ms"`     // default 50
+	RetryBudgetPct     int    `toml:"retry_budget_pct"`     // default 20
+	RetryNonIdempotent bool   `toml:"retry_non_idempotent"` // default false
+
+	// Circuit breaker for proxy and tcp routes, per backend. Off while both
+	// breaker_error_pct and breaker_latency_ms are 0.
+	BreakerErrorPct    int    `toml:"breaker_error_pct"`    // % of bad calls that opens the circuit
+	BreakerLatencyMs   int    `toml:"breaker_latency_ms"`   // slower calls count as bad
+	BreakerWindowSec   int    `toml:"breaker_window_sec"`   // default 30
+	BreakerMinRequests int    `toml:"breaker_min_requests"` // default 20
+	BreakerOpenSec     int    `toml:"breaker_open_sec"`     // default 30
+	BreakerFallback    string `toml:"breaker_fallback"`     // backend or static path; default 503
+
+	// Response cache for proxy routes, sized by [cache].
+	Cache           bool `toml:"cache"`             // default false
+	CacheDefaultTTL int  `toml:"cache_default_ttl"` // seconds, for responses without freshness info
+
+	// Response compression for proxy and static routes.
+	Compress        bool   `toml:"compress"`          // default false
+	CompressTypes   string `toml:"compress_types"`    // MIME allowlist; default text/*, JS, JSON, XML, SVG, wasm
+	CompressMinSize int    `toml:"compress_min_size"` // bytes; default 1024
+
+	// Header rewrite rules for HTTP routes, one "<set|add|append|remove> Name
+	// [value]" rule per entry. Request rules apply to proxy routes only.
+	RequestHeaders  []string `toml:"request_headers"`
+	ResponseHeaders []string `toml:"response_headers"`
+
+	// Security-header profile for HTTP routes. Profile headers are only added
+	// when the response lacks them; security_headers overrides single headers
+	// ("" drops one).
+	SecurityProfile string            `toml:"security_profile"` // "strict", "basic", a [security_profiles] name; default none
+	SecurityHeaders map[string]string `toml:"security_headers"`
+
+	// Extra hosts (e.g. www.example.com) redirected to this route's host on the
+	// same listener, keeping path and query.
+	RedirectHosts []string `toml:"redirect_hosts"`
+
+	// Redirect routes (type = "redirect"): target is the Location template.
+	RedirectCode  int    `toml:"redirect_code"`  // 301, 302, 303, 307 or 308; default 302
+	RedirectMatch string `toml:"redirect_match"` // path regexp whose groups ($1, ${name}) target may use
+
+	// Upstream path rewrite rules for proxy routes, one "<pattern>
+	// <replacement>" rule per entry; the first match wins.
+	Rewrite []string `toml:"rewrite"`
+
+	// Directory of error-page templates overriding [error_pages] for this route.
+	ErrorPages string `toml:"error_pages"`
+}
+
+// transport returns the route's backend transport tuning in storage form.
+func (r Route) transport() storage.RouteTransport {
+	return storage.RouteTransport{
+		DialTimeout:           r.DialTimeout,
+		TLSHandshakeTimeout:   r.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: r.ResponseHeaderTimeout,
+		RequestTimeout:        r.RequestTimeout,
+		IdleConnTimeout:       r.IdleConnTimeout,
+		MaxIdleConns:          r.MaxIdleConns,
+		MaxIdleConnsPerHost:   r.MaxIdleConnsPerHost,
+	}
+}
+
+// retry returns the route's retry/failover policy in storage form.
+func (r Route) retry() storage.RouteRetry {
+	return storage.RouteRetry{
+		Attempts:      r.RetryAttempts,
+		On:            r.RetryOn,
+		BackoffMs:     r.RetryBackoffMs,
+		BudgetPct:     r.RetryBudgetPct,
+		NonIdempotent: r.RetryNonIdempotent,
+	}
+}
+
+// breaker returns the route's circuit-breaker policy in storage form.
+func (r Route) breaker() storage.RouteBreaker {
+	return storage.RouteBreaker{
+		ErrorPct:    r.BreakerErrorPct,
+		LatencyMs:   r.BreakerLatencyMs,
+		WindowSec:   r.BreakerWindowSec,
+		MinRequests: r.BreakerMinRequests,
+		OpenSec:     r.BreakerOpenSec,
+		Fallback:    r.BreakerFallback,
+	}
+}
+
+// cache returns the route's response-cache policy in storage form.
+func (r Route) cache() storage.RouteCache {
+	return storage.RouteCache{Enabled: r.Cache, DefaultTTL: r.CacheDefaultTTL}
+}
+
+// compress returns the route's compression policy in storage form.
+func (r Route) compress() storage.RouteCompress {
+	return storage.RouteCompress{Enabled: r.Compress, Types: r.CompressTypes, MinSize: r.CompressMinSize}
+}
+
+// headers returns the route's header rewrite rules in storage form.
+func (r Route) headers() storage.RouteHeaders {
+	return storage.RouteHeaders{
+		Request:  strings.Join(r.RequestHeaders, "\n"),
+		Response: strings.Join(r.ResponseHeaders, "\n"),
+	}
+}
+
+// security returns the route's security-header profile in storage form.
+func (r Route) security() storage.RouteSecurity {
+	lines := make([]string, 0, len(r.SecurityHeaders))
+	for name, value := range r.SecurityHeaders {
+		lines = append(lines, name+": "+value)
+	}
+	sort.Strings(lines)
+	return storage.RouteSecurity{Profile: r.SecurityProfile, Headers: strings.Join(lines, "\n")}
 }
 
+// redirectHosts returns the route's canonical-host aliases in storage form.
+func (r Route) redirectHosts() string {
+	return strings.Join(r.RedirectHosts, ",")
+}
+
+// redirect returns the route's redirect settings in storage form.
+func (r Route) redirect() storage.RouteRedirect {
+	return storage.RouteRedirect{Code: r.RedirectCode, Match: r.RedirectMatch}
+}
+
+// rewrite returns the route's upstream rewrite rules in storage form.
+func (r Route) rewrite() string {
+	return strings.Join(r.Rewrite, "\n")
+}
+
+// securityProfiles converts [security_profiles] into the proxy's form.
+func (c *Config) securityProfiles() map[string]proxy.SecurityProfile {
+	m := make(map[string]proxy.SecurityProfile, len(c.SecurityProfiles))
+	for name, p := range c.SecurityProfiles {
+		m[name] = proxy.SecurityProfile{Extends: p.Extends, Headers: p.Headers}
+	}
+	return m
+}
+
+// redirect converts [redirect] into the proxy's form.
+func (c *Config) redirect() proxy.RedirectConfig {
+	rc := proxy.RedirectConfig{Enabled: c.Redirect.Enabled, Code: c.Redirect.Code, ACMEDir: c.Redirect.ACMEDir}
+	if c.Redirect.Port > 0 {
+		rc.Port = strconv.Itoa(c.Redirect.Port)
+	}
+	return rc
+}
+
+// errorPages converts [error_pages] into the proxy's form. Auth errors link to
+// the [web] login host unless login_url says otherwise.
+func (c *Config) errorPages() proxy.ErrorPagesConfig {
+	ep := proxy.ErrorPagesConfig{Dir: c.ErrorPages.Dir, LoginURL: c.ErrorPages.LoginURL}
+	if ep.LoginURL == "" && c.Web.Enabled {
+		scheme, host := "http", c.Web.Url
+		if c.Web.Tls {
+			scheme = "https"
+		}
+		if h, port, ok := strings.Cut(host, ":"); ok && (port == "80" && !c.Web.Tls || port == "443" && c.Web.Tls) {
+			host = h
+		}
+		ep.LoginURL = scheme + "://" + host + "/"
+	}
+	return ep
+}
+
+// requestID converts [request_id] into the proxy's form.
+func (c *Config) requestID() proxy.RequestIDConfig {
+	return proxy.RequestIDConfig{TrustedIPs: c.RequestID.TrustedIPs}
+}
+
+// accessLog converts [access_log] into the proxy's form.
+func (c *Config) accessLog() proxy.AccessLogConfig {
+	a := c.AccessLog
+	return proxy.AccessLogConfig{
+		File: a.File, MaxSizeMB: a.MaxSizeMB, MaxBackups: a.MaxBackups, Stdout: a.Stdout,
+		DBMaxRows: a.DBMaxRows, DBRetentionDays: a.DBRetentionDays,
+	}
+}
+
+// cache converts [cache] into the proxy's form.
+func (c *Config) cache() proxy.CacheConfig {
+	ca := c.Cache
+	return proxy.CacheConfig{MemoryMB: ca.MemoryMB, Dir: ca.Dir, DiskMB: ca.DiskMB, MaxObjectKB: ca.MaxObjectKB}
+}
+
+// prometheus converts [prometheus]; without a listen address the endpoint is
+// served on the admin host.
+func (c *Config) prometheus() proxy.PrometheusConfig {
+	p := c.Prometheus
+	cfg := proxy.PrometheusConfig{
+		Enabled: p.Enabled, Listen: p.Listen, Path: p.Path,
+		AllowedIPs: p.AllowedIPs, BearerToken: p.BearerToken,
+	}
+	if c.Admin.Enabled {
+		cfg.AdminURL = c.Admin.Url
+	}
+	return cfg
+}
+
+// log converts [log]; errors are logged with their stack trace.
+func (c *Config) log() proxy.LogConfig {
+	l := c.Log
+	return proxy.LogConfig{
+		Level: l.Level, Format: l.Format, Outputs: l.Outputs,
+		File: l.File, MaxSizeMB: l.MaxSizeMB, MaxBackups: l.MaxBackups, Rotate: l.Rotate,
+		Syslog: l.Syslog, Subsystems: l.Subsystems,
+		ReplaceAttr: expandErrors,
+	}
+}
+
+// cluster converts [cluster].
+func (c *Config) cluster() proxy.ClusterConfig {
+	cl := c.Cluster
+	return proxy.ClusterConfig{
+		Enabled: cl.Enabled, NodeID: cl.NodeID, Listen: cl.Listen, Peers: cl.Peers, Secret: cl.Secret,
+		SyncInterval: time.Duration(cl.SyncIntervalMs) * time.Millisecond,
+	}
+}
+
+// configRoutes converts [[routes]] into the form storage syncs them in.
+func (c *Config) configRoutes() []storage.ConfigRoute {
+	out := make([]storage.ConfigRoute, len(c.Routes))
+	for i, r := range c.Routes {
+		out[i] = storage.ConfigRoute{
+			Url: r.Url, Target: r.Target, Type: r.Type,
+			Tls: r.Tls, Cert: r.Cert, Key: r.Key,
+			Transport: r.transport(),
+			Retry:     r.retry(),
+			Breaker:   r.breaker(),
+			Cache:     r.cache(),
+			Compress:  r.compress(),
+			Headers:   r.headers(),
+			Security:  r.security(),
+
+			RedirectHosts: r.redirectHosts(),
+			Redirect:      r.redirect(),
+			Rewrite:       r.rewrite(),
+			ErrorPages:    r.ErrorPages,
+		}
+	}
+	return out
+}
+
+// routeKey names route i of c.Routes as the config file sets it.
+func (c *Config) routeKey(i int) string {
+	if i < c.fileRoutes {
+		return fmt.Sprintf("routes[%d]", i)
+	}
+	if i == c.fileRoutes && c.Web.Enabled {
+		return "web"
+	}
+	return "admin"
+}
+
+// problem locates err at a setting: "config.toml:12: routes[0].target: ...".
+func (c *Config) problem(key string, err error) error {
+	if c.lines == nil {
+		return xerrors.Newf("%s: %w", key, err)
+	}
+	return xerrors.Newf("%s: %s: %w", c.lines.position(c.path, c.lines.line(key)), key, err)
+}
+
+// listenerTimeouts converts [[listeners]] into the proxy's per-port overrides.
+func (c *Config) listenerTimeouts() map[string]proxy.ListenerTimeouts {
+	m := make(map[string]proxy.ListenerTimeouts, len(c.Listeners))
+	for _, l := range c.Listeners {
+		m[strconv.Itoa(l.Port)] = proxy.ListenerTimeouts{
+			ReadHeaderTimeout: l.ReadHeaderTimeout,
+			ReadTimeout:       l.ReadTimeout,
+			WriteTimeout:      l.WriteTimeout,
+			IdleTimeout:       l.IdleTimeout,
+		}
+	}
+	return m
+}
+
+// loadConfig reads the config file, applies the environment to it (see
+// configload.go) and fills in defaults.
 func loadConfig(path string) (*Config, error) {
 	var cfg Config
 
-	if _, err := toml.DecodeFile(path, &cfg); err != nil {
-		return nil, xerrors.Newf("decode config: %w", err)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Newf("read config: %w", err)
+	}
+	if err := decodeConfig(path, data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := expandEnv(path, data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(&cfg, os.Environ()); err != nil {
+		return nil, err
+	}
+	if err := readSecrets(&cfg); err != nil {
+		return nil, err
 	}
 
+	cfg.path, cfg.lines, cfg.fileRoutes = path, scanConfigLines(data), len(cfg.Routes)
 	validateConfig(&cfg)
 	generateAuthAdm(&cfg)
 
@@ -73,12 +529,29 @@ func validateConfig(cfg *Config) {
 		cfg.Database = "./remazarin.db"
 	}
 
+	if cfg.Backup.Dir == "" {
+		cfg.Backup.Dir = "./backups"
+	}
+	if cfg.Backup.Keep == 0 {
+		cfg.Backup.Keep = 7
+	}
+
+	if cfg.Policy.ReloadSec <= 0 {
+		cfg.Policy.ReloadSec = 30
+	}
+
 	if cfg.Admin.Url == "" {
 		cfg.Admin.Url = "localhost:8081"
 	}
 	if cfg.Admin.Target == "" {
 		cfg.Admin.Target = "./www/admin"
 	}
+	if cfg.Web.SecurityProfile == "" {
+		cfg.Web.SecurityProfile = "strict"
+	}
+	if cfg.Admin.SecurityProfile == "" {
+		cfg.Admin.SecurityProfile = "strict"
+	}
 
 	if cfg.Otel.ServiceName == "" {
 		cfg.Otel.ServiceName = "remazarin"
@@ -89,6 +562,12 @@ func validateConfig(cfg *Config) {
 	if cfg.Otel.RuntimeInterval <= 0 {
 		cfg.Otel.RuntimeInterval = 30
 	}
+	if cfg.Otel.Sampler == "" {
+		cfg.Otel.Sampler = "traceidratio"
+	}
+	if cfg.Otel.SampleRatio == 0 {
+		cfg.Otel.SampleRatio = 0.1
+	}
 }
 
 func generateAuthAdm(cfg *Config) {
@@ -100,6 +579,9 @@ func generateAuthAdm(cfg *Config) {
 			Tls:    cfg.Web.Tls,
 			Cert:   cfg.Web.Cert,
 			Key:    cfg.Web.Key,
+			// The login pages and their scripts are text: always worth compressing.
+			Compress:        true,
+			SecurityProfile: cfg.Web.SecurityProfile,
 		}
 		cfg.Routes = append(cfg.Routes, webRoute)
 	}
@@ -112,6 +594,9 @@ func generateAuthAdm(cfg *Config) {
 			Tls:    cfg.Admin.Tls,
 			Cert:   cfg.Admin.Cert,
 			Key:    cfg.Admin.Key,
+			// The admin panel's JS and CSS are text: always worth compressing.
+			Compress:        true,
+			SecurityProfile: cfg.Admin.SecurityProfile,
 		}
 		cfg.Routes = append(cfg.Routes, admRoute)
 	}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRouteKey(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		web, admin bool
+		want       []string
+	}{
+		{"web and admin", true, true, []string{"routes[0]", "routes[1]", "web", "admin"}},
+		{"admin only", false, true, []string{"routes[0]", "routes[1]", "admin"}},
+		{"web only", true, false, []string{"routes[0]", "routes[1]", "web"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{Routes: make([]Route, 2), fileRoutes: 2}
+			cfg.Web.Enabled, cfg.Admin.Enabled = tc.web, tc.admin
+			generateAuthAdm(cfg)
+			if len(cfg.Routes) != len(tc.want) {
+				t.Fatalf("%d routes, want %d", len(cfg.Routes), len(tc.want))
+			}
+			for i, want := range tc.want {
+				if got := cfg.routeKey(i); got != want {
+					t.Errorf("routeKey(%d) = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigProblem(t *testing.T) {
+	data := []byte("[web]\nurl = \"localhost:8080\"\n\n[[routes]]\nurl = \"a:80\"\n\n[[routes]]\nurl = \"b:80\"\ntarget = \"x\"\n")
+	cfg := &Config{path: "config.toml", lines: scanConfigLines(data)}
+	err := errors.New("boom")
+	for _, tc := range []struct{ key, want string }{
+		{"web.url", "config.toml:2: web.url: boom"},
+		{"web.cert", "config.toml:1: web.cert: boom"}, // unset: its section
+		{"routes[1].target", "config.toml:9: routes[1].target: boom"},
+		{"routes[1].cert", "config.toml:7: routes[1].cert: boom"},
+		{"routes[0].url", "config.toml:5: routes[0].url: boom"},
+		{"cluster.listen", "config.toml: cluster.listen: boom"},
+	} {
+		if got := cfg.problem(tc.key, err).Error(); got != tc.want {
+			t.Errorf("problem(%q) = %q, want %q", tc.key, got, tc.want)
+		}
+	}
+	if got := (&Config{}).problem("web.url", err).Error(); got != "web.url: boom" {
+		t.Errorf("without a file: %q", got)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mdobak/go-xerrors"
+)
+
+// Loading config.toml takes these steps, in order:
+//
+//  1. The file is decoded strictly: a key that no setting has is an error.
+//  2. ${NAME} and ${NAME:-default} in string values are replaced with
+//     environment variables.
+//  3. REMAZARIN_<SECTION>_<KEY> environment variables override settings of
+//     the file, e.g. REMAZARIN_OTEL_ENDPOINT or REMAZARIN_DATABASE.
+//  4. Secrets given as *_file settings are read from their files.
+//
+// Defaults are filled in after that, by validateConfig.
+
+// envPrefix starts the environment variables that override settings.
+const envPrefix = "REMAZARIN_"
+
+// decodeConfig decodes a config file, rejecting keys that no setting has.
+func decodeConfig(path string, data []byte, cfg *Config) error {
+	md, err := toml.Decode(string(data), cfg)
+	if err != nil {
+		return xerrors.Newf("decode config: %w", err)
+	}
+	lines := scanConfigLines(data)
+	undecoded := make(map[string]bool)
+	var errs []error
+	for _, key := range md.Undecoded() {
+		name := strings.Join(key, ".")
+		undecoded[name] = true
+		if len(key) > 1 && undecoded[strings.Join(key[:len(key)-1], ".")] {
+			continue // inside an unknown table, which is reported already
+		}
+		errs = append(errs, xerrors.Newf("%s: unknown key %q", lines.position(path, lines.next(name)), name))
+	}
+	return errors.Join(errs...)
+}
+
+// configLines records where each key of a TOML file is set, so errors can
+// point at the line. Elements of arrays of tables are numbered: routes[0].url.
+type configLines struct {
+	keys  []string // in file order
+	lines []int
+	at    map[string]int
+	used  int // keys before this index were handed out by next
+}
+
+func scanConfigLines(data []byte) *configLines {
+	c := &configLines{at: make(map[string]int)}
+	add := func(key string, line int) {
+		c.keys = append(c.keys, key)
+		c.lines = append(c.lines, line)
+		if _, ok := c.at[key]; !ok {
+			c.at[key] = line
+		}
+	}
+	counts := make(map[string]int)
+	table := ""
+	closeString := "" // delimiter of the multi-line string being skipped
+	for i, line := range strings.Split(string(data), "\n") {
+		n := i + 1
+		s := strings.TrimSpace(line)
+		if closeString != "" {
+			if strings.Contains(s, closeString) {
+				closeString = ""
+			}
+			continue
+		}
+		switch {
+		case s == "" || s[0] == '#':
+		case strings.HasPrefix(s, "[["):
+			name := tomlKeyPath(strings.SplitN(s[2:], "]]", 2)[0])
+			table = fmt.Sprintf("%s[%d]", name, counts[name])
+			counts[name]++
+			add(table, n)
+		case s[0] == '[':
+			table = tomlKeyPath(strings.SplitN(s[1:], "]", 2)[0])
+			add(table, n)
+		default:
+			eq := indexUnquoted(s, '=')
+			if eq < 0 {
+				continue // inside a multi-line array
+			}
+			key := tomlKeyPath(s[:eq])
+			if table != "" {
+				key = table + "." + key
+			}
+			add(key, n)
+			for _, delim := range []string{`"""`, `'''`} {
+				if strings.Count(s[eq:], delim)%2 == 1 {
+					closeString = delim
+				}
+			}
+		}
+	}
+	return c
+}
+
+// line returns the line of a key, or of the nearest enclosing table or key
+// that is on record; 0 if none is.
+func (c *configLines) line(key string) int {
+	for key != "" {
+		if n, ok := c.at[key]; ok {
+			return n
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+// next returns the line of the next occurrence of a key given without array
+// indexes, as toml.MetaData lists them. Keys must be asked for in file order.
+func (c *configLines) next(key string) int {
+	for i := c.used; i < len(c.keys); i++ {
+		if arrayIndex.ReplaceAllString(c.keys[i], "") == key {
+			c.used = i + 1
+			return c.lines[i]
+		}
+	}
+	return 0
+}
+
+var arrayIndex = regexp.MustCompile(`\[\d+\]`)
+
+// position formats path:line, or just path when the line is unknown.
+func (c *configLines) position(path string, line int) string {
+	if line == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s:%d", path, line)
+}
+
+// tomlKeyPath normalizes a possibly dotted, possibly quoted TOML key to
+// a.b.c form.
+func tomlKeyPath(s string) string {
+	var parts []string
+	for s != "" {
+		i := indexUnquoted(s, '.')
+		if i < 0 {
+			i = len(s)
+		}
+		part := strings.TrimSpace(s[:i])
+		if len(part) >= 2 && (part[0] == '"' || part[0] == '\'') && part[len(part)-1] == part[0] {
+			part = part[1 : len(part)-1]
+		}
+		parts = append(parts, part)
+		if i == len(s) {
+			break
+		}
+		s = s[i+1:]
+	}
+	return strings.Join(parts, ".")
+}
+
+// indexUnquoted is strings.IndexByte ignoring bytes inside quotes.
+func indexUnquoted(s string, b byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == b:
+			return i
+		}
+	}
+	return -1
+}
+
+// envRef matches ${NAME}, ${NAME:-default} and the escape $${NAME}. Only
+// upper-case names are expanded, so a redirect target's ${group} is left to
+// the redirect.
+var envRef = regexp.MustCompile(`\$(\$?)\{([A-Z_][A-Z0-9_]*)(:-[^}]*)?\}`)
+
+// expandEnv replaces environment references in every string setting.
+func expandEnv(path string, data []byte, cfg *Config) error {
+	lines := scanConfigLines(data)
+	return errors.Join(walkStrings(reflect.ValueOf(cfg).Elem(), "", func(key, s string) (string, error) {
+		var missing []string
+		s = envRef.ReplaceAllStringFunc(s, func(ref string) string {
+			m := envRef.FindStringSubmatch(ref)
+			if m[1] != "" {
+				return ref[1:]
+			}
+			if v, ok := os.LookupEnv(m[2]); ok && (v != "" || m[3] == "") {
+				return v
+			}
+			if m[3] != "" {
+				return m[3][2:]
+			}
+			missing = append(missing, m[2])
+			return ref
+		})
+		if len(missing) > 0 {
+			return "", xerrors.Newf("%s: %s: environment variable %s is not set",
+				lines.position(path, lines.line(key)), key, strings.Join(missing, ", "))
+		}
+		return s, nil
+	})...)
+}
+
+// walkStrings calls fn on every string in v, which is named key, and stores
+// what it returns.
+func walkStrings(v reflect.Value, key string, fn func(key, s string) (string, error)) []error {
+	join := func(name string) string {
+		if key == "" {
+			return name
+		}
+		return key + "." + name
+	}
+	var errs []error
+	switch v.Kind() {
+	case reflect.String:
+		s, err := fn(key, v.String())
+		if err != nil {
+			return []error{err}
+		}
+		v.SetString(s)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if name := tomlName(t.Field(i)); name != "" {
+				errs = append(errs, walkStrings(v.Field(i), join(name), fn)...)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", key, i), fn)...)
+		}
+	case reflect.Map:
+		// Map values can't be set in place: copy, walk and store back.
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			errs = append(errs, walkStrings(e, join(k.String()), fn)...)
+			v.SetMapIndex(k, e)
+		}
+	}
+	return errs
+}
+
+func tomlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+	return name
+}
+
+// applyEnvOverrides sets the settings named by REMAZARIN_* variables in
+// environ. Top-level settings and those of [sections] can be overridden;
+// array tables ([[routes]], [[listeners]]) and [security_profiles] can't.
+// Lists are comma-separated and maps are comma-separated key=value pairs.
+//
+// A variable that starts with a section or top-level key but names no
+// setting is an error, as it is most likely a typo. Others, such as
+// REMAZARIN_CONFIG or REMAZARIN_TEST_POSTGRES, belong to something else.
+func applyEnvOverrides(cfg *Config, environ []string) error {
+	type setting struct {
+		key string
+		v   reflect.Value
+	}
+	settings := make(map[string]setting)
+	add := func(key string, v reflect.Value) {
+		if overridable(v.Type()) {
+			settings[envName(key)] = setting{key, v}
+		}
+	}
+	var roots []string
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		name, v := tomlName(root.Type().Field(i)), root.Field(i)
+		if name == "" {
+			continue
+		}
+		roots = append(roots, envName(name))
+		if v.Kind() != reflect.Struct {
+			add(name, v)
+			continue
+		}
+		for j := 0; j < v.NumField(); j++ {
+			if sub := tomlName(v.Type().Field(j)); sub != "" {
+				add(name+"."+sub, v.Field(j))
+			}
+		}
+	}
+	known := func(name string) bool {
+		for _, r := range roots {
+			if name == r || strings.HasPrefix(name, r+"_") {
+				return true
+			}
+		}
+		return false
+	}
+
+	sort.Strings(environ)
+	var errs []error
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		s, ok := settings[name]
+		if !ok {
+			if known(name) {
+				errs = append(errs, xerrors.Newf("%s: no such setting", name))
+			}
+			continue
+		}
+		if err := setFromEnv(s.v, value); err != nil {
+			errs = append(errs, xerrors.Newf("%s: %w", name, err))
+			continue
+		}
+		// The override replaces the setting however the file gave it.
+		for _, sec := range cfg.secrets() {
+			switch s.key {
+			case sec.key:
+				*sec.file = ""
+			case sec.key + "_file":
+				sec.clear()
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// envName is the variable overriding a setting: otel.endpoint is
+// REMAZARIN_OTEL_ENDPOINT.
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func overridable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func setFromEnv(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return xerrors.Newf("want true or false, got %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return xerrors.Newf("want an integer, got %q", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return xerrors.Newf("want a number, got %q", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		list := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, e := range splitEnvList(s) {
+			list = reflect.Append(list, reflect.ValueOf(e))
+		}
+		v.Set(list)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, e := range splitEnvList(s) {
+			k, val, ok := strings.Cut(e, "=")
+			if !ok {
+				return xerrors.Newf("want key=value pairs, got %q", e)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), reflect.ValueOf(strings.TrimSpace(val)))
+		}
+		v.Set(m)
+	}
+	return nil
+}
+
+func splitEnvList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// secret is a setting that can instead be read from a file named by its
+// *_file variant, such as a Docker secret or a systemd credential.
+type secret struct {
+	key  string
+	file *string
+	// isSet reports whether the setting itself is given, clear drops it and
+	// parse sets it from the file's contents.
+	isSet func() bool
+	clear func()
+	parse func(string) error
+}
+
+func stringSecret(key string, value, file *string) secret {
+	return secret{
+		key:   key,
+		file:  file,
+		isSet: func() bool { return *value != "" },
+		clear: func() { *value = "" },
+		parse: func(s string) error { *value = s; return nil },
+	}
+}
+
+func (c *Config) secrets() []secret {
+	return []secret{
+		stringSecret("database", &c.Database, &c.DatabaseFile),
+		stringSecret("prometheus.bearer_token", &c.Prometheus.BearerToken, &c.Prometheus.BearerTokenFile),
+		stringSecret("cluster.secret", &c.Cluster.Secret, &c.Cluster.SecretFile),
+		{
+			key:   "otel.headers",
+			file:  &c.Otel.HeadersFile,
+			isSet: func() bool { return len(c.Otel.Headers) > 0 },
+			clear: func() { c.Otel.Headers = nil },
+			parse: func(s string) error {
+				h, err := parseHeaderLines(s)
+				c.Otel.Headers = h
+				return err
+			},
+		},
+	}
+}
+
+// parseHeaderLines reads headers given one key=value pair per line. Blank
+// lines and lines starting with # are skipped.
+func parseHeaderLines(s string) (map[string]string, error) {
+	h := make(map[string]string)
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return nil, xerrors.Newf("line %d: want key=value", i+1)
+		}
+		h[k] = strings.TrimSpace(v)
+	}
+	return h, nil
+}
+
+// readSecrets fills in the secrets given as files. A trailing newline in the
+// file is not part of the secret.
+func readSecrets(cfg *Config) error {
+	var errs []error
+	for _, s := range cfg.secrets() {
+		if *s.file == "" {
+			continue
+		}
+		if s.isSet() {
+			errs = append(errs, xerrors.Newf("%s and %s_file are both set", s.key, s.key))
+			continue
+		}
+		b, err := os.ReadFile(*s.file)
+		if err != nil {
+			errs = append(errs, xerrors.Newf("%s_file: %w", s.key, err))
+			continue
+		}
+		if err := s.parse(strings.TrimRight(string(b), "\r\n")); err != nil {
+			errs = append(errs, xerrors.Newf("%s_file %s: %w", s.key, *s.file, err))
+		}
+	}
+	return errors.Join(errs...)
+}
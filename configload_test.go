@@ -0,0 +1,293 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `# comment = not a key
+database = "state.db"
+
+[otel]
+"service_name" = "edge # not a comment"
+headers = { Authorization = "Bearer x" }
+
+[[routes]]
+url = "a.example.com:80"
+target = """
+multi = line
+"""
+
+[[routes]]
+url = "b.example.com:80"
+log.level = "debug"
+`
+
+func TestScanConfigLines(t *testing.T) {
+	lines := scanConfigLines([]byte(testConfig))
+	for _, tc := range []struct {
+		key  string
+		want int
+	}{
+		{"database", 2},
+		{"otel", 4},
+		{"otel.service_name", 5},
+		{"otel.headers", 6},
+		{"otel.headers.Authorization", 6}, // falls back to the enclosing key
+		{"routes[0]", 8},
+		{"routes[0].url", 9},
+		{"routes[0].target", 10},
+		{"routes[1].url", 15},
+		{"routes[1].log.level", 16},
+		{"routes[2].url", 0},
+		{"multi", 0}, // inside a multi-line string
+		{"cluster.secret", 0},
+	} {
+		if got := lines.line(tc.key); got != tc.want {
+			t.Errorf("line(%q) = %d, want %d", tc.key, got, tc.want)
+		}
+	}
+
+	// next hands out repeated keys in file order, as MetaData lists them.
+	if a, b, c := lines.next("routes.url"), lines.next("routes.url"), lines.next("routes.url"); a != 9 || b != 15 || c != 0 {
+		t.Errorf("next(routes.url) = %d, %d, %d; want 9, 15, 0", a, b, c)
+	}
+	if got := lines.position("config.toml", 7); got != "config.toml:7" {
+		t.Errorf("position = %q", got)
+	}
+	if got := lines.position("config.toml", 0); got != "config.toml" {
+		t.Errorf("position without a line = %q", got)
+	}
+}
+
+func TestTOMLKeyPath(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"url", "url"},
+		{" log . level ", "log.level"},
+		{`"service_name"`, "service_name"},
+		{`'quoted'`, "quoted"},
+		{`headers."X.Api.Key"`, "headers.X.Api.Key"},
+		{`a.'b.c'.d`, "a.b.c.d"},
+		{`"say \"hi\".x"`, `say \"hi\".x`},
+	} {
+		if got := tomlKeyPath(tc.in); got != tc.want {
+			t.Errorf("tomlKeyPath(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestIndexUnquoted(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		b    byte
+		want int
+	}{
+		{`a = 1`, '=', 2},
+		{`"a=b" = 1`, '=', 6},
+		{`'a=b' = 1`, '=', 6},
+		{`"a\"=" = 1`, '=', 7},
+		{`'a\' = 1`, '=', 5}, // no escapes in literal strings
+		{`x = "# not a comment" # comment`, '#', 22},
+		{`x = "#"`, '#', -1},
+		{`a.b`, '.', 1},
+		{`"a.b".c`, '.', 5},
+		{`"unclosed = 1`, '=', -1},
+	} {
+		if got := indexUnquoted(tc.in, tc.b); got != tc.want {
+			t.Errorf("indexUnquoted(%q, %q) = %d, want %d", tc.in, tc.b, got, tc.want)
+		}
+	}
+}
+
+// Unknown keys are reported with their line, once per unknown table.
+func TestDecodeConfigUnknownKeys(t *testing.T) {
+	data := []byte("database = \"x\"\ndatabse = \"y\"\n\n[otel]\nendpiont = \"z\"\n\n[nope]\na = 1\nb = 2\n")
+	var cfg Config
+	err := decodeConfig("config.toml", data, &cfg)
+	if err == nil {
+		t.Fatal("unknown keys were accepted")
+	}
+	got := strings.Split(err.Error(), "\n")
+	want := []string{
+		`config.toml:2: unknown key "databse"`,
+		`config.toml:5: unknown key "otel.endpiont"`,
+		`config.toml:7: unknown key "nope"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("RMZ_HOST", "collector")
+	t.Setenv("RMZ_EMPTY", "")
+	for _, tc := range []struct {
+		name, value, want, err string
+	}{
+		{"plain", "localhost:4317", "localhost:4317", ""},
+		{"set", "${RMZ_HOST}:4317", "collector:4317", ""},
+		{"default unused", "${RMZ_HOST:-other}", "collector", ""},
+		{"default", "${RMZ_UNSET:-fallback}:4317", "fallback:4317", ""},
+		{"empty default", "${RMZ_UNSET:-}", "", ""},
+		{"empty uses default", "${RMZ_EMPTY:-fallback}", "fallback", ""},
+		{"empty without default", "x${RMZ_EMPTY}y", "xy", ""},
+		{"escaped", "$${RMZ_HOST}", "${RMZ_HOST}", ""},
+		{"lower case left alone", "${group}", "${group}", ""},
+		{"missing", "${RMZ_UNSET}:4317", "", "config.toml:2: otel.endpoint: environment variable RMZ_UNSET is not set"},
+		{"several missing", "${RMZ_A}${RMZ_B}", "", "environment variable RMZ_A, RMZ_B is not set"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := []byte("[otel]\nendpoint = " + `"` + tc.value + `"` + "\n")
+			var cfg Config
+			if err := decodeConfig("config.toml", data, &cfg); err != nil {
+				t.Fatal(err)
+			}
+			err := expandEnv("config.toml", data, &cfg)
+			switch {
+			case tc.err != "":
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want %q", err, tc.err)
+				}
+			case err != nil:
+				t.Errorf("error = %v", err)
+			case cfg.Otel.Endpoint != tc.want:
+				t.Errorf("endpoint = %q, want %q", cfg.Otel.Endpoint, tc.want)
+			}
+		})
+	}
+}
+
+// Expansion reaches strings nested in arrays of tables and maps.
+func TestExpandEnvNested(t *testing.T) {
+	t.Setenv("RMZ_TOKEN", "t0k")
+	data := []byte("[otel]\nheaders = { Authorization = \"Bearer ${RMZ_TOKEN}\" }\n\n[[routes]]\nurl = \"a:80\"\ntarget = \"${RMZ_TOKEN}:80\"\n")
+	var cfg Config
+	if err := decodeConfig("config.toml", data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := expandEnv("config.toml", data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Otel.Headers["Authorization"] != "Bearer t0k" || cfg.Routes[0].Target != "t0k:80" {
+		t.Errorf("headers %v, target %q", cfg.Otel.Headers, cfg.Routes[0].Target)
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  []string
+		err  string
+		ok   func(*Config) bool
+	}{
+		{"string", []string{"REMAZARIN_OTEL_ENDPOINT=collector:4317"}, "",
+			func(c *Config) bool { return c.Otel.Endpoint == "collector:4317" }},
+		{"top-level", []string{"REMAZARIN_DATABASE=postgres://db"}, "",
+			func(c *Config) bool { return c.Database == "postgres://db" }},
+		{"bool", []string{"REMAZARIN_OTEL_TLS=true"}, "",
+			func(c *Config) bool { return c.Otel.TLS }},
+		{"int", []string{"REMAZARIN_OTEL_INTERVAL=30"}, "",
+			func(c *Config) bool { return c.Otel.Interval == 30 }},
+		{"float", []string{"REMAZARIN_OTEL_SAMPLE_RATIO=0.25"}, "",
+			func(c *Config) bool { return c.Otel.SampleRatio == 0.25 }},
+		{"list", []string{"REMAZARIN_CLUSTER_PEERS= a:1, ,b:2 "}, "",
+			func(c *Config) bool { return reflect.DeepEqual(c.Cluster.Peers, []string{"a:1", "b:2"}) }},
+		{"map", []string{"REMAZARIN_OTEL_HEADERS=Authorization=Bearer x, X-Key = k=v"}, "",
+			func(c *Config) bool {
+				return reflect.DeepEqual(c.Otel.Headers, map[string]string{"Authorization": "Bearer x", "X-Key": "k=v"})
+			}},
+		{"bad bool", []string{"REMAZARIN_OTEL_TLS=yes"}, "REMAZARIN_OTEL_TLS: want true or false", nil},
+		{"bad int", []string{"REMAZARIN_OTEL_INTERVAL=15s"}, "REMAZARIN_OTEL_INTERVAL: want an integer", nil},
+		{"bad float", []string{"REMAZARIN_OTEL_SAMPLE_RATIO=half"}, "want a number", nil},
+		{"bad map", []string{"REMAZARIN_OTEL_HEADERS=Authorization"}, "want key=value pairs", nil},
+		{"typo in a section", []string{"REMAZARIN_OTEL_ENDPIONT=x"}, "REMAZARIN_OTEL_ENDPIONT: no such setting", nil},
+		{"array table", []string{"REMAZARIN_ROUTES_URL=x"}, "REMAZARIN_ROUTES_URL: no such setting", nil},
+		{"not a setting", []string{"REMAZARIN_CONFIG=/etc/x.toml", "REMAZARIN_TEST_POSTGRES=postgres://t", "PATH=/bin"}, "",
+			func(c *Config) bool { return c.Database == "file.db" }},
+		{"secret replaces file", []string{"REMAZARIN_CLUSTER_SECRET=s"}, "",
+			func(c *Config) bool { return c.Cluster.Secret == "s" && c.Cluster.SecretFile == "" }},
+		{"file replaces secret", []string{"REMAZARIN_DATABASE_FILE=/run/secrets/db"}, "",
+			func(c *Config) bool { return c.Database == "" && c.DatabaseFile == "/run/secrets/db" }},
+		{"headers file replaces headers", []string{"REMAZARIN_OTEL_HEADERS_FILE=/run/secrets/h"}, "",
+			func(c *Config) bool { return c.Otel.Headers == nil && c.Otel.HeadersFile == "/run/secrets/h" }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{Database: "file.db"}
+			cfg.Cluster.SecretFile = "/run/secrets/cluster"
+			cfg.Otel.Headers = map[string]string{"From": "file"}
+			err := applyEnvOverrides(&cfg, tc.env)
+			switch {
+			case tc.err != "":
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want %q", err, tc.err)
+				}
+			case err != nil:
+				t.Errorf("error = %v", err)
+			case !tc.ok(&cfg):
+				t.Errorf("not applied: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestReadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	secretFile := write("secret", "s3cret\n")
+	crlfFile := write("crlf", "tok\r\n")
+	inner := write("inner", "a\nb\n\n")
+	headersFile := write("headers", "# exporter auth\nAuthorization = Bearer x\n\nX-Key=k=v\n")
+	badHeaders := write("bad-headers", "Authorization\n")
+	missing := filepath.Join(dir, "missing")
+
+	for _, tc := range []struct {
+		name string
+		set  func(*Config)
+		err  string
+		ok   func(*Config) bool
+	}{
+		{"trailing newline dropped", func(c *Config) { c.Cluster.SecretFile = secretFile }, "",
+			func(c *Config) bool { return c.Cluster.Secret == "s3cret" }},
+		{"crlf dropped", func(c *Config) { c.Prometheus.BearerTokenFile = crlfFile }, "",
+			func(c *Config) bool { return c.Prometheus.BearerToken == "tok" }},
+		{"inner newline kept", func(c *Config) { c.DatabaseFile = inner }, "",
+			func(c *Config) bool { return c.Database == "a\nb" }},
+		{"headers", func(c *Config) { c.Otel.HeadersFile = headersFile }, "",
+			func(c *Config) bool {
+				return reflect.DeepEqual(c.Otel.Headers, map[string]string{"Authorization": "Bearer x", "X-Key": "k=v"})
+			}},
+		{"bad headers", func(c *Config) { c.Otel.HeadersFile = badHeaders }, "otel.headers_file " + badHeaders + ": line 1: want key=value", nil},
+		{"missing file", func(c *Config) { c.Cluster.SecretFile = missing }, "cluster.secret_file: open " + missing, nil},
+		{"both set", func(c *Config) { c.Database, c.DatabaseFile = "x.db", secretFile }, "database and database_file are both set", nil},
+		{"both headers set", func(c *Config) {
+			c.Otel.Headers, c.Otel.HeadersFile = map[string]string{"A": "b"}, headersFile
+		}, "otel.headers and otel.headers_file are both set", nil},
+		{"no files", func(c *Config) { c.Database = "x.db" }, "",
+			func(c *Config) bool { return c.Database == "x.db" }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			tc.set(&cfg)
+			err := readSecrets(&cfg)
+			switch {
+			case tc.err != "":
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want %q", err, tc.err)
+				}
+			case err != nil:
+				t.Errorf("error = %v", err)
+			case !tc.ok(&cfg):
+				t.Errorf("not read: %+v", cfg)
+			}
+		})
+	}
+}
@@ -4,27 +4,31 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"reMazarin/proxy"
 
 	"github.com/mdobak/go-xerrors"
 )
 
+// setupLogging installs the application log handler with its defaults (info
+// level, JSON on stdout); run reconfigures it from [log] once the config is
+// loaded.
 func setupLogging() *slog.Logger {
-	opts := &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
-			if a.Value.Kind() == slog.KindAny {
-				if err, ok := a.Value.Any().(error); ok {
-					a.Value = slog.GroupValue(
-						slog.String("msg", err.Error()),
-						slog.Any("trace", getFrames(err)),
-					)
-				}
-			}
-			return a
-		},
-	}
+	proxy.ConfigureLogging(proxy.LogConfig{ReplaceAttr: expandErrors})
+	return slog.New(proxy.LogHandler())
+}
 
-	return slog.New(slog.NewJSONHandler(os.Stdout, opts))
+// expandErrors logs errors as their message plus the stack trace recorded by
+// xerrors.
+func expandErrors(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindAny {
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.GroupValue(
+				slog.String("msg", err.Error()),
+				slog.Any("trace", getFrames(err)),
+			)
+		}
+	}
+	return a
 }
 
 func getFrames(err error) []map[string]any {
@@ -47,3 +51,9 @@ func getFrames(err error) []map[string]any {
 	}
 	return result
 }
+
+// setupCLILogging keeps the one-shot commands quiet: warnings and errors only,
+// as text on stderr, leaving stdout to the command's own output.
+func setupCLILogging() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+}
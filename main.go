@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
@@ -22,13 +23,14 @@ import (
 const version = "0.1.1"
 
 func main() {
-	if err := run(); err != nil {
+	if err := runCLI(os.Args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
+// run serves with the config at configPath until interrupted.
+func run(configPath string) error {
 	logger := setupLogging()
 	slog.SetDefault(logger)
 
@@ -37,10 +39,13 @@ func run() error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	cfg, err := loadConfig("config.toml")
+	cfg, err := loadConfig(configPath)
 	if err != nil {
 		return xerrors.Newf("load config: %w", err)
 	}
+	if err := proxy.ConfigureLogging(cfg.log()); err != nil {
+		return xerrors.Newf("configure logging: %w", err)
+	}
 
 	slog.Info("config loaded",
 		"web_enabled", cfg.Web.Enabled,
@@ -48,8 +53,13 @@ func run() error {
 		"routes_count", len(cfg.Routes),
 	)
 
-	if cfg.Otel.Enabled {
-		otelShutdown, err := setupOTelSDK(ctx, cfg)
+	promReader, err := proxy.ConfigurePrometheus(cfg.prometheus())
+	if err != nil {
+		return xerrors.Newf("configure prometheus: %w", err)
+	}
+	metricsEnabled := cfg.Otel.Enabled || promReader != nil
+	if metricsEnabled {
+		otelShutdown, err := setupOTelSDK(ctx, cfg, promReader)
 		if err != nil {
 			return err
 		}
@@ -84,15 +94,32 @@ func run() error {
 	// flushes buffered entries while the DB is still open (LIFO defer order).
 	stopAuth := proxy.InitAuth(ctx, store)
 	defer stopAuth()
+	if cfg.Backup.IntervalHours > 0 {
+		go scheduleBackups(ctx, store, cfg.Backup)
+	}
 
-	configRoutes := make([]storage.ConfigRoute, len(cfg.Routes))
-	for i, r := range cfg.Routes {
-		configRoutes[i] = storage.ConfigRoute{
-			Url: r.Url, Target: r.Target, Type: r.Type,
-			Tls: r.Tls, Cert: r.Cert, Key: r.Key,
+	// Check the config routes before anything listens, so that a bad target,
+	// cert or port is reported with its place in the config file instead of
+	// when a listener starts or a backend is dialled. A target that doesn't
+	// resolve yet may be a backend still starting: that is only a warning.
+	dbRoutes, err := store.GetAllRoutes(ctx)
+	if err != nil {
+		return xerrors.Newf("get routes: %w", err)
+	}
+	for _, url := range replacedUIRoutes(cfg, dbRoutes) {
+		slog.Warn("config route replaces the route added in the admin panel", "url", url)
+	}
+	warnUnresolved := func(ctx context.Context, host string) error {
+		if err := lookupHost(ctx, host); err != nil {
+			slog.Warn("route target does not resolve", "host", host, "error", err)
 		}
+		return nil
 	}
-	if err := store.SyncRoutes(configRoutes); err != nil {
+	if errs := checkRoutes(ctx, cfg, nil, warnUnresolved); len(errs) > 0 {
+		return xerrors.Newf("check config: %w", errors.Join(errs...))
+	}
+
+	if err := store.SyncRoutes(cfg.configRoutes()); err != nil {
 		return xerrors.Newf("sync routes: %w", err)
 	}
 
@@ -104,16 +131,53 @@ func run() error {
 		}
 	}
 
+	// A [policy] file goes in before the routes are loaded, so the UI routes
+	// it defines are served from the start.
+	var policy *policyFile
+	if cfg.Policy.File != "" {
+		policy = &policyFile{path: cfg.Policy.File, store: store}
+		if cfg.Admin.Enabled {
+			policy.adminURL = cfg.Admin.Url
+		}
+		if _, err := policy.apply(ctx); err != nil {
+			return xerrors.Newf("apply policy file: %w", err)
+		}
+		api.PolicyOwner = policy.owner
+	}
+
 	// Refresh the auth cache now that routes are synced and protected.
 	// (InitAuth ran before SyncRoutes so the initial cache load was empty.)
 	proxy.RefreshCache()
-	api.OnRouteUpdate = proxy.RefreshCache
+	api.OnRouteUpdate = func() {
+		proxy.RefreshCache()
+		proxy.PublishRefresh()
+	}
 	api.RouteStats = proxy.GetRouteStats
 	api.EventStats = proxy.GetEventStats
 	api.RecentEvents = func() any { return proxy.GetRecentEvents() }
+	api.Breakers = func() any { return proxy.GetBreakers() }
+	api.CacheStats = func() any { return proxy.GetCacheStats() }
+	api.CachePurge = proxy.PurgeCache
+	api.OnHeaderRulesValidate = proxy.ValidateHeaderRules
+	api.OnSecurityValidate = proxy.ValidateRouteSecurity
+	api.SecurityProfiles = proxy.SecurityProfileNames
+	api.OnRedirectValidate = proxy.ValidateRedirect
+	api.OnRewriteValidate = proxy.ValidateRewriteRules
+	api.OnMaintenanceValidate = proxy.ValidateMaintenance
+	api.OnErrorPagesValidate = proxy.ValidateErrorPages
 	api.ActiveBans = proxy.GetActiveBans
 	api.BanIP = proxy.BanIP
 	api.UnbanIP = proxy.UnbanIP
+	api.LiveConns = func() any { return proxy.LiveConns() }
+	api.KillConns = func(ctx context.Context, id, ip, user string) int {
+		proxy.PublishKill(id, ip, user)
+		return proxy.KillConns(ctx, id, ip, user)
+	}
+	api.ClusterStatus = func() any { return proxy.GetClusterStatus() }
+	api.LogLevels = func() any { return proxy.GetLogLevels() }
+	api.SetLogLevels = proxy.SetLogLevels
+	api.BackupDir = cfg.Backup.Dir
+	api.BackupNow = func(ctx context.Context) (any, error) { return takeBackup(ctx, store, cfg.Backup) }
 	api.DefaultCert = cfg.Web.Cert
 	api.DefaultKey = cfg.Web.Key
 
@@ -121,27 +185,103 @@ func run() error {
 	if err != nil {
 		return xerrors.Newf("get routes: %w", err)
 	}
-	proxyRoutes := make([]proxy.ProxyRoute, len(allRoutes))
-	for i, r := range allRoutes {
-		proxyRoutes[i] = proxy.ProxyRoute{
+	toProxyRoute := func(r storage.Route) proxy.ProxyRoute {
+		return proxy.ProxyRoute{
 			Url: r.Url, Target: r.Target, Type: r.Type,
 			Tls: r.Tls, Cert: r.Cert, Key: r.Key,
 			InjectAPI: r.Url == cfg.Web.Url || r.Url == cfg.Admin.Url,
+			Transport: r.Transport,
+			Retry:     r.Retry,
+			Breaker:   r.Breaker,
+			Cache:     r.Cache,
+			Compress:  r.Compress,
+			Headers:   r.Headers,
+			Security:  r.Security,
+
+			RedirectHosts: r.RedirectHosts,
+			Redirect:      r.Redirect,
+			Rewrite:       r.Rewrite,
 		}
 	}
+	proxyRoutes := make([]proxy.ProxyRoute, len(allRoutes))
+	for i, r := range allRoutes {
+		proxyRoutes[i] = toProxyRoute(r)
+	}
+
+	if err := proxy.ConfigureCache(cfg.cache()); err != nil {
+		return xerrors.Newf("configure response cache: %w", err)
+	}
+	if err := proxy.ConfigureSecurityProfiles(cfg.securityProfiles()); err != nil {
+		return xerrors.Newf("configure security profiles: %w", err)
+	}
+	if err := proxy.ConfigureErrorPages(cfg.errorPages()); err != nil {
+		return xerrors.Newf("configure error pages: %w", err)
+	}
+	if err := proxy.ConfigureRequestIDs(cfg.requestID()); err != nil {
+		return xerrors.Newf("configure request ids: %w", err)
+	}
+	if err := proxy.ConfigureAccessLog(cfg.accessLog()); err != nil {
+		return xerrors.Newf("configure access log: %w", err)
+	}
+	if err := proxy.ConfigureCluster(cfg.cluster()); err != nil {
+		return xerrors.Newf("configure cluster: %w", err)
+	}
 
 	var wg sync.WaitGroup
-	p := proxy.Proxy{Proxies: proxyRoutes, Wg: &wg}
+	p := proxy.Proxy{Proxies: proxyRoutes, Listeners: cfg.listenerTimeouts(), Redirect: cfg.redirect(), Wg: &wg}
 
 	// Wire dynamic route callbacks after p is initialised.
-	api.OnRouteRegister = func(url, target, routeType string, tls bool, cert, key string) error {
-		return p.RegisterRoute(proxy.ProxyRoute{Url: url, Target: target, Type: routeType, Tls: tls, Cert: cert, Key: key})
+	api.OnRouteRegister = func(r storage.Route) error {
+		if err := p.RegisterRoute(toProxyRoute(r)); err != nil {
+			return err
+		}
+		proxy.PublishRouteChange(r.Url, false)
+		return nil
+	}
+	api.OnRouteDelete = func(url string) {
+		p.UnregisterRoute(url)
+		proxy.PublishRouteChange(url, true)
+	}
+	// Route changes made on a peer; the peer refreshes our auth cache separately.
+	proxy.OnClusterRoute = func(url string, deleted bool) {
+		if deleted {
+			p.UnregisterRoute(url)
+			return
+		}
+		r, err := store.GetRouteByUrl(context.Background(), url)
+		if err != nil {
+			slog.Warn("cluster route not found", "url", url, "error", err)
+			return
+		}
+		if err := p.RegisterRoute(toProxyRoute(*r)); err != nil {
+			slog.Warn("cluster route not registered", "url", url, "error", err)
+		}
+	}
+	api.Listeners = func() any { return p.GetListeners() }
+	if policy != nil {
+		go policy.watch(ctx, time.Duration(cfg.Policy.ReloadSec)*time.Second, func(changes []storage.PolicyChange) {
+			for _, c := range changes {
+				switch {
+				case c.Kind != "route":
+				case c.Action == "delete":
+					api.OnRouteDelete(c.Name)
+				case c.Reregister:
+					r, err := store.GetRouteByUrl(ctx, c.Name)
+					if err == nil {
+						err = api.OnRouteRegister(*r)
+					}
+					if err != nil {
+						slog.Warn("policy route not registered", "url", c.Name, "error", err)
+					}
+				}
+			}
+			api.OnRouteUpdate()
+		})
 	}
-	api.OnRouteDelete = func(url string) { p.UnregisterRoute(url) }
 
 	api.OnRouteValidate = p.ValidateRoute
 
-	if err := p.StartProxy(ctx, cfg.Otel.Enabled); err != nil {
+	if err := p.StartProxy(ctx, metricsEnabled); err != nil {
 		return xerrors.Newf("start proxy: %w", err)
 	}
 
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"os"
+	"reMazarin/proxy"
 	"time"
 
 	"github.com/mdobak/go-xerrors"
@@ -15,9 +19,13 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/grpc/credentials"
 )
 
-func setupOTelSDK(ctx context.Context, cfg *Config) (shutdown func(context.Context) error, err error) {
+// setupOTelSDK installs the global tracer and meter providers. Traces and the
+// OTLP metric exporter need [otel] enabled; promReader, when not nil, is the
+// Prometheus endpoint's reader and gets the same metrics.
+func setupOTelSDK(ctx context.Context, cfg *Config, promReader metric.Reader) (shutdown func(context.Context) error, err error) {
 	config := cfg
 	var shutdownFuncs []func(context.Context) error
 
@@ -44,53 +52,133 @@ func setupOTelSDK(ctx context.Context, cfg *Config) (shutdown func(context.Conte
 		return shutdown, xerrors.Newf("create otel resource: %w", err)
 	}
 
-	// Propagator
-	// Enables trace context to flow through HTTP headers (W3C TraceContext + Baggage).
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
-
-	// Trace exporter
-	traceExporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(config.Otel.Endpoint),
-		otlptracegrpc.WithInsecure(),
-	)
-	if err != nil {
-		return shutdown, xerrors.Newf("create trace exporter: %w", err)
+	meterOpts := []metric.Option{metric.WithResource(res)}
+	if promReader != nil {
+		meterOpts = append(meterOpts, metric.WithReader(promReader))
 	}
-	shutdownFuncs = append(shutdownFuncs, traceExporter.Shutdown)
 
-	tracerProvider := trace.NewTracerProvider(
-		trace.WithResource(res),
-		trace.WithBatcher(traceExporter,
-			trace.WithBatchTimeout(time.Duration(config.Otel.Interval)*time.Second),
-		),
-		trace.WithSampler(trace.TraceIDRatioBased(0.1)),
-	)
-	shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
-	otel.SetTracerProvider(tracerProvider)
+	if config.Otel.Enabled {
+		// Propagator
+		// Enables trace context to flow through HTTP headers (W3C TraceContext + Baggage).
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		))
 
-	// Metric exporter
-	metricExporter, err := otlpmetricgrpc.New(ctx,
-		otlpmetricgrpc.WithEndpoint(config.Otel.Endpoint),
-		otlpmetricgrpc.WithInsecure(),
-	)
-	if err != nil {
-		return shutdown, xerrors.Newf("create metric exporter: %w", err)
-	}
-	shutdownFuncs = append(shutdownFuncs, metricExporter.Shutdown)
+		sampler, err := newSampler(config.Otel)
+		if err != nil {
+			return shutdown, err
+		}
+		creds, err := otlpCredentials(config.Otel)
+		if err != nil {
+			return shutdown, err
+		}
+
+		// Trace exporter
+		traceOpts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(config.Otel.Endpoint),
+			otlptracegrpc.WithHeaders(config.Otel.Headers),
+		}
+		if creds != nil {
+			traceOpts = append(traceOpts, otlptracegrpc.WithTLSCredentials(creds))
+		} else {
+			traceOpts = append(traceOpts, otlptracegrpc.WithInsecure())
+		}
+		traceExporter, err := otlptracegrpc.New(ctx, traceOpts...)
+		if err != nil {
+			return shutdown, xerrors.Newf("create trace exporter: %w", err)
+		}
+		shutdownFuncs = append(shutdownFuncs, traceExporter.Shutdown)
+
+		tracerProvider := trace.NewTracerProvider(
+			trace.WithResource(res),
+			trace.WithBatcher(traceExporter,
+				trace.WithBatchTimeout(time.Duration(config.Otel.Interval)*time.Second),
+			),
+			trace.WithSampler(sampler),
+		)
+		shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
+		otel.SetTracerProvider(tracerProvider)
+
+		// Metric exporter
+		metricOpts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(config.Otel.Endpoint),
+			otlpmetricgrpc.WithHeaders(config.Otel.Headers),
+		}
+		if creds != nil {
+			metricOpts = append(metricOpts, otlpmetricgrpc.WithTLSCredentials(creds))
+		} else {
+			metricOpts = append(metricOpts, otlpmetricgrpc.WithInsecure())
+		}
+		metricExporter, err := otlpmetricgrpc.New(ctx, metricOpts...)
+		if err != nil {
+			return shutdown, xerrors.Newf("create metric exporter: %w", err)
+		}
+		shutdownFuncs = append(shutdownFuncs, metricExporter.Shutdown)
 
-	meterProvider := metric.NewMeterProvider(
-		metric.WithResource(res),
-		metric.WithReader(
+		meterOpts = append(meterOpts, metric.WithReader(
 			metric.NewPeriodicReader(metricExporter,
 				metric.WithInterval(time.Duration(config.Otel.Interval)*time.Second),
 			),
-		),
-	)
+		))
+	}
+
+	meterProvider := metric.NewMeterProvider(meterOpts...)
 	shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
 	otel.SetMeterProvider(meterProvider)
 
 	return shutdown, nil
 }
+
+// newSampler builds the trace sampler named by [otel] sampler. The names are
+// those of the OTEL_TRACES_SAMPLER environment variable.
+func newSampler(cfg OtelConfig) (trace.Sampler, error) {
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, proxy.Problem{Field: "sample_ratio", Err: xerrors.Newf("otel sample_ratio %v: want 0 to 1", cfg.SampleRatio)}
+	}
+	switch cfg.Sampler {
+	case "always_on":
+		return trace.AlwaysSample(), nil
+	case "always_off":
+		return trace.NeverSample(), nil
+	case "traceidratio":
+		return trace.TraceIDRatioBased(cfg.SampleRatio), nil
+	case "parentbased_always_on":
+		return trace.ParentBased(trace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return trace.ParentBased(trace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return trace.ParentBased(trace.TraceIDRatioBased(cfg.SampleRatio)), nil
+	}
+	return nil, proxy.Problem{Field: "sampler", Err: xerrors.Newf("otel sampler %q: want always_on, always_off, traceidratio or parentbased_<one of those>", cfg.Sampler)}
+}
+
+// otlpCredentials returns the TLS credentials of the OTLP connection, or nil
+// for plain gRPC.
+func otlpCredentials(cfg OtelConfig) (credentials.TransportCredentials, error) {
+	if !cfg.TLS {
+		if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+			return nil, proxy.Problem{Field: "tls", Err: xerrors.Newf("otel ca_file, cert_file and key_file need tls = true")}
+		}
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, proxy.Problem{Field: "ca_file", Err: xerrors.Newf("otel ca_file: %w", err)}
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, proxy.Problem{Field: "ca_file", Err: xerrors.Newf("otel ca_file %s: no PEM certificates", cfg.CAFile)}
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, proxy.Problem{Field: "cert_file", Err: xerrors.Newf("otel client certificate: %w", err)}
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reMazarin/proxy"
+	"reMazarin/storage"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mdobak/go-xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy documents (storage.Policy) are written and read as YAML, TOML or
+// JSON. The JSON field names are the schema for all three: YAML and TOML are
+// converted through JSON, so every format rejects the same unknown keys.
+
+// policyFormat picks the format from a file name, defaulting to YAML.
+func policyFormat(path, flagValue string) (string, error) {
+	format := flagValue
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".toml":
+			format = "toml"
+		case ".json":
+			format = "json"
+		default:
+			format = "yaml"
+		}
+	}
+	switch format {
+	case "yaml", "toml", "json":
+		return format, nil
+	}
+	return "", xerrors.Newf("unknown format %q; use yaml, toml or json", format)
+}
+
+func encodePolicy(w io.Writer, doc *storage.Policy, format string) error {
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+		_, err = w.Write(append(b, '\n'))
+		return err
+	case "yaml":
+		// JSON is YAML: parsing it into a node keeps the field order.
+		var n yaml.Node
+		if err := yaml.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		blockStyle(&n)
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(&n); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var m map[string]any
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+		enc := toml.NewEncoder(w)
+		enc.Indent = ""
+		return enc.Encode(tomlValue(m))
+	}
+}
+
+// blockStyle turns the flow style of parsed JSON into block style, with
+// multi-line strings as literal blocks.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" && strings.Contains(n.Value, "\n") {
+		n.Style = yaml.LiteralStyle
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// tomlValue prepares decoded JSON for the TOML encoder, which has no null and
+// would write every JSON number as a float.
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = tomlValue(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = tomlValue(e)
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// decodePolicy reads a policy document in the given format.
+func decodePolicy(data []byte, format string) (*storage.Policy, error) {
+	if format != "json" {
+		var m map[string]any
+		var err error
+		if format == "toml" {
+			err = toml.Unmarshal(data, &m)
+		} else {
+			err = yaml.Unmarshal(data, &m)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var doc storage.Policy
+	if err := dec.Decode(&doc); err != nil {
+		// The JSON is internal; its package's prefix would only confuse.
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return &doc, nil
+}
+
+func readPolicyFile(path, format string) (*storage.Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Newf("read policy: %w", err)
+	}
+	return parsePolicy(path, format, data)
+}
+
+// parsePolicy decodes the document read from path and checks its routes.
+func parsePolicy(path, format string, data []byte) (*storage.Policy, error) {
+	format, err := policyFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodePolicy(data, format)
+	if err != nil {
+		return nil, xerrors.Newf("%s: %w", path, err)
+	}
+	if errs := checkPolicyRoutes(doc); len(errs) > 0 {
+		return nil, xerrors.Newf("%s: %w", path, errors.Join(errs...))
+	}
+	return doc, nil
+}
+
+// checkPolicyRoutes runs the proxy's checks on the UI routes of a document,
+// as the admin panel does when a route is created or edited.
+func checkPolicyRoutes(doc *storage.Policy) []error {
+	var errs []error
+	add := func(where string, err error) {
+		if err != nil {
+			errs = append(errs, xerrors.Newf("%s: %w", where, err))
+		}
+	}
+	var p proxy.Proxy
+	for _, r := range doc.Routes {
+		where := fmt.Sprintf("route %q", r.Url)
+		if r.Maintenance != nil {
+			add(where+" maintenance", proxy.ValidateMaintenance(*r.Maintenance))
+		}
+		if r.Source != "ui" {
+			continue
+		}
+		add(where, p.ValidateRoute(r.Url, r.Type))
+		if r.Headers != nil {
+			add(where+" request headers", proxy.ValidateHeaderRules(r.Headers.Request))
+			add(where+" response headers", proxy.ValidateHeaderRules(r.Headers.Response))
+		}
+		if r.Security != nil {
+			add(where, proxy.ValidateRouteSecurity(*r.Security))
+		}
+		if r.Type == "redirect" {
+			var rd storage.RouteRedirect
+			if r.Redirect != nil {
+				rd = *r.Redirect
+			}
+			add(where, proxy.ValidateRedirect(r.Target, rd))
+		}
+		add(where+" rewrite", proxy.ValidateRewriteRules(r.Rewrite))
+		if r.ErrorPages != "" {
+			add(where+" error_pages", proxy.ValidateErrorPages(r.ErrorPages))
+		}
+	}
+	return errs
+}
+
+// policyFile is the [policy] file of a running server.
+type policyFile struct {
+	path  string
+	store *storage.Storage
+	// adminURL is re-protected with the admin group after each apply, as at
+	// startup, in case the file left the admin panel's route open.
+	adminURL string
+
+	mu       sync.Mutex
+	sum      [32]byte // of the version applied last
+	failed   [32]byte // of the version that last failed to apply
+	sections []string
+}
+
+// owner implements api.PolicyOwner.
+func (f *policyFile) owner(section string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.sections {
+		if s == section {
+			return f.path
+		}
+	}
+	return ""
+}
+
+// apply applies the file, with pruning, if it changed since the last call.
+// A version that failed is not retried until the file changes again.
+func (f *policyFile) apply(ctx context.Context) ([]storage.PolicyChange, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, xerrors.Newf("read policy: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	f.mu.Lock()
+	seen := sum == f.sum || sum == f.failed
+	f.mu.Unlock()
+	if seen {
+		return nil, nil
+	}
+	doc, err := parsePolicy(f.path, "", data)
+	if err != nil {
+		f.fail(sum)
+		return nil, err
+	}
+	changes, err := f.store.ApplyPolicy(ctx, doc, true)
+	if err != nil {
+		f.fail(sum)
+		return nil, xerrors.Newf("%s: %w", f.path, err)
+	}
+	f.applied(ctx, doc, sum, changes)
+	return changes, nil
+}
+
+func (f *policyFile) fail(sum [32]byte) {
+	f.mu.Lock()
+	f.failed = sum
+	f.mu.Unlock()
+}
+
+// applied records a successfully applied version of the file.
+func (f *policyFile) applied(ctx context.Context, doc *storage.Policy, sum [32]byte, changes []storage.PolicyChange) {
+	if f.adminURL != "" {
+		if err := f.store.EnsureRouteGroup(ctx, f.adminURL, "admin"); err != nil {
+			slog.Warn("could not protect admin route", "error", err)
+		}
+	}
+	var sections []string
+	for name, present := range map[string]bool{
+		"settings": doc.Settings != nil, "groups": doc.Groups != nil, "users": doc.Users != nil,
+		"routes": doc.Routes != nil, "throttle": doc.Throttle != nil,
+	} {
+		if present {
+			sections = append(sections, name)
+		}
+	}
+	f.mu.Lock()
+	f.sum, f.sections = sum, sections
+	f.mu.Unlock()
+	for _, c := range changes {
+		slog.Info("policy change", "file", f.path, "action", c.Action, "kind", c.Kind, "name", c.Name)
+	}
+}
+
+// watch re-applies the file whenever it changes and hands the changes to
+// onChange, until ctx is done. A file that fails to apply leaves the
+// database as it was.
+func (f *policyFile) watch(ctx context.Context, every time.Duration, onChange func([]storage.PolicyChange)) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		changes, err := f.apply(ctx)
+		if err != nil {
+			slog.Error("policy file not applied", "error", err)
+			continue
+		}
+		if len(changes) > 0 {
+			onChange(changes)
+		}
+	}
+}
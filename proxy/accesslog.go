@@ -0,0 +1,451 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reMazarin/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// The access log has one entry per HTTP request, written when the response is
+// done, and one per TCP/UDP flow, written when the flow is authorized. Entries
+// go to up to three sinks:
+//
+//	file    JSON lines, rotated by size; every request and flow
+//	stdout  Common or Combined Log Format; HTTP requests only
+//	DB      the access_log table behind the admin Access Log; only requests
+//	        and flows that reached route access control, so scans and junk
+//	        stay out of the database
+//
+// Entries are queued and written by one goroutine (see InitAuth); a full queue
+// drops entries rather than stall requests.
+
+// Auth methods recorded with an entry.
+const (
+	AuthPublic      = "public"       // route has no restrictions
+	AuthIPSession   = "ip_session"   // a signed-in user's session on the client IP
+	AuthIPAllowlist = "ip_allowlist" // the route's allowed_ips
+	AuthCookie      = "cookie"       // the browser session cookie
+	AuthDenied      = "denied"       // access refused
+)
+
+const (
+	defaultAccessLogMaxSizeMB  = 100
+	defaultAccessLogMaxBackups = 5
+	defaultAccessLogDBMaxRows  = 5000
+)
+
+// AccessLogConfig configures the access-log sinks.
+type AccessLogConfig struct {
+	File            string // JSON-lines file; "" = none
+	MaxSizeMB       int    // rotate when the file would grow past this; 0 = 100
+	MaxBackups      int    // rotated files kept as <file>.1 … <file>.N; 0 = 5
+	Stdout          string // "", "common" or "combined"
+	DBMaxRows       int    // rows kept in the DB; 0 = 5000, -1 = don't log to the DB
+	DBRetentionDays int    // DB rows older than this are deleted; 0 = no age limit
+}
+
+type accessSinks struct {
+	file     *rotatingFile // nil = none
+	stdout   io.Writer     // nil = none
+	combined bool
+	db       bool
+	maxRows  int
+	maxAge   int
+}
+
+var accessLog atomic.Pointer[accessSinks]
+
+// ConfigureAccessLog opens the access-log sinks. Until it is called, entries
+// go to the DB only, as before.
+func ConfigureAccessLog(cfg AccessLogConfig) error {
+	if ps := CheckAccessLog(cfg); len(ps) > 0 {
+		return ps[0]
+	}
+	s := &accessSinks{db: cfg.DBMaxRows >= 0, maxRows: cfg.DBMaxRows, maxAge: cfg.DBRetentionDays}
+	if s.maxRows <= 0 {
+		s.maxRows = defaultAccessLogDBMaxRows
+	}
+	if cfg.Stdout != "" {
+		s.stdout, s.combined = os.Stdout, cfg.Stdout == "combined"
+	}
+	if cfg.File != "" {
+		size, backups := cfg.MaxSizeMB, cfg.MaxBackups
+		if size <= 0 {
+			size = defaultAccessLogMaxSizeMB
+		}
+		if backups <= 0 {
+			backups = defaultAccessLogMaxBackups
+		}
+		f, err := openRotatingFile(cfg.File, int64(size)<<20, backups, 0)
+		if err != nil {
+			return xerrors.Newf("access_log file: %w", err)
+		}
+		s.file = f
+	}
+	if old := accessLog.Swap(s); old != nil && old.file != nil {
+		old.file.Close()
+	}
+	return nil
+}
+
+// CheckAccessLog reports every problem with cfg that ConfigureAccessLog would
+// refuse, without opening the file. Field is the [access_log] key at fault.
+func CheckAccessLog(cfg AccessLogConfig) []Problem {
+	var ps []Problem
+	if cfg.DBRetentionDays < 0 {
+		ps = append(ps, Problem{"db_retention_days", xerrors.Newf("access_log db_retention_days must not be negative")})
+	}
+	switch cfg.Stdout {
+	case "", "common", "combined":
+	default:
+		ps = append(ps, Problem{"stdout", xerrors.Newf("access_log stdout %q: want \"common\" or \"combined\"", cfg.Stdout)})
+	}
+	return ps
+}
+
+// accessSinksOrDefault returns the configured sinks, or DB-only defaults.
+func accessSinksOrDefault() *accessSinks {
+	if s := accessLog.Load(); s != nil {
+		return s
+	}
+	return &accessSinks{db: true, maxRows: defaultAccessLogDBMaxRows}
+}
+
+// writeAccess writes one entry to every sink. Only the log goroutine calls it.
+func writeAccess(e storage.AccessEvent) {
+	s := accessSinksOrDefault()
+	if s.db && e.AuthMethod != "" && authStore != nil {
+		authStore.LogAccess(context.Background(), e)
+	}
+	if s.file != nil {
+		if line, err := json.Marshal(e); err == nil {
+			s.file.Write(append(line, '\n'))
+		}
+	}
+	if s.stdout != nil && e.Protocol == "http" {
+		io.WriteString(s.stdout, formatCLF(e, s.combined))
+	}
+}
+
+// cleanupAccessLog applies the DB retention settings.
+func cleanupAccessLog(ctx context.Context) {
+	s := accessSinksOrDefault()
+	authStore.CleanupOldAccessLog(ctx, s.maxRows, s.maxAge)
+}
+
+// closeAccessLog closes the access-log file once the queue is drained.
+func closeAccessLog() {
+	if s := accessLog.Load(); s != nil && s.file != nil {
+		s.file.Close()
+	}
+}
+
+// enqueueAccess queues an entry without ever blocking the caller.
+func enqueueAccess(e storage.AccessEvent) {
+	select {
+	case logChan <- e:
+	default:
+		// Drop rather than stall the request handler if the log queue is full.
+	}
+}
+
+// logAccess queues the access-log entry of a TCP/UDP flow whose ID ctx
+// carries.
+func logAccess(ctx context.Context, protocol, ip, username, route, authMethod string) {
+	enqueueAccess(storage.AccessEvent{
+		IP: ip, Username: username, RouteUrl: route, RequestID: RequestID(ctx),
+		Protocol: protocol, AuthMethod: authMethod, CreatedAt: time.Now(),
+	})
+}
+
+// accessRecord collects what the layers below the router learn about a
+// request: the route key, who it was authorized as, and the backend timing.
+// Only the request's own goroutine writes it; mu guards what the live
+// connection list reads from other goroutines.
+type accessRecord struct {
+	mu            sync.Mutex
+	route         string
+	username      string
+	authMethod    string // "" = never reached route access control
+	upstreamStart time.Time
+	upstream      time.Duration
+}
+
+type accessRecordKey struct{}
+
+// accessRecordOf returns the request's access record, or nil outside the router.
+func accessRecordOf(ctx context.Context) *accessRecord {
+	rec, _ := ctx.Value(accessRecordKey{}).(*accessRecord)
+	return rec
+}
+
+// noteRoute records the route key the request was addressed to.
+func noteRoute(ctx context.Context, route string) {
+	if rec := accessRecordOf(ctx); rec != nil {
+		rec.mu.Lock()
+		rec.route = route
+		rec.mu.Unlock()
+	}
+}
+
+// noteAccess records the access-control decision for the request.
+func noteAccess(ctx context.Context, username, authMethod string) {
+	if rec := accessRecordOf(ctx); rec != nil {
+		rec.mu.Lock()
+		rec.username, rec.authMethod = username, authMethod
+		rec.mu.Unlock()
+	}
+}
+
+func (rec *accessRecord) routeAndUser() (route, username string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.route, rec.username
+}
+
+// upstreamStarted and upstreamDone bracket the wait for the backend's response
+// headers, across all retry attempts.
+func upstreamStarted(ctx context.Context) {
+	if rec := accessRecordOf(ctx); rec != nil {
+		rec.upstreamStart = time.Now()
+	}
+}
+
+func upstreamDone(ctx context.Context) {
+	if rec := accessRecordOf(ctx); rec != nil && !rec.upstreamStart.IsZero() && rec.upstream == 0 {
+		rec.upstream = time.Since(rec.upstreamStart)
+	}
+}
+
+// accessWriter records the status and size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  atomic.Int64 // read live by the connection list
+}
+
+func (aw *accessWriter) WriteHeader(code int) {
+	if aw.status == 0 || aw.status < 200 && code >= 200 {
+		aw.status = code
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *accessWriter) Write(p []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(p)
+	aw.bytes.Add(int64(n))
+	return n, err
+}
+
+// Flush keeps streamed responses flowing through the wrapper.
+func (aw *accessWriter) Flush() {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	http.NewResponseController(aw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (aw *accessWriter) Unwrap() http.ResponseWriter { return aw.ResponseWriter }
+
+// withAccessLog runs next with an access record, lists the request among the
+// live connections while it runs, and queues its access-log entry once the
+// response is done.
+func withAccessLog(w http.ResponseWriter, r *http.Request, clientIP string, next func(http.ResponseWriter, *http.Request)) {
+	start := time.Now()
+	rec := &accessRecord{}
+	aw := &accessWriter{ResponseWriter: w}
+	uri := r.URL.RequestURI()
+	ctx, cancel := context.WithCancel(context.WithValue(r.Context(), accessRecordKey{}, rec))
+	defer cancel()
+	r = r.WithContext(ctx)
+	live := &liveRequest{
+		id: RequestID(ctx), ip: clientIP, method: r.Method, path: uri, start: start,
+		rec: rec, aw: aw, cancel: cancel,
+	}
+	liveConns.Store(live, struct{}{})
+	next(aw, r)
+	liveConns.Delete(live)
+
+	status := aw.status
+	if status == 0 {
+		status = http.StatusOK // the server sends 200 for a handler that wrote nothing
+	}
+	if rec.authMethod != "" {
+		recordRouteDuration(rec.route, status, time.Since(start))
+	}
+	enqueueAccess(storage.AccessEvent{
+		IP:         clientIP,
+		Username:   rec.username,
+		RouteUrl:   rec.route,
+		RequestID:  RequestID(r.Context()),
+		Protocol:   "http",
+		Method:     r.Method,
+		Path:       uri,
+		Proto:      r.Proto,
+		Status:     status,
+		Bytes:      aw.bytes.Load(),
+		DurationMs: milliseconds(time.Since(start)),
+		UpstreamMs: milliseconds(rec.upstream),
+		UserAgent:  r.UserAgent(),
+		Referer:    r.Referer(),
+		AuthMethod: rec.authMethod,
+		CreatedAt:  start,
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// formatCLF renders an HTTP entry in Common Log Format, or Combined with the
+// referer and user agent appended.
+func formatCLF(e storage.AccessEvent, combined bool) string {
+	user := e.Username
+	if user == "" || e.AuthMethod == AuthDenied {
+		user = "-"
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		e.IP, strings.ReplaceAll(user, " ", "_"), e.CreatedAt.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, clfQuote(e.Path), e.Proto, e.Status, size)
+	if combined {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", clfQuote(e.Referer), clfQuote(e.UserAgent))
+	}
+	return line + "\n"
+}
+
+// clfQuote escapes what would break a quoted CLF field; "-" for empty.
+func clfQuote(s string) string {
+	if s == "" {
+		return "-"
+	}
+	q := strconv.Quote(s)
+	return q[1 : len(q)-1]
+}
+
+// rotatingFile is an append-only file that is renamed to <path>.1 (shifting
+// older backups up) once it would grow past maxBytes or, if every is set, once
+// the current period (e.g. the UTC day) has ended. A rotation that fails keeps
+// writing to the current file and is retried after rotateRetry. It is safe for
+// concurrent use.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	backups  int
+	every    time.Duration
+	mu       sync.Mutex
+	f        *os.File
+	closed   bool
+	size     int64
+	period   time.Time // start of the period the open file covers
+	retryAt  time.Time // no rotation is attempted before this, after a failed one
+}
+
+// rotateRetry is how long a failed rotation is postponed, so a persistent
+// failure does not shift the backups on every write.
+const rotateRetry = time.Minute
+
+func openRotatingFile(path string, maxBytes int64, backups int, every time.Duration) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups, every: every}
+	return rf, rf.open()
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	if rf.every > 0 {
+		// A file left over from an earlier run belongs to the period it was
+		// last written in.
+		rf.period = fi.ModTime().Truncate(rf.every)
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.closed {
+		return 0, os.ErrClosed
+	}
+	now := time.Now()
+	if rf.f != nil && now.After(rf.retryAt) {
+		due := rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes
+		if rf.every > 0 && now.Truncate(rf.every).After(rf.period) {
+			if rf.size > 0 {
+				due = true
+			} else {
+				rf.period = now.Truncate(rf.every)
+			}
+		}
+		if due && rf.rotate() != nil {
+			rf.retryAt = now.Add(rotateRetry)
+		}
+	}
+	if rf.f == nil {
+		// The file could not be reopened after an earlier rotation.
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file to <path>.1 and reopens path. The file is
+// closed first, since Windows cannot rename an open file; it is reopened even
+// when the rename fails, so writing carries on in the old file.
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	rf.f = nil
+	for i := rf.backups; i > 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i-1), fmt.Sprintf("%s.%d", rf.path, i))
+	}
+	err := os.Rename(rf.path, rf.path+".1")
+	if oerr := rf.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.closed = true
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drainAccessLog empties the access-log queue and returns what was in it.
+func drainAccessLog() []storage.AccessEvent {
+	var out []storage.AccessEvent
+	for {
+		select {
+		case e := <-logChan:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+func TestAccessLogRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+
+	h, err := createHandlerForRoute(&ProxyRoute{Url: "x:80", Target: strings.TrimPrefix(backend.URL, "http://"), Type: "proxy"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := &listenServer{Port: "80"}
+	ls.handlers.Store(map[string]http.Handler{"x": h})
+	p := &Proxy{servers: map[string]*listenServer{"80": ls}}
+
+	drainAccessLog()
+	req := httptest.NewRequest(http.MethodPost, "http://x/items?id=7", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	rec := httptest.NewRecorder()
+	p.route(rec, req)
+	p.route(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://unknown/", nil))
+
+	got := drainAccessLog()
+	if len(got) != 2 {
+		t.Fatalf("want 2 entries, got %d", len(got))
+	}
+	e := got[0]
+	if e.Protocol != "http" || e.Method != http.MethodPost || e.Path != "/items?id=7" || e.RouteUrl != "x:80" ||
+		e.Status != http.StatusCreated || e.Bytes != 5 || e.UserAgent != "test-agent" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.RequestID == "" || e.RequestID != rec.Header().Get("X-Request-Id") {
+		t.Errorf("request ID %q does not match the response's", e.RequestID)
+	}
+	if e.UpstreamMs < 5 || e.DurationMs < e.UpstreamMs {
+		t.Errorf("timing: upstream %v ms, total %v ms", e.UpstreamMs, e.DurationMs)
+	}
+	if e := got[1]; e.Status != http.StatusNotFound || e.RouteUrl != "unknown:80" || e.UpstreamMs != 0 || e.AuthMethod != "" {
+		t.Errorf("unknown host entry %+v", e)
+	}
+}
+
+func TestFormatCLF(t *testing.T) {
+	e := storage.AccessEvent{
+		IP: "192.0.2.1", Username: "Unauthorized User", Method: "GET", Path: `/a "b"`, Proto: "HTTP/1.1",
+		Status: 403, UserAgent: "curl/8", AuthMethod: AuthDenied,
+		CreatedAt: time.Date(2026, 10, 19, 9, 12, 44, 0, time.UTC),
+	}
+	want := `192.0.2.1 - - [19/Oct/2026:09:12:44 +0000] "GET /a \"b\" HTTP/1.1" 403 -`
+	if got := formatCLF(e, false); got != want+"\n" {
+		t.Errorf("common:\n got %q\nwant %q", got, want)
+	}
+	if got := formatCLF(e, true); got != want+` "-" "curl/8"`+"\n" {
+		t.Errorf("combined: got %q", got)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.jsonl")
+	rf, err := openRotatingFile(path, 10, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf.Close()
+
+	for name, want := range map[string]string{"": "four\n", ".1": "three\n", ".2": "one\ntwo\n"} {
+		if b, _ := os.ReadFile(path + name); string(b) != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(path+name), b, want)
+		}
+	}
+}
+
+// A rotation that fails, here because a directory is in the way of the
+// backup, must keep writing to the current file and try again later.
+func TestRotatingFileRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.jsonl")
+	if err := os.Mkdir(path+".1", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := openRotatingFile(path, 10, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("write %q after a failed rotation: %v", line, err)
+		}
+	}
+	if b, _ := os.ReadFile(path); string(b) != "one\ntwo\nthree\n" {
+		t.Fatalf("want every line in the current file, got %q", b)
+	}
+
+	os.Remove(path + ".1")
+	rf.retryAt = time.Time{}
+	if _, err := rf.Write([]byte("four\n")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"": "four\n", ".1": "one\ntwo\nthree\n"} {
+		if b, _ := os.ReadFile(path + name); string(b) != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(path+name), b, want)
+		}
+	}
+}
+
+func TestRotatingFileByTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := openRotatingFile(path, 1<<20, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("old\n"))
+	rf.period = rf.period.Add(-time.Hour) // as if written in the previous hour
+	rf.Write([]byte("new\n"))
+	rf.Write([]byte("newer\n"))
+	rf.Close()
+
+	for name, want := range map[string]string{"": "new\nnewer\n", ".1": "old\n"} {
+		if b, _ := os.ReadFile(path + name); string(b) != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(path+name), b, want)
+		}
+	}
+}
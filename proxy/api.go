@@ -15,7 +15,7 @@ func createAPIHandler(route *ProxyRoute) (http.Handler, error) {
 	}
 
 	wrapped := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slog.Debug("api handler called",
+		slog.DebugContext(r.Context(), "api handler called",
 			"function", route.Target,
 			"path", r.URL.Path,
 		)
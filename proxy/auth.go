@@ -10,6 +10,9 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -17,19 +20,17 @@ var (
 	authCache      atomic.Value // stores map[string]cachedRoute
 	globalSettings atomic.Value // stores storage.Settings
 
-	logChan = make(chan logEntry, 512)
+	logChan = make(chan storage.AccessEvent, 512)
 )
 
-type logEntry struct {
-	ip, username, route string
-}
-
 type cachedRoute struct {
 	storage.Route
 	groupSet     map[string]struct{} // pre-parsed from AllowedGroups ("1","3",…)
 	groupIDs     []int               // same groups as ints, for IP-session group filtering
 	allowedAddrs []net.IP            // pre-parsed plain IPs from AllowedIPs
 	allowedNets  []*net.IPNet        // pre-parsed CIDR ranges from AllowedIPs
+	maintenance  *maintenanceState   // nil unless maintenance is enabled
+	errorPages   *errorPageSet       // route's error_pages override, or nil
 }
 
 // InitAuth initialises the auth subsystem and returns a stop function.
@@ -48,7 +49,14 @@ func InitAuth(ctx context.Context, s *storage.Storage) func() {
 	go func() {
 		defer close(logDrained)
 		for e := range logChan {
-			authStore.LogAccess(context.Background(), e.ip, e.username, e.route)
+			writeAccess(e)
+		}
+	}()
+	flowsDrained := make(chan struct{})
+	go func() {
+		defer close(flowsDrained)
+		for f := range flowChan {
+			authStore.RecordFlow(context.Background(), f)
 		}
 	}()
 
@@ -62,7 +70,8 @@ func InitAuth(ctx context.Context, s *storage.Storage) func() {
 				bctx := context.Background()
 				authStore.CleanupExpiredSessions(bctx)
 				authStore.CleanupExpiredInvites(bctx)
-				authStore.CleanupOldAccessLog(bctx)
+				cleanupAccessLog(bctx)
+				authStore.CleanupOldFlows(bctx, flowsKept)
 				authStore.CleanupExpiredBans(bctx)
 				sweepBuckets()
 			case <-ctx.Done():
@@ -73,7 +82,10 @@ func InitAuth(ctx context.Context, s *storage.Storage) func() {
 
 	return func() {
 		close(logChan)
+		close(flowChan)
 		<-logDrained
+		<-flowsDrained
+		closeAccessLog()
 	}
 }
 
@@ -112,20 +124,35 @@ func parseCachedRoute(r storage.Route) cachedRoute {
 			}
 		}
 	}
-	for _, entry := range strings.Split(r.AllowedIPs, ",") {
+	cr.allowedAddrs, cr.allowedNets = parseIPList(r.AllowedIPs)
+	cr.maintenance = newMaintenanceState(r)
+	if pages, err := loadErrorPages(r.ErrorPages); err != nil {
+		slog.Warn("route error pages unavailable, using the global ones", "route", r.Url, "error", err)
+	} else {
+		cr.errorPages = pages
+	}
+	return cr
+}
+
+// parseIPList splits a comma-separated list of IPs and CIDR ranges, skipping
+// entries that do not parse.
+func parseIPList(list string) ([]net.IP, []*net.IPNet) {
+	var addrs []net.IP
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
 		entry = strings.TrimSpace(entry)
 		if entry == "" {
 			continue
 		}
 		if strings.Contains(entry, "/") {
 			if _, n, err := net.ParseCIDR(entry); err == nil {
-				cr.allowedNets = append(cr.allowedNets, n)
+				nets = append(nets, n)
 			}
 		} else if ip := net.ParseIP(entry); ip != nil {
-			cr.allowedAddrs = append(cr.allowedAddrs, ip)
+			addrs = append(addrs, ip)
 		}
 	}
-	return cr
+	return addrs, nets
 }
 
 // isTCP / isUDP report which raw listeners a route type needs. "tcp+udp" binds
@@ -136,8 +163,8 @@ func isUDP(t string) bool { return t == "udp" || t == "tcp+udp" }
 func isRaw(t string) bool { return isTCP(t) || isUDP(t) }
 
 // authorizeIP enforces IP-based access control for a raw (TCP/UDP) flow. It
-// returns whether the client IP is allowed and, when authorised via IP session
-// auth, the matched username for access logging. A route with no auth configured
+// returns whether the client IP is allowed, how it was decided and, when
+// authorised via IP session auth, the matched username for access logging. A route with no auth configured
 // (no ip_auth, no groups, no allowed_ips) — or one not yet in the cache — is
 // public and always allowed.
 //
@@ -146,31 +173,75 @@ func isRaw(t string) bool { return isTCP(t) || isUDP(t) }
 // auth regardless of the ip_auth flag — otherwise a group-restricted route with
 // ip_auth off would fail open and pass everyone. The static IP allowlist is a
 // separate fallback that always grants matching IPs.
-func authorizeIP(routeUrl, clientIP string) (authorized bool, accessUser string) {
+func authorizeIP(ctx context.Context, routeUrl, clientIP string) (authorized bool, accessUser, authMethod string) {
 	m := authCache.Load().(map[string]cachedRoute)
 	route, found := m[routeUrl]
 	if !found || (!route.IPAuth && route.AllowedGroups == "" && route.AllowedIPs == "") {
-		return true, ""
+		return true, "", AuthPublic
 	}
 
 	if (route.IPAuth || route.AllowedGroups != "") && authStore != nil {
 		// The returned session is in an allowed group (enforced by the lookup);
 		// orphaned/non-matching sessions on the same IP are skipped.
-		if sg, err := authStore.ValidateSessionByIPInGroups(context.Background(), clientIP, route.groupIDs); err == nil {
+		if sg, err := validateIPSession(ctx, routeUrl, clientIP, route.groupIDs); err == nil {
 			gs := globalSettings.Load().(storage.Settings)
 			if gs.RenewOnAccess {
 				// Renew every session this user holds on the IP so raw-protocol
 				// activity keeps the browser session alive too.
-				authStore.ExtendUserSessionsByIP(context.Background(), sg.UserID, clientIP, gs.SessionDur())
+				authStore.ExtendUserSessionsByIP(ctx, sg.UserID, clientIP, gs.SessionDur())
 			}
-			return true, sg.Username
+			return true, sg.Username, AuthIPSession
 		}
 	}
 
 	if route.AllowedIPs != "" && ipAllows(route, clientIP) {
-		return true, ""
+		return true, "", AuthIPAllowlist
 	}
-	return false, ""
+	return false, "", AuthDenied
+}
+
+// recordFlowAuth counts the decision authorizeIP made for a TCP/UDP flow.
+func recordFlowAuth(routeUrl, protocol string, authorized bool, method string) {
+	if authorized {
+		recordAuthDecision(routeUrl, protocol, method, authAllowed)
+	} else {
+		recordAuthDecision(routeUrl, protocol, "none", authNoCredentials)
+	}
+}
+
+// recentSessions logs the newest sessions. The query runs only when a record
+// carrying it is actually written, i.e. with auth debug logging on.
+type recentSessions struct{ ctx context.Context }
+
+func (s recentSessions) LogValue() slog.Value {
+	return slog.AnyValue(authStore.DebugDumpSessions(s.ctx, 10))
+}
+
+// validateIPSession looks up an IP session in one of groupIDs, in a span.
+func validateIPSession(ctx context.Context, rk, clientIP string, groupIDs []int) (*storage.SessionWithGroups, error) {
+	ctx, span := tracer.Start(ctx, "auth.validate_ip_session", trace.WithAttributes(
+		attribute.String("route", rk), attribute.String("client.address", clientIP)))
+	defer span.End()
+	sg, err := authStore.ValidateSessionByIPInGroups(ctx, clientIP, groupIDs)
+	endSessionSpan(span, sg, err)
+	return sg, err
+}
+
+// validateCookieSession looks up the session of a session cookie, in a span.
+func validateCookieSession(ctx context.Context, rk, token string) (*storage.SessionWithGroups, error) {
+	ctx, span := tracer.Start(ctx, "auth.validate_session", trace.WithAttributes(attribute.String("route", rk)))
+	defer span.End()
+	sg, err := authStore.ValidateSessionAndGroups(ctx, token)
+	endSessionSpan(span, sg, err)
+	return sg, err
+}
+
+func endSessionSpan(span trace.Span, sg *storage.SessionWithGroups, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.Bool("auth.valid", false))
+		return // a missing session is an answer, not an error
+	}
+	span.SetAttributes(attribute.Bool("auth.valid", true), attribute.String("user.name", sg.Username))
 }
 
 // withAuthForKey returns a handler pre-bound to rk that enforces access control.
@@ -187,14 +258,15 @@ func withAuthForKey(rk string, next http.Handler) http.Handler {
 		// Cheapest gates first, before any DB work: a banned IP is dropped, then
 		// the per-tier rate limit (anonymous by default for unseen IPs).
 		if IsBanned(clientIP) {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			RecordEvent(clientIP, rk, OutcomeBanned)
+			writeError(w, r, rk, http.StatusForbidden)
+			RecordEventContext(r.Context(), clientIP, rk, OutcomeBanned)
 			return
 		}
 		if allowed, retry := Allow(clientIP); !allowed {
 			w.Header().Set("Retry-After", strconv.Itoa(retry))
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			RecordEvent(clientIP, rk, OutcomeRateLimited)
+			writeError(w, r, rk, http.StatusTooManyRequests)
+			RecordEventContext(r.Context(), clientIP, rk, OutcomeRateLimited)
+			recordRateLimited(rk)
 			RecordFailure(clientIP)
 			return
 		}
@@ -217,9 +289,9 @@ func withAuthForKey(rk string, next http.Handler) http.Handler {
 		}
 
 		if !found {
-			http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
-			slog.Debug("auth deny: route not in cache", base...)
-			denyAuth(clientIP, rk)
+			writeError(w, r, rk, http.StatusProxyAuthRequired)
+			slog.DebugContext(r.Context(), "auth deny: route not in cache", base...)
+			denyAuth(r.Context(), clientIP, rk, "none", authNoRoute)
 			return
 		}
 
@@ -233,9 +305,10 @@ func withAuthForKey(rk string, next http.Handler) http.Handler {
 
 		// Public route: no restrictions configured.
 		if !route.IPAuth && route.AllowedGroups == "" && route.AllowedIPs == "" && !route.RequireLogin {
-			slog.Debug("auth allow: public route", base...)
-			logAccess(clientIP, "", rk)
-			RecordEvent(clientIP, rk, OutcomeServed)
+			slog.DebugContext(r.Context(), "auth allow: public route", base...)
+			noteAccess(r.Context(), "", AuthPublic)
+			recordAuthDecision(rk, "http", AuthPublic, authAllowed)
+			RecordEventContext(r.Context(), clientIP, rk, OutcomeServed)
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -246,21 +319,22 @@ func withAuthForKey(rk string, next http.Handler) http.Handler {
 		// is in the allowed groups (the lookup enforces both, skipping orphaned and
 		// non-matching-user sessions on the same IP). A returned session is authorized.
 		if route.IPAuth {
-			sg, err := authStore.ValidateSessionByIPInGroups(r.Context(), clientIP, route.groupIDs)
+			sg, err := validateIPSession(r.Context(), rk, clientIP, route.groupIDs)
 			if err != nil {
-				slog.Debug("auth: no authorized ip session for match_ip, falling through",
-					append(base, "error", err.Error(), "recent_sessions", authStore.DebugDumpSessions(r.Context(), 10))...)
+				slog.DebugContext(r.Context(), "auth: no authorized ip session for match_ip, falling through",
+					append(base, "error", err.Error(), "recent_sessions", recentSessions{r.Context()})...)
 			} else {
 				if gs.RenewOnAccess {
 					// Renew every session this user holds on the IP so HTTP and TCP
 					// activity keep each other's sessions alive (see ExtendUserSessionsByIP).
 					authStore.ExtendUserSessionsByIP(r.Context(), sg.UserID, clientIP, gs.SessionDur())
 				}
-				slog.Debug("auth allow: ip session", append(base, "user", sg.Username, "session_groups", sg.GroupIDs)...)
-				logAccess(clientIP, sg.Username, rk)
-				RecordEvent(clientIP, rk, OutcomeServed)
+				slog.DebugContext(r.Context(), "auth allow: ip session", append(base, "user", sg.Username, "session_groups", sg.GroupIDs)...)
+				noteAccess(r.Context(), sg.Username, AuthIPSession)
+				recordAuthDecision(rk, "http", AuthIPSession, authAllowed)
+				RecordEventContext(r.Context(), clientIP, rk, OutcomeServed)
 				SetTier(clientIP, ResolveTier(sg.GroupIDs))
-				next.ServeHTTP(w, r)
+				next.ServeHTTP(w, withAccessScope(r, "user:"+strconv.Itoa(sg.UserID), sg.Username))
 				return
 			}
 		}
@@ -268,13 +342,14 @@ func withAuthForKey(rk string, next http.Handler) http.Handler {
 		// Static IP allowlist: matching IP grants access without a session.
 		if route.AllowedIPs != "" {
 			if ipAllows(route, clientIP) {
-				slog.Debug("auth allow: ip allowlist", base...)
-				logAccess(clientIP, "", rk)
-				RecordEvent(clientIP, rk, OutcomeServed)
-				next.ServeHTTP(w, r)
+				slog.DebugContext(r.Context(), "auth allow: ip allowlist", base...)
+				noteAccess(r.Context(), "", AuthIPAllowlist)
+				recordAuthDecision(rk, "http", AuthIPAllowlist, authAllowed)
+				RecordEventContext(r.Context(), clientIP, rk, OutcomeServed)
+				next.ServeHTTP(w, withAccessScope(r, "ip:"+clientIP, ""))
 				return
 			}
-			slog.Debug("auth: match_ip not in allowlist, falling through", base...)
+			slog.DebugContext(r.Context(), "auth: match_ip not in allowlist, falling through", base...)
 		}
 
 		// Cookie (persistent-login) auth — an independent alternative to IP session
@@ -282,39 +357,39 @@ func withAuthForKey(rk string, next http.Handler) http.Handler {
 		// auth at all: even a valid session cookie is ignored and the request denied
 		// (the IP checks above were the only way in). The cookie is never touched.
 		if !route.PersistentLogin {
-			http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
-			slog.Debug("auth deny: persistent-login (cookie) auth disabled for route", base...)
-			denyAuth(clientIP, rk)
+			writeError(w, r, rk, http.StatusProxyAuthRequired)
+			slog.DebugContext(r.Context(), "auth deny: persistent-login (cookie) auth disabled for route", base...)
+			denyAuth(r.Context(), clientIP, rk, "none", authNoCredentials)
 			return
 		}
 
 		// Cookie auth needs either an allowed group or require_login (any session).
 		if len(route.groupSet) == 0 && !route.RequireLogin {
-			http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
-			slog.Debug("auth deny: no allowed groups and ip checks failed", base...)
-			denyAuth(clientIP, rk)
+			writeError(w, r, rk, http.StatusProxyAuthRequired)
+			slog.DebugContext(r.Context(), "auth deny: no allowed groups and ip checks failed", base...)
+			denyAuth(r.Context(), clientIP, rk, "none", authNoCredentials)
 			return
 		}
 
 		c, err := r.Cookie("session")
 		if err != nil {
-			http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
-			slog.Debug("auth deny: no session cookie", base...)
-			denyAuth(clientIP, rk)
+			writeError(w, r, rk, http.StatusProxyAuthRequired)
+			slog.DebugContext(r.Context(), "auth deny: no session cookie", base...)
+			denyAuth(r.Context(), clientIP, rk, AuthCookie, authNoCredentials)
 			return
 		}
-		sg, err := authStore.ValidateSessionAndGroups(r.Context(), c.Value)
+		sg, err := validateCookieSession(r.Context(), rk, c.Value)
 		if err != nil {
-			http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
-			slog.Debug("auth deny: invalid/expired session cookie", append(base, "error", err.Error())...)
-			denyAuth(clientIP, rk)
+			writeError(w, r, rk, http.StatusProxyAuthRequired)
+			slog.DebugContext(r.Context(), "auth deny: invalid/expired session cookie", append(base, "error", err.Error())...)
+			denyAuth(r.Context(), clientIP, rk, AuthCookie, authInvalidSession)
 			return
 		}
 		// require_login accepts any valid session; otherwise enforce group membership.
 		if !route.RequireLogin && !groupsAllow(route.groupSet, sg.GroupIDs) {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			slog.Debug("auth deny: cookie user not in allowed group", append(base, "user", sg.Username, "session_groups", sg.GroupIDs)...)
-			denyAuth(clientIP, rk)
+			writeError(w, r, rk, http.StatusForbidden)
+			slog.DebugContext(r.Context(), "auth deny: cookie user not in allowed group", append(base, "user", sg.Username, "session_groups", sg.GroupIDs)...)
+			denyAuth(r.Context(), clientIP, rk, AuthCookie, authForbidden)
 			return
 		}
 		if gs.RenewOnAccess {
@@ -324,28 +399,50 @@ func withAuthForKey(rk string, next http.Handler) http.Handler {
 		// (persistent by default) and the DB session — kept alive by access,
 		// including TCP — is the authority on validity. IP auth and cookie auth are
 		// independent; neither path rewrites the other's cookie.
-		slog.Debug("auth allow: cookie session", append(base, "user", sg.Username)...)
-		logAccess(clientIP, sg.Username, rk)
-		RecordEvent(clientIP, rk, OutcomeServed)
+		slog.DebugContext(r.Context(), "auth allow: cookie session", append(base, "user", sg.Username)...)
+		noteAccess(r.Context(), sg.Username, AuthCookie)
+		recordAuthDecision(rk, "http", AuthCookie, authAllowed)
+		RecordEventContext(r.Context(), clientIP, rk, OutcomeServed)
 		SetTier(clientIP, ResolveTier(sg.GroupIDs))
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withAccessScope(r, "user:"+strconv.Itoa(sg.UserID), sg.Username))
 	})
 }
 
-// denyAuth records an HTTP authorization denial: the DB access-log entry plus the
-// in-memory denied event, and feeds the failure counter that drives auto-ban.
-func denyAuth(clientIP, rk string) {
-	logAccess(clientIP, "Unauthorized User", rk)
-	RecordEvent(clientIP, rk, OutcomeDenied)
-	RecordFailure(clientIP)
+type accessKey struct{}
+
+// access is who a request was authorized as.
+type access struct {
+	scope    string // "user:<id>" or "ip:<addr>"
+	username string // "" when authorized by IP allowlist
 }
 
-func logAccess(ip, username, route string) {
-	select {
-	case logChan <- logEntry{ip, username, route}:
-	default:
-		// Drop rather than stall the request handler if the log queue is full.
-	}
+// withAccessScope records on the request who it was authorized as, so per-user
+// state such as the response cache can be kept apart and header rules can name
+// the user. Requests to public routes carry no scope.
+func withAccessScope(r *http.Request, scope, username string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), accessKey{}, access{scope, username}))
+}
+
+// accessScope returns the scope set by withAccessScope, or "" for public routes.
+func accessScope(ctx context.Context) string {
+	a, _ := ctx.Value(accessKey{}).(access)
+	return a.scope
+}
+
+// accessUsername returns the signed-in user a request was authorized as, or "".
+func accessUsername(ctx context.Context) string {
+	a, _ := ctx.Value(accessKey{}).(access)
+	return a.username
+}
+
+// denyAuth records an HTTP authorization denial: the DB access-log entry, the
+// in-memory denied event and the auth-decision metric, and feeds the failure
+// counter that drives auto-ban.
+func denyAuth(ctx context.Context, clientIP, rk, method, outcome string) {
+	noteAccess(ctx, "Unauthorized User", AuthDenied)
+	recordAuthDecision(rk, "http", method, outcome)
+	RecordEventContext(ctx, clientIP, rk, OutcomeDenied)
+	RecordFailure(clientIP)
 }
 
 // groupsAllow returns true if any of the user's group IDs appear in the pre-parsed set.
@@ -376,16 +473,21 @@ func extractClientIP(r *http.Request) string {
 
 // ipAllows returns true if clientIP matches any pre-parsed entry in cr.
 func ipAllows(cr cachedRoute, clientIP string) bool {
+	return ipInList(cr.allowedAddrs, cr.allowedNets, clientIP)
+}
+
+// ipInList reports whether clientIP is one of addrs or inside one of nets.
+func ipInList(addrs []net.IP, nets []*net.IPNet, clientIP string) bool {
 	addr := net.ParseIP(clientIP)
 	if addr == nil {
 		return false
 	}
-	for _, ip := range cr.allowedAddrs {
+	for _, ip := range addrs {
 		if ip.Equal(addr) {
 			return true
 		}
 	}
-	for _, n := range cr.allowedNets {
+	for _, n := range nets {
 		if n.Contains(addr) {
 			return true
 		}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"log/slog"
+	"reMazarin/storage"
+	"sync"
+	"time"
+)
+
+// Circuit breakers. Every backend of a route gets its own breaker, fed with the
+// outcome of each request or TCP dial sent to it. Once the share of bad calls
+// (errors, 5xx, or calls slower than the route's latency threshold) in the
+// rolling window reaches the route's threshold the circuit opens: the backend
+// is skipped, and when no backend is left the request fails fast (503 or the
+// route's fallback) instead of piling up on a dead host. After the open period
+// a few probe calls are let through; if they all succeed the circuit closes.
+
+const (
+	defaultBreakerWindow      = 30 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerOpen        = 30 * time.Second
+	// defaultBreakerErrorPct applies when a route sets only a latency threshold.
+	defaultBreakerErrorPct = 50
+	// breakerProbes is both the number of concurrent half-open probe calls and
+	// the number of successes needed to close the circuit again.
+	breakerProbes = 3
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half_open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// breakerBucket counts the calls that finished within one second.
+type breakerBucket struct {
+	sec   int64
+	total int
+	bad   int
+}
+
+type breaker struct {
+	routeUrl    string
+	upstream    string
+	errorPct    int
+	slow        time.Duration
+	minRequests int
+	openFor     time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	openedAt time.Time
+	buckets  []breakerBucket // one per second of the window, indexed by sec % len
+	probes   int             // half-open probe calls in flight
+	probeOK  int             // half-open probe calls that succeeded
+}
+
+func newBreaker(routeUrl, upstream string, cfg storage.RouteBreaker) *breaker {
+	b := &breaker{
+		routeUrl:    routeUrl,
+		upstream:    upstream,
+		errorPct:    cfg.ErrorPct,
+		slow:        time.Duration(cfg.LatencyMs) * time.Millisecond,
+		minRequests: defaultBreakerMinRequests,
+		openFor:     defaultBreakerOpen,
+		now:         time.Now,
+	}
+	if b.errorPct <= 0 {
+		b.errorPct = defaultBreakerErrorPct
+	}
+	if cfg.MinRequests > 0 {
+		b.minRequests = cfg.MinRequests
+	}
+	if cfg.OpenSec > 0 {
+		b.openFor = time.Duration(cfg.OpenSec) * time.Second
+	}
+	window := defaultBreakerWindow
+	if cfg.WindowSec > 0 {
+		window = time.Duration(cfg.WindowSec) * time.Second
+	}
+	b.buckets = make([]breakerBucket, int(window/time.Second))
+	return b
+}
+
+// allow reports whether a call may be sent to the backend now. Every allowed
+// call must be followed by exactly one done.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.openFor {
+			return false
+		}
+		b.transition(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= breakerProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// release returns a call admitted by allow that was never sent, so it neither
+// counts toward the window nor holds a half-open probe slot.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// done records the outcome of a call admitted by allow.
+func (b *breaker) done(ok bool, latency time.Duration) {
+	bad := !ok || (b.slow > 0 && latency > b.slow)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		b.probes--
+		if bad {
+			b.transition(breakerOpen)
+			return
+		}
+		if b.probeOK++; b.probeOK >= breakerProbes {
+			b.transition(breakerClosed)
+		}
+		return
+	case breakerOpen:
+		// A call admitted before the circuit opened; it no longer counts.
+		return
+	}
+
+	sec := b.now().Unix()
+	bk := &b.buckets[sec%int64(len(b.buckets))]
+	if bk.sec != sec {
+		*bk = breakerBucket{sec: sec}
+	}
+	bk.total++
+	if bad {
+		bk.bad++
+	}
+
+	var total, badTotal int
+	for _, x := range b.buckets {
+		if sec-x.sec < int64(len(b.buckets)) {
+			total += x.total
+			badTotal += x.bad
+		}
+	}
+	if total >= b.minRequests && badTotal*100 >= total*b.errorPct {
+		b.transition(breakerOpen)
+	}
+}
+
+// transition moves the breaker to state to and reports it. Callers hold b.mu.
+func (b *breaker) transition(to breakerState) {
+	from := b.state
+	b.state = to
+	b.probes, b.probeOK = 0, 0
+	switch to {
+	case breakerOpen:
+		b.openedAt = b.now()
+	case breakerClosed:
+		clear(b.buckets)
+	}
+
+	outcome := OutcomeBreakerClosed
+	switch to {
+	case breakerOpen:
+		outcome = OutcomeBreakerOpen
+	case breakerHalfOpen:
+		outcome = OutcomeBreakerHalfOpen
+	}
+	RecordUpstreamEvent(b.routeUrl, b.upstream, outcome)
+	recordBreakerTransition(b.routeUrl, b.upstream, to)
+	slog.Warn("circuit breaker state changed",
+		"route", b.routeUrl, "upstream", b.upstream, "from", from.String(), "to", to.String())
+}
+
+// current returns the breaker's state for reporting.
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// breakers holds the live breakers, keyed by route URL and then backend, so
+// state can be reported and dropped when a route goes away.
+var breakers sync.Map // routeUrl → map[string]*breaker
+
+// newBreakers creates one breaker per backend of a route, replacing any from a
+// previous registration of the route. It returns nil when the route has no
+// breaker configured.
+func newBreakers(routeUrl string, upstreams []string, cfg storage.RouteBreaker) map[string]*breaker {
+	if !cfg.Enabled() {
+		breakers.Delete(routeUrl)
+		return nil
+	}
+	m := make(map[string]*breaker, len(upstreams))
+	for _, u := range upstreams {
+		m[u] = newBreaker(routeUrl, u, cfg)
+	}
+	breakers.Store(routeUrl, m)
+	return m
+}
+
+// dropBreakers forgets the breakers of a removed route.
+func dropBreakers(routeUrl string) { breakers.Delete(routeUrl) }
+
+// BreakerInfo is the reported state of one backend's breaker.
+type BreakerInfo struct {
+	Route    string `json:"route"`
+	Upstream string `json:"upstream"`
+	State    string `json:"state"`
+}
+
+// GetBreakers returns the state of every live circuit breaker.
+func GetBreakers() []BreakerInfo {
+	out := []BreakerInfo{}
+	breakers.Range(func(_, v any) bool {
+		for _, b := range v.(map[string]*breaker) {
+			out = append(out, BreakerInfo{Route: b.routeUrl, Upstream: b.upstream, State: b.current().String()})
+		}
+		return true
+	})
+	return out
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The breaker must open once the bad-call share crosses the threshold, refuse
+// calls while open, then close again after enough successful probes.
+func TestBreakerLifecycle(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newBreaker("x:80", "b:80", storage.RouteBreaker{ErrorPct: 50, MinRequests: 4, OpenSec: 10})
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		if !b.allow() {
+			t.Fatalf("closed breaker refused call %d", i)
+		}
+		b.done(i%2 == 0, time.Millisecond) // 2 of 4 bad = 50%
+	}
+	if b.current() != breakerOpen {
+		t.Fatalf("want open after 50%% bad calls, got %s", b.current())
+	}
+	if b.allow() {
+		t.Fatal("open breaker admitted a call")
+	}
+
+	now = now.Add(11 * time.Second)
+	for i := 0; i < breakerProbes; i++ {
+		if !b.allow() {
+			t.Fatalf("half-open breaker refused probe %d", i)
+		}
+	}
+	if b.allow() {
+		t.Fatal("half-open breaker admitted more than breakerProbes calls")
+	}
+	for i := 0; i < breakerProbes; i++ {
+		b.done(true, time.Millisecond)
+	}
+	if b.current() != breakerClosed {
+		t.Fatalf("want closed after successful probes, got %s", b.current())
+	}
+}
+
+// With only a latency threshold, slow calls alone must open the circuit, and a
+// failed probe must re-open it.
+func TestBreakerLatencyAndFailedProbe(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newBreaker("x:80", "b:80", storage.RouteBreaker{LatencyMs: 100, MinRequests: 2})
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		b.allow()
+		b.done(true, 200*time.Millisecond)
+	}
+	if b.current() != breakerOpen {
+		t.Fatalf("want open after slow calls, got %s", b.current())
+	}
+	now = now.Add(defaultBreakerOpen)
+	if !b.allow() {
+		t.Fatal("breaker did not half-open after the open period")
+	}
+	b.done(false, time.Millisecond)
+	if b.current() != breakerOpen {
+		t.Fatalf("want open after a failed probe, got %s", b.current())
+	}
+}
+
+// Bad calls that have left the rolling window must not count toward opening.
+func TestBreakerWindowExpires(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newBreaker("x:80", "b:80", storage.RouteBreaker{ErrorPct: 50, WindowSec: 5, MinRequests: 3})
+	b.now = func() time.Time { return now }
+
+	b.allow()
+	b.done(false, 0)
+	b.allow()
+	b.done(false, 0)
+	now = now.Add(6 * time.Second)
+	b.allow()
+	b.done(true, 0)
+	b.allow()
+	b.done(true, 0)
+	b.allow()
+	b.done(false, 0)
+	if b.current() != breakerClosed {
+		t.Fatalf("expired failures opened the breaker")
+	}
+}
+
+// Once every backend's circuit is open, requests fail fast with 503 without
+// reaching the backend, or are served by the route's fallback.
+func TestBreakerFailsFast(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("maintenance"))
+	}))
+	defer fallback.Close()
+
+	for _, fb := range []string{"", strings.TrimPrefix(fallback.URL, "http://")} {
+		calls = 0
+		route := &ProxyRoute{
+			Url:     "x:80",
+			Target:  strings.TrimPrefix(backend.URL, "http://"),
+			Type:    "proxy",
+			Breaker: storage.RouteBreaker{ErrorPct: 50, MinRequests: 2, Fallback: fb},
+		}
+		h, err := createHandlerForRoute(route, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/", nil))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://x/", nil))
+		if calls != 2 {
+			t.Fatalf("fallback %q: open circuit still reached the backend (%d calls)", fb, calls)
+		}
+		if fb == "" && (rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "") {
+			t.Fatalf("want 503 with Retry-After, got %d", rec.Code)
+		}
+		if fb != "" && rec.Body.String() != "maintenance" {
+			t.Fatalf("want fallback body, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	dropBreakers("x:80")
+}
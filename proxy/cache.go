@@ -0,0 +1,559 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Response caching for proxy routes. A caching route answers GET/HEAD requests
+// from the shared cacheStore while a stored response is fresh, and revalidates
+// stale ones with the backend (If-None-Match / If-Modified-Since) so an
+// unchanged resource costs a 304 instead of a full transfer. Freshness follows
+// the backend's Cache-Control / Expires; the route's default TTL only applies
+// when the backend says nothing.
+//
+// Responses are shared between clients only when that is safe. On routes with
+// access control every authorized request carries an access scope (user or IP,
+// see withAccessScope); responses to it are stored under that scope and never
+// served to anyone else unless the backend marks them public. Cache-Control:
+// private is always stored per scope, or not at all for anonymous requests.
+
+const (
+	cacheHit         = "hit"
+	cacheMiss        = "miss"
+	cacheRevalidated = "revalidated"
+	cacheBypass      = "bypass"
+)
+
+// cacheableStatus lists the status codes stored (RFC 9111 heuristically cacheable).
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+var respCache atomic.Pointer[cacheStore]
+
+// ConfigureCache sets up the response cache shared by every caching route.
+// Without it, caching routes use a memory-only cache of the default size.
+func ConfigureCache(cfg CacheConfig) error {
+	if ps := CheckCache(cfg); len(ps) > 0 {
+		return ps[0]
+	}
+	s, err := newCacheStore(cfg)
+	if err != nil {
+		return err
+	}
+	respCache.Store(s)
+	return nil
+}
+
+// CheckCache reports every problem with cfg that ConfigureCache would refuse,
+// without creating the disk tier. Field is the [cache] key at fault.
+func CheckCache(cfg CacheConfig) []Problem {
+	if cfg.Dir == "" {
+		return nil
+	}
+	if fi, err := os.Stat(cfg.Dir); err == nil && !fi.IsDir() {
+		return []Problem{{"dir", xerrors.Newf("cache dir %s: not a directory", cfg.Dir)}}
+	}
+	return nil
+}
+
+func sharedCache() *cacheStore {
+	if s := respCache.Load(); s != nil {
+		return s
+	}
+	s, _ := newCacheStore(CacheConfig{})
+	if respCache.CompareAndSwap(nil, s) {
+		return s
+	}
+	return respCache.Load()
+}
+
+// withCache wraps a proxy route's handler with the response cache, if the
+// route enables it.
+func withCache(route *ProxyRoute, next http.Handler) http.Handler {
+	if !route.Cache.Enabled {
+		return next
+	}
+	defaultTTL := time.Duration(route.Cache.DefaultTTL) * time.Second
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store := sharedCache()
+		reqCC := parseCacheControl(r.Header)
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+			r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" || reqCC.has("no-store") {
+			countCache(route.Url, cacheBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		private := privateScope(r)
+		now := time.Now()
+		key, e := lookupCache(store, route.Url, private, r)
+		if e != nil && !reqCC.has("no-cache") && reqCC["max-age"] != "0" && now.Before(e.Expires) {
+			countCache(route.Url, cacheHit)
+			serveCached(w, r, e, now, "HIT")
+			return
+		}
+
+		cw := &cacheWriter{ResponseWriter: w, max: store.maxObject}
+		out := r
+		if e != nil && r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+			etag, lm := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+			if etag != "" || lm != "" {
+				out = r.Clone(r.Context())
+				if etag != "" {
+					out.Header.Set("If-None-Match", etag)
+				}
+				if lm != "" {
+					out.Header.Set("If-Modified-Since", lm)
+				}
+				cw.revalidating = true
+			}
+		}
+		next.ServeHTTP(cw, out)
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK) // the backend wrote nothing
+		}
+
+		if cw.notModified {
+			// The backend confirmed the stored copy: refresh its headers and
+			// freshness, then answer from it. A cookie it set is for this
+			// client only and never joins the entry.
+			updated := *e
+			updated.Header = e.Header.Clone()
+			for k, vs := range storableHeader(cw.header) {
+				if k != "Content-Length" {
+					updated.Header[k] = vs
+				}
+			}
+			cc := parseCacheControl(updated.Header)
+			updated.Stored = now
+			updated.Expires = now.Add(freshness(updated.Header, cc, e.Shared, now, defaultTTL))
+			store.put(key, &updated)
+			for _, c := range cw.header.Values("Set-Cookie") {
+				w.Header().Add("Set-Cookie", c)
+			}
+			countCache(route.Url, cacheRevalidated)
+			serveCached(w, r, &updated, now, "REVALIDATED")
+			return
+		}
+
+		countCache(route.Url, cacheMiss)
+		if r.Method == http.MethodGet && !cw.tooBig && cacheableStatus[cw.status] {
+			storeResponse(store, route.Url, private, r, cw.status, cw.header, cw.buf.Bytes(), now, defaultTTL)
+		}
+	})
+}
+
+// cacheControl holds parsed Cache-Control directives, lower-cased.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(val, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(d string) bool {
+	_, ok := cc[d]
+	return ok
+}
+
+func (cc cacheControl) seconds(d string) (time.Duration, bool) {
+	v, ok := cc[d]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, true // malformed: treat as stale
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// freshness returns how long a response stays fresh from now.
+func freshness(h http.Header, cc cacheControl, shared bool, now time.Time, def time.Duration) time.Duration {
+	if cc.has("no-cache") {
+		return 0
+	}
+	var life time.Duration
+	if v, ok := cc.seconds("s-maxage"); ok && shared {
+		life = v
+	} else if v, ok := cc.seconds("max-age"); ok {
+		life = v
+	} else if exp := h.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			return 0 // an invalid Expires means already expired
+		}
+		date := now
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		life = t.Sub(date)
+	} else {
+		life = def
+	}
+	if age, err := strconv.Atoi(h.Get("Age")); err == nil {
+		life -= time.Duration(age) * time.Second
+	}
+	return max(life, 0)
+}
+
+// privateScope returns the scope a request's non-shared responses are stored
+// under: its access scope on protected routes, otherwise a hash of the
+// credentials it carries. "" means the request is anonymous.
+func privateScope(r *http.Request) string {
+	if s := accessScope(r.Context()); s != "" {
+		return s
+	}
+	auth, cookie := r.Header.Get("Authorization"), r.Header.Get("Cookie")
+	if auth == "" && cookie == "" {
+		return ""
+	}
+	return "cred:" + hashName(auth+"\n"+cookie)
+}
+
+// storeScope decides whether a response may be stored and under which scope
+// ("" = shared).
+func storeScope(cc cacheControl, r *http.Request, private string) (string, bool) {
+	switch {
+	case cc.has("private"):
+		return private, private != ""
+	case cc.has("public") || cc.has("s-maxage"):
+		return "", true
+	case r.Header.Get("Authorization") != "" || accessScope(r.Context()) != "":
+		// Responses to authenticated requests are only shared when marked public.
+		return private, private != ""
+	}
+	return "", true
+}
+
+func cacheKey(routeUrl, scope string, r *http.Request) string {
+	return routeUrl + "\x00" + scope + "\x00" + r.Host + r.URL.RequestURI()
+}
+
+// variantKey extends a base key with the request's values of the Vary headers.
+func variantKey(base string, r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteString("\x00" + name + "=" + strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// varyHeaders returns the canonical request header names a response varies on.
+func varyHeaders(h http.Header) []string {
+	var out []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				out = append(out, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// lookupCache finds the stored response for r, trying the private scope before
+// the shared one and following Vary markers.
+func lookupCache(s *cacheStore, routeUrl, private string, r *http.Request) (string, *cacheEntry) {
+	scopes := []string{""}
+	if private != "" {
+		scopes = []string{private, ""}
+	}
+	for _, scope := range scopes {
+		key := cacheKey(routeUrl, scope, r)
+		e := s.get(key)
+		if e != nil && len(e.Vary) > 0 {
+			key = variantKey(key, r, e.Vary)
+			e = s.get(key)
+		}
+		if e != nil {
+			return key, e
+		}
+	}
+	return "", nil
+}
+
+func storeResponse(s *cacheStore, routeUrl, private string, r *http.Request, status int, h http.Header, body []byte, now time.Time, def time.Duration) {
+	cc := parseCacheControl(h)
+	if cc.has("no-store") {
+		return
+	}
+	if h.Get("Set-Cookie") != "" && !cc.has("public") && !cc.has("s-maxage") {
+		return // a per-client response unless the backend says otherwise
+	}
+	vary := varyHeaders(h)
+	if slices.Contains(vary, "*") {
+		return
+	}
+	scope, ok := storeScope(cc, r, private)
+	if !ok {
+		return
+	}
+	life := freshness(h, cc, scope == "", now, def)
+	if life <= 0 && h.Get("ETag") == "" && h.Get("Last-Modified") == "" {
+		return // could never be served without a full refetch
+	}
+
+	header := storableHeader(h)
+	key := cacheKey(routeUrl, scope, r)
+	if len(vary) > 0 {
+		s.put(key, &cacheEntry{Route: routeUrl, Vary: vary, Stored: now})
+		key = variantKey(key, r, vary)
+	}
+	s.put(key, &cacheEntry{
+		Route:   routeUrl,
+		Shared:  scope == "",
+		Status:  status,
+		Header:  header,
+		Body:    bytes.Clone(body),
+		Stored:  now,
+		Expires: now.Add(life),
+	})
+}
+
+// hopHeaders describe the connection rather than the response and are never
+// stored (RFC 9111 section 3.1).
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// storableHeader returns a copy of a backend response's headers without what
+// must not be replayed to other clients: cookies, connection-level headers
+// and per-request ones.
+func storableHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			out.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		out.Del(name)
+	}
+	out.Del("Set-Cookie")
+	out.Del("X-Cache")
+	out.Del(requestIDHeader) // per request, set by the router
+	return out
+}
+
+// serveCached writes a stored response, answering the client's own conditional
+// request with 304 when it already has this version.
+func serveCached(w http.ResponseWriter, r *http.Request, e *cacheEntry, now time.Time, xcache string) {
+	h := w.Header()
+	for k, vs := range e.Header {
+		h[k] = slices.Clone(vs)
+	}
+	h.Set("Age", strconv.Itoa(int(now.Sub(e.Stored)/time.Second)))
+	h.Set("X-Cache", xcache)
+	if e.Status == http.StatusOK && clientHasCurrent(r, e.Header) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		w.Write(e.Body)
+	}
+}
+
+func clientHasCurrent(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(h.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			if t = strings.TrimSpace(t); t == "*" || strings.TrimPrefix(t, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// cacheWriter passes a backend response through to the client while keeping a
+// bounded copy for the cache. While revalidating, a 304 from the backend is
+// swallowed so the stored response can be served instead.
+//
+// The backend writes its headers into a map of the cacheWriter's own, which
+// is copied out when the response is passed on. What the wrappers around the
+// cache set on the shared map (compression, header rules, session cookies,
+// the request ID) therefore never ends up in a stored entry, and they apply
+// afresh to every response served from it.
+type cacheWriter struct {
+	http.ResponseWriter
+	max          int64
+	revalidating bool
+
+	header      http.Header // the backend's headers, as of its WriteHeader
+	passed      bool        // headers went to the client; Header is theirs now
+	status      int
+	notModified bool
+	tooBig      bool
+	buf         bytes.Buffer
+}
+
+func (c *cacheWriter) Header() http.Header {
+	if c.passed {
+		// Trailers are set after the headers were sent.
+		return c.ResponseWriter.Header()
+	}
+	if c.header == nil {
+		c.header = make(http.Header)
+	}
+	return c.header
+}
+
+func (c *cacheWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		// Informational: not the final response. Send its headers without
+		// leaving them on the final one.
+		h := c.ResponseWriter.Header()
+		saved := h.Clone()
+		addHeader(h, c.header)
+		c.ResponseWriter.WriteHeader(code)
+		clear(h)
+		addHeader(h, saved)
+		return
+	}
+	if c.status != 0 {
+		return
+	}
+	c.status = code
+	if c.header == nil {
+		c.header = make(http.Header)
+	}
+	if c.revalidating && code == http.StatusNotModified {
+		c.notModified = true
+		return
+	}
+	h := c.ResponseWriter.Header()
+	addHeader(h, c.header)
+	h.Set("X-Cache", "MISS")
+	c.passed = true
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func addHeader(dst, src http.Header) {
+	for k, vs := range src {
+		dst[k] = append(dst[k], vs...)
+	}
+}
+
+func (c *cacheWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.notModified {
+		return len(p), nil
+	}
+	if !c.tooBig {
+		if int64(c.buf.Len()+len(p)) > c.max {
+			c.tooBig = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p)
+		}
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (Flush).
+func (c *cacheWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+// Per-route cache counters, in-memory like the event counters.
+
+type cacheCounters struct {
+	hits, misses, revalidated, bypass atomic.Int64
+}
+
+var cacheStats sync.Map // routeUrl → *cacheCounters
+
+func countCache(routeUrl, result string) {
+	v, _ := cacheStats.LoadOrStore(routeUrl, new(cacheCounters))
+	c := v.(*cacheCounters)
+	switch result {
+	case cacheHit:
+		c.hits.Add(1)
+	case cacheMiss:
+		c.misses.Add(1)
+	case cacheRevalidated:
+		c.revalidated.Add(1)
+	case cacheBypass:
+		c.bypass.Add(1)
+	}
+	recordCacheRequest(routeUrl, result)
+}
+
+// CacheRouteStats are one route's cache counters since restart.
+type CacheRouteStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated"`
+	Bypass      int64 `json:"bypass"`
+}
+
+// CacheStats is a snapshot of the response cache.
+type CacheStats struct {
+	Routes        map[string]CacheRouteStats `json:"routes"`
+	MemoryEntries int                        `json:"memory_entries"`
+	MemoryBytes   int64                      `json:"memory_bytes"`
+	DiskEntries   int                        `json:"disk_entries"`
+	DiskBytes     int64                      `json:"disk_bytes"`
+	DiskEnabled   bool                       `json:"disk_enabled"`
+}
+
+// GetCacheStats returns the per-route hit/miss counters and cache usage.
+func GetCacheStats() CacheStats {
+	st := CacheStats{Routes: make(map[string]CacheRouteStats)}
+	cacheStats.Range(func(k, v any) bool {
+		c := v.(*cacheCounters)
+		st.Routes[k.(string)] = CacheRouteStats{
+			Hits: c.hits.Load(), Misses: c.misses.Load(),
+			Revalidated: c.revalidated.Load(), Bypass: c.bypass.Load(),
+		}
+		return true
+	})
+	s := sharedCache()
+	st.MemoryEntries, st.MemoryBytes = s.memoryUsage()
+	if s.disk != nil {
+		st.DiskEnabled = true
+		st.DiskEntries, st.DiskBytes = s.disk.usage()
+	}
+	return st
+}
+
+// PurgeCache drops the cached responses of routeUrl, or of every route when
+// routeUrl is "". It returns the number of in-memory entries removed.
+func PurgeCache(routeUrl string) int {
+	return sharedCache().purge(routeUrl)
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cachingHandler builds a caching proxy handler in front of backend, with a
+// fresh memory-only cache.
+func cachingHandler(t *testing.T, backend *httptest.Server) http.Handler {
+	t.Helper()
+	if err := ConfigureCache(CacheConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	route := &ProxyRoute{
+		Url:    "cache.test:80",
+		Target: strings.TrimPrefix(backend.URL, "http://"),
+		Type:   "proxy",
+		Cache:  storage.RouteCache{Enabled: true},
+	}
+	h, err := createHandlerForRoute(route, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func get(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+// A response with max-age is served from the cache until it goes stale.
+func TestCacheServesFreshResponse(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "asset")
+	}))
+	defer backend.Close()
+	h := cachingHandler(t, backend)
+
+	get(h, httptest.NewRequest(http.MethodGet, "http://cache.test/app.js", nil))
+	rec := get(h, httptest.NewRequest(http.MethodGet, "http://cache.test/app.js", nil))
+	if calls != 1 || rec.Body.String() != "asset" || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("want one backend call and a HIT, got %d calls, %q, X-Cache %q", calls, rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+	if rec := get(h, httptest.NewRequest(http.MethodPost, "http://cache.test/app.js", nil)); rec.Header().Get("X-Cache") == "HIT" {
+		t.Fatal("POST must bypass the cache")
+	}
+}
+
+// A stale entry with an ETag is revalidated; a 304 from the backend refreshes
+// it and the client still gets the full stored body.
+func TestCacheRevalidatesWithETag(t *testing.T) {
+	calls, notModified := 0, 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "body")
+	}))
+	defer backend.Close()
+	h := cachingHandler(t, backend)
+
+	get(h, httptest.NewRequest(http.MethodGet, "http://cache.test/", nil))
+	rec := get(h, httptest.NewRequest(http.MethodGet, "http://cache.test/", nil))
+	if calls != 2 || notModified != 1 {
+		t.Fatalf("want a conditional second request, got %d calls, %d 304s", calls, notModified)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "body" || rec.Header().Get("X-Cache") != "REVALIDATED" {
+		t.Fatalf("want the stored body after revalidation, got %d %q X-Cache %q", rec.Code, rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://cache.test/", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+	if rec := get(h, r); rec.Code != http.StatusNotModified {
+		t.Fatalf("client revalidation: want 304, got %d", rec.Code)
+	}
+}
+
+// Responses to one authorized user are never served to another unless the
+// backend marks them public, and private responses to anonymous requests are
+// not stored at all.
+func TestCacheScopesAuthenticatedResponses(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		fmt.Fprint(w, r.Header.Get("X-User"))
+	}))
+	defer backend.Close()
+	h := cachingHandler(t, backend)
+
+	as := func(user, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://cache.test"+path, nil)
+		r.Header.Set("X-User", user)
+		if user != "" {
+			r = withAccessScope(r, "user:"+user, user)
+		}
+		return get(h, r)
+	}
+
+	as("1", "/me")
+	if rec := as("2", "/me"); rec.Body.String() != "2" {
+		t.Fatalf("user 2 got user 1's response: %q", rec.Body.String())
+	}
+	if rec := as("1", "/me"); rec.Body.String() != "1" || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("user 1 should hit their own entry, got %q X-Cache %q", rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+
+	calls = 0
+	as("1", "/public")
+	if rec := as("2", "/public"); calls != 1 || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("public response should be shared, got %d backend calls", calls)
+	}
+
+	calls = 0
+	as("", "/private")
+	as("", "/private")
+	if calls != 2 {
+		t.Fatalf("anonymous private response must not be stored, got %d backend calls", calls)
+	}
+}
+
+// Vary selects between stored variants.
+func TestCacheVary(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprint(w, r.Header.Get("Accept-Language"))
+	}))
+	defer backend.Close()
+	h := cachingHandler(t, backend)
+
+	lang := func(l string) string {
+		r := httptest.NewRequest(http.MethodGet, "http://cache.test/", nil)
+		r.Header.Set("Accept-Language", l)
+		return get(h, r).Body.String()
+	}
+	lang("en")
+	lang("fr")
+	if got := lang("en"); got != "en" {
+		t.Fatalf("want the en variant, got %q", got)
+	}
+	if got := lang("fr"); got != "fr" {
+		t.Fatalf("want the fr variant, got %q", got)
+	}
+}
+
+// A cookie the backend sets never reaches another client: not on a 304 that
+// refreshes a stored entry, and not on a response stored because it is public.
+func TestCacheNeverStoresSetCookie(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		switch {
+		case r.URL.Path == "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Header().Set("Set-Cookie", "session=first")
+		case r.URL.Path == "/login":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", "session=first")
+		case r.Header.Get("If-None-Match") == `"v1"`:
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", "session=revalidator")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		default:
+			w.Header().Set("Cache-Control", "max-age=0")
+		}
+		fmt.Fprint(w, "page")
+	}))
+	defer backend.Close()
+	h := cachingHandler(t, backend)
+	from := func(addr, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://cache.test"+path, nil)
+		r.RemoteAddr = addr
+		return get(h, r)
+	}
+
+	from("192.0.2.1:1000", "/")
+	rec := from("192.0.2.1:1000", "/")
+	if rec.Header().Get("X-Cache") != "REVALIDATED" || rec.Header().Get("Set-Cookie") != "session=revalidator" {
+		t.Fatalf("the revalidating client should get its cookie, got X-Cache %q, Set-Cookie %q",
+			rec.Header().Get("X-Cache"), rec.Header().Get("Set-Cookie"))
+	}
+	rec = from("192.0.2.2:2000", "/")
+	if rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != "page" {
+		t.Fatalf("want a HIT, got X-Cache %q, body %q", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	if c := rec.Header().Values("Set-Cookie"); len(c) != 0 {
+		t.Fatalf("another client's cookie leaked from the cache: %q", c)
+	}
+	if rec.Header().Get("X-Hop") != "" {
+		t.Fatal("a connection-level header was stored")
+	}
+
+	calls = 0
+	from("192.0.2.1:1000", "/login")
+	from("192.0.2.2:2000", "/login")
+	if calls != 2 {
+		t.Fatalf("a response setting a cookie must not be stored unless public, got %d backend calls", calls)
+	}
+
+	calls = 0
+	from("192.0.2.1:1000", "/public")
+	rec = from("192.0.2.2:2000", "/public")
+	if calls != 1 || rec.Header().Get("X-Cache") != "HIT" || rec.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("want a public HIT without the cookie, got %d backend calls, X-Cache %q, Set-Cookie %q",
+			calls, rec.Header().Get("X-Cache"), rec.Header().Get("Set-Cookie"))
+	}
+}
+
+// Entries written to the disk tier survive a restart and are purged per route.
+func TestCacheDiskTier(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newCacheStore(CacheConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &cacheEntry{Route: "a:80", Status: 200, Header: http.Header{"X": {"1"}}, Body: []byte("hi"), Expires: time.Now().Add(time.Minute)}
+	s.put("a:80\x00\x00/x", e)
+	s.put("b:80\x00\x00/x", e)
+
+	s2, err := newCacheStore(CacheConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s2.get("a:80\x00\x00/x"); got == nil || string(got.Body) != "hi" {
+		t.Fatalf("entry not reloaded from disk: %+v", got)
+	}
+	s2.purge("a:80")
+	if s2.get("a:80\x00\x00/x") != nil {
+		t.Fatal("purged entry still served")
+	}
+	if s2.get("b:80\x00\x00/x") == nil {
+		t.Fatal("purging one route removed another's entry")
+	}
+}
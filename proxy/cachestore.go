@@ -0,0 +1,367 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Response cache storage: a byte-bounded in-memory LRU, optionally backed by an
+// on-disk tier. Entries are written through to disk so they survive restarts;
+// a memory miss falls back to disk and promotes the entry. On disk every route
+// has its own directory so purging a route is a single RemoveAll.
+
+// Built-in cache sizes, used wherever CacheConfig leaves a field at zero.
+const (
+	defaultCacheMemory    = 64 << 20
+	defaultCacheDisk      = 1 << 30
+	defaultCacheMaxObject = 1 << 20
+)
+
+// CacheConfig sizes the response cache shared by every caching route.
+type CacheConfig struct {
+	MemoryMB    int    // in-memory LRU bound; default 64
+	Dir         string // on-disk tier directory; "" = memory only
+	DiskMB      int    // on-disk tier bound; default 1024
+	MaxObjectKB int    // larger responses are never cached; default 1024
+}
+
+// cacheEntry is one stored response, or a Vary marker: the entry at a
+// request's base key that names the request headers selecting the variant.
+type cacheEntry struct {
+	Route   string
+	Shared  bool // stored for every client rather than one scope
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Stored  time.Time // when the response was fetched or last revalidated
+	Expires time.Time // fresh until; zero = must revalidate every time
+	Vary    []string  // set on Vary markers only
+}
+
+func (e *cacheEntry) size() int64 {
+	n := int64(len(e.Body)) + 256
+	for k, vs := range e.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+type memItem struct {
+	key   string
+	entry *cacheEntry
+	size  int64
+}
+
+// cacheStore is the two-tier store.
+type cacheStore struct {
+	maxObject int64
+
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List // front = most recently used
+	items    map[string]*list.Element
+
+	disk *diskCache // nil = memory only
+}
+
+func newCacheStore(cfg CacheConfig) (*cacheStore, error) {
+	s := &cacheStore{
+		maxBytes:  defaultCacheMemory,
+		maxObject: defaultCacheMaxObject,
+		ll:        list.New(),
+		items:     make(map[string]*list.Element),
+	}
+	if cfg.MemoryMB > 0 {
+		s.maxBytes = int64(cfg.MemoryMB) << 20
+	}
+	if cfg.MaxObjectKB > 0 {
+		s.maxObject = int64(cfg.MaxObjectKB) << 10
+	}
+	if cfg.Dir != "" {
+		limit := int64(defaultCacheDisk)
+		if cfg.DiskMB > 0 {
+			limit = int64(cfg.DiskMB) << 20
+		}
+		d, err := openDiskCache(cfg.Dir, limit)
+		if err != nil {
+			return nil, err
+		}
+		s.disk = d
+	}
+	return s, nil
+}
+
+func (s *cacheStore) get(key string) *cacheEntry {
+	s.mu.Lock()
+	if el, ok := s.items[key]; ok {
+		s.ll.MoveToFront(el)
+		e := el.Value.(*memItem).entry
+		s.mu.Unlock()
+		return e
+	}
+	s.mu.Unlock()
+
+	if s.disk == nil {
+		return nil
+	}
+	e := s.disk.get(key)
+	if e != nil {
+		s.putMemory(key, e)
+	}
+	return e
+}
+
+func (s *cacheStore) put(key string, e *cacheEntry) {
+	s.putMemory(key, e)
+	if s.disk != nil {
+		s.disk.put(key, e)
+	}
+}
+
+func (s *cacheStore) putMemory(key string, e *cacheEntry) {
+	size := e.size()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		it := el.Value.(*memItem)
+		s.bytes += size - it.size
+		it.entry, it.size = e, size
+		s.ll.MoveToFront(el)
+	} else {
+		s.items[key] = s.ll.PushFront(&memItem{key: key, entry: e, size: size})
+		s.bytes += size
+	}
+	for s.bytes > s.maxBytes && s.ll.Len() > 1 {
+		s.removeElement(s.ll.Back())
+	}
+}
+
+func (s *cacheStore) removeElement(el *list.Element) {
+	it := el.Value.(*memItem)
+	s.ll.Remove(el)
+	delete(s.items, it.key)
+	s.bytes -= it.size
+}
+
+// purge drops every entry of routeUrl, or everything when routeUrl is "". It
+// returns the number of in-memory entries removed.
+func (s *cacheStore) purge(routeUrl string) int {
+	s.mu.Lock()
+	n := 0
+	for el := s.ll.Front(); el != nil; {
+		next := el.Next()
+		if routeUrl == "" || el.Value.(*memItem).entry.Route == routeUrl {
+			s.removeElement(el)
+			n++
+		}
+		el = next
+	}
+	s.mu.Unlock()
+	if s.disk != nil {
+		s.disk.purge(routeUrl)
+	}
+	return n
+}
+
+func (s *cacheStore) memoryUsage() (entries int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len(), s.bytes
+}
+
+// diskCache is the on-disk tier: one gob-encoded file per entry, laid out as
+// <dir>/<hash(route)>/<hash(key)>, evicted least-recently-used by size.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List // of *diskItem, front = most recently used
+	items map[string]*list.Element
+}
+
+type diskItem struct {
+	path string
+	size int64
+}
+
+func hashName(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// openDiskCache opens (creating if needed) the cache directory and indexes the
+// entries already in it, oldest first, so LRU order survives a restart.
+func openDiskCache(dir string, maxBytes int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, xerrors.Newf("create cache dir: %w", err)
+	}
+	d := &diskCache{dir: dir, maxBytes: maxBytes, ll: list.New(), items: make(map[string]*list.Element)}
+
+	type found struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var files []found
+	filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			os.Remove(path) // left over from an interrupted write
+			return nil
+		}
+		if info, err := de.Info(); err == nil {
+			files = append(files, found{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+	for _, f := range files {
+		d.items[f.path] = d.ll.PushFront(&diskItem{path: f.path, size: f.size})
+		d.bytes += f.size
+	}
+	d.mu.Lock()
+	d.evict()
+	d.mu.Unlock()
+	slog.Info("response cache disk tier opened", "dir", dir, "entries", len(files), "bytes", d.bytes)
+	return d, nil
+}
+
+func (d *diskCache) path(key string) string {
+	// Keys start with the route URL up to the first NUL (see cacheKey).
+	route, _, _ := strings.Cut(key, "\x00")
+	return filepath.Join(d.dir, hashName(route), hashName(key))
+}
+
+func (d *diskCache) get(key string) *cacheEntry {
+	p := d.path(key)
+	d.mu.Lock()
+	el, ok := d.items[p]
+	if ok {
+		d.ll.MoveToFront(el)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var e cacheEntry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		slog.Warn("response cache: unreadable disk entry", "path", p, "error", err)
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return &e
+}
+
+func (d *diskCache) put(key string, e *cacheEntry) {
+	p := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		slog.Warn("response cache: disk write failed", "error", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return
+	}
+	// Write to a temp file and rename so readers never see a partial entry.
+	f, err := os.CreateTemp(filepath.Dir(p), "*.tmp")
+	if err != nil {
+		slog.Warn("response cache: disk write failed", "error", err)
+		return
+	}
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		slog.Warn("response cache: disk write failed", "error", err)
+		return
+	}
+	size := int64(buf.Len())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[p]; ok {
+		it := el.Value.(*diskItem)
+		d.bytes += size - it.size
+		it.size = size
+		d.ll.MoveToFront(el)
+	} else {
+		d.items[p] = d.ll.PushFront(&diskItem{path: p, size: size})
+		d.bytes += size
+	}
+	d.evict()
+}
+
+// evict removes least-recently-used files until the tier fits. Callers hold d.mu.
+func (d *diskCache) evict() {
+	for d.bytes > d.maxBytes && d.ll.Len() > 0 {
+		el := d.ll.Back()
+		it := el.Value.(*diskItem)
+		d.ll.Remove(el)
+		delete(d.items, it.path)
+		d.bytes -= it.size
+		os.Remove(it.path)
+	}
+}
+
+func (d *diskCache) purge(routeUrl string) {
+	prefix := d.dir
+	if routeUrl != "" {
+		prefix = filepath.Join(d.dir, hashName(routeUrl))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for p, el := range d.items {
+		if routeUrl == "" || filepath.Dir(p) == prefix {
+			d.bytes -= el.Value.(*diskItem).size
+			d.ll.Remove(el)
+			delete(d.items, p)
+		}
+	}
+	if routeUrl != "" {
+		os.RemoveAll(prefix)
+		return
+	}
+	entries, _ := os.ReadDir(d.dir)
+	for _, de := range entries {
+		os.RemoveAll(filepath.Join(d.dir, de.Name()))
+	}
+}
+
+func (d *diskCache) usage() (entries int, bytes int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ll.Len(), d.bytes
+}
@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// RouteProblem is a problem CheckRoutes found with one route.
+type RouteProblem struct {
+	Route int    // index of the route in the slice checked
+	Field string // the setting at fault: "url", "type", "target", "tls", "cert", "key" or "breaker_fallback"
+	Other int    // index of the route it conflicts with; -1 = none
+	Err   error
+}
+
+// Problem is a problem with one setting of a config section, found by one of
+// the Check functions. As an error it reads as Err alone.
+type Problem struct {
+	Field string // the setting at fault, e.g. "level" or "subsystems.auth"
+	Err   error
+}
+
+func (p Problem) Error() string { return p.Err.Error() }
+func (p Problem) Unwrap() error { return p.Err }
+
+// CheckOptions is what CheckRoutes needs besides the routes.
+type CheckOptions struct {
+	// Lookup resolves a target's host; nil skips resolving. It is called
+	// concurrently, once per host.
+	Lookup func(ctx context.Context, host string) error
+	// Reserved are ports bound by listeners other than the routes', mapped to
+	// their owner, e.g. "9464" -> "[prometheus] listen".
+	Reserved map[string]string
+	Redirect RedirectConfig
+}
+
+// CheckRoutes checks routes before they are served, reporting every problem
+// instead of the first. Beyond what StartProxy would refuse, it checks that
+// targets are well-formed and resolve, that static targets exist, that TLS
+// cert/key pairs load and belong together, and that no port is claimed in
+// ways that can't share it.
+func CheckRoutes(ctx context.Context, routes []ProxyRoute, opts CheckOptions) []RouteProblem {
+	var problems []RouteProblem
+	add := func(i int, field string, other int, err error) {
+		if err != nil {
+			problems = append(problems, RouteProblem{Route: i, Field: field, Other: other, Err: err})
+		}
+	}
+
+	resolved := resolveTargets(ctx, routes, opts.Lookup)
+	resolve := func(host string) error { return resolved[host] }
+
+	urls := make(map[string]int)
+	httpPorts := make(map[string]int) // port -> first HTTP route on it
+	tcpPorts := make(map[string]int)
+	udpPorts := make(map[string]int)
+	for i, r := range routes {
+		_, port, err := parseHostPort(r.Url)
+		if err == nil {
+			if n, perr := strconv.Atoi(port); perr != nil || n < 1 || n > 65535 {
+				err = xerrors.Newf("port %q is not a number from 1 to 65535", port)
+			}
+		}
+		if err != nil {
+			add(i, "url", -1, xerrors.Newf("invalid url %q: expected host:port: %w", r.Url, err))
+			continue
+		}
+		if j, ok := urls[r.Url]; ok {
+			add(i, "url", j, xerrors.Newf("%s is defined more than once", r.Url))
+			continue
+		}
+		urls[r.Url] = i
+
+		switch r.Type {
+		case "proxy", "", "static", "api", "redirect", "tcp", "udp", "tcp+udp":
+		default:
+			add(i, "type", -1, xerrors.Newf("unknown route type %q; want proxy, static, api, redirect, tcp, udp or tcp+udp", r.Type))
+			continue
+		}
+
+		// Ports. A raw TCP listener and an HTTP listener both bind TCP, so
+		// they can't share a port; UDP binds apart from either.
+		if owner, ok := opts.Reserved[port]; ok && (isTCP(r.Type) || !isRaw(r.Type)) {
+			add(i, "url", -1, xerrors.Newf("port %s is also used by %s", port, owner))
+		}
+		if isTCP(r.Type) {
+			if j, ok := tcpPorts[port]; ok {
+				add(i, "url", j, xerrors.Newf("TCP port %s is already used by another tcp route", port))
+			} else if j, ok := httpPorts[port]; ok {
+				add(i, "url", j, xerrors.Newf("port %s is already used by an HTTP route", port))
+			} else if opts.Redirect.Enabled && port == opts.Redirect.port() {
+				add(i, "url", -1, xerrors.Newf("port %s is the [redirect] listener's", port))
+			}
+			tcpPorts[port] = i
+		}
+		if isUDP(r.Type) {
+			if j, ok := udpPorts[port]; ok {
+				add(i, "url", j, xerrors.Newf("UDP port %s is already used by another udp route", port))
+			}
+			udpPorts[port] = i
+		}
+		if !isRaw(r.Type) {
+			if j, ok := tcpPorts[port]; ok {
+				add(i, "url", j, xerrors.Newf("port %s is already used by a TCP route", port))
+			} else if j, ok := httpPorts[port]; ok && routes[j].Tls != r.Tls {
+				add(i, "tls", j, xerrors.Newf("port %s has routes with tls on and off; one listener can't serve both", port))
+			} else if r.Tls && opts.Redirect.Enabled && port == opts.Redirect.port() {
+				add(i, "tls", -1, xerrors.Newf("port %s is the [redirect] listener's, which is plain HTTP", port))
+			}
+			if _, ok := httpPorts[port]; !ok {
+				httpPorts[port] = i
+			}
+			if r.Tls {
+				field, err := checkKeyPair(r.Cert, r.Key)
+				add(i, field, -1, err)
+			}
+		}
+
+		add(i, "target", -1, checkTarget(r, resolve))
+		if fb := strings.TrimSpace(r.Breaker.Fallback); fb != "" {
+			add(i, "breaker_fallback", -1, checkTarget(fallbackRoute(r, fb), resolve))
+		}
+	}
+	return problems
+}
+
+// maxLookups bounds the lookups resolveTargets has in flight.
+const maxLookups = 16
+
+// resolveTargets looks up every host named by the routes' targets and
+// fallbacks at once, so that many slow or unresolvable hosts cost about one
+// lookup's time rather than one each. It returns the hosts that failed.
+func resolveTargets(ctx context.Context, routes []ProxyRoute, lookup func(context.Context, string) error) map[string]error {
+	if lookup == nil {
+		return nil
+	}
+	hosts := make(map[string]bool)
+	collect := func(host string) error {
+		if host != "" && net.ParseIP(host) == nil {
+			hosts[host] = true
+		}
+		return nil
+	}
+	for _, r := range routes {
+		checkTarget(r, collect)
+		if fb := strings.TrimSpace(r.Breaker.Fallback); fb != "" {
+			checkTarget(fallbackRoute(r, fb), collect)
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed = make(map[string]error)
+		slots  = make(chan struct{}, maxLookups)
+	)
+	for host := range hosts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			if err := lookup(ctx, host); err != nil {
+				mu.Lock()
+				failed[host] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return failed
+}
+
+// fallbackRoute is the route createFallback would build for a fallback.
+func fallbackRoute(r ProxyRoute, fb string) ProxyRoute {
+	switch {
+	case isTCP(r.Type):
+		return ProxyRoute{Type: "tcp", Target: fb}
+	case strings.HasPrefix(fb, "/") || strings.HasPrefix(fb, "."):
+		return ProxyRoute{Type: "static", Target: fb}
+	}
+	return ProxyRoute{Type: "proxy", Target: fb}
+}
+
+// checkTarget checks the target of a route of a known type.
+func checkTarget(r ProxyRoute, resolve func(host string) error) error {
+	switch r.Type {
+	case "proxy", "":
+		ups, err := parseUpstreams(r.Target)
+		if err != nil {
+			return err
+		}
+		for _, t := range ups.targets {
+			if t.Hostname() == "" {
+				return xerrors.Newf("backend %q has no host", t.String())
+			}
+			if err := checkPort(t); err != nil {
+				return err
+			}
+			if err := resolve(t.Hostname()); err != nil {
+				return err
+			}
+		}
+	case "tcp", "udp", "tcp+udp":
+		host, port, err := net.SplitHostPort(r.Target)
+		if err != nil {
+			return xerrors.Newf("want host:port, got %q", r.Target)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return xerrors.Newf("port %q is not a number from 1 to 65535", port)
+		}
+		return resolve(host)
+	case "static":
+		if r.Target == "" {
+			return xerrors.New("no directory or file given")
+		}
+		if _, err := os.Stat(r.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPort(u *url.URL) error {
+	if p := u.Port(); p != "" {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return xerrors.Newf("backend %q: port %q is not a number from 1 to 65535", u.String(), p)
+		}
+	}
+	return nil
+}
+
+// checkKeyPair loads a TLS route's certificate and key as the listener
+// would, returning the field at fault.
+func checkKeyPair(cert, key string) (string, error) {
+	switch {
+	case cert == "":
+		return "cert", xerrors.New("tls is on but no cert is given")
+	case key == "":
+		return "key", xerrors.New("tls is on but no key is given")
+	}
+	if _, err := os.Stat(cert); err != nil {
+		return "cert", err
+	}
+	if _, err := os.Stat(key); err != nil {
+		return "key", err
+	}
+	if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+		// "private key does not match public key" and the like.
+		return "key", xerrors.Newf("%s and %s: %w", cert, key, err)
+	}
+	return "", nil
+}
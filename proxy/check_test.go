@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reMazarin/storage"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a self-signed certificate and its key under dir.
+func writeKeyPair(t *testing.T, dir, name string) (cert, key string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return cert, key
+}
+
+func TestCheckRoutes(t *testing.T) {
+	dir := t.TempDir()
+	certA, keyA := writeKeyPair(t, dir, "a")
+	_, keyB := writeKeyPair(t, dir, "b")
+	lookup := func(_ context.Context, host string) error {
+		if strings.HasSuffix(host, ".invalid") {
+			return errors.New("no such host")
+		}
+		return nil
+	}
+
+	for _, tc := range []struct {
+		name   string
+		routes []ProxyRoute
+		want   []string // route:field of each problem, in order
+	}{
+		{"fine", []ProxyRoute{
+			{Url: "a.example.com:443", Type: "proxy", Target: "10.0.0.1:80,backend:8080", Tls: true, Cert: certA, Key: keyA},
+			{Url: "b.example.com:443", Type: "static", Target: dir, Tls: true, Cert: certA, Key: keyA},
+			{Url: ":2222", Type: "tcp+udp", Target: "db.internal:22"},
+			{Url: ":80", Type: "redirect", Target: "https://example.com"},
+		}, nil},
+		{"bad url and type", []ProxyRoute{
+			{Url: "a.example.com", Type: "proxy", Target: "x:80"},
+			{Url: "a.example.com:http", Type: "proxy", Target: "x:80"},
+			{Url: "a.example.com:80", Type: "ftp"},
+		}, []string{"0:url", "1:url", "2:type"}},
+		{"duplicate", []ProxyRoute{
+			{Url: "a.example.com:80", Target: "x:80"},
+			{Url: "a.example.com:80", Target: "y:80"},
+		}, []string{"1:url"}},
+		{"port conflicts", []ProxyRoute{
+			{Url: "a.example.com:443", Target: "x:80", Tls: true, Cert: certA, Key: keyA},
+			{Url: "b.example.com:443", Target: "x:80"},
+			{Url: ":443", Type: "tcp", Target: "x:443"},
+			{Url: ":53", Type: "udp", Target: "x:53"},
+			{Url: "dns:53", Type: "tcp+udp", Target: "x:53"},
+			{Url: ":9464", Type: "tcp", Target: "x:1"},
+			{Url: ":80", Type: "tcp", Target: "x:1"},
+		}, []string{"1:tls", "2:url", "4:url", "5:url", "6:url"}},
+		{"targets", []ProxyRoute{
+			{Url: "a:80", Type: "proxy", Target: ""},
+			{Url: "b:80", Type: "proxy", Target: "x:80,gone.invalid:80"},
+			{Url: ":22", Type: "tcp", Target: "x"},
+			{Url: ":23", Type: "tcp", Target: "gone.invalid:23"},
+			{Url: "c:80", Type: "static", Target: filepath.Join(dir, "missing")},
+			{Url: "d:80", Type: "proxy", Target: "x:80", Breaker: storage.RouteBreaker{Fallback: "./missing"}},
+		}, []string{"0:target", "1:target", "2:target", "3:target", "4:target", "5:breaker_fallback"}},
+		{"tls", []ProxyRoute{
+			{Url: "a:443", Target: "x:80", Tls: true, Key: keyA},
+			{Url: "b:443", Target: "x:80", Tls: true, Cert: certA, Key: filepath.Join(dir, "missing.key")},
+			{Url: "c:443", Target: "x:80", Tls: true, Cert: certA, Key: keyB},
+		}, []string{"0:cert", "1:key", "2:key"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			problems := CheckRoutes(context.Background(), tc.routes, CheckOptions{
+				Lookup:   lookup,
+				Reserved: map[string]string{"9464": "[prometheus] listen"},
+				Redirect: RedirectConfig{Enabled: true},
+			})
+			var got []string
+			for _, p := range problems {
+				got = append(got, fmt.Sprintf("%d:%s", p.Route, p.Field))
+			}
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				for _, p := range problems {
+					t.Log(p.Route, p.Field, p.Err)
+				}
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Hosts are resolved concurrently and once each, so slow lookups don't add
+// up across routes.
+func TestCheckRoutesResolvesConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	lookup := func(ctx context.Context, host string) error {
+		mu.Lock()
+		calls[host]++
+		mu.Unlock()
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if strings.HasSuffix(host, ".invalid") {
+			return errors.New("no such host")
+		}
+		return nil
+	}
+	var routes []ProxyRoute
+	for i := range 10 {
+		routes = append(routes, ProxyRoute{
+			Url: fmt.Sprintf("r%d.example.com:80", i), Target: fmt.Sprintf("backend%d:80,shared:80", i),
+			Breaker: storage.RouteBreaker{Fallback: "fallback.invalid:80"},
+		})
+	}
+
+	start := time.Now()
+	problems := CheckRoutes(context.Background(), routes, CheckOptions{Lookup: lookup})
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v for 12 hosts of 200ms each", d)
+	}
+	if len(calls) != 12 {
+		t.Errorf("looked up %d hosts, want 12", len(calls))
+	}
+	for host, n := range calls {
+		if n != 1 {
+			t.Errorf("%s looked up %d times", host, n)
+		}
+	}
+	if len(problems) != 10 {
+		t.Fatalf("%d problems, want the fallback of each route", len(problems))
+	}
+	for _, p := range problems {
+		if p.Field != "breaker_fallback" {
+			t.Errorf("route %d: %s: %v", p.Route, p.Field, p.Err)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Cluster mode. Instances sharing one database publish the changes that live
+// in memory — bans, unbans, connection kills, route changes — to their peers
+// as they happen, instead of waiting for the 5-minute cache refresh. Events go
+// out as signed HTTP POSTs to every peer's cluster listener; each peer has its
+// own queue so a slow or dead node holds up nobody.
+//
+// Rate limits are approximate: every sync interval each node sends the tokens
+// and failures its IPs used, and peers deduct them from their own buckets.
+// A client spread across n nodes gets at most about one interval of extra
+// burst per node before the limit catches up.
+//
+// Everything durable is in the shared database, so applying an event only
+// touches memory. A node that missed events (queue overflow, peer down) is sent
+// a refresh once it is reachable again, which reloads routes, settings, bans
+// and policies from the database.
+//
+// Each request is signed over its timestamp, a random nonce and its body. A
+// receiver accepts a timestamp within clusterMaxSkew of its own clock and
+// remembers nonces for twice that, so a captured request can't be replayed.
+// The listener speaks plain HTTP: the signature authenticates, it does not
+// encrypt, so the cluster belongs on a private network or a TLS tunnel.
+
+const (
+	clusterPath        = "/cluster/v1/events"
+	clusterQueueSize   = 1024 // events waiting per peer
+	clusterBatchSize   = 256  // events per request
+	clusterMaxBody     = 8 << 20
+	clusterMaxSkew     = time.Minute
+	clusterTimeout     = 5 * time.Second
+	defaultClusterSync = time.Second
+
+	clusterNodeHeader  = "X-Remazarin-Node"
+	clusterTimeHeader  = "X-Remazarin-Timestamp"
+	clusterNonceHeader = "X-Remazarin-Nonce"
+	clusterSignHeader  = "X-Remazarin-Signature"
+)
+
+// Cluster event types.
+const (
+	clusterEventBan     = "ban"
+	clusterEventUnban   = "unban"
+	clusterEventKill    = "kill"    // connection kill, e.g. after a session revocation
+	clusterEventRoute   = "route"   // route registered or removed
+	clusterEventRefresh = "refresh" // reload the route/auth cache from the database
+	clusterEventUsage   = "usage"   // rate-limit usage since the last sync
+)
+
+// ClusterConfig configures cluster mode.
+type ClusterConfig struct {
+	Enabled      bool
+	NodeID       string        // default <hostname><listen>
+	Listen       string        // cluster listener, e.g. ":7946"
+	Peers        []string      // peer base URLs or host:port, e.g. "http://10.0.0.2:7946"
+	Secret       string        // shared HMAC key; required
+	SyncInterval time.Duration // rate-limit usage exchange; default 1s
+}
+
+// OnClusterRoute is called when a peer registered (deleted = false) or removed
+// a route, so this node can start or stop the route's listeners. Wired by main.
+var OnClusterRoute func(url string, deleted bool)
+
+// clusterEvent is one change published to the peers. Only the fields of its
+// type are set.
+type clusterEvent struct {
+	Type    string     `json:"type"`
+	IP      string     `json:"ip,omitempty"`      // ban, unban, kill
+	Reason  string     `json:"reason,omitempty"`  // ban
+	Tier    string     `json:"tier,omitempty"`    // ban
+	Expires *time.Time `json:"expires,omitempty"` // ban; nil = until cleared
+	ID      string     `json:"id,omitempty"`      // kill: request ID
+	User    string     `json:"user,omitempty"`    // kill
+	URL     string     `json:"url,omitempty"`     // route
+	Deleted bool       `json:"deleted,omitempty"` // route
+	Usage   []ipUsage  `json:"usage,omitempty"`   // usage
+}
+
+// ipUsage is what one IP consumed on the sending node since its last sync.
+type ipUsage struct {
+	IP       string  `json:"ip"`
+	Tokens   float64 `json:"tokens,omitempty"`
+	Failures int     `json:"failures,omitempty"`
+}
+
+type clusterBatch struct {
+	Node   string         `json:"node"`
+	Events []clusterEvent `json:"events"`
+}
+
+type cluster struct {
+	cfg    ClusterConfig
+	peers  []*clusterPeer
+	client *http.Client
+
+	seenMu    sync.Mutex
+	seen      map[string]time.Time // nonce -> when it may be forgotten
+	seenPrune time.Time
+}
+
+type clusterPeer struct {
+	url   string
+	queue chan clusterEvent
+	stale atomic.Bool // events were lost; send a refresh first
+
+	mu      sync.Mutex
+	lastOK  time.Time
+	lastErr string
+}
+
+// ClusterPeer is a peer's delivery status for the admin API.
+type ClusterPeer struct {
+	URL       string    `json:"url"`
+	Queued    int       `json:"queued"`
+	LastOK    time.Time `json:"last_ok"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// ClusterStatus is this node's view of the cluster.
+type ClusterStatus struct {
+	Enabled bool          `json:"enabled"`
+	NodeID  string        `json:"node_id,omitempty"`
+	Peers   []ClusterPeer `json:"peers"`
+}
+
+var activeCluster atomic.Pointer[cluster]
+
+// ConfigureCluster validates the cluster settings. Peers are contacted once
+// the proxy starts.
+func ConfigureCluster(cfg ClusterConfig) error {
+	if !cfg.Enabled {
+		activeCluster.Store(nil)
+		return nil
+	}
+	if cfg.Listen == "" {
+		return xerrors.Newf("cluster: listen is required")
+	}
+	if cfg.Secret == "" {
+		return xerrors.Newf("cluster: secret is required")
+	}
+	if cfg.NodeID == "" {
+		host, _ := os.Hostname()
+		cfg.NodeID = host + cfg.Listen
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = defaultClusterSync
+	}
+	c := &cluster{cfg: cfg, client: &http.Client{Timeout: clusterTimeout}}
+	for _, p := range cfg.Peers {
+		p = strings.TrimRight(strings.TrimSpace(p), "/")
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "http://") && !strings.HasPrefix(p, "https://") {
+			p = "http://" + p
+		}
+		c.peers = append(c.peers, &clusterPeer{url: p, queue: make(chan clusterEvent, clusterQueueSize)})
+	}
+	activeCluster.Store(c)
+	return nil
+}
+
+// GetClusterStatus reports the node ID and each peer's delivery status.
+func GetClusterStatus() ClusterStatus {
+	c := activeCluster.Load()
+	if c == nil {
+		return ClusterStatus{Peers: []ClusterPeer{}}
+	}
+	st := ClusterStatus{Enabled: true, NodeID: c.cfg.NodeID, Peers: make([]ClusterPeer, 0, len(c.peers))}
+	for _, p := range c.peers {
+		p.mu.Lock()
+		st.Peers = append(st.Peers, ClusterPeer{URL: p.url, Queued: len(p.queue), LastOK: p.lastOK, LastError: p.lastErr})
+		p.mu.Unlock()
+	}
+	return st
+}
+
+// addClusterListener serves the cluster endpoint and starts the peer senders
+// and the usage sync.
+func (p *Proxy) addClusterListener() {
+	c := activeCluster.Load()
+	if c == nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(clusterPath, c)
+	server := &http.Server{
+		Addr:              c.cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+	}
+	p.serversMu.Lock()
+	p.liveHTTP = append(p.liveHTTP, server)
+	p.serversMu.Unlock()
+
+	p.Wg.Add(1)
+	go p.startServe(server, false)
+	c.start(p.ctx)
+	slog.Info("cluster listener started", "addr", c.cfg.Listen, "node", c.cfg.NodeID, "peers", len(c.peers))
+}
+
+func (c *cluster) start(ctx context.Context) {
+	for _, peer := range c.peers {
+		go c.deliver(ctx, peer)
+	}
+	go c.syncUsage(ctx)
+}
+
+// publish queues an event for every peer. A full queue drops the event and
+// marks the peer stale.
+func publish(e clusterEvent) {
+	c := activeCluster.Load()
+	if c == nil {
+		return
+	}
+	for _, p := range c.peers {
+		select {
+		case p.queue <- e:
+		default:
+			if !p.stale.Swap(true) {
+				slog.Warn("cluster queue full, dropping events", "peer", p.url)
+			}
+		}
+	}
+}
+
+// PublishKill asks the peers to terminate their connections matching the
+// same filters as KillConns.
+func PublishKill(id, ip, username string) {
+	if id == "" && ip == "" && username == "" {
+		return
+	}
+	publish(clusterEvent{Type: clusterEventKill, ID: id, IP: ip, User: username})
+}
+
+// PublishRouteChange tells the peers a route was registered or removed.
+func PublishRouteChange(url string, deleted bool) {
+	publish(clusterEvent{Type: clusterEventRoute, URL: url, Deleted: deleted})
+}
+
+// PublishRefresh asks the peers to reload their route/auth cache, as after
+// an access-control, settings or throttle-policy change.
+func PublishRefresh() { publish(clusterEvent{Type: clusterEventRefresh}) }
+
+// deliver sends a peer's queued events in batches until ctx is done. Failed
+// batches are not retried; the peer is refreshed once it answers again.
+func (c *cluster) deliver(ctx context.Context, peer *clusterPeer) {
+	for {
+		var batch []clusterEvent
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-peer.queue:
+			batch = append(batch, e)
+		}
+	drain:
+		for len(batch) < clusterBatchSize {
+			select {
+			case e := <-peer.queue:
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		stale := peer.stale.Swap(false)
+		if stale {
+			batch = append([]clusterEvent{{Type: clusterEventRefresh}}, batch...)
+		}
+
+		err := c.send(ctx, peer.url, batch)
+		peer.mu.Lock()
+		failing := peer.lastErr != ""
+		if err != nil {
+			peer.lastErr = err.Error()
+		} else {
+			peer.lastOK, peer.lastErr = time.Now(), ""
+		}
+		peer.mu.Unlock()
+		switch {
+		case err != nil:
+			peer.stale.Store(true)
+			if !failing {
+				slog.Warn("cluster peer unreachable", "peer", peer.url, "error", err)
+			}
+		case failing:
+			slog.Info("cluster peer reachable again", "peer", peer.url)
+		}
+	}
+}
+
+func (c *cluster) send(ctx context.Context, peer string, events []clusterEvent) error {
+	body, err := json.Marshal(clusterBatch{Node: c.cfg.NodeID, Events: events})
+	if err != nil {
+		return xerrors.Newf("encode events: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+clusterPath, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Newf("build request: %w", err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := newClusterNonce()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(clusterNodeHeader, c.cfg.NodeID)
+	req.Header.Set(clusterTimeHeader, ts)
+	req.Header.Set(clusterNonceHeader, nonce)
+	req.Header.Set(clusterSignHeader, c.sign(ts, nonce, body))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return xerrors.Newf("peer answered %s", resp.Status)
+	}
+	return nil
+}
+
+func newClusterNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sign is the hex HMAC-SHA256 of the timestamp, nonce and body under the
+// shared secret.
+func (c *cluster) sign(ts, nonce string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(c.cfg.Secret))
+	m.Write([]byte(ts))
+	m.Write([]byte{'\n'})
+	m.Write([]byte(nonce))
+	m.Write([]byte{'\n'})
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// firstUse records a nonce, reporting false if it was seen before. A nonce is
+// kept for 2*clusterMaxSkew: by then any timestamp signed with it is stale.
+func (c *cluster) firstUse(nonce string, now time.Time) bool {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	if now.After(c.seenPrune) {
+		for n, until := range c.seen {
+			if now.After(until) {
+				delete(c.seen, n)
+			}
+		}
+		c.seenPrune = now.Add(clusterMaxSkew)
+	}
+	if until, ok := c.seen[nonce]; ok && !now.After(until) {
+		return false
+	}
+	c.seen[nonce] = now.Add(2 * clusterMaxSkew)
+	return true
+}
+
+// ServeHTTP receives a peer's batch. Requests must be signed with the shared
+// secret, timestamped within a minute of this node's clock and carry a nonce
+// not seen before.
+func (c *cluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, clusterMaxBody))
+	if err != nil {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	ts := r.Header.Get(clusterTimeHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(sec, 0)).Abs() > clusterMaxSkew {
+		http.Error(w, "stale or missing timestamp", http.StatusUnauthorized)
+		return
+	}
+	nonce := r.Header.Get(clusterNonceHeader)
+	if nonce == "" {
+		http.Error(w, "missing nonce", http.StatusUnauthorized)
+		return
+	}
+	want := c.sign(ts, nonce, body)
+	if !hmac.Equal([]byte(r.Header.Get(clusterSignHeader)), []byte(want)) {
+		slog.Warn("cluster request with bad signature", "ip", extractClientIP(r), "node", r.Header.Get(clusterNodeHeader))
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if !c.firstUse(nonce, time.Now()) {
+		slog.Warn("cluster request replayed", "ip", extractClientIP(r), "node", r.Header.Get(clusterNodeHeader))
+		http.Error(w, "replayed request", http.StatusUnauthorized)
+		return
+	}
+	var batch clusterBatch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if batch.Node != c.cfg.NodeID { // a node listed among its own peers
+		for _, e := range batch.Events {
+			applyEvent(batch.Node, e)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyEvent applies a peer's event to this node's memory. The peer already
+// wrote any durable part of it to the shared database.
+func applyEvent(node string, e clusterEvent) {
+	switch e.Type {
+	case clusterEventBan:
+		var exp time.Time
+		if e.Expires != nil {
+			exp = *e.Expires
+		}
+		banned.set(e.IP, exp)
+		slog.Info("cluster ban applied", "ip", e.IP, "reason", e.Reason, "tier", e.Tier, "node", node)
+	case clusterEventUnban:
+		banned.del(e.IP)
+		slog.Info("cluster unban applied", "ip", e.IP, "node", node)
+	case clusterEventKill:
+		n := KillConns(context.Background(), e.ID, e.IP, e.User)
+		slog.Info("cluster kill applied", "id", e.ID, "ip", e.IP, "user", e.User, "killed", n, "node", node)
+	case clusterEventRoute:
+		if OnClusterRoute != nil {
+			OnClusterRoute(e.URL, e.Deleted)
+		}
+	case clusterEventRefresh:
+		if authStore != nil {
+			refreshCache()
+		}
+	case clusterEventUsage:
+		for _, u := range e.Usage {
+			applyUsage(u)
+		}
+	default:
+		slog.Warn("unknown cluster event", "type", e.Type, "node", node)
+	}
+}
+
+// syncUsage publishes the IP usage counted since the last tick.
+func (c *cluster) syncUsage(ctx context.Context) {
+	t := time.NewTicker(c.cfg.SyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if usage := takeUsage(); len(usage) > 0 {
+				publish(clusterEvent{Type: clusterEventUsage, Usage: usage})
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testClusterSecret = "s3cret"
+
+// startTestCluster makes the active cluster a node "a" whose peers are the
+// given URLs and starts its senders.
+func startTestCluster(t *testing.T, peers ...string) *cluster {
+	t.Helper()
+	if err := ConfigureCluster(ClusterConfig{
+		Enabled: true, NodeID: "a", Listen: ":0", Peers: peers, Secret: testClusterSecret,
+		SyncInterval: time.Hour,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		ConfigureCluster(ClusterConfig{})
+	})
+	c := activeCluster.Load()
+	c.start(ctx)
+	return c
+}
+
+// peerNode serves a second node, "b", on a local port.
+func peerNode(t *testing.T) *httptest.Server {
+	b := &cluster{cfg: ClusterConfig{NodeID: "b", Secret: testClusterSecret}}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestClusterDeliversEvents(t *testing.T) {
+	authStore = nil
+	routes := make(chan string, 1)
+	OnClusterRoute = func(url string, deleted bool) { routes <- url + " " + strconv.FormatBool(deleted) }
+	defer func() { OnClusterRoute = nil }()
+
+	startTestCluster(t, peerNode(t).URL)
+
+	// Published events reach b, which applies them to its memory — the same
+	// ban set here, so nothing is set locally before publishing.
+	ip := "10.2.0.1"
+	banned.del(ip)
+	exp := time.Now().Add(time.Minute)
+	publish(clusterEvent{Type: clusterEventBan, IP: ip, Reason: "manual", Tier: "manual", Expires: &exp})
+	waitFor(t, "ban", func() bool { return IsBanned(ip) })
+	publish(clusterEvent{Type: clusterEventUnban, IP: ip})
+	waitFor(t, "unban", func() bool { return !IsBanned(ip) })
+
+	PublishRouteChange("app:443", true)
+	select {
+	case got := <-routes:
+		if got != "app:443 true" {
+			t.Errorf("route event = %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("route event not delivered")
+	}
+
+	st := GetClusterStatus()
+	if !st.Enabled || st.NodeID != "a" || len(st.Peers) != 1 || st.Peers[0].LastOK.IsZero() {
+		t.Errorf("status = %+v", st)
+	}
+}
+
+func TestClusterRefreshesStalePeer(t *testing.T) {
+	got := make(chan []string, 4)
+	var up atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var batch clusterBatch
+		json.NewDecoder(r.Body).Decode(&batch)
+		var types []string
+		for _, e := range batch.Events {
+			types = append(types, e.Type)
+		}
+		got <- types
+	}))
+	defer srv.Close()
+	startTestCluster(t, srv.URL)
+
+	PublishKill("", "10.2.0.2", "")
+	waitFor(t, "failed delivery", func() bool { return GetClusterStatus().Peers[0].LastError != "" })
+	up.Store(true)
+	PublishKill("", "10.2.0.3", "")
+	if types := <-got; len(types) != 2 || types[0] != clusterEventRefresh || types[1] != clusterEventKill {
+		t.Errorf("batch after failure = %v", types)
+	}
+}
+
+func TestClusterRejectsUnsignedRequests(t *testing.T) {
+	b := &cluster{cfg: ClusterConfig{NodeID: "b", Secret: testClusterSecret}}
+	ip := "10.2.0.4"
+	banned.del(ip)
+	body, _ := json.Marshal(clusterBatch{Node: "a", Events: []clusterEvent{{Type: clusterEventBan, IP: ip}}})
+	post := func(ts time.Time, secret string) int {
+		s, nonce := strconv.FormatInt(ts.Unix(), 10), newClusterNonce()
+		req := httptest.NewRequest(http.MethodPost, clusterPath, bytes.NewReader(body))
+		req.Header.Set(clusterTimeHeader, s)
+		req.Header.Set(clusterNonceHeader, nonce)
+		req.Header.Set(clusterSignHeader, (&cluster{cfg: ClusterConfig{Secret: secret}}).sign(s, nonce, body))
+		rec := httptest.NewRecorder()
+		b.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(time.Now(), "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: %d", code)
+	}
+	if code := post(time.Now().Add(-2*clusterMaxSkew), testClusterSecret); code != http.StatusUnauthorized {
+		t.Errorf("stale timestamp: %d", code)
+	}
+	if IsBanned(ip) {
+		t.Fatal("rejected request was applied")
+	}
+	if code := post(time.Now(), testClusterSecret); code != http.StatusNoContent || !IsBanned(ip) {
+		t.Errorf("signed request: %d, banned %v", code, IsBanned(ip))
+	}
+	banned.del(ip)
+
+	// A node listed among its own peers ignores its own events.
+	b.cfg.NodeID = "a"
+	if code := post(time.Now(), testClusterSecret); code != http.StatusNoContent || IsBanned(ip) {
+		t.Errorf("own event: %d, banned %v", code, IsBanned(ip))
+	}
+}
+
+// A signed request is accepted once; sending it again, even within the
+// allowed skew, is refused.
+func TestClusterRejectsReplayedRequests(t *testing.T) {
+	b := &cluster{cfg: ClusterConfig{NodeID: "b", Secret: testClusterSecret}}
+	ip := "10.2.0.5"
+	banned.del(ip)
+	defer banned.del(ip)
+	body, _ := json.Marshal(clusterBatch{Node: "a", Events: []clusterEvent{{Type: clusterEventBan, IP: ip}}})
+	ts, nonce := strconv.FormatInt(time.Now().Unix(), 10), newClusterNonce()
+	sig := b.sign(ts, nonce, body)
+	post := func(nonce string) int {
+		req := httptest.NewRequest(http.MethodPost, clusterPath, bytes.NewReader(body))
+		req.Header.Set(clusterTimeHeader, ts)
+		req.Header.Set(clusterNonceHeader, nonce)
+		req.Header.Set(clusterSignHeader, sig)
+		rec := httptest.NewRecorder()
+		b.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(nonce); code != http.StatusNoContent || !IsBanned(ip) {
+		t.Fatalf("first request: %d, banned %v", code, IsBanned(ip))
+	}
+	banned.del(ip)
+	if code := post(nonce); code != http.StatusUnauthorized || IsBanned(ip) {
+		t.Errorf("replayed request: %d, banned %v", code, IsBanned(ip))
+	}
+	if code := post(newClusterNonce()); code != http.StatusUnauthorized {
+		t.Errorf("signature reused with another nonce: %d", code)
+	}
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Errorf("no nonce: %d", code)
+	}
+
+	// Nonces are forgotten once no timestamp they came with can still pass.
+	later := time.Now().Add(2*clusterMaxSkew + time.Second)
+	if !b.firstUse(nonce, later) {
+		t.Error("nonce still remembered after 2*clusterMaxSkew")
+	}
+	if len(b.seen) != 1 {
+		t.Errorf("expired nonces were not pruned: %d left", len(b.seen))
+	}
+}
+
+func TestClusterUsage(t *testing.T) {
+	authStore = nil
+	setPolicy(storage.ThrottlePolicy{
+		Tier: storage.TierAnonymous, Enabled: true, RatePerSec: 0.001, Burst: 5,
+		BanEnabled: true, BanThreshold: 3, BanWindowSec: 60, BanDurationSec: 60,
+	})
+	ip := "10.2.0.5"
+	ipBuckets.Delete(ip)
+	banned.del(ip)
+	defer banned.del(ip)
+
+	Allow(ip)
+	Allow(ip)
+	RecordFailure(ip)
+	usage := takeUsage()
+	if len(usage) != 1 || usage[0] != (ipUsage{IP: ip, Tokens: 2, Failures: 1}) {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if usage := takeUsage(); len(usage) != 0 {
+		t.Fatalf("usage not reset: %+v", usage)
+	}
+
+	// A peer used the remaining three tokens, and more: the bucket empties.
+	applyUsage(ipUsage{IP: ip, Tokens: 4})
+	if ok, _ := Allow(ip); ok {
+		t.Error("peer usage not charged to the bucket")
+	}
+	// Two peer failures on top of our one cross the threshold of three.
+	applyUsage(ipUsage{IP: ip, Failures: 2})
+	if !IsBanned(ip) {
+		t.Error("peer failures did not count towards the ban")
+	}
+	// Peer usage is not reported back to the cluster.
+	if usage := takeUsage(); len(usage) != 0 {
+		t.Errorf("peer usage re-reported: %+v", usage)
+	}
+}
+
+func TestConfigureClusterErrors(t *testing.T) {
+	defer ConfigureCluster(ClusterConfig{})
+	for _, cfg := range []ClusterConfig{
+		{Enabled: true, Secret: "x"},
+		{Enabled: true, Listen: ":7946"},
+	} {
+		if err := ConfigureCluster(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	if err := ConfigureCluster(ClusterConfig{Enabled: true, Listen: ":7946", Secret: "x", Peers: []string{"10.0.0.2:7946/", " "}}); err != nil {
+		t.Fatal(err)
+	}
+	if st := GetClusterStatus(); len(st.Peers) != 1 || st.Peers[0].URL != "http://10.0.0.2:7946" {
+		t.Errorf("peers = %+v", st.Peers)
+	}
+}
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"reMazarin/storage"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Response compression for proxy and static routes. The encoding is negotiated
+// from Accept-Encoding (brotli, zstd, gzip); only responses whose type is on the
+// route's allowlist and whose size reaches the route's minimum are compressed,
+// and responses that already carry a Content-Encoding (a compressing backend, a
+// precompressed static file) pass through untouched.
+
+const defaultCompressMinSize = 1024
+
+// defaultCompressTypes is the MIME allowlist used when a route sets none.
+// Entries ending in "/*" match a whole top-level type.
+const defaultCompressTypes = "text/*,application/javascript,application/json,application/xml," +
+	"application/xhtml+xml,application/manifest+json,application/wasm,image/svg+xml"
+
+// compressEncodings are the supported encodings in server preference order,
+// used to break ties between equally weighted client choices.
+var compressEncodings = []string{"br", "zstd", "gzip"}
+
+// compressPolicy is the parsed form of a storage.RouteCompress.
+type compressPolicy struct {
+	minSize  int
+	types    map[string]bool // exact media types
+	prefixes []string        // "text/" for "text/*"
+}
+
+func newCompressPolicy(c storage.RouteCompress) *compressPolicy {
+	p := &compressPolicy{minSize: defaultCompressMinSize, types: make(map[string]bool)}
+	if c.MinSize > 0 {
+		p.minSize = c.MinSize
+	}
+	types := strings.TrimSpace(c.Types)
+	if types == "" {
+		types = defaultCompressTypes
+	}
+	for _, t := range strings.Split(types, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			p.prefixes = append(p.prefixes, prefix+"/")
+		} else if t != "" {
+			p.types[t] = true
+		}
+	}
+	return p
+}
+
+// allows reports whether a response of the given Content-Type may be compressed.
+func (p *compressPolicy) allows(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mt == "text/event-stream" {
+		return false // unknown type, or a stream that must flush event by event
+	}
+	if p.types[mt] {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(mt, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseEncoding picks the offer the client weights highest in acceptEncoding,
+// breaking ties by offer order. It returns "" if none is acceptable.
+func chooseEncoding(acceptEncoding string, offers []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	q := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+	best, bestQ := "", 0.0
+	for _, o := range offers {
+		w, ok := q[o]
+		if !ok {
+			w, ok = q["*"]
+		}
+		if ok && w > bestQ {
+			best, bestQ = o, w
+		}
+	}
+	return best
+}
+
+// withCompression wraps a route's handler with on-the-fly compression, if the
+// route enables it.
+func withCompression(route *ProxyRoute, next http.Handler) http.Handler {
+	if !route.Compress.Enabled {
+		return next
+	}
+	policy := newCompressPolicy(route.Compress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := chooseEncoding(r.Header.Get("Accept-Encoding"), compressEncodings)
+		if enc == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, policy: policy, enc: enc}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter decides at WriteHeader whether the response is eligible. When
+// the length is unknown it holds back up to minSize bytes, so small responses
+// go out uncompressed and large or streamed ones are compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	policy *compressPolicy
+	enc    string
+
+	status  int
+	decided bool
+	held    []byte
+	encoder io.WriteCloser // nil = passing through
+	release func()
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if c.status != 0 {
+		return
+	}
+	c.status = code
+	h := c.Header()
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		strings.Contains(h.Get("Cache-Control"), "no-transform") || !c.policy.allows(h.Get("Content-Type")) {
+		c.passThrough()
+		return
+	}
+	if !strings.Contains(strings.ToLower(strings.Join(h.Values("Vary"), ",")), "accept-encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < c.policy.minSize {
+			c.passThrough()
+			return
+		}
+		c.start()
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.decided {
+		if c.encoder != nil {
+			return c.encoder.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	c.held = append(c.held, p...)
+	if len(c.held) >= c.policy.minSize {
+		c.start()
+	}
+	return len(p), nil
+}
+
+// passThrough sends the response as is.
+func (c *compressWriter) passThrough() {
+	c.decided = true
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.held) > 0 {
+		c.ResponseWriter.Write(c.held)
+		c.held = nil
+	}
+}
+
+// start switches the response to the negotiated encoding.
+func (c *compressWriter) start() {
+	c.decided = true
+	h := c.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", c.enc)
+	// The encoded body is a different byte sequence: a strong ETag would lie.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	c.encoder, c.release = getEncoder(c.enc, c.ResponseWriter)
+	if len(c.held) > 0 {
+		c.encoder.Write(c.held)
+		c.held = nil
+	}
+}
+
+// Flush sends what has been written so far, so streamed responses still flow.
+func (c *compressWriter) Flush() {
+	if c.status == 0 {
+		return
+	}
+	if !c.decided {
+		c.start()
+	}
+	if f, ok := c.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (c *compressWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+// close finishes the response once the handler returns.
+func (c *compressWriter) close() {
+	if c.status == 0 {
+		return // nothing written (e.g. hijacked)
+	}
+	if !c.decided {
+		c.passThrough()
+	}
+	if c.encoder != nil {
+		c.encoder.Close()
+		c.release()
+	}
+}
+
+var (
+	gzipPool   = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	brotliPool = sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, 4) }}
+	zstdPool   = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// getEncoder returns a pooled encoder for enc writing to w, and the function
+// that returns it to the pool after Close.
+func getEncoder(enc string, w io.Writer) (io.WriteCloser, func()) {
+	switch enc {
+	case "br":
+		e := brotliPool.Get().(*brotli.Writer)
+		e.Reset(w)
+		return e, func() { brotliPool.Put(e) }
+	case "zstd":
+		e := zstdPool.Get().(*zstd.Encoder)
+		e.Reset(w)
+		return e, func() { zstdPool.Put(e) }
+	}
+	e := gzipPool.Get().(*gzip.Writer)
+	e.Reset(w)
+	return e, func() { gzipPool.Put(e) }
+}
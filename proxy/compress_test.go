@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestChooseEncoding(t *testing.T) {
+	for _, tc := range []struct{ accept, want string }{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"zstd, gzip", "zstd"},
+		{"br;q=0, gzip", "gzip"},
+		{"*", "br"},
+		{"identity", ""},
+	} {
+		if got := chooseEncoding(tc.accept, compressEncodings); got != tc.want {
+			t.Errorf("chooseEncoding(%q) = %q, want %q", tc.accept, got, tc.want)
+		}
+	}
+}
+
+// staticRoute writes files into a temp dir and returns a compressing static
+// handler serving it.
+func staticRoute(t *testing.T, files map[string]string) http.Handler {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := createHandlerForRoute(&ProxyRoute{
+		Url: "static.test:80", Target: dir, Type: "static",
+		Compress: storage.RouteCompress{Enabled: true},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func fetch(h http.Handler, path, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "http://static.test"+path, nil)
+	if accept != "" {
+		r.Header.Set("Accept-Encoding", accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func decode(t *testing.T, enc string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch enc {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return string(body)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decode %s: %v", enc, err)
+	}
+	return string(out)
+}
+
+// Every supported encoding round-trips, and the response advertises Vary.
+func TestCompressNegotiatedEncodings(t *testing.T) {
+	js := strings.Repeat("console.log('hello');\n", 200)
+	h := staticRoute(t, map[string]string{"app.js": js})
+	for _, enc := range compressEncodings {
+		rec := fetch(h, "/app.js", enc)
+		if got := rec.Header().Get("Content-Encoding"); got != enc {
+			t.Fatalf("Accept-Encoding %s: got Content-Encoding %q", enc, got)
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("missing Vary: Accept-Encoding")
+		}
+		if got := decode(t, enc, rec.Body.Bytes()); got != js {
+			t.Fatalf("%s: body does not round-trip", enc)
+		}
+	}
+	if rec := fetch(h, "/app.js", ""); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != js {
+		t.Fatal("a client without Accept-Encoding must get the plain body")
+	}
+}
+
+// Small bodies and types off the allowlist are sent as is.
+func TestCompressSkipsSmallAndBinary(t *testing.T) {
+	h := staticRoute(t, map[string]string{
+		"small.css": "body{}",
+		"logo.png":  strings.Repeat("\x89PNG", 1000),
+	})
+	for _, p := range []string{"/small.css", "/logo.png"} {
+		if rec := fetch(h, p, "gzip"); rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s should not be compressed", p)
+		}
+	}
+}
+
+// A precompressed sibling is served in place of on-the-fly compression, with
+// the original file's Content-Type; a missing .br falls back to .gz.
+func TestCompressServesPrecompressedSiblings(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("precompressed"))
+	zw.Close()
+	h := staticRoute(t, map[string]string{"index.html": "<p>plain</p>", "index.html.gz": gz.String()})
+
+	rec := fetch(h, "/", "br, gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("want the .gz sibling as text/html, got %q %q", rec.Header().Get("Content-Encoding"), rec.Header().Get("Content-Type"))
+	}
+	if got := decode(t, "gzip", rec.Body.Bytes()); got != "precompressed" {
+		t.Fatalf("served %q instead of the sibling", got)
+	}
+}
+
+// A backend that already compressed its response is passed through untouched.
+func TestCompressSkipsEncodedBackendResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "identity-test")
+		w.Write(bytes.Repeat([]byte("x"), 4096))
+	}))
+	defer backend.Close()
+	h, err := createHandlerForRoute(&ProxyRoute{
+		Url: "x:80", Target: strings.TrimPrefix(backend.URL, "http://"), Type: "proxy",
+		Compress: storage.RouteCompress{Enabled: true},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := fetch(h, "/", "gzip")
+	if rec.Header().Get("Content-Encoding") != "identity-test" || rec.Body.Len() != 4096 {
+		t.Fatalf("encoded backend response was altered: %q, %d bytes", rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+}
+
+// With caching on the same route, the cache keeps the backend's plain response
+// and each hit is encoded for the client asking, whatever the first one took.
+func TestCompressWithCache(t *testing.T) {
+	js := strings.Repeat("console.log('cached');\n", 200)
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/javascript")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(js))
+	}))
+	defer backend.Close()
+	if err := ConfigureCache(CacheConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := createHandlerForRoute(&ProxyRoute{
+		Url: "cz.test:80", Target: strings.TrimPrefix(backend.URL, "http://"), Type: "proxy",
+		Cache:    storage.RouteCache{Enabled: true},
+		Compress: storage.RouteCompress{Enabled: true},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct{ accept, enc, xcache, etag string }{
+		{"gzip", "gzip", "MISS", `W/"v1"`},
+		{"", "", "HIT", `"v1"`},
+		{"gzip", "gzip", "HIT", `W/"v1"`},
+		{"br", "br", "HIT", `W/"v1"`},
+	} {
+		rec := fetch(h, "/app.js", tc.accept)
+		res := rec.Result().Header
+		if res.Get("X-Cache") != tc.xcache || res.Get("Content-Encoding") != tc.enc || res.Get("ETag") != tc.etag {
+			t.Fatalf("request %d (%q): X-Cache %q, Content-Encoding %q, ETag %q; want %q, %q, %q", i, tc.accept,
+				res.Get("X-Cache"), res.Get("Content-Encoding"), res.Get("ETag"), tc.xcache, tc.enc, tc.etag)
+		}
+		if got := decode(t, tc.enc, rec.Body.Bytes()); got != js {
+			t.Fatalf("request %d (%q): body does not decode to the original", i, tc.accept)
+		}
+		if v := res.Values("Vary"); tc.enc != "" && (len(v) != 1 || v[0] != "Accept-Encoding") {
+			t.Fatalf("request %d (%q): Vary %q", i, tc.accept, v)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("want one backend call, got %d", calls)
+	}
+}
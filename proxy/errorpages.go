@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Error pages replace the bare text bodies of the proxy's own denials and
+// failures (401/407, 403, 404, 429, 502, 503, 504). Each status is rendered
+// from an html/template looked up, first match wins, as:
+//
+//	<route error_pages dir>/<code>.html
+//	<route error_pages dir>/default.html
+//	<[error_pages] dir>/<code>.html
+//	<[error_pages] dir>/default.html
+//	the built-in page
+//
+// Clients that prefer JSON get an equivalent JSON body instead.
+
+// ErrorPagesConfig configures the global error pages.
+type ErrorPagesConfig struct {
+	Dir      string // templates named <code>.html and default.html; "" = built-in only
+	LoginURL string // linked from auth errors; "" = no link
+}
+
+// errorPageData is what a template renders.
+type errorPageData struct {
+	Code      int
+	Status    string // "Forbidden"
+	Message   string // a sentence for humans
+	RequestID string
+	LoginURL  string // set on auth errors only
+	Host      string
+}
+
+// errorPageSet is one directory's templates.
+type errorPageSet struct {
+	byCode   map[int]*template.Template
+	fallback *template.Template // default.html, or nil
+}
+
+var (
+	errorPages    atomic.Pointer[errorPageSet] // global [error_pages] set
+	errorLoginURL atomic.Value                 // string
+)
+
+var builtinErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Code}} {{.Status}}</title></head>
+<body style="margin:0;font-family:system-ui,sans-serif;background:#f4f7f9;color:#2b3a44;display:flex;min-height:100vh;align-items:center;justify-content:center">
+<main style="background:#fff;border-radius:10px;box-shadow:0 2px 12px rgba(0,0,0,.08);padding:32px 40px;max-width:440px;text-align:center">
+<div style="font-size:48px;font-weight:700;color:#2d6385">{{.Code}}</div>
+<h1 style="font-size:20px;margin:4px 0 12px">{{.Status}}</h1>
+<p style="margin:0 0 16px;line-height:1.5">{{.Message}}</p>
+{{if .LoginURL}}<p><a href="{{.LoginURL}}" style="display:inline-block;background:#2d6385;color:#fff;padding:8px 18px;border-radius:6px;text-decoration:none">Sign in</a></p>{{end}}
+{{if .RequestID}}<p style="font-size:11px;color:#889;margin:16px 0 0">Request ID: <code>{{.RequestID}}</code></p>{{end}}
+</main></body></html>
+`))
+
+// errorMessages are the human-readable explanations of the built-in page.
+var errorMessages = map[int]string{
+	http.StatusUnauthorized:       "You need to sign in to access this page.",
+	http.StatusForbidden:          "You do not have permission to access this page.",
+	http.StatusNotFound:           "There is nothing at this address.",
+	http.StatusProxyAuthRequired:  "You need to sign in to access this page.",
+	http.StatusTooManyRequests:    "Too many requests from your address. Please wait a moment and try again.",
+	http.StatusBadGateway:         "The service behind this address is not responding correctly. Please try again shortly.",
+	http.StatusServiceUnavailable: "This service is temporarily unavailable. Please try again shortly.",
+	http.StatusGatewayTimeout:     "The service behind this address took too long to respond.",
+}
+
+// ConfigureErrorPages loads the global error-page templates.
+func ConfigureErrorPages(cfg ErrorPagesConfig) error {
+	set, err := loadErrorPages(cfg.Dir)
+	if err != nil {
+		return err
+	}
+	errorPages.Store(set)
+	errorLoginURL.Store(cfg.LoginURL)
+	return nil
+}
+
+// ValidateErrorPages checks a route's error-page directory before it is stored.
+func ValidateErrorPages(dir string) error {
+	_, err := loadErrorPages(dir)
+	return err
+}
+
+var errorPageName = regexp.MustCompile(`^([1-5][0-9][0-9]|default)\.html$`)
+
+// loadErrorPages parses the templates of dir; nil for "".
+func loadErrorPages(dir string) (*errorPageSet, error) {
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, xerrors.Newf("error pages: %w", err)
+	}
+	set := &errorPageSet{byCode: make(map[int]*template.Template)}
+	for _, e := range entries {
+		m := errorPageName.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		t, err := template.ParseFiles(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, xerrors.Newf("error page %s: %w", e.Name(), err)
+		}
+		if m[1] == "default" {
+			set.fallback = t
+		} else {
+			code, _ := strconv.Atoi(m[1])
+			set.byCode[code] = t
+		}
+	}
+	return set, nil
+}
+
+// lookup returns the template for code, or nil.
+func (s *errorPageSet) lookup(code int) *template.Template {
+	if s == nil {
+		return nil
+	}
+	if t := s.byCode[code]; t != nil {
+		return t
+	}
+	return s.fallback
+}
+
+// writeError answers r with the error page for code. rk is the route key
+// ("host:port") whose error_pages override applies, if it has one.
+func writeError(w http.ResponseWriter, r *http.Request, rk string, code int) {
+	data := errorPageData{
+		Code:      code,
+		Status:    http.StatusText(code),
+		Message:   errorMessages[code],
+		RequestID: requestIDOf(r),
+		Host:      r.Host,
+	}
+	if code == http.StatusUnauthorized || code == http.StatusProxyAuthRequired || code == http.StatusForbidden {
+		data.LoginURL, _ = errorLoginURL.Load().(string)
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set(requestIDHeader, data.RequestID)
+
+	if prefersJSON(r) {
+		body := map[string]any{"status": code, "error": data.Status, "message": data.Message, "request_id": data.RequestID}
+		if data.LoginURL != "" {
+			body["login_url"] = data.LoginURL
+		}
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	var routePages *errorPageSet
+	if m, ok := authCache.Load().(map[string]cachedRoute); ok {
+		routePages = m[rk].errorPages
+	}
+	t := routePages.lookup(code)
+	if t == nil {
+		t = errorPages.Load().lookup(code)
+	}
+	if t == nil {
+		t = builtinErrorPage
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		slog.Warn("error page template failed, using the built-in page", "code", code, "route", rk, "error", err)
+		buf.Reset()
+		builtinErrorPage.Execute(&buf, data)
+	}
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// prefersJSON reports whether the client ranks JSON above HTML in Accept.
+func prefersJSON(r *http.Request) bool {
+	var qJSON, qHTML float64 = -1, -1
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+		switch {
+		case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+			qJSON = max(qJSON, q)
+		case mt == "text/html":
+			qHTML = max(qHTML, q)
+		}
+	}
+	return qJSON > 0 && qJSON > qHTML
+}
+
+// requestIDOf returns the ID the router assigned to r, or a new one for a
+// request that did not come through it.
+func requestIDOf(r *http.Request) string {
+	if id := RequestID(r.Context()); id != "" {
+		return id
+	}
+	return newRequestID()
+}
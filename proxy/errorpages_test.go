@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+)
+
+func TestErrorPages(t *testing.T) {
+	global, route := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(global, "default.html"), []byte("global {{.Code}}"), 0o644)
+	os.WriteFile(filepath.Join(global, "404.html"), []byte("global missing {{.Host}}"), 0o644)
+	os.WriteFile(filepath.Join(route, "503.html"), []byte("route down {{.RequestID}}"), 0o644)
+	os.WriteFile(filepath.Join(route, "notes.txt"), []byte("ignored {{"), 0o644)
+
+	if err := ConfigureErrorPages(ErrorPagesConfig{Dir: global, LoginURL: "https://auth.example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureErrorPages(ErrorPagesConfig{})
+	setRouteCache(t, storage.Route{Url: "app:80", ErrorPages: route})
+
+	for _, tc := range []struct {
+		rk   string
+		code int
+		want string
+	}{
+		{"app:80", http.StatusServiceUnavailable, "route down req-1"},
+		{"app:80", http.StatusNotFound, "global missing app"},
+		{"other:80", http.StatusServiceUnavailable, "global 503"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://app/", nil)
+		req = req.WithContext(withRequestID(req.Context(), "req-1"))
+		rec := httptest.NewRecorder()
+		writeError(rec, req, tc.rk, tc.code)
+		if rec.Code != tc.code || rec.Body.String() != tc.want {
+			t.Errorf("%s %d: got %d %q, want %q", tc.rk, tc.code, rec.Code, rec.Body.String(), tc.want)
+		}
+		if rec.Header().Get("X-Request-Id") != "req-1" {
+			t.Errorf("%s %d: request ID not echoed", tc.rk, tc.code)
+		}
+	}
+
+	// JSON clients get the same information, with the login link on auth errors.
+	req := httptest.NewRequest(http.MethodGet, "http://app/", nil)
+	req.Header.Set("Accept", "application/json, text/html;q=0.5")
+	rec := httptest.NewRecorder()
+	writeError(rec, req, "app:80", http.StatusProxyAuthRequired)
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("not JSON: %q", rec.Body.String())
+	}
+	if body["status"] != float64(407) || body["login_url"] != "https://auth.example.com/" || body["request_id"] == "" {
+		t.Errorf("unexpected JSON error body: %v", body)
+	}
+}
+
+func TestBuiltinErrorPage(t *testing.T) {
+	ConfigureErrorPages(ErrorPagesConfig{LoginURL: "https://auth.example.com/"})
+	defer ConfigureErrorPages(ErrorPagesConfig{})
+
+	rec := httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodGet, "http://app/", nil), "app:80", http.StatusForbidden)
+	page := rec.Body.String()
+	id := rec.Header().Get("X-Request-Id")
+	if rec.Header().Get("Content-Type") != "text/html; charset=utf-8" || id == "" ||
+		!strings.Contains(page, "403") || !strings.Contains(page, id) || !strings.Contains(page, `href="https://auth.example.com/"`) {
+		t.Errorf("built-in page missing status, request ID or login link:\n%s", page)
+	}
+
+	rec = httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodGet, "http://app/", nil), "app:80", http.StatusBadGateway)
+	if strings.Contains(rec.Body.String(), "auth.example.com") {
+		t.Error("only auth errors link to the login page")
+	}
+
+	if ValidateErrorPages(filepath.Join(t.TempDir(), "missing")) == nil {
+		t.Error("missing directory must be rejected")
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"reMazarin/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Flow accounting. Every authorized TCP connection and UDP session is tracked
+// from the moment its backend socket is open until it ends, and then stored
+// with its duration, traffic and close reason. Like access-log entries, flow
+// records are queued and written by one goroutine (see InitAuth).
+
+// Why a flow ended.
+const (
+	FlowClientEOF  = "client_eof"  // the client closed the connection
+	FlowBackendEOF = "backend_eof" // the backend closed the connection or stopped answering
+	FlowIdle       = "idle"        // a UDP session saw no traffic for udpSessionTimeout
+	FlowShutdown   = "shutdown"    // the proxy stopped or the route was removed
+	FlowKilled     = "killed"      // an admin terminated the flow (see live.go)
+)
+
+// flowsKept bounds the flows table; older rows are deleted with the access log.
+const flowsKept = 10000
+
+var flowChan = make(chan storage.Flow, 512)
+
+// flow accounts one open TCP connection or UDP session. Bytes are counted
+// from the client's side: in = client → backend, out = backend → client.
+type flow struct {
+	requestID string
+	protocol  string
+	route     string
+	ip        string
+	username  string
+	start     time.Time
+
+	bytesIn, bytesOut atomic.Int64
+
+	reasonOnce sync.Once
+	reason     string
+
+	killFn func() // set by track
+}
+
+// newFlow starts accounting a flow; ctx carries its request ID.
+func newFlow(ctx context.Context, protocol, route, ip, username string) *flow {
+	return &flow{requestID: RequestID(ctx), protocol: protocol, route: route, ip: ip, username: username, start: time.Now()}
+}
+
+// setReason records why the flow ended; the first reason given wins.
+func (f *flow) setReason(reason string) {
+	f.reasonOnce.Do(func() { f.reason = reason })
+}
+
+// finish queues the flow's record. Call it once, after both directions have
+// stopped.
+func (f *flow) finish() {
+	f.setReason(FlowShutdown) // fallback; every relay path sets its own reason
+	liveConns.Delete(f)
+	end := time.Now()
+	rec := storage.Flow{
+		RequestID:   f.requestID,
+		RouteUrl:    f.route,
+		Protocol:    f.protocol,
+		IP:          f.ip,
+		Username:    f.username,
+		StartedAt:   f.start,
+		EndedAt:     end,
+		DurationMs:  end.Sub(f.start).Milliseconds(),
+		BytesIn:     f.bytesIn.Load(),
+		BytesOut:    f.bytesOut.Load(),
+		CloseReason: f.reason,
+	}
+	slog.DebugContext(withRequestID(context.Background(), f.requestID), f.protocol+": flow closed",
+		"client", f.ip, "route", f.route, "reason", rec.CloseReason,
+		"bytes_in", rec.BytesIn, "bytes_out", rec.BytesOut, "duration_ms", rec.DurationMs)
+	recordFlow(rec)
+	select {
+	case flowChan <- rec:
+	default:
+		// Drop rather than stall the relay if the queue is full.
+	}
+}
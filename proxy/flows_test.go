@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextFlow waits for the next queued flow record.
+func nextFlow(t *testing.T) storage.Flow {
+	t.Helper()
+	select {
+	case f := <-flowChan:
+		return f
+	case <-time.After(2 * time.Second):
+		t.Fatal("no flow recorded")
+		return storage.Flow{}
+	}
+}
+
+func TestTCPFlowAccounting(t *testing.T) {
+	setRouteCache(t, storage.Route{Url: "ssh:2222", Type: "tcp"})
+
+	// The backend answers every line with "ok\n" and hangs up when told to.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 64)
+				for {
+					n, err := c.Read(buf)
+					if err != nil || strings.Contains(string(buf[:n]), "bye") {
+						return
+					}
+					c.Write([]byte("ok\n"))
+				}
+			}()
+		}
+	}()
+
+	relay := func(ctx context.Context, talk func(c net.Conn)) storage.Flow {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handleTCPConn(ctx, &pipeConn{server}, newTCPTarget(ProxyRoute{Url: "ssh:2222", Target: ln.Addr().String()}), "ssh:2222")
+		}()
+		talk(client)
+		<-done
+		return nextFlow(t)
+	}
+
+	// The client hangs up.
+	f := relay(context.Background(), func(c net.Conn) {
+		c.Write([]byte("hello\n"))
+		io.ReadFull(c, make([]byte, 3))
+		c.Close()
+	})
+	if f.Protocol != "tcp" || f.IP != "192.0.2.7" || f.BytesIn != 6 || f.BytesOut != 3 || f.CloseReason != FlowClientEOF || f.RequestID == "" {
+		t.Errorf("client close: %+v", f)
+	}
+
+	// The backend hangs up.
+	f = relay(context.Background(), func(c net.Conn) {
+		c.Write([]byte("bye\n"))
+		io.Copy(io.Discard, c)
+		c.Close()
+	})
+	if f.CloseReason != FlowBackendEOF || f.BytesIn != 4 || f.BytesOut != 0 {
+		t.Errorf("backend close: %+v", f)
+	}
+
+	// The proxy shuts down mid-flow.
+	ctx, cancel := context.WithCancel(context.Background())
+	f = relay(ctx, func(c net.Conn) {
+		c.Write([]byte("hi\n"))
+		io.ReadFull(c, make([]byte, 3))
+		cancel()
+		io.Copy(io.Discard, c)
+	})
+	if f.CloseReason != FlowShutdown || f.BytesOut != 3 {
+		t.Errorf("shutdown: %+v", f)
+	}
+}
+
+// pipeConn gives one end of a net.Pipe a client address.
+type pipeConn struct{ net.Conn }
+
+func (pipeConn) RemoteAddr() net.Addr { return &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 50000} }
@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Header rewrite rules. Each route may carry one rule per line for the request
+// sent to the backend and for the response sent to the client:
+//
+//	set     X-Env production        replace every value (remove if it resolves to "")
+//	add     X-User {username}       add another header line
+//	append  Cache-Control private   add to the existing value, comma-separated
+//	remove  Server                  drop the header; "X-Debug-*" drops a family
+//
+// Values may contain the placeholders listed in headerVars. Blank lines and
+// lines starting with "#" are ignored.
+
+type headerOp int
+
+const (
+	headerSet headerOp = iota
+	headerAdd
+	headerAppend
+	headerRemove
+)
+
+var headerOps = map[string]headerOp{
+	"set":    headerSet,
+	"add":    headerAdd,
+	"append": headerAppend,
+	"remove": headerRemove,
+}
+
+// headerEnv is what a rule's placeholders are resolved against.
+type headerEnv struct {
+	r            *http.Request
+	route        string
+	forwardedFor string // X-Forwarded-For chain the client sent
+}
+
+func newHeaderEnv(r *http.Request, route string) *headerEnv {
+	return &headerEnv{r: r, route: route, forwardedFor: strings.Join(r.Header.Values("X-Forwarded-For"), ", ")}
+}
+
+// headerVars are the placeholders a rule value may use.
+var headerVars = map[string]func(e *headerEnv) string{
+	"client_ip":     func(e *headerEnv) string { return extractClientIP(e.r) },
+	"forwarded_for": func(e *headerEnv) string { return e.forwardedFor },
+	"username":      func(e *headerEnv) string { return accessUsername(e.r.Context()) },
+	"route":         func(e *headerEnv) string { return e.route },
+	"request_id":    func(e *headerEnv) string { return RequestID(e.r.Context()) },
+	"host":          func(e *headerEnv) string { return e.r.Host },
+	"method":        func(e *headerEnv) string { return e.r.Method },
+	"path":          func(e *headerEnv) string { return e.r.URL.Path },
+	"query":         func(e *headerEnv) string { return e.r.URL.RawQuery },
+	"uri":           func(e *headerEnv) string { return e.r.URL.RequestURI() },
+	"scheme": func(e *headerEnv) string {
+		if e.r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	},
+}
+
+// protectedHeaders are framing headers the proxy manages itself.
+var protectedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// headerValue is a parsed rule value: literal text interleaved with placeholders.
+type headerValue []headerPart
+
+type headerPart struct {
+	text string
+	vr   func(e *headerEnv) string // nil for literal text
+}
+
+func (v headerValue) expand(e *headerEnv) string {
+	var b strings.Builder
+	for _, part := range v {
+		if part.vr != nil {
+			b.WriteString(part.vr(e))
+		} else {
+			b.WriteString(part.text)
+		}
+	}
+	return b.String()
+}
+
+type headerRule struct {
+	op     headerOp
+	name   string // canonical header name, or a prefix when prefix is set
+	value  headerValue
+	prefix bool // remove matches every header starting with name
+}
+
+// headerRules is an ordered rule list; later rules see the effect of earlier ones.
+type headerRules []headerRule
+
+// ValidateHeaderRules reports the first invalid line of a rule text.
+func ValidateHeaderRules(text string) error {
+	_, err := parseHeaderRules(text)
+	return err
+}
+
+// parseHeaderRules parses the rule text of one direction.
+func parseHeaderRules(text string) (headerRules, error) {
+	var rules headerRules
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseHeaderRule(line)
+		if err != nil {
+			return nil, xerrors.Newf("header rule line %d: %w", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseHeaderRule(line string) (headerRule, error) {
+	verb, rest, _ := strings.Cut(line, " ")
+	op, ok := headerOps[strings.ToLower(verb)]
+	if !ok {
+		return headerRule{}, xerrors.Newf("unknown action %q (want set, add, append or remove)", verb)
+	}
+	name, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	value = strings.TrimSpace(value)
+	if name == "" {
+		return headerRule{}, xerrors.Newf("missing header name")
+	}
+	rule := headerRule{op: op}
+	if op == headerRemove {
+		if value != "" {
+			return headerRule{}, xerrors.Newf("remove takes no value")
+		}
+		name, rule.prefix = strings.CutSuffix(name, "*")
+	}
+	if !validHeaderName(name) {
+		return headerRule{}, xerrors.Newf("invalid header name %q", name)
+	}
+	rule.name = http.CanonicalHeaderKey(name)
+	if protectedHeaders[rule.name] {
+		return headerRule{}, xerrors.Newf("%s is managed by the proxy", rule.name)
+	}
+	if rule.name == "Host" && op != headerSet {
+		return headerRule{}, xerrors.Newf("Host can only be set")
+	}
+	if op != headerRemove {
+		v, err := parseHeaderValue(value)
+		if err != nil {
+			return headerRule{}, err
+		}
+		rule.value = v
+	}
+	return rule, nil
+}
+
+// validHeaderName reports whether s is a non-empty RFC 9110 token.
+func validHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func parseHeaderValue(s string) (headerValue, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, xerrors.Newf("value must be a single line")
+	}
+	var v headerValue
+	for s != "" {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			v = append(v, headerPart{text: s})
+			break
+		}
+		if open > 0 {
+			v = append(v, headerPart{text: s[:open]})
+		}
+		end := strings.IndexByte(s[open:], '}')
+		if end < 0 {
+			return nil, xerrors.Newf("unclosed placeholder in %q", s)
+		}
+		name := s[open+1 : open+end]
+		vr, ok := headerVars[name]
+		if !ok {
+			return nil, xerrors.Newf("unknown placeholder {%s}", name)
+		}
+		v = append(v, headerPart{vr: vr})
+		s = s[open+end+1:]
+	}
+	return v, nil
+}
+
+// apply runs the rules against h. Values are resolved from env; a set that
+// resolves to "" removes the header, so e.g. an anonymous request cannot pass a
+// spoofed user header through. Setting Host on a request changes req.Host,
+// since Go ignores a Host entry in the map.
+func (rs headerRules) apply(h http.Header, env *headerEnv, req *http.Request) {
+	for _, rule := range rs {
+		if rule.op == headerRemove {
+			if !rule.prefix {
+				h.Del(rule.name)
+				continue
+			}
+			for k := range h {
+				if strings.HasPrefix(k, rule.name) {
+					delete(h, k)
+				}
+			}
+			continue
+		}
+		v := rule.value.expand(env)
+		switch rule.op {
+		case headerSet:
+			switch {
+			case rule.name == "Host" && req != nil:
+				if v != "" {
+					req.Host = v
+				}
+			case v == "":
+				h.Del(rule.name)
+			default:
+				h.Set(rule.name, v)
+			}
+		case headerAdd:
+			h.Add(rule.name, v)
+		case headerAppend:
+			if old := strings.Join(h.Values(rule.name), ", "); old != "" {
+				v = old + ", " + v
+			}
+			h.Set(rule.name, v)
+		}
+	}
+}
+
+// withResponseHeaders applies a route's response rules to every response it
+// serves, just before the status line goes out. It wraps the cache, which
+// keeps its own copy of the backend's headers, so the rules never become part
+// of a stored entry and run once per response, hit or miss.
+func withResponseHeaders(route *ProxyRoute, rules headerRules, next http.Handler) http.Handler {
+	if len(rules) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerWriter{ResponseWriter: w, rules: rules, env: newHeaderEnv(r, route.Url)}
+		next.ServeHTTP(hw, r)
+		hw.apply() // the handler may return without writing; the server then sends 200
+	})
+}
+
+// headerWriter applies response rules once, at the first WriteHeader or Write.
+type headerWriter struct {
+	http.ResponseWriter
+	rules   headerRules
+	env     *headerEnv
+	applied bool
+}
+
+func (hw *headerWriter) apply() {
+	if !hw.applied {
+		hw.applied = true
+		hw.rules.apply(hw.Header(), hw.env, nil)
+	}
+}
+
+func (hw *headerWriter) WriteHeader(code int) {
+	if code >= 200 {
+		hw.apply()
+	}
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerWriter) Write(p []byte) (int, error) {
+	if !hw.applied {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(p)
+}
+
+// Flush keeps streamed responses flowing through the wrapper.
+func (hw *headerWriter) Flush() {
+	if !hw.applied {
+		hw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(hw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (hw *headerWriter) Unwrap() http.ResponseWriter { return hw.ResponseWriter }
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaderRulesRejectsInvalid(t *testing.T) {
+	for _, rules := range []string{
+		"replace X-A b",
+		"set",
+		"set X:A b",
+		"remove X-A value",
+		"set X-A {nope}",
+		"set X-A {client_ip",
+		"set Content-Length 0",
+		"add Host example.com",
+	} {
+		if _, err := parseHeaderRules(rules); err == nil {
+			t.Errorf("%q: want a parse error", rules)
+		}
+	}
+	rules, err := parseHeaderRules("# comment\n\nset X-A 1\nremove X-Debug-*\n")
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("want 2 rules, got %d (%v)", len(rules), err)
+	}
+}
+
+// Request rules run after the proxy's fixed headers and can use the route,
+// client and signed-in user; response rules rewrite what the client receives.
+func TestHeaderRulesRewriteRequestAndResponse(t *testing.T) {
+	var got http.Header
+	var gotHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, gotHost = r.Header.Clone(), r.Host
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("X-Debug-Trace", "abc")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	route := &ProxyRoute{
+		Url:    "app:80",
+		Target: strings.TrimPrefix(backend.URL, "http://"),
+		Type:   "proxy",
+		Headers: storage.RouteHeaders{
+			Request:  "set X-Remote-User {username}\nset X-Proxy {route}\nset X-Forwarded-For {forwarded_for}\nremove Authorization\nset Host internal.example",
+			Response: "remove Server\nremove X-Debug-*\nappend Cache-Control private\nadd X-Client {client_ip}",
+		},
+	}
+	h, err := createHandlerForRoute(route, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://app/", nil)
+	req.RemoteAddr = "192.0.2.7:5000"
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req = withAccessScope(req, "user:1", "alice")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	for k, want := range map[string]string{
+		"X-Remote-User":   "alice",
+		"X-Proxy":         "app:80",
+		"X-Forwarded-For": "198.51.100.1, 192.0.2.7",
+		"Authorization":   "",
+	} {
+		if v := got.Get(k); v != want {
+			t.Errorf("request %s = %q, want %q", k, v, want)
+		}
+	}
+	if gotHost != "internal.example" {
+		t.Errorf("backend saw Host %q, want internal.example", gotHost)
+	}
+	res := rec.Result().Header
+	for k, want := range map[string]string{
+		"Server":        "",
+		"X-Debug-Trace": "",
+		"Cache-Control": "max-age=60, private",
+		"X-Client":      "192.0.2.7",
+	} {
+		if v := res.Get(k); v != want {
+			t.Errorf("response %s = %q, want %q", k, v, want)
+		}
+	}
+}
+
+// Response rules apply once to every response of a caching route, and what
+// they add is never stored with the cached copy.
+func TestHeaderRulesWithCache(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Server", "backend/1.0")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	if err := ConfigureCache(CacheConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := createHandlerForRoute(&ProxyRoute{
+		Url:     "hc:80",
+		Target:  strings.TrimPrefix(backend.URL, "http://"),
+		Type:    "proxy",
+		Cache:   storage.RouteCache{Enabled: true},
+		Headers: storage.RouteHeaders{Response: "add X-Frame-Options DENY\nappend Cache-Control must-revalidate\nremove Server"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"MISS", "HIT", "HIT"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://hc/", nil))
+		res := rec.Result().Header
+		if res.Get("X-Cache") != want {
+			t.Fatalf("X-Cache = %q, want %q", res.Get("X-Cache"), want)
+		}
+		if v := res.Values("X-Frame-Options"); len(v) != 1 {
+			t.Errorf("%s: X-Frame-Options = %q, want one DENY", want, v)
+		}
+		if v := res.Values("Cache-Control"); len(v) != 1 || v[0] != "max-age=60, must-revalidate" {
+			t.Errorf("%s: Cache-Control = %q", want, v)
+		}
+		if v := res.Get("Server"); v != "" {
+			t.Errorf("%s: Server = %q, want it removed", want, v)
+		}
+	}
+}
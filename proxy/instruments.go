@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"reMazarin/storage"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// OpenTelemetry instruments for the proxy. They are created against the global
+// meter provider, which forwards to the real provider once main sets it up and
+// is a no-op when neither OTel nor Prometheus is enabled, so recording is
+// always safe. The in-memory counters behind the admin Metrics tab (routes,
+// events, bans, live connections) are exported as observable instruments, so
+// the OTLP exporter and the Prometheus endpoint see the same numbers.
+
+const meterName = "reMazarin/proxy"
+
+// durationBuckets are the request-duration histogram bounds, in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var (
+	instrumentsOnce    sync.Once
+	breakerTransitions metric.Int64Counter
+	cacheRequests      metric.Int64Counter
+	rateLimited        metric.Int64Counter
+	bansIssued         metric.Int64Counter
+	authDecisions      metric.Int64Counter
+	routeDuration      metric.Float64Histogram
+	flowsClosed        metric.Int64Counter
+	flowBytes          metric.Int64Counter
+	flowDuration       metric.Float64Histogram
+)
+
+// flowDurationBuckets are the flow-duration histogram bounds, in seconds:
+// flows last from a DNS exchange to a day-long SSH session.
+var flowDurationBuckets = []float64{0.1, 1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}
+
+// tracer creates the proxy's own spans, such as session validation.
+var tracer = otel.Tracer(meterName)
+
+func initInstruments() {
+	instrumentsOnce.Do(func() {
+		meter := otel.Meter(meterName)
+		breakerTransitions, _ = meter.Int64Counter("remazarin.breaker.transitions",
+			metric.WithDescription("Circuit-breaker state transitions, by route, upstream and new state"))
+		cacheRequests, _ = meter.Int64Counter("remazarin.cache.requests",
+			metric.WithDescription("Response-cache lookups, by route and result (hit, miss, revalidated, bypass)"))
+		rateLimited, _ = meter.Int64Counter("remazarin.ratelimit.rejections",
+			metric.WithDescription("Requests refused by the per-IP rate limit, by route"))
+		bansIssued, _ = meter.Int64Counter("remazarin.bans",
+			metric.WithDescription("IP bans issued, by tier (\"manual\" for admin bans)"))
+		authDecisions, _ = meter.Int64Counter("remazarin.auth.decisions",
+			metric.WithDescription("Route access-control decisions, by route, protocol, method and outcome"))
+		routeDuration, _ = meter.Float64Histogram("remazarin.route.duration",
+			metric.WithUnit("s"),
+			metric.WithDescription("HTTP request duration of known routes, by route and status class"),
+			metric.WithExplicitBucketBoundaries(durationBuckets...))
+		flowsClosed, _ = meter.Int64Counter("remazarin.flows",
+			metric.WithDescription("Finished TCP/UDP flows, by route, protocol and close reason"))
+		flowBytes, _ = meter.Int64Counter("remazarin.flow.bytes",
+			metric.WithUnit("By"),
+			metric.WithDescription("TCP/UDP flow traffic, by route, protocol and direction (in = client to backend)"))
+		flowDuration, _ = meter.Float64Histogram("remazarin.flow.duration",
+			metric.WithUnit("s"),
+			metric.WithDescription("TCP/UDP flow duration, by route and protocol"),
+			metric.WithExplicitBucketBoundaries(flowDurationBuckets...))
+		_, _ = meter.Int64ObservableCounter("remazarin.route.requests",
+			metric.WithDescription("Requests and connections served, by route"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				for route, n := range GetRouteStats() {
+					o.Observe(n, metric.WithAttributes(attribute.String("route", route)))
+				}
+				return nil
+			}))
+		_, _ = meter.Int64ObservableCounter("remazarin.events",
+			metric.WithDescription("Proxy events, by outcome"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				for outcome, n := range GetEventStats() {
+					o.Observe(n, metric.WithAttributes(attribute.String("outcome", outcome)))
+				}
+				return nil
+			}))
+		_, _ = meter.Int64ObservableGauge("remazarin.bans.active",
+			metric.WithDescription("Currently banned IPs"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(int64(banned.count()))
+				return nil
+			}))
+		_, _ = meter.Int64ObservableGauge("remazarin.sessions.active",
+			metric.WithDescription("Signed-in sessions that have not expired"),
+			metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+				if authStore == nil {
+					return nil
+				}
+				n, err := authStore.CountActiveSessions(ctx)
+				if err != nil {
+					return err
+				}
+				o.Observe(int64(n))
+				return nil
+			}))
+		_, _ = meter.Int64ObservableGauge("remazarin.connections.active",
+			metric.WithDescription("Open TCP/UDP flows and in-flight HTTP requests of known routes, by route and protocol"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				type key struct{ route, protocol string }
+				counts := map[key]int64{}
+				liveConns.Range(func(k, _ any) bool {
+					if c := k.(liveConn); knownRoute(c) {
+						s := c.snapshot()
+						counts[key{s.Route, s.Protocol}]++
+					}
+					return true
+				})
+				for k, n := range counts {
+					o.Observe(n, metric.WithAttributes(
+						attribute.String("route", k.route),
+						attribute.String("protocol", k.protocol),
+					))
+				}
+				return nil
+			}))
+		// Current state per backend: 0 closed, 1 half-open, 2 open.
+		_, _ = meter.Int64ObservableGauge("remazarin.breaker.state",
+			metric.WithDescription("Circuit-breaker state per upstream: 0 closed, 1 half-open, 2 open"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				breakers.Range(func(_, v any) bool {
+					for _, b := range v.(map[string]*breaker) {
+						o.Observe(int64(b.current()), metric.WithAttributes(
+							attribute.String("route", b.routeUrl),
+							attribute.String("upstream", b.upstream),
+						))
+					}
+					return true
+				})
+				return nil
+			}))
+	})
+}
+
+func recordBreakerTransition(routeUrl, upstream string, to breakerState) {
+	initInstruments()
+	breakerTransitions.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("route", routeUrl),
+		attribute.String("upstream", upstream),
+		attribute.String("state", to.String()),
+	))
+}
+
+func recordCacheRequest(routeUrl, result string) {
+	initInstruments()
+	cacheRequests.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("route", routeUrl),
+		attribute.String("result", result),
+	))
+}
+
+// Auth decision outcomes. A denial names what was missing or wrong.
+const (
+	authAllowed        = "allowed"
+	authNoRoute        = "no_route"        // the route is not in the auth cache
+	authNoCredentials  = "no_credentials"  // no session, cookie or allowlisted IP
+	authInvalidSession = "invalid_session" // the session cookie is unknown or expired
+	authForbidden      = "forbidden"       // signed in, but not in an allowed group
+)
+
+// recordAuthDecision counts one access-control decision. method is the auth
+// method that decided (AuthPublic, AuthCookie …) or "none" when no method
+// applied.
+func recordAuthDecision(routeUrl, protocol, method, outcome string) {
+	initInstruments()
+	authDecisions.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("route", routeUrl),
+		attribute.String("protocol", protocol),
+		attribute.String("method", method),
+		attribute.String("outcome", outcome),
+	))
+}
+
+func recordBan(tier string) {
+	initInstruments()
+	bansIssued.Add(context.Background(), 1, metric.WithAttributes(attribute.String("tier", tier)))
+}
+
+func recordRateLimited(routeUrl string) {
+	initInstruments()
+	rateLimited.Add(context.Background(), 1, metric.WithAttributes(attribute.String("route", routeUrl)))
+}
+
+// recordRouteDuration records an HTTP request that reached route access
+// control; requests for unknown hosts are left out so a scan cannot create a
+// time series per made-up Host.
+func recordRouteDuration(routeUrl string, status int, d time.Duration) {
+	initInstruments()
+	routeDuration.Record(context.Background(), d.Seconds(), metric.WithAttributes(
+		attribute.String("route", routeUrl),
+		attribute.String("status_class", strconv.Itoa(status/100)+"xx"),
+	))
+}
+
+func recordFlow(f storage.Flow) {
+	initInstruments()
+	ctx := context.Background()
+	route, proto := attribute.String("route", f.RouteUrl), attribute.String("protocol", f.Protocol)
+	flowsClosed.Add(ctx, 1, metric.WithAttributes(route, proto, attribute.String("reason", f.CloseReason)))
+	flowBytes.Add(ctx, f.BytesIn, metric.WithAttributes(route, proto, attribute.String("direction", "in")))
+	flowBytes.Add(ctx, f.BytesOut, metric.WithAttributes(route, proto, attribute.String("direction", "out")))
+	flowDuration.Record(ctx, f.EndedAt.Sub(f.StartedAt).Seconds(), metric.WithAttributes(route, proto))
+}
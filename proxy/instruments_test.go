@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestAuthDecisionTelemetry(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(ctx)
+	otel.SetMeterProvider(mp)
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	defer tp.Shutdown(ctx)
+	otel.SetTracerProvider(tp)
+
+	s, err := storage.New(t.TempDir() + "/telemetry.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	u, _ := s.CreateUser(ctx, "alice", "pw")
+	g, _ := s.CreateGroup(ctx, "g1", "")
+	s.AddUserToGroup(ctx, u.ID, g.ID)
+	tok, _ := s.CreateSession(ctx, u.ID, time.Hour, "10.0.0.1")
+	oldStore := authStore
+	authStore = s
+	defer func() { authStore = oldStore }()
+	globalSettings.Store(storage.Settings{SessionDurationHours: 168})
+	setRouteCache(t,
+		storage.Route{Url: "app:443", AllowedGroups: strconv.Itoa(g.ID), PersistentLogin: true},
+		storage.Route{Url: "open:443"})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	do := func(rk, cookie string) {
+		req := httptest.NewRequest(http.MethodGet, "http://"+rk+"/", nil)
+		req.RemoteAddr = "192.0.2.50:1234"
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+		withAuthForKey(rk, next).ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do("app:443", tok)
+	do("app:443", "bogus")
+	do("app:443", "")
+	do("open:443", "")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[[3]string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "remazarin.auth.decisions" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				route, _ := dp.Attributes.Value(attribute.Key("route"))
+				method, _ := dp.Attributes.Value(attribute.Key("method"))
+				outcome, _ := dp.Attributes.Value(attribute.Key("outcome"))
+				got[[3]string{route.AsString(), method.AsString(), outcome.AsString()}] += dp.Value
+			}
+		}
+	}
+	for k, want := range map[[3]string]int64{
+		{"app:443", AuthCookie, authAllowed}:        1,
+		{"app:443", AuthCookie, authInvalidSession}: 1,
+		{"app:443", AuthCookie, authNoCredentials}:  1,
+		{"open:443", AuthPublic, authAllowed}:       1,
+	} {
+		if got[k] != want {
+			t.Errorf("%v = %d, want %d (all: %v)", k, got[k], want, got)
+		}
+	}
+
+	// Each cookie lookup is a span; the valid one names the user.
+	var validated []sdktrace.ReadOnlySpan
+	for _, sp := range spans.Ended() {
+		if sp.Name() == "auth.validate_session" {
+			validated = append(validated, sp)
+		}
+	}
+	if len(validated) != 2 {
+		t.Fatalf("want 2 auth.validate_session spans, got %d", len(validated))
+	}
+	attrs := attribute.NewSet(validated[0].Attributes()...)
+	if v, _ := attrs.Value("auth.valid"); !v.AsBool() {
+		t.Errorf("first span: %v", validated[0].Attributes())
+	}
+	if v, _ := attrs.Value("user.name"); v.AsString() != "alice" {
+		t.Errorf("first span: %v", validated[0].Attributes())
+	}
+}
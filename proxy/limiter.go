@@ -34,6 +34,10 @@ type ipBucket struct {
 	failCount   int
 	windowStart time.Time
 	lastSeen    time.Time
+
+	// Consumed here since the last cluster sync; see takeUsage.
+	usedTokens   float64
+	usedFailures int
 }
 
 var (
@@ -69,6 +73,20 @@ func (b *banSet) has(ip string) bool {
 	return true
 }
 
+// count returns how many bans are in force.
+func (b *banSet) count() int {
+	now := time.Now()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n := 0
+	for _, exp := range b.m {
+		if exp.IsZero() || now.Before(exp) {
+			n++
+		}
+	}
+	return n
+}
+
 func (b *banSet) set(ip string, exp time.Time) {
 	b.mu.Lock()
 	b.m[ip] = exp
@@ -149,11 +167,26 @@ func Allow(ip string) (bool, int) {
 		return true, 0
 	}
 
+	now := time.Now()
+	b.refill(pol, now)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		b.usedTokens++
+		return true, 0
+	}
+	retry := int((1-b.tokens)/pol.RatePerSec) + 1
+	return false, retry
+}
+
+// refill adds the tokens earned since the last refill, up to the burst. The
+// caller holds b.mu.
+func (b *ipBucket) refill(pol storage.ThrottlePolicy, now time.Time) {
 	burst := float64(pol.Burst)
 	if burst < 1 {
 		burst = 1
 	}
-	now := time.Now()
 	if b.lastRefill.IsZero() {
 		b.tokens = burst
 	} else {
@@ -163,20 +196,15 @@ func Allow(ip string) (bool, int) {
 		b.tokens = burst
 	}
 	b.lastRefill = now
-	b.lastSeen = now
-
-	if b.tokens >= 1 {
-		b.tokens -= 1
-		return true, 0
-	}
-	retry := int((1-b.tokens)/pol.RatePerSec) + 1
-	return false, retry
 }
 
 // RecordFailure counts one failure (denied auth, rate-limit hit, TLS junk, etc.)
 // for the IP against its tier's auto-ban policy, banning it once the threshold is
 // crossed within the sliding window.
-func RecordFailure(ip string) {
+func RecordFailure(ip string) { addFailures(ip, 1, true) }
+
+// addFailures counts n failures; local ones are also reported to the cluster.
+func addFailures(ip string, n int, local bool) {
 	b := bucketFor(ip)
 	b.mu.Lock()
 
@@ -193,7 +221,10 @@ func RecordFailure(ip string) {
 		b.windowStart = now
 		b.failCount = 0
 	}
-	b.failCount++
+	b.failCount += n
+	if local {
+		b.usedFailures += n
+	}
 	b.lastSeen = now
 	tripped := b.failCount >= pol.BanThreshold
 	tier := b.tier
@@ -209,8 +240,8 @@ func RecordFailure(ip string) {
 	}
 }
 
-// Ban bans an IP for durationSec (0 = until manually cleared), updating both the
-// in-memory set and the DB.
+// Ban bans an IP for durationSec (0 = until manually cleared), updating the
+// in-memory set and the DB, and tells the cluster.
 func Ban(ip, reason, tier string, durationSec int) {
 	var expiry time.Time
 	var exp *time.Time
@@ -219,20 +250,23 @@ func Ban(ip, reason, tier string, durationSec int) {
 		exp = &expiry
 	}
 	banned.set(ip, expiry)
+	recordBan(tier)
 	if authStore != nil {
 		if err := authStore.InsertBan(context.Background(), ip, reason, tier, exp); err != nil {
 			slog.Error("persist ban failed", "ip", ip, "error", err)
 		}
 	}
+	publish(clusterEvent{Type: clusterEventBan, IP: ip, Reason: reason, Tier: tier, Expires: exp})
 	slog.Warn("ip banned", "ip", ip, "reason", reason, "duration_sec", durationSec)
 }
 
-// Unban lifts a ban from both the in-memory set and the DB.
+// Unban lifts a ban from the in-memory set and the DB, and tells the cluster.
 func Unban(ip string) {
 	banned.del(ip)
 	if authStore != nil {
 		authStore.DeleteBan(context.Background(), ip)
 	}
+	publish(clusterEvent{Type: clusterEventUnban, IP: ip})
 	slog.Info("ip unbanned", "ip", ip)
 }
 
@@ -302,3 +336,38 @@ func sweepBuckets() {
 		return true
 	})
 }
+
+// takeUsage collects and resets the tokens and failures each IP used since the
+// last call, for the cluster sync.
+func takeUsage() []ipUsage {
+	var out []ipUsage
+	ipBuckets.Range(func(k, v any) bool {
+		b := v.(*ipBucket)
+		b.mu.Lock()
+		if b.usedTokens > 0 || b.usedFailures > 0 {
+			out = append(out, ipUsage{IP: k.(string), Tokens: b.usedTokens, Failures: b.usedFailures})
+			b.usedTokens, b.usedFailures = 0, 0
+		}
+		b.mu.Unlock()
+		return true
+	})
+	return out
+}
+
+// applyUsage charges a peer's usage to the local bucket: its tokens are taken
+// from ours (never below empty) and its failures count towards our auto-ban
+// threshold.
+func applyUsage(u ipUsage) {
+	b := bucketFor(u.IP)
+	b.mu.Lock()
+	now := time.Now()
+	if pol, ok := policyFor(b.tier); ok && pol.Enabled && pol.RatePerSec > 0 && u.Tokens > 0 {
+		b.refill(pol, now)
+		b.tokens = max(b.tokens-u.Tokens, 0)
+	}
+	b.lastSeen = now
+	b.mu.Unlock()
+	if u.Failures > 0 {
+		addFailures(u.IP, u.Failures, false)
+	}
+}
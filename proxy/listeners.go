@@ -5,11 +5,62 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/mdobak/go-xerrors"
 )
 
+// Built-in HTTP listener timeouts, used for any port without a [[listeners]]
+// override (and for any override field left at zero). ReadHeaderTimeout is what
+// stops slowloris; read/write stay unbounded by default so large uploads and
+// streamed responses keep working.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 0
+	defaultWriteTimeout      = 0
+	defaultIdleTimeout       = 120 * time.Second
+)
+
+// ListenerTimeouts bounds how long an HTTP listener waits on its clients. Values
+// are seconds: 0 uses the built-in default, a negative value disables the limit.
+type ListenerTimeouts struct {
+	ReadHeaderTimeout int `json:"read_header_timeout"`
+	ReadTimeout       int `json:"read_timeout"`
+	WriteTimeout      int `json:"write_timeout"`
+	IdleTimeout       int `json:"idle_timeout"`
+}
+
+// Effective returns the timeouts with defaults applied, in seconds (0 = none),
+// for display in the admin panel.
+func (t ListenerTimeouts) Effective() ListenerTimeouts {
+	s := func(v int, def time.Duration) int { return int(seconds(v, def).Seconds()) }
+	return ListenerTimeouts{
+		ReadHeaderTimeout: s(t.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       s(t.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      s(t.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       s(t.IdleTimeout, defaultIdleTimeout),
+	}
+}
+
+// ListenerInfo is one live HTTP listener as reported to the admin panel.
+type ListenerInfo struct {
+	Port     string           `json:"port"`
+	Tls      bool             `json:"tls"`
+	Timeouts ListenerTimeouts `json:"timeouts"`
+}
+
+// GetListeners returns the live HTTP listeners with their effective timeouts.
+func (p *Proxy) GetListeners() []ListenerInfo {
+	out := make([]ListenerInfo, 0, len(p.servers))
+	for port, ls := range p.servers {
+		out = append(out, ListenerInfo{Port: port, Tls: ls.Tls, Timeouts: p.Listeners[port].Effective()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Port < out[j].Port })
+	return out
+}
+
 // tlsErrWriter captures connection-level errors that net/http reports to
 // Server.ErrorLog before any handler runs — most importantly TLS handshake
 // failures (plain HTTP to a TLS port, junk bytes, scans). These never reach the
@@ -51,10 +102,15 @@ func (p *Proxy) startListeners() {
 
 func (p *Proxy) startListener(listener *listenServer) error {
 	mux := http.NewServeMux()
+	t := p.Listeners[listener.Port]
 	server := &http.Server{
-		Addr:     ":" + listener.Port,
-		Handler:  mux,
-		ErrorLog: log.New(tlsErrWriter{port: listener.Port}, "", 0),
+		Addr:              ":" + listener.Port,
+		Handler:           mux,
+		ErrorLog:          log.New(tlsErrWriter{port: listener.Port}, "", 0),
+		ReadHeaderTimeout: seconds(t.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       seconds(t.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      seconds(t.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       seconds(t.IdleTimeout, defaultIdleTimeout),
 	}
 
 	if listener.Tls {
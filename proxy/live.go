@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Live connections. Every open TCP/UDP flow and every in-flight HTTP request
+// is registered here while it runs, so admins can see what is connected right
+// now and cut it off. Killing a flow closes its sockets; killing an HTTP
+// request cancels its context, which aborts the backend round trip and closes
+// upgraded (WebSocket) connections.
+
+// LiveConn is a snapshot of one open flow or in-flight request. Bytes are
+// counted from the client's side: in = client → backend, out = backend →
+// client; HTTP requests count response bytes only.
+type LiveConn struct {
+	ID       string    `json:"id"` // request ID
+	Protocol string    `json:"protocol"`
+	Route    string    `json:"route_url"`
+	IP       string    `json:"ip"`
+	Username string    `json:"username"`
+	Started  time.Time `json:"started_at"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	Method   string    `json:"method,omitempty"`
+	Path     string    `json:"path,omitempty"`
+}
+
+type liveConn interface {
+	snapshot() LiveConn
+	kill()
+}
+
+// liveConns holds the registered connections as keys; values are unused.
+var liveConns sync.Map
+
+// LiveConns lists the open connections, oldest first.
+func LiveConns() []LiveConn {
+	out := []LiveConn{}
+	liveConns.Range(func(k, _ any) bool {
+		out = append(out, k.(liveConn).snapshot())
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+// KillConns terminates the open connections matching every non-empty filter —
+// a request ID, a client IP, a username — and returns how many it killed. The
+// request carried by ctx (the admin's own call) is never killed. With no
+// filter at all nothing is killed.
+func KillConns(ctx context.Context, id, ip, username string) int {
+	if id == "" && ip == "" && username == "" {
+		return 0
+	}
+	self := RequestID(ctx)
+	n := 0
+	liveConns.Range(func(k, _ any) bool {
+		c := k.(liveConn)
+		s := c.snapshot()
+		if (id == "" || s.ID == id) && (ip == "" || s.IP == ip) && (username == "" || s.Username == username) &&
+			!(self != "" && s.ID == self) {
+			c.kill()
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// track registers the flow as live; kill is how to tear it down. finish
+// unregisters it.
+func (f *flow) track(kill func()) {
+	f.killFn = kill
+	liveConns.Store(f, struct{}{})
+}
+
+func (f *flow) snapshot() LiveConn {
+	return LiveConn{
+		ID: f.requestID, Protocol: f.protocol, Route: f.route, IP: f.ip, Username: f.username,
+		Started: f.start, BytesIn: f.bytesIn.Load(), BytesOut: f.bytesOut.Load(),
+	}
+}
+
+func (f *flow) kill() {
+	f.setReason(FlowKilled)
+	f.killFn()
+}
+
+// liveRequest is an in-flight HTTP request.
+type liveRequest struct {
+	id, ip       string
+	method, path string
+	start        time.Time
+	rec          *accessRecord
+	aw           *accessWriter
+	cancel       context.CancelFunc
+}
+
+func (lr *liveRequest) snapshot() LiveConn {
+	route, username := lr.rec.routeAndUser()
+	return LiveConn{
+		ID: lr.id, Protocol: "http", Route: route, IP: lr.ip, Username: username,
+		Started: lr.start, BytesOut: lr.aw.bytes.Load(), Method: lr.method, Path: lr.path,
+	}
+}
+
+func (lr *liveRequest) kill() { lr.cancel() }
+
+// knownRoute reports whether c belongs to a configured route: every flow does;
+// an HTTP request once it has reached route access control.
+func knownRoute(c liveConn) bool {
+	lr, ok := c.(*liveRequest)
+	if !ok {
+		return true
+	}
+	lr.rec.mu.Lock()
+	defer lr.rec.mu.Unlock()
+	return lr.rec.authMethod != ""
+}
+
+// countingWriter counts the bytes written through it, so a relay's traffic is
+// visible while it is still running.
+type countingWriter struct {
+	io.Writer
+	n *atomic.Int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.Writer.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"testing"
+	"time"
+)
+
+// waitLive polls until the live list holds n connections.
+func waitLive(t *testing.T, n int) []LiveConn {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if conns := LiveConns(); len(conns) == n {
+			return conns
+		}
+	}
+	t.Fatalf("want %d live connections, have %v", n, LiveConns())
+	return nil
+}
+
+func TestKillTCPFlow(t *testing.T) {
+	setRouteCache(t, storage.Route{Url: "db:5432", Type: "tcp"})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c) // echo until the proxy hangs up
+	}()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleTCPConn(context.Background(), &pipeConn{server}, newTCPTarget(ProxyRoute{Url: "db:5432", Target: ln.Addr().String()}), "db:5432")
+	}()
+	client.Write([]byte("ping"))
+	io.ReadFull(client, make([]byte, 4))
+
+	conns := waitLive(t, 1)
+	if c := conns[0]; c.Protocol != "tcp" || c.IP != "192.0.2.7" || c.Route != "db:5432" || c.BytesIn != 4 {
+		t.Errorf("live flow %+v", c)
+	}
+	if n := KillConns(context.Background(), "", "198.51.100.1", ""); n != 0 {
+		t.Errorf("killed %d connections of another IP", n)
+	}
+	if n := KillConns(context.Background(), "", "192.0.2.7", ""); n != 1 {
+		t.Fatalf("killed %d, want 1", n)
+	}
+	go io.Copy(io.Discard, client)
+	<-done
+	if f := nextFlow(t); f.CloseReason != FlowKilled {
+		t.Errorf("close reason %q, want %q", f.CloseReason, FlowKilled)
+	}
+	waitLive(t, 0)
+}
+
+func TestKillHTTPRequest(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	canceled := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noteAccess(r.Context(), "alice", AuthCookie)
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-release:
+		}
+	})
+	ls := &listenServer{Port: "80"}
+	ls.handlers.Store(map[string]http.Handler{"app": h})
+	p := &Proxy{servers: map[string]*listenServer{"80": ls}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.route(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://app/slow?x=1", nil))
+	}()
+	conns := waitLive(t, 1)
+	if c := conns[0]; c.Protocol != "http" || c.Route != "app:80" || c.Username != "alice" || c.Method != "GET" || c.Path != "/slow?x=1" {
+		t.Errorf("live request %+v", c)
+	}
+
+	// The admin's own request is never killed.
+	self := withRequestID(context.Background(), conns[0].ID)
+	if n := KillConns(self, conns[0].ID, "", ""); n != 0 {
+		t.Errorf("killed the caller's own request")
+	}
+	if n := KillConns(context.Background(), "", "", "alice"); n != 1 {
+		t.Fatalf("killed %d, want 1", n)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request context not canceled")
+	}
+	<-done
+	waitLive(t, 0)
+	drainAccessLog()
+}
@@ -0,0 +1,428 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Application logging. Every slog call in the binary goes through one handler
+// (LogHandler) whose outputs and levels ConfigureLogging can swap at any time:
+// main installs it with defaults before the config is read and reconfigures it
+// from [log] afterwards, and the admin API changes levels at runtime.
+//
+// Per-subsystem levels are keyed on the source file that logged the record
+// (see logSubsystemFiles), so call sites need no logger plumbing.
+
+const (
+	defaultLogMaxSizeMB  = 100
+	defaultLogMaxBackups = 5
+	journaldSocket       = "/run/systemd/journal/socket"
+)
+
+// LogSubsystems lists the subsystems that can have their own level.
+var LogSubsystems = []string{"auth", "limiter", "tcp", "udp", "storage"}
+
+// logSubsystemFiles maps a source file ("dir/file.go") to its subsystem.
+// Files of the storage package all belong to "storage".
+var logSubsystemFiles = map[string]string{
+	"proxy/auth.go":    "auth",
+	"api/auth.go":      "auth",
+	"proxy/limiter.go": "limiter",
+	"proxy/tcp.go":     "tcp",
+	"proxy/udp.go":     "udp",
+}
+
+// LogConfig configures application logging.
+type LogConfig struct {
+	Level      string            // "debug", "info", "warn" or "error"; default "info"
+	Format     string            // "json" or "text"; default "json"
+	Outputs    []string          // "stdout", "file", "syslog", "journald"; default stdout
+	File       string            // path, for the "file" output
+	MaxSizeMB  int               // rotate the file past this size (default 100)
+	MaxBackups int               // rotated files kept (default 5)
+	Rotate     string            // also rotate "hourly" or "daily" (UTC); "" = by size only
+	Syslog     string            // e.g. "udp://logs:514"; "" = the local daemon
+	Subsystems map[string]string // subsystem -> level, overriding Level
+
+	// ReplaceAttr is passed to the JSON or text handler, e.g. to expand errors.
+	ReplaceAttr func(groups []string, a slog.Attr) slog.Attr
+}
+
+// LogLevels is the current base level and per-subsystem overrides.
+type LogLevels struct {
+	Level      string            `json:"level"`
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+// logState is what the handler reads on every record. It is replaced, never
+// modified, under logMu.
+type logState struct {
+	base    slog.Level
+	subs    map[string]slog.Level
+	min     slog.Level // lowest of base and subs: the Enabled threshold
+	sinks   []slog.Handler
+	closers []io.Closer
+}
+
+var (
+	logMu      sync.Mutex
+	logCurrent atomic.Pointer[logState]
+	// logFileSubsystem caches the subsystem of each logging call site.
+	logFileSubsystem sync.Map // uintptr -> string
+)
+
+func init() {
+	logCurrent.Store(&logState{sinks: []slog.Handler{slog.NewJSONHandler(os.Stdout, nil)}})
+}
+
+// LogHandler returns the handler to install as the default logger's. Records
+// logged with a request's context carry its request_id.
+func LogHandler() slog.Handler {
+	return NewLogHandler(&switchHandler{})
+}
+
+// ConfigureLogging replaces the outputs and levels of LogHandler. On error the
+// previous configuration stays in place.
+func ConfigureLogging(cfg LogConfig) error {
+	if ps := CheckLogging(cfg); len(ps) > 0 {
+		return ps[0]
+	}
+	// CheckLogging has vetted these settings, so they parse.
+	base, subs, _ := parseLogLevels(cfg.Level, cfg.Subsystems)
+	format, _ := logFormat(cfg.Format, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: cfg.ReplaceAttr})
+	every, _ := logRotation(cfg.Rotate)
+
+	outputs := cfg.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{"stdout"}
+	}
+	st := &logState{}
+	fail := func(err error) error {
+		for _, c := range st.closers {
+			c.Close()
+		}
+		return err
+	}
+	for _, out := range outputs {
+		switch out {
+		case "stdout":
+			st.sinks = append(st.sinks, format(os.Stdout))
+		case "file":
+			size, backups := cfg.MaxSizeMB, cfg.MaxBackups
+			if size <= 0 {
+				size = defaultLogMaxSizeMB
+			}
+			if backups <= 0 {
+				backups = defaultLogMaxBackups
+			}
+			f, err := openRotatingFile(cfg.File, int64(size)<<20, backups, every)
+			if err != nil {
+				return fail(xerrors.Newf("log file: %w", err))
+			}
+			st.sinks = append(st.sinks, format(f))
+			st.closers = append(st.closers, f)
+		case "syslog":
+			network, addr := "", cfg.Syslog
+			if n, a, ok := strings.Cut(cfg.Syslog, "://"); ok {
+				network, addr = n, a
+			}
+			send, c, err := dialSyslog(network, addr)
+			if err != nil {
+				return fail(xerrors.Newf("log syslog: %w", err))
+			}
+			st.sinks = append(st.sinks, newPriorityHandler(format, send))
+			st.closers = append(st.closers, c)
+		case "journald":
+			conn, err := net.Dial("unixgram", journaldSocket)
+			if err != nil {
+				return fail(xerrors.Newf("log journald: %w", err))
+			}
+			st.sinks = append(st.sinks, newPriorityHandler(format, func(l slog.Level, msg string) error {
+				_, err := conn.Write(journaldEntry(l, msg))
+				return err
+			}))
+			st.closers = append(st.closers, conn)
+		}
+	}
+
+	logMu.Lock()
+	st.base, st.subs, st.min = base, subs, minLevel(base, subs)
+	old := logCurrent.Swap(st)
+	logMu.Unlock()
+	for _, c := range old.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// CheckLogging reports every problem with cfg that ConfigureLogging would
+// refuse, without opening any output. Field is the [log] key at fault.
+func CheckLogging(cfg LogConfig) []Problem {
+	var ps []Problem
+	add := func(field string, err error) {
+		if err != nil {
+			ps = append(ps, Problem{field, err})
+		}
+	}
+	_, _, err := parseLogLevels(cfg.Level, nil)
+	add("level", err)
+	for _, sub := range slices.Sorted(maps.Keys(cfg.Subsystems)) {
+		_, _, err := parseLogLevels("", map[string]string{sub: cfg.Subsystems[sub]})
+		add("subsystems."+sub, err)
+	}
+	_, err = logFormat(cfg.Format, nil)
+	add("format", err)
+	_, err = logRotation(cfg.Rotate)
+	add("rotate", err)
+	for _, out := range cfg.Outputs {
+		switch out {
+		case "stdout", "syslog", "journald":
+		case "file":
+			if cfg.File == "" {
+				add("file", xerrors.Newf("log output \"file\" needs file"))
+			}
+		default:
+			add("outputs", xerrors.Newf("log output %q: want stdout, file, syslog or journald", out))
+		}
+	}
+	return ps
+}
+
+// logFormat returns the handler constructor for a [log] format.
+func logFormat(format string, opts *slog.HandlerOptions) (func(io.Writer) slog.Handler, error) {
+	switch format {
+	case "", "json":
+		return func(w io.Writer) slog.Handler { return slog.NewJSONHandler(w, opts) }, nil
+	case "text":
+		return func(w io.Writer) slog.Handler { return slog.NewTextHandler(w, opts) }, nil
+	}
+	return nil, xerrors.Newf("log format %q: want \"json\" or \"text\"", format)
+}
+
+// logRotation returns the period of a [log] rotate setting; 0 = by size only.
+func logRotation(rotate string) (time.Duration, error) {
+	switch rotate {
+	case "":
+		return 0, nil
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	}
+	return 0, xerrors.Newf("log rotate %q: want \"hourly\" or \"daily\"", rotate)
+}
+
+// GetLogLevels returns the levels in effect.
+func GetLogLevels() LogLevels {
+	st := logCurrent.Load()
+	l := LogLevels{Level: levelName(st.base), Subsystems: map[string]string{}}
+	for sub, lvl := range st.subs {
+		l.Subsystems[sub] = levelName(lvl)
+	}
+	return l
+}
+
+// SetLogLevels changes levels without touching the outputs. An empty level
+// keeps the base level; subsystems are merged into the current overrides, an
+// empty value removing one. Changes last until the next restart.
+func SetLogLevels(level string, subsystems map[string]string) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	cur := logCurrent.Load()
+	if level == "" {
+		level = levelName(cur.base)
+	}
+	merged := map[string]string{}
+	for sub, lvl := range cur.subs {
+		merged[sub] = levelName(lvl)
+	}
+	for sub, lvl := range subsystems {
+		if lvl == "" {
+			delete(merged, sub)
+			continue
+		}
+		merged[sub] = lvl
+	}
+	base, subs, err := parseLogLevels(level, merged)
+	if err != nil {
+		return err
+	}
+	st := *cur
+	st.base, st.subs, st.min = base, subs, minLevel(base, subs)
+	logCurrent.Store(&st)
+	slog.Info("log levels changed", "level", levelName(base), "subsystems", merged)
+	return nil
+}
+
+func parseLogLevels(level string, subsystems map[string]string) (slog.Level, map[string]slog.Level, error) {
+	var base slog.Level
+	if level != "" {
+		if err := base.UnmarshalText([]byte(level)); err != nil {
+			return 0, nil, xerrors.Newf("log level %q: want debug, info, warn or error", level)
+		}
+	}
+	subs := make(map[string]slog.Level, len(subsystems))
+	for sub, l := range subsystems {
+		if !slices.Contains(LogSubsystems, sub) {
+			return 0, nil, xerrors.Newf("log subsystem %q: want one of %s", sub, strings.Join(LogSubsystems, ", "))
+		}
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(l)); err != nil {
+			return 0, nil, xerrors.Newf("log level %q for %s: want debug, info, warn or error", l, sub)
+		}
+		subs[sub] = lvl
+	}
+	return base, subs, nil
+}
+
+func minLevel(base slog.Level, subs map[string]slog.Level) slog.Level {
+	m := base
+	for _, l := range subs {
+		m = min(m, l)
+	}
+	return m
+}
+
+func levelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// logSubsystem returns the subsystem of the call site pc, or "" if it has
+// none.
+func logSubsystem(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	if sub, ok := logFileSubsystem.Load(pc); ok {
+		return sub.(string)
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	dir, file := path.Split(frame.File)
+	dir = path.Base(dir)
+	sub := logSubsystemFiles[dir+"/"+file]
+	if dir == "storage" {
+		sub = "storage"
+	}
+	logFileSubsystem.Store(pc, sub)
+	return sub
+}
+
+// switchHandler is the handler behind LogHandler. It applies the current
+// levels and fans records out to the current sinks. Attributes and groups
+// added with WithAttrs/WithGroup are kept as steps and replayed on the sinks,
+// so derived loggers follow reconfiguration too.
+type switchHandler struct {
+	steps []func(slog.Handler) slog.Handler
+}
+
+func (h *switchHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logCurrent.Load().min
+}
+
+func (h *switchHandler) Handle(ctx context.Context, r slog.Record) error {
+	st := logCurrent.Load()
+	threshold := st.base
+	if len(st.subs) > 0 {
+		if l, ok := st.subs[logSubsystem(r.PC)]; ok {
+			threshold = l
+		}
+	}
+	if r.Level < threshold {
+		return nil
+	}
+	var errs []error
+	for _, sink := range st.sinks {
+		for _, step := range h.steps {
+			sink = step(sink)
+		}
+		if err := sink.Handle(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (h *switchHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &switchHandler{steps: append(slices.Clip(h.steps), func(s slog.Handler) slog.Handler { return s.WithAttrs(attrs) })}
+}
+
+func (h *switchHandler) WithGroup(name string) slog.Handler {
+	return &switchHandler{steps: append(slices.Clip(h.steps), func(s slog.Handler) slog.Handler { return s.WithGroup(name) })}
+}
+
+// priorityHandler formats a record into a buffer and hands the line, without
+// its newline, to send together with the level: syslog and journald keep the
+// severity out of band.
+type priorityHandler struct {
+	mu    *sync.Mutex
+	buf   *bytes.Buffer
+	inner slog.Handler
+	send  func(slog.Level, string) error
+}
+
+func newPriorityHandler(format func(io.Writer) slog.Handler, send func(slog.Level, string) error) *priorityHandler {
+	buf := &bytes.Buffer{}
+	return &priorityHandler{mu: &sync.Mutex{}, buf: buf, inner: format(buf), send: send}
+}
+
+func (h *priorityHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *priorityHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.send(r.Level, strings.TrimSuffix(h.buf.String(), "\n"))
+}
+
+func (h *priorityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &priorityHandler{mu: h.mu, buf: h.buf, inner: h.inner.WithAttrs(attrs), send: h.send}
+}
+
+func (h *priorityHandler) WithGroup(name string) slog.Handler {
+	return &priorityHandler{mu: h.mu, buf: h.buf, inner: h.inner.WithGroup(name), send: h.send}
+}
+
+// journaldEntry encodes one record in the journal's native protocol. MESSAGE
+// uses the length-prefixed form when it contains a newline.
+func journaldEntry(level slog.Level, msg string) []byte {
+	priority := "7"
+	switch {
+	case level >= slog.LevelError:
+		priority = "3"
+	case level >= slog.LevelWarn:
+		priority = "4"
+	case level >= slog.LevelInfo:
+		priority = "6"
+	}
+	var b bytes.Buffer
+	b.WriteString("PRIORITY=" + priority + "\nSYSLOG_IDENTIFIER=remazarin\n")
+	if strings.Contains(msg, "\n") {
+		b.WriteString("MESSAGE\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(msg)))
+		b.WriteString(msg + "\n")
+	} else {
+		b.WriteString("MESSAGE=" + msg + "\n")
+	}
+	return b.Bytes()
+}
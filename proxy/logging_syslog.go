@@ -0,0 +1,30 @@
+//go:build !windows
+
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"log/syslog"
+)
+
+// dialSyslog connects to a syslog daemon (the local one when network is
+// empty) and returns a sender that maps slog levels to syslog severities.
+func dialSyslog(network, addr string) (func(slog.Level, string) error, io.Closer, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "remazarin")
+	if err != nil {
+		return nil, nil, err
+	}
+	send := func(l slog.Level, msg string) error {
+		switch {
+		case l >= slog.LevelError:
+			return w.Err(msg)
+		case l >= slog.LevelWarn:
+			return w.Warning(msg)
+		case l >= slog.LevelInfo:
+			return w.Info(msg)
+		}
+		return w.Debug(msg)
+	}
+	return send, w, nil
+}
@@ -0,0 +1,14 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// dialSyslog refuses the syslog output: Go's log/syslog is not built on
+// windows.
+func dialSyslog(network, addr string) (func(slog.Level, string) error, io.Closer, error) {
+	return nil, nil, xerrors.New("syslog output is not supported on windows")
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogSubsystemLevels(t *testing.T) {
+	// Treat this file as the auth subsystem.
+	logSubsystemFiles["proxy/logging_test.go"] = "auth"
+	defer delete(logSubsystemFiles, "proxy/logging_test.go")
+	defer ConfigureLogging(LogConfig{})
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := ConfigureLogging(LogConfig{
+		Level: "warn", Format: "text", Outputs: []string{"file"}, File: path,
+		Subsystems: map[string]string{"tcp": "debug"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(LogHandler()).With("component", "test")
+	log.Info("dropped: info below warn")
+	if err := SetLogLevels("", map[string]string{"auth": "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("kept: auth at debug")
+	if err := SetLogLevels("", map[string]string{"auth": ""}); err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("dropped: override removed")
+	log.Warn("kept: warn")
+
+	got := GetLogLevels()
+	if got.Level != "warn" || len(got.Subsystems) != 1 || got.Subsystems["tcp"] != "debug" {
+		t.Errorf("levels = %+v", got)
+	}
+	if err := SetLogLevels("", map[string]string{"dns": "debug"}); err == nil {
+		t.Error("unknown subsystem accepted")
+	}
+	if err := SetLogLevels("loud", nil); err == nil {
+		t.Error("unknown level accepted")
+	}
+
+	ConfigureLogging(LogConfig{}) // closes the file
+	b, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var msgs []string
+	for _, l := range lines {
+		if strings.Contains(l, "component=test") {
+			msgs = append(msgs, l)
+		}
+	}
+	if len(msgs) != 2 || !strings.Contains(msgs[0], `msg="kept: auth at debug"`) || !strings.Contains(msgs[1], `msg="kept: warn"`) {
+		t.Errorf("got %q", lines)
+	}
+}
+
+func TestConfigureLoggingErrors(t *testing.T) {
+	defer ConfigureLogging(LogConfig{})
+	for _, cfg := range []LogConfig{
+		{Level: "verbose"},
+		{Format: "xml"},
+		{Outputs: []string{"file"}},
+		{Outputs: []string{"kafka"}},
+		{Outputs: []string{"file"}, File: filepath.Join(t.TempDir(), "a.log"), Rotate: "weekly"},
+		{Subsystems: map[string]string{"auth": "chatty"}},
+	} {
+		if err := ConfigureLogging(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestJournaldEntry(t *testing.T) {
+	if got := string(journaldEntry(slog.LevelWarn, "disk full")); got != "PRIORITY=4\nSYSLOG_IDENTIFIER=remazarin\nMESSAGE=disk full\n" {
+		t.Errorf("got %q", got)
+	}
+	got := journaldEntry(slog.LevelDebug, "a\nb")
+	prefix := "PRIORITY=7\nSYSLOG_IDENTIFIER=remazarin\nMESSAGE\n"
+	if !bytes.HasPrefix(got, []byte(prefix)) {
+		t.Fatalf("got %q", got)
+	}
+	rest := got[len(prefix):]
+	if n := binary.LittleEndian.Uint64(rest); n != 3 || string(rest[8:]) != "a\nb\n" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"reMazarin/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Maintenance mode takes a route offline without deleting or disabling it.
+// While it is active, HTTP requests get a 503 maintenance page and new TCP/UDP
+// flows are refused; clients on the bypass list (IPs, or users in the bypass
+// groups) still reach the backend. The state lives on the route row like the
+// access settings, so toggling it takes effect on the next cache refresh
+// rather than on a route rebuild.
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Down for maintenance</title></head>
+<body style="font-family:sans-serif;text-align:center;margin-top:15vh;color:#333">
+<h1>Down for maintenance</h1>
+<p>This service is being worked on and will be back shortly.</p>
+</body></html>
+`
+
+// maintenanceState is a route's parsed maintenance settings.
+type maintenanceState struct {
+	start, end   time.Time // zero = open-ended
+	retryAfter   int       // seconds; 0 = until end, if scheduled
+	page         http.Handler
+	bypassAddrs  []net.IP
+	bypassNets   []*net.IPNet
+	bypassGroups []int
+}
+
+// ValidateMaintenance checks a route's maintenance window, page and retry
+// hint before they are stored.
+func ValidateMaintenance(m storage.RouteMaintenance) error {
+	start, end, err := maintenanceWindow(m)
+	if err != nil {
+		return err
+	}
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return xerrors.Newf("maintenance window ends before it starts")
+	}
+	if m.RetryAfter < 0 {
+		return xerrors.Newf("retry_after must not be negative")
+	}
+	if page := strings.TrimSpace(m.Page); page != "" && !strings.HasPrefix(page, "<") {
+		if _, err := os.Stat(page); err != nil {
+			return xerrors.Newf("maintenance page: %w", err)
+		}
+	}
+	return nil
+}
+
+func maintenanceWindow(m storage.RouteMaintenance) (start, end time.Time, err error) {
+	if m.Start != "" {
+		if start, err = time.Parse(time.RFC3339, m.Start); err != nil {
+			return start, end, xerrors.Newf("maintenance start: want RFC 3339 time: %w", err)
+		}
+	}
+	if m.End != "" {
+		if end, err = time.Parse(time.RFC3339, m.End); err != nil {
+			return start, end, xerrors.Newf("maintenance end: want RFC 3339 time: %w", err)
+		}
+	}
+	return start, end, nil
+}
+
+// newMaintenanceState parses a route's maintenance settings; nil when
+// maintenance is off. A bad window or page is logged and the defaults used, so
+// a route is never left serving its backend because of a typo.
+func newMaintenanceState(r storage.Route) *maintenanceState {
+	m := r.Maintenance
+	if !m.Enabled {
+		return nil
+	}
+	ms := &maintenanceState{retryAfter: m.RetryAfter}
+	var err error
+	if ms.start, ms.end, err = maintenanceWindow(m); err != nil {
+		slog.Warn("maintenance window ignored", "route", r.Url, "error", err)
+	}
+	ms.bypassAddrs, ms.bypassNets = parseIPList(m.BypassIPs)
+	for _, g := range strings.Split(m.BypassGroups, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(g)); err == nil {
+			ms.bypassGroups = append(ms.bypassGroups, id)
+		}
+	}
+	if !isRaw(r.Type) {
+		if ms.page, err = maintenancePage(m.Page); err != nil {
+			slog.Warn("maintenance page unavailable, using the default", "route", r.Url, "error", err)
+			ms.page, _ = maintenancePage("")
+		}
+	}
+	return ms
+}
+
+// active reports whether the maintenance window covers now.
+func (ms *maintenanceState) active(now time.Time) bool {
+	return ms != nil && (ms.start.IsZero() || !now.Before(ms.start)) && (ms.end.IsZero() || now.Before(ms.end))
+}
+
+// bypassIP reports whether a client may skip maintenance by its IP alone: it
+// is on the bypass list, or holds an IP session of a bypass-group user.
+func (ms *maintenanceState) bypassIP(ctx context.Context, clientIP string) bool {
+	if ipInList(ms.bypassAddrs, ms.bypassNets, clientIP) {
+		return true
+	}
+	if len(ms.bypassGroups) == 0 || authStore == nil {
+		return false
+	}
+	_, err := authStore.ValidateSessionByIPInGroups(ctx, clientIP, ms.bypassGroups)
+	return err == nil
+}
+
+// bypass extends bypassIP with the browser session cookie for HTTP routes.
+func (ms *maintenanceState) bypass(r *http.Request, clientIP string) bool {
+	if ms.bypassIP(r.Context(), clientIP) {
+		return true
+	}
+	if len(ms.bypassGroups) == 0 || authStore == nil {
+		return false
+	}
+	c, err := r.Cookie("session")
+	if err != nil {
+		return false
+	}
+	sg, err := authStore.ValidateSessionAndGroups(r.Context(), c.Value)
+	if err != nil {
+		return false
+	}
+	for _, want := range ms.bypassGroups {
+		for _, id := range sg.GroupIDs {
+			if id == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryAfterAt is the Retry-After hint at now: the configured value, else the
+// time left in a scheduled window, else none.
+func (ms *maintenanceState) retryAfterAt(now time.Time) int {
+	if ms.retryAfter > 0 {
+		return ms.retryAfter
+	}
+	if !ms.end.IsZero() {
+		return int(ms.end.Sub(now)/time.Second) + 1
+	}
+	return 0
+}
+
+// routeMaintenance returns the live maintenance state of a route, or nil.
+func routeMaintenance(routeUrl string) *maintenanceState {
+	m, _ := authCache.Load().(map[string]cachedRoute)
+	return m[routeUrl].maintenance
+}
+
+// inMaintenance reports whether a new raw (TCP/UDP) flow from clientIP must be
+// refused, recording the event if so. ctx carries the flow's request ID.
+func inMaintenance(ctx context.Context, routeUrl, clientIP string) bool {
+	ms := routeMaintenance(routeUrl)
+	if !ms.active(time.Now()) || ms.bypassIP(ctx, clientIP) {
+		return false
+	}
+	RecordEventContext(ctx, clientIP, routeUrl, OutcomeMaintenance)
+	return true
+}
+
+// withMaintenance serves the route's maintenance page while maintenance is
+// active, except to bypassing clients.
+func withMaintenance(rk string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms := routeMaintenance(rk)
+		now := time.Now()
+		if !ms.active(now) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		clientIP := extractClientIP(r)
+		if ms.bypass(r, clientIP) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		RecordEventContext(r.Context(), clientIP, rk, OutcomeMaintenance)
+		if s := ms.retryAfterAt(now); s > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(s))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		ms.page.ServeHTTP(w, r)
+	})
+}
+
+// maintenancePage builds the page handler: inline HTML when page starts with
+// "<", a file or directory when it is a path, the built-in page when empty.
+// A directory's own files (stylesheets, images) are served as they are; every
+// other path gets its index.html.
+func maintenancePage(page string) (http.Handler, error) {
+	page = strings.TrimSpace(page)
+	if page == "" {
+		page = defaultMaintenancePage
+	}
+	if strings.HasPrefix(page, "<") {
+		return serve503([]byte(page)), nil
+	}
+	fi, err := os.Stat(page)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		body, err := os.ReadFile(page)
+		if err != nil {
+			return nil, err
+		}
+		return serve503(body), nil
+	}
+	root, err := os.OpenRoot(page)
+	if err != nil {
+		return nil, err
+	}
+	index, err := root.ReadFile("index.html")
+	if err != nil {
+		return nil, xerrors.Newf("maintenance page directory: %w", err)
+	}
+	indexPage := serve503(index)
+	files := http.FileServerFS(root.FS())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if fi, err := root.Stat(name); err == nil && name != "" && name != "index.html" && fi.Mode().IsRegular() {
+			files.ServeHTTP(w, r)
+			return
+		}
+		indexPage.ServeHTTP(w, r)
+	}), nil
+}
+
+func serve503(body []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if r.Method != http.MethodHead {
+			w.Write(body)
+		}
+	})
+}
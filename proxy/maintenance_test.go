@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRouteCache installs routes in the auth cache for the duration of a test.
+func setRouteCache(t *testing.T, routes ...storage.Route) {
+	t.Helper()
+	old := authCache.Load()
+	m := make(map[string]cachedRoute, len(routes))
+	for _, r := range routes {
+		m[r.Url] = parseCachedRoute(r)
+	}
+	authCache.Store(m)
+	t.Cleanup(func() {
+		if old != nil {
+			authCache.Store(old)
+		} else {
+			authCache.Store(map[string]cachedRoute{})
+		}
+	})
+}
+
+func TestMaintenanceHTTP(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("backend")) })
+	h := withMaintenance("app:80", backend)
+	get := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://app/some/page", nil)
+		req.RemoteAddr = remote + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	now := time.Now().UTC()
+	setRouteCache(t, storage.Route{Url: "app:80", Type: "proxy", Maintenance: storage.RouteMaintenance{
+		Enabled:   true,
+		End:       now.Add(time.Hour).Format(time.RFC3339),
+		Page:      "<h1>back soon</h1>",
+		BypassIPs: "10.0.0.0/8",
+	}})
+	rec := get("192.0.2.1")
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "<h1>back soon</h1>" {
+		t.Fatalf("got %d %q, want the 503 page", rec.Code, rec.Body.String())
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "3600" && ra != "3601" {
+		t.Errorf("Retry-After = %q, want the time left in the window", ra)
+	}
+	if rec := get("10.1.2.3"); rec.Body.String() != "backend" {
+		t.Errorf("bypass IP got %q, want the backend", rec.Body.String())
+	}
+
+	// A window that has not started yet leaves the route up.
+	setRouteCache(t, storage.Route{Url: "app:80", Type: "proxy", Maintenance: storage.RouteMaintenance{
+		Enabled: true, Start: now.Add(time.Hour).Format(time.RFC3339),
+	}})
+	if rec := get("192.0.2.1"); rec.Body.String() != "backend" {
+		t.Errorf("scheduled maintenance applied early: %q", rec.Body.String())
+	}
+}
+
+func TestMaintenanceRaw(t *testing.T) {
+	setRouteCache(t,
+		storage.Route{Url: "db:5432", Type: "tcp", Maintenance: storage.RouteMaintenance{Enabled: true, BypassIPs: "192.0.2.9"}},
+		storage.Route{Url: "cache:6379", Type: "tcp"},
+	)
+	if !inMaintenance(context.Background(), "db:5432", "192.0.2.1") {
+		t.Error("new connection must be refused during maintenance")
+	}
+	if inMaintenance(context.Background(), "db:5432", "192.0.2.9") {
+		t.Error("bypass IP must be let through")
+	}
+	if inMaintenance(context.Background(), "cache:6379", "192.0.2.1") {
+		t.Error("route without maintenance must not refuse connections")
+	}
+}
+
+func TestMaintenancePageDirectory(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>down</p>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "style.css"), []byte("p{}"), 0o644)
+
+	page, err := maintenancePage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"/": "503 <p>down</p>", "/deep/link": "503 <p>down</p>", "/style.css": "200 p{}"} {
+		rec := httptest.NewRecorder()
+		page.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app"+path, nil))
+		if got := strings.TrimSpace(rec.Result().Status[:3] + " " + rec.Body.String()); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+
+	for _, m := range []storage.RouteMaintenance{
+		{Start: "tomorrow"},
+		{Start: "2030-01-02T00:00:00Z", End: "2030-01-01T00:00:00Z"},
+		{Page: filepath.Join(dir, "missing")},
+	} {
+		if ValidateMaintenance(m) == nil {
+			t.Errorf("%+v: want an error", m)
+		}
+	}
+}
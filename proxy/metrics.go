@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,6 +21,15 @@ const (
 	OutcomeTLSError    = "tls_error"    // TLS handshake failed (junk / plain HTTP to TLS)
 	OutcomeTCPRejected = "tcp_rejected" // raw TCP/UDP connection not authorized
 	OutcomeDialError   = "dial_error"   // backend dial failed
+	OutcomeRetry       = "retry"        // proxied request retried on the next backend
+	OutcomeCircuitOpen = "circuit_open" // request/connection failed fast: every backend's circuit is open
+	OutcomeRedirected  = "redirected"   // sent to the TLS route or canonical host instead
+	OutcomeMaintenance = "maintenance"  // route in maintenance: page served or connection refused
+
+	// Circuit-breaker state transitions, recorded per backend.
+	OutcomeBreakerOpen     = "breaker_open"
+	OutcomeBreakerHalfOpen = "breaker_half_open"
+	OutcomeBreakerClosed   = "breaker_closed"
 )
 
 // recentEventsCap bounds the in-memory recent-events ring shown in the admin UI.
@@ -31,6 +41,10 @@ type Event struct {
 	IP      string    `json:"ip"`
 	Route   string    `json:"route"`
 	Outcome string    `json:"outcome"`
+	// Upstream is the backend an event concerns, for per-backend events.
+	Upstream string `json:"upstream,omitempty"`
+	// RequestID is the ID of the request or flow the event belongs to.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 var (
@@ -47,6 +61,23 @@ var (
 // to the recent-events ring, and (for served outcomes) bumps the per-route
 // served counter. All in-memory; counters reset on process restart.
 func RecordEvent(ip, route, outcome string) {
+	recordEvent(Event{IP: ip, Route: route, Outcome: outcome})
+}
+
+// RecordEventContext is RecordEvent for an event of the request or flow whose
+// ID ctx carries.
+func RecordEventContext(ctx context.Context, ip, route, outcome string) {
+	recordEvent(Event{IP: ip, Route: route, Outcome: outcome, RequestID: RequestID(ctx)})
+}
+
+// RecordUpstreamEvent records an event about one backend of a route rather than
+// about a client, such as a circuit-breaker transition.
+func RecordUpstreamEvent(route, upstream, outcome string) {
+	recordEvent(Event{Route: route, Outcome: outcome, Upstream: upstream})
+}
+
+func recordEvent(e Event) {
+	route, outcome := e.Route, e.Outcome
 	ev, _ := eventCounters.LoadOrStore(outcome, new(int64))
 	atomic.AddInt64(ev.(*int64), 1)
 
@@ -56,7 +87,8 @@ func RecordEvent(ip, route, outcome string) {
 	}
 
 	eventsMu.Lock()
-	eventRing[eventHead] = Event{Time: time.Now(), IP: ip, Route: route, Outcome: outcome}
+	e.Time = time.Now()
+	eventRing[eventHead] = e
 	eventHead = (eventHead + 1) % recentEventsCap
 	if eventCount < recentEventsCap {
 		eventCount++
@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// Prometheus scrape endpoint. A manual reader on the OTel meter provider is
+// collected on every scrape and rendered in the Prometheus text format, so the
+// endpoint serves everything the OTLP exporter sends — otelhttp, Go runtime and
+// the proxy's own instruments (see instruments.go) — without a collector.
+// It is served on its own listener, or under a path of the [admin] host.
+
+const defaultPrometheusPath = "/metrics"
+
+// PrometheusConfig configures the scrape endpoint.
+type PrometheusConfig struct {
+	Enabled     bool
+	Listen      string   // own listener, e.g. ":9464"; "" = serve on the admin host
+	Path        string   // default "/metrics"
+	AdminURL    string   // the admin route (host:port) when Listen is ""
+	AllowedIPs  []string // IPs and CIDRs that may scrape; empty = anyone
+	BearerToken string   // required as "Authorization: Bearer <token>" if set
+}
+
+type prometheusExporter struct {
+	cfg    PrometheusConfig
+	reader *sdkmetric.ManualReader
+	addrs  []net.IP
+	nets   []*net.IPNet
+}
+
+var promExporter atomic.Pointer[prometheusExporter]
+
+// ConfigurePrometheus sets up the scrape endpoint and returns the reader main
+// must register with the meter provider, or nil when the endpoint is disabled.
+func ConfigurePrometheus(cfg PrometheusConfig) (sdkmetric.Reader, error) {
+	if !cfg.Enabled {
+		promExporter.Store(nil)
+		return nil, nil
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultPrometheusPath
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, xerrors.Newf("prometheus path %q must start with /", cfg.Path)
+	}
+	if cfg.Listen == "" {
+		if cfg.AdminURL == "" {
+			return nil, xerrors.Newf("prometheus: set listen or enable [admin] to serve the endpoint on")
+		}
+		if len(cfg.AllowedIPs) == 0 && cfg.BearerToken == "" {
+			return nil, xerrors.Newf("prometheus on the admin host needs allowed_ips or bearer_token")
+		}
+	}
+	for _, entry := range cfg.AllowedIPs {
+		entry = strings.TrimSpace(entry)
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return nil, xerrors.Newf("prometheus allowed_ips: %q is not an IP or CIDR", entry)
+		}
+	}
+	e := &prometheusExporter{cfg: cfg, reader: sdkmetric.NewManualReader()}
+	e.addrs, e.nets = parseIPList(strings.Join(cfg.AllowedIPs, ","))
+	promExporter.Store(e)
+	return e.reader, nil
+}
+
+// addPrometheusListener starts the endpoint's own listener, if it has one.
+func (p *Proxy) addPrometheusListener() {
+	e := promExporter.Load()
+	if e == nil || e.cfg.Listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(e.cfg.Path, e)
+	server := &http.Server{
+		Addr:              e.cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+	}
+	p.serversMu.Lock()
+	p.liveHTTP = append(p.liveHTTP, server)
+	p.serversMu.Unlock()
+
+	p.Wg.Add(1)
+	go p.startServe(server, false)
+	slog.Info("prometheus endpoint started", "addr", e.cfg.Listen, "path", e.cfg.Path)
+}
+
+// withPrometheus serves the scrape endpoint on the admin route, ahead of
+// maintenance and route auth (scrapers have no session); the exporter's own
+// allowlist and token apply instead.
+func withPrometheus(routeUrl string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e := promExporter.Load(); e != nil && e.cfg.Listen == "" && e.cfg.AdminURL == routeUrl && r.URL.Path == e.cfg.Path {
+			e.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (e *prometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(e.cfg.AllowedIPs) > 0 && !ipInList(e.addrs, e.nets, extractClientIP(r)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if e.cfg.BearerToken != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(e.cfg.BearerToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	var rm metricdata.ResourceMetrics
+	if err := e.reader.Collect(ctx, &rm); err != nil {
+		slog.WarnContext(r.Context(), "prometheus: collect failed", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writePrometheus(bw, &rm)
+	bw.Flush()
+}
+
+// promFamily is one metric family of the text format.
+type promFamily struct {
+	name, help, typ string
+	lines           []string
+}
+
+// writePrometheus renders rm in the Prometheus text exposition format, families
+// sorted by name. Names follow the OTel-to-Prometheus conventions: dots become
+// underscores, the unit is appended (e.g. _seconds, _bytes) and monotonic sums
+// end in _total. Exemplars, exponential histograms and summaries are skipped.
+func writePrometheus(w *bufio.Writer, rm *metricdata.ResourceMetrics) {
+	families := map[string]*promFamily{}
+	family := func(name, help, typ string) *promFamily {
+		f := families[name]
+		if f == nil {
+			f = &promFamily{name: name, help: help, typ: typ}
+			families[name] = f
+		}
+		if f.typ != typ {
+			return nil // same name, different type: keep the first
+		}
+		return f
+	}
+
+	if rm.Resource != nil && rm.Resource.Len() > 0 {
+		f := family("target_info", "Target metadata", "gauge")
+		f.lines = append(f.lines, "target_info"+promLabels(rm.Resource.Iter(), "", "")+" 1")
+	}
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			base := promName(m.Name, m.Unit)
+			switch d := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				promSum(family, base, m.Description, d.IsMonotonic, d.DataPoints)
+			case metricdata.Sum[float64]:
+				promSum(family, base, m.Description, d.IsMonotonic, d.DataPoints)
+			case metricdata.Gauge[int64]:
+				promPoints(family(base, m.Description, "gauge"), base, d.DataPoints)
+			case metricdata.Gauge[float64]:
+				promPoints(family(base, m.Description, "gauge"), base, d.DataPoints)
+			case metricdata.Histogram[int64]:
+				promHistogram(family(base, m.Description, "histogram"), base, d.DataPoints)
+			case metricdata.Histogram[float64]:
+				promHistogram(family(base, m.Description, "histogram"), base, d.DataPoints)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for n := range families {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		f := families[n]
+		if len(f.lines) == 0 {
+			continue
+		}
+		if f.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", f.name, promEscape(f.help, false))
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+		for _, l := range f.lines {
+			w.WriteString(l)
+			w.WriteByte('\n')
+		}
+	}
+}
+
+func promSum[N int64 | float64](family func(name, help, typ string) *promFamily, base, help string, monotonic bool, points []metricdata.DataPoint[N]) {
+	if monotonic {
+		name := strings.TrimSuffix(base, "_total") + "_total"
+		promPoints(family(name, help, "counter"), name, points)
+		return
+	}
+	promPoints(family(base, help, "gauge"), base, points)
+}
+
+func promPoints[N int64 | float64](f *promFamily, name string, points []metricdata.DataPoint[N]) {
+	if f == nil {
+		return
+	}
+	for _, dp := range points {
+		f.lines = append(f.lines, name+promLabels(dp.Attributes.Iter(), "", "")+" "+promValue(float64(dp.Value)))
+	}
+}
+
+func promHistogram[N int64 | float64](f *promFamily, name string, points []metricdata.HistogramDataPoint[N]) {
+	if f == nil {
+		return
+	}
+	for _, dp := range points {
+		var cum uint64
+		for i, bound := range dp.Bounds {
+			cum += dp.BucketCounts[i]
+			f.lines = append(f.lines, name+"_bucket"+promLabels(dp.Attributes.Iter(), "le", promValue(bound))+" "+strconv.FormatUint(cum, 10))
+		}
+		f.lines = append(f.lines,
+			name+"_bucket"+promLabels(dp.Attributes.Iter(), "le", "+Inf")+" "+strconv.FormatUint(dp.Count, 10),
+			name+"_sum"+promLabels(dp.Attributes.Iter(), "", "")+" "+promValue(float64(dp.Sum)),
+			name+"_count"+promLabels(dp.Attributes.Iter(), "", "")+" "+strconv.FormatUint(dp.Count, 10),
+		)
+	}
+}
+
+// promUnits maps OTel units to Prometheus name suffixes.
+var promUnits = map[string]string{
+	"s": "seconds", "ms": "milliseconds", "us": "microseconds", "ns": "nanoseconds",
+	"By": "bytes", "KiBy": "kibibytes", "MiBy": "mebibytes", "%": "percent", "1": "",
+}
+
+// promName converts an OTel instrument name and unit to a Prometheus metric
+// name.
+func promName(name, unit string) string {
+	n := promSanitize(name)
+	suffix, ok := promUnits[unit]
+	if !ok && !strings.HasPrefix(unit, "{") {
+		suffix = promSanitize(unit) // annotations like {request} are dropped
+	}
+	if suffix != "" && !strings.HasSuffix(n, "_"+suffix) {
+		n += "_" + suffix
+	}
+	return n
+}
+
+// promSanitize replaces what Prometheus does not allow in names with '_'.
+func promSanitize(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// promLabels renders a label set, plus the extra label if extraName is set.
+func promLabels(it attribute.Iterator, extraName, extraValue string) string {
+	var parts []string
+	for it.Next() {
+		kv := it.Attribute()
+		parts = append(parts, promSanitize(string(kv.Key))+`="`+promEscape(kv.Value.Emit(), true)+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// promEscape escapes a HELP text or (quoted) label value.
+func promEscape(s string, quoted bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quoted {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
+
+func promValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+func TestPrometheusExposition(t *testing.T) {
+	reader, err := ConfigurePrometheus(PrometheusConfig{Enabled: true, Listen: ":0", AllowedIPs: []string{"192.0.2.0/24"}, BearerToken: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigurePrometheus(PrometheusConfig{})
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(resource.NewSchemaless(attribute.String("service.name", "remazarin"))),
+	)
+	defer mp.Shutdown(context.Background())
+	meter := mp.Meter("test")
+
+	ctx := context.Background()
+	c, _ := meter.Int64Counter("remazarin.events")
+	c.Add(ctx, 3, metric.WithAttributes(attribute.String("outcome", `say "hi"`)))
+	h, _ := meter.Float64Histogram("remazarin.route.duration", metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.1, 1))
+	h.Record(ctx, 0.05, metric.WithAttributes(attribute.String("route", "app:443")))
+	h.Record(ctx, 0.5, metric.WithAttributes(attribute.String("route", "app:443")))
+	g, _ := meter.Int64UpDownCounter("remazarin.flows.open", metric.WithUnit("{flow}"))
+	g.Add(ctx, 2)
+
+	scrape := func(remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remote + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		promExporter.Load().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := scrape("198.51.100.1", "s3cret"); rec.Code != http.StatusForbidden {
+		t.Errorf("outside allowed_ips: %d", rec.Code)
+	}
+	if rec := scrape("192.0.2.1", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad token: %d", rec.Code)
+	}
+	rec := scrape("192.0.2.1", "s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape: %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE remazarin_events_total counter\n",
+		`remazarin_events_total{outcome="say \"hi\""} 3`,
+		"# TYPE remazarin_route_duration_seconds histogram\n",
+		`remazarin_route_duration_seconds_bucket{route="app:443",le="0.1"} 1`,
+		`remazarin_route_duration_seconds_bucket{route="app:443",le="1"} 2`,
+		`remazarin_route_duration_seconds_bucket{route="app:443",le="+Inf"} 2`,
+		`remazarin_route_duration_seconds_sum{route="app:443"} 0.55`,
+		`remazarin_route_duration_seconds_count{route="app:443"} 2`,
+		"# TYPE remazarin_flows_open gauge\nremazarin_flows_open 2\n",
+		`target_info{service_name="remazarin"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestConfigurePrometheusAdminHost(t *testing.T) {
+	defer ConfigurePrometheus(PrometheusConfig{})
+	if _, err := ConfigurePrometheus(PrometheusConfig{Enabled: true, AdminURL: "admin:443"}); err == nil {
+		t.Error("admin host endpoint without allowed_ips or bearer_token accepted")
+	}
+	if _, err := ConfigurePrometheus(PrometheusConfig{Enabled: true}); err == nil {
+		t.Error("endpoint with neither listen nor admin host accepted")
+	}
+	if _, err := ConfigurePrometheus(PrometheusConfig{Enabled: true, AdminURL: "admin:443", BearerToken: "t"}); err != nil {
+		t.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	for url, want := range map[string]int{"admin:443": http.StatusUnauthorized, "app:443": http.StatusTeapot} {
+		rec := httptest.NewRecorder()
+		withPrometheus(url, next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != want {
+			t.Errorf("%s: got %d, want %d", url, rec.Code, want)
+		}
+	}
+}
+
+func TestPromName(t *testing.T) {
+	for _, tc := range []struct{ name, unit, want string }{
+		{"http.server.request.duration", "s", "http_server_request_duration_seconds"},
+		{"go.memory.used", "By", "go_memory_used_bytes"},
+		{"go.goroutine.count", "{goroutine}", "go_goroutine_count"},
+		{"remazarin.breaker.state", "", "remazarin_breaker_state"},
+		{"1st.metric", "1", "_st_metric"},
+	} {
+		if got := promName(tc.name, tc.unit); got != tc.want {
+			t.Errorf("promName(%q, %q) = %q, want %q", tc.name, tc.unit, got, tc.want)
+		}
+	}
+}
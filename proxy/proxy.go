@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"reMazarin/api"
+	"reMazarin/storage"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -22,6 +23,18 @@ type ProxyRoute struct {
 	Cert      string
 	Key       string
 	InjectAPI bool // true only for auth/admin hosts — enables built-in /api/ handlers
+	Transport storage.RouteTransport
+	Retry     storage.RouteRetry
+	Breaker   storage.RouteBreaker
+	Cache     storage.RouteCache
+	Compress  storage.RouteCompress
+	Headers   storage.RouteHeaders
+	Security  storage.RouteSecurity
+
+	RedirectHosts string // comma-separated hosts that redirect to this route's host
+
+	Redirect storage.RouteRedirect // status and match pattern of redirect routes
+	Rewrite  string                // upstream path rewrite rules of proxy routes
 }
 
 type listenServer struct {
@@ -29,19 +42,24 @@ type listenServer struct {
 	Tls      bool
 	CertPath string
 	KeyPath  string
-	mu       sync.Mutex // serialises writes to Routes; hot-path reads use handlers
+	mu       sync.Mutex // serialises writes to Routes and own; hot-path reads use handlers
 	Routes   map[string]*ProxyRoute
-	handlers atomic.Value // stores map[string]http.Handler
+	own      map[string]http.Handler // this listener's route handlers by host
+	handlers atomic.Value            // stores map[string]http.Handler: own plus derived redirects
+	acme     http.Handler            // ACME HTTP-01 challenges; set on the redirect listener only
 }
 
 type Proxy struct {
 	Proxies     []ProxyRoute
+	Listeners   map[string]ListenerTimeouts // per-port overrides; unset ports use defaults
+	Redirect    RedirectConfig
 	servers     map[string]*listenServer
 	tcpCancels  map[string]context.CancelFunc
 	tcpMu       sync.Mutex
 	udpCancels  map[string]context.CancelFunc
 	udpMu       sync.Mutex
 	serversMu   sync.Mutex
+	publishMu   sync.Mutex // serialises publishHandlers
 	liveHTTP    []*http.Server
 	ctx         context.Context
 	Wg          *sync.WaitGroup
@@ -62,6 +80,9 @@ func (p *Proxy) StartProxy(ctx context.Context, otel bool) error {
 	if err := p.parseProxies(); err != nil {
 		return xerrors.Newf("parse proxies: %w", err)
 	}
+	if err := p.addRedirectListener(); err != nil {
+		return xerrors.Newf("redirect listener: %w", err)
+	}
 
 	if err := p.initProxies(otel); err != nil {
 		return xerrors.Newf("init proxies: %w", err)
@@ -79,11 +100,14 @@ func (p *Proxy) StartProxy(ctx context.Context, otel bool) error {
 	p.ErrChan = make(chan error, len(p.servers)+rawCount+16)
 
 	p.startListeners()
+	p.addPrometheusListener()
+	p.addClusterListener()
+	initInstruments()
 
 	for _, route := range p.Proxies {
 		_, port, _ := parseHostPort(route.Url)
 		if isTCP(route.Type) {
-			p.startTCPProxy(port, route.Target, route.Url)
+			p.startTCPProxy(port, route)
 		}
 		if isUDP(route.Type) {
 			p.startUDPProxy(port, route.Target, route.Url)
@@ -117,7 +141,7 @@ func (p *Proxy) ValidateRoute(url, routeType string) error {
 		return xerrors.Newf("invalid url %q: expected host:port", url)
 	}
 	switch routeType {
-	case "proxy", "tcp", "udp", "tcp+udp", "static", "api", "":
+	case "proxy", "tcp", "udp", "tcp+udp", "static", "api", "redirect", "":
 	default:
 		return xerrors.Newf("unknown route type %q", routeType)
 	}
@@ -222,6 +246,7 @@ func (p *Proxy) parseProxies() error {
 			CertPath: route.Cert,
 			KeyPath:  route.Key,
 			Routes:   make(map[string]*ProxyRoute),
+			own:      make(map[string]http.Handler),
 		}
 		ls.Routes[host] = &route
 		p.servers[port] = &ls
@@ -240,17 +265,18 @@ func (p *Proxy) parseProxies() error {
 func (p *Proxy) initProxies(otel bool) error {
 	slog.Info("initializing reverse proxies")
 	for port, server := range p.servers {
-		m := make(map[string]http.Handler, len(server.Routes))
 		for host, route := range server.Routes {
 			raw, err := createHandlerForRoute(route, otel)
 			if err != nil {
 				return xerrors.Newf("create handler for %s: %w", route.Url, err)
 			}
-			m[host] = wrapRouteHandler(route, raw)
+			if server.own[host], err = wrapRouteHandler(route, raw); err != nil {
+				return xerrors.Newf("create handler for %s: %w", route.Url, err)
+			}
 			slog.Debug("handler cached", "host", host, "port", port, "type", route.Type)
 		}
-		server.handlers.Store(m)
 	}
+	p.publishHandlers()
 	slog.Info("all proxies initialized", "count", len(p.Proxies))
 	return nil
 }
@@ -263,14 +289,26 @@ func createHandlerForRoute(route *ProxyRoute, otel bool) (http.Handler, error) {
 		handler, err = createStaticHandler(route)
 	case "api":
 		handler, err = createAPIHandler(route)
+	case "redirect":
+		handler, err = createRedirectHandler(route)
 	case "proxy", "":
-		handler, err = createReverseProxy(route)
+		var rp http.Handler
+		rp, err = createReverseProxy(route)
+		handler = withCache(route, withRequestTimeout(seconds(route.Transport.RequestTimeout, defaultRequestTimeout), rp))
 	default:
 		return nil, xerrors.Newf("unknown handler type: %s", route.Type)
 	}
 	if err != nil {
 		return nil, err
 	}
+	// Compression and response header rules wrap the cache, so that it stores
+	// the backend's own response and they apply afresh to every hit.
+	handler = withCompression(route, handler)
+	respRules, err := parseHeaderRules(route.Headers.Response)
+	if err != nil {
+		return nil, xerrors.Newf("response_headers: %w", err)
+	}
+	handler = withResponseHeaders(route, respRules, handler)
 	if otel {
 		handler = otelhttp.NewHandler(handler, "/")
 	}
@@ -290,7 +328,7 @@ func (p *Proxy) RegisterRoute(route ProxyRoute) error {
 
 	if isRaw(route.Type) {
 		if isTCP(route.Type) {
-			p.startTCPProxy(port, route.Target, route.Url)
+			p.startTCPProxy(port, route)
 		}
 		if isUDP(route.Type) {
 			p.startUDPProxy(port, route.Target, route.Url)
@@ -314,6 +352,7 @@ func (p *Proxy) RegisterRoute(route ProxyRoute) error {
 			CertPath: route.Cert,
 			KeyPath:  route.Key,
 			Routes:   make(map[string]*ProxyRoute),
+			own:      make(map[string]http.Handler),
 		}
 		ls.handlers.Store(make(map[string]http.Handler))
 		p.servers[port] = ls
@@ -328,18 +367,16 @@ func (p *Proxy) RegisterRoute(route ProxyRoute) error {
 		return xerrors.Newf("create handler: %w", err)
 	}
 	r := route
-	finalHandler := wrapRouteHandler(&r, raw)
+	finalHandler, err := wrapRouteHandler(&r, raw)
+	if err != nil {
+		return xerrors.Newf("create handler: %w", err)
+	}
 
 	ls.mu.Lock()
 	ls.Routes[host] = &r
-	old := ls.handlers.Load().(map[string]http.Handler)
-	newMap := make(map[string]http.Handler, len(old)+1)
-	for k, v := range old {
-		newMap[k] = v
-	}
-	newMap[host] = finalHandler
-	ls.handlers.Store(newMap)
+	ls.own[host] = finalHandler
 	ls.mu.Unlock()
+	p.publishHandlers()
 
 	slog.Info("route registered", "url", route.Url)
 	return nil
@@ -351,20 +388,16 @@ func (p *Proxy) UnregisterRoute(url string) {
 	if err != nil {
 		return
 	}
+	dropBreakers(url)
+	PurgeCache(url)
 
 	ls, ok := p.servers[port]
 	if ok {
 		ls.mu.Lock()
 		delete(ls.Routes, host)
-		old := ls.handlers.Load().(map[string]http.Handler)
-		newMap := make(map[string]http.Handler, len(old))
-		for k, v := range old {
-			if k != host {
-				newMap[k] = v
-			}
-		}
-		ls.handlers.Store(newMap)
+		delete(ls.own, host)
 		ls.mu.Unlock()
+		p.publishHandlers()
 		slog.Info("route unregistered", "url", url)
 		return
 	}
@@ -377,13 +410,21 @@ func (p *Proxy) UnregisterRoute(url string) {
 }
 
 // wrapRouteHandler applies auth middleware (and API injection for InjectAPI routes)
-// once at registration time so the router hot path just calls ServeHTTP.
-func wrapRouteHandler(route *ProxyRoute, raw http.Handler) http.Handler {
-	h := withAuthForKey(route.Url, raw)
+// once at registration time so the router hot path just calls ServeHTTP. The
+// security headers go outermost so login redirects and API replies carry them too.
+func wrapRouteHandler(route *ProxyRoute, raw http.Handler) (http.Handler, error) {
+	// Maintenance sits outside auth (bypass groups are checked on their own)
+	// but inside the API injection, so the admin API stays reachable.
+	h := withMaintenance(route.Url, withAuthForKey(route.Url, raw))
 	if route.InjectAPI {
 		h = withAPIInject(h)
 	}
-	return h
+	h = withPrometheus(route.Url, h)
+	sec, err := newSecurityHeaders(route.Security)
+	if err != nil {
+		return nil, err
+	}
+	return withSecurityHeaders(sec, h), nil
 }
 
 // withAPIInject intercepts /api/<name> requests and dispatches them to the
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Redirects derived from the route table rather than configured as routes:
+//
+//   - canonical hosts: a route's redirect_hosts (e.g. www.example.com) answer on
+//     the route's listener with a redirect to the route's own host;
+//   - the redirect listener: an optional plain-HTTP port (80 by default) that
+//     sends every host with a TLS route to that route, and serves ACME HTTP-01
+//     challenges from a webroot so certificates can be issued and renewed.
+//
+// Both are published into the listeners' host → handler maps alongside the
+// real routes (see publishHandlers); a real route for a host always wins.
+
+const (
+	defaultRedirectPort = "80"
+	defaultRedirectCode = http.StatusPermanentRedirect
+	acmeChallengePath   = "/.well-known/acme-challenge/"
+)
+
+// RedirectConfig configures the plain-HTTP redirect listener. Code is also the
+// status of canonical-host redirects, which work without the listener.
+type RedirectConfig struct {
+	Enabled bool
+	Port    string // default "80"
+	Code    int    // 301, 302, 307 or 308; default 308
+	ACMEDir string // webroot holding .well-known/acme-challenge/; "" = none
+}
+
+func (c RedirectConfig) port() string {
+	if c.Port == "" {
+		return defaultRedirectPort
+	}
+	return c.Port
+}
+
+func (c RedirectConfig) code() int {
+	switch c.Code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return c.Code
+	}
+	return defaultRedirectCode
+}
+
+// splitHosts parses a comma-separated host list, lowercased.
+func splitHosts(s string) []string {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// redirectTo answers every request with a redirect to scheme://host[:port]
+// plus the request's path and query. Default ports are left out of the URL.
+func redirectTo(routeKey, scheme, host, port string, code int) http.Handler {
+	base := scheme + "://" + host
+	if !(scheme == "https" && port == "443") && !(scheme == "http" && port == "80") {
+		base += ":" + port
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RecordEventContext(r.Context(), extractClientIP(r), routeKey, OutcomeRedirected)
+		http.Redirect(w, r, base+r.URL.RequestURI(), code)
+	})
+}
+
+// addRedirectListener adds the redirect listener's port to p.servers, sharing
+// it with any plain-HTTP routes already configured there.
+func (p *Proxy) addRedirectListener() error {
+	if !p.Redirect.Enabled {
+		return nil
+	}
+	port := p.Redirect.port()
+	ls, ok := p.servers[port]
+	if ok && ls.Tls {
+		return xerrors.Newf("redirect port %s is a TLS listener", port)
+	}
+	if !ok {
+		ls = &listenServer{
+			Port:   port,
+			Routes: make(map[string]*ProxyRoute),
+			own:    make(map[string]http.Handler),
+		}
+		p.servers[port] = ls
+	}
+	if p.Redirect.ACMEDir != "" {
+		h, err := newACMEHandler(p.Redirect.ACMEDir)
+		if err != nil {
+			return err
+		}
+		ls.acme = h
+	}
+	slog.Info("redirect listener configured", "port", port, "code", p.Redirect.code(), "acme_dir", p.Redirect.ACMEDir)
+	return nil
+}
+
+// publishHandlers rebuilds every listener's host → handler snapshot from its
+// own routes plus the redirects derived from the whole route table.
+func (p *Proxy) publishHandlers() {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+
+	code := p.Redirect.code()
+	redirectPort := ""
+	if p.Redirect.Enabled {
+		redirectPort = p.Redirect.port()
+	}
+
+	// Where each TLS host lives, preferring 443, with the aliases pointing at it.
+	type tlsHome struct{ host, port string }
+	tlsHosts := make(map[string]tlsHome)
+	for port, ls := range p.servers {
+		if !ls.Tls {
+			continue
+		}
+		ls.mu.Lock()
+		for host, route := range ls.Routes {
+			for _, h := range append([]string{host}, splitHosts(route.RedirectHosts)...) {
+				if cur, ok := tlsHosts[h]; !ok || preferPort(port, cur.port) {
+					tlsHosts[h] = tlsHome{host, port}
+				}
+			}
+		}
+		ls.mu.Unlock()
+	}
+
+	for port, ls := range p.servers {
+		m := make(map[string]http.Handler)
+		if port == redirectPort {
+			for h, home := range tlsHosts {
+				m[h] = redirectTo(h+":"+port, "https", home.host, home.port, code)
+			}
+		}
+		scheme := "http"
+		if ls.Tls {
+			scheme = "https"
+		}
+		ls.mu.Lock()
+		for host, route := range ls.Routes {
+			for _, alias := range splitHosts(route.RedirectHosts) {
+				m[alias] = redirectTo(alias+":"+port, scheme, host, port, code)
+			}
+		}
+		for host, h := range ls.own {
+			m[host] = h
+		}
+		ls.mu.Unlock()
+		ls.handlers.Store(m)
+	}
+}
+
+// preferPort reports whether TLS port a is a better redirect target than b:
+// 443 first, then the lowest port.
+func preferPort(a, b string) bool {
+	if a == "443" || b == "443" {
+		return a == "443" && b != "443"
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// newACMEHandler serves HTTP-01 challenge tokens from a webroot, in the layout
+// certbot, lego and acme.sh write in webroot mode:
+// <dir>/.well-known/acme-challenge/<token>.
+func newACMEHandler(dir string) (http.Handler, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, xerrors.Newf("open acme dir %s: %w", dir, err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, acmeChallengePath)
+		if !validACMEToken(token) || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := root.Open(strings.TrimPrefix(acmeChallengePath, "/") + token)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		slog.Info("acme challenge served", "host", r.Host, "token", token)
+		w.Header().Set("Content-Type", "text/plain")
+		io.Copy(w, io.LimitReader(f, 4096))
+	}), nil
+}
+
+// validACMEToken reports whether token is a base64url string, as RFC 8555
+// requires, so it can never name anything outside the challenge directory.
+func validACMEToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range []byte(token) {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
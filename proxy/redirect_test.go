@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testListener(port string, tls bool, routes ...*ProxyRoute) *listenServer {
+	ls := &listenServer{Port: port, Tls: tls, Routes: make(map[string]*ProxyRoute), own: make(map[string]http.Handler)}
+	for _, r := range routes {
+		host, _, _ := parseHostPort(r.Url)
+		ls.Routes[host] = r
+		ls.own[host] = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte(r.Url)) })
+	}
+	return ls
+}
+
+// Aliases redirect to the canonical host on their own listener; the redirect
+// listener sends TLS hosts and their aliases to https, and a real route on the
+// redirect port still wins.
+func TestPublishHandlersRedirects(t *testing.T) {
+	p := &Proxy{
+		Redirect: RedirectConfig{Enabled: true},
+		servers: map[string]*listenServer{
+			"443":  testListener("443", true, &ProxyRoute{Url: "example.com:443", RedirectHosts: "www.example.com, WWW.example.org"}),
+			"8443": testListener("8443", true, &ProxyRoute{Url: "app.example.com:8443"}),
+			"80":   testListener("80", false, &ProxyRoute{Url: "plain.example.com:80"}, &ProxyRoute{Url: "app.example.com:80"}),
+		},
+	}
+	p.publishHandlers()
+
+	for _, tc := range []struct{ url, code, location, body string }{
+		{"https://www.example.com/a?b=1", "308", "https://example.com/a?b=1", ""},
+		{"https://www.example.org/", "308", "https://example.com/", ""},
+		{"https://example.com/", "200", "", "example.com:443"},
+		{"http://example.com/x", "308", "https://example.com/x", ""},
+		{"http://www.example.com/x", "308", "https://example.com/x", ""},
+		{"http://app.example.com/", "200", "", "app.example.com:80"},
+		{"http://plain.example.com/", "200", "", "plain.example.com:80"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		if req.URL.Scheme == "https" {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		p.route(rec, req)
+		res := rec.Result()
+		if got := res.Status[:3]; got != tc.code {
+			t.Errorf("%s: status %s, want %s", tc.url, got, tc.code)
+		}
+		if got := res.Header.Get("Location"); got != tc.location {
+			t.Errorf("%s: Location %q, want %q", tc.url, got, tc.location)
+		}
+		if tc.body != "" && rec.Body.String() != tc.body {
+			t.Errorf("%s: served by %q, want %q", tc.url, rec.Body.String(), tc.body)
+		}
+	}
+}
+
+func TestACMEHandler(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".well-known", "acme-challenge"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, ".well-known", "acme-challenge", "tok-EN_1"), []byte("tok-EN_1.thumb"), 0o644)
+	os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644)
+
+	h, err := newACMEHandler(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+acmeChallengePath+"tok-EN_1", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "tok-EN_1.thumb" {
+		t.Errorf("token: %d %q", rec.Code, rec.Body.String())
+	}
+	for _, path := range []string{"missing", "..%2F..%2Fsecret", ""} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+acmeChallengePath+path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%q: status %d, want 404", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Request IDs correlate one HTTP request or TCP/UDP flow across the proxy's
+// logs, the access log, the event ring and the backend. The router assigns
+// one to every request before anything else runs; it is forwarded upstream and
+// returned to the client as X-Request-Id. A peer in [request_id] trusted_ips
+// may bring its own, as X-Request-Id or as the trace ID of a W3C traceparent;
+// anyone else's is replaced so clients cannot forge log correlation.
+
+const requestIDHeader = "X-Request-Id"
+
+// RequestIDConfig lists the peers whose incoming request IDs are reused.
+type RequestIDConfig struct {
+	TrustedIPs []string // IPs and CIDRs, typically a load balancer in front
+}
+
+type requestIDPeers struct {
+	addrs []net.IP
+	nets  []*net.IPNet
+}
+
+var trustedRequestIDPeers atomic.Pointer[requestIDPeers]
+
+// ConfigureRequestIDs sets the peers whose X-Request-Id and traceparent are
+// trusted.
+func ConfigureRequestIDs(cfg RequestIDConfig) error {
+	for _, entry := range cfg.TrustedIPs {
+		entry = strings.TrimSpace(entry)
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return xerrors.Newf("request_id trusted_ips: %q is not an IP or CIDR", entry)
+		}
+	}
+	addrs, nets := parseIPList(strings.Join(cfg.TrustedIPs, ","))
+	trustedRequestIDPeers.Store(&requestIDPeers{addrs, nets})
+	return nil
+}
+
+type requestIDKey struct{}
+
+// withRequestID returns ctx carrying the request ID id.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random 128-bit ID, hex-encoded like a trace ID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+var (
+	validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:+=/-]{1,128}$`)
+	traceparent    = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+)
+
+// incomingRequestID returns the ID a trusted peer sent with r, or "".
+func incomingRequestID(r *http.Request, clientIP string) string {
+	peers := trustedRequestIDPeers.Load()
+	if peers == nil || !ipInList(peers.addrs, peers.nets, clientIP) {
+		return ""
+	}
+	if id := r.Header.Get(requestIDHeader); validRequestID.MatchString(id) {
+		return id
+	}
+	if m := traceparent.FindStringSubmatch(r.Header.Get("Traceparent")); m != nil && strings.Trim(m[1], "0") != "" {
+		return m[1]
+	}
+	return ""
+}
+
+// assignRequestID gives r its request ID: forwarded upstream in the request
+// header, returned in the response header and carried by the context.
+func assignRequestID(w http.ResponseWriter, r *http.Request, clientIP string) *http.Request {
+	id := incomingRequestID(r, clientIP)
+	if id == "" {
+		id = newRequestID()
+	}
+	r.Header.Set(requestIDHeader, id)
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(withRequestID(r.Context(), id))
+}
+
+// NewLogHandler wraps h so every record logged with a request's context
+// carries its request_id.
+func NewLogHandler(h slog.Handler) slog.Handler {
+	return requestIDLogHandler{h}
+}
+
+type requestIDLogHandler struct {
+	slog.Handler
+}
+
+func (h requestIDLogHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDLogHandler) WithGroup(name string) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithGroup(name)}
+}
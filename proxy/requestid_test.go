@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAssignRequestID(t *testing.T) {
+	if err := ConfigureRequestIDs(RequestIDConfig{TrustedIPs: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trustedRequestIDPeers.Store(nil) })
+
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	const trace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, tc := range []struct {
+		name, ip, id, traceparent string
+		want                      string // "" = a generated ID
+	}{
+		{"untrusted peer", "192.0.2.1", "forged", "", ""},
+		{"trusted peer", "10.1.2.3", "lb-42", "", "lb-42"},
+		{"trusted traceparent", "10.1.2.3", "", "00-" + trace + "-00f067aa0ba902b7-01", trace},
+		{"trusted but invalid", "10.1.2.3", "<script>", "", ""},
+		{"zero trace ID", "10.1.2.3", "", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://app/", nil)
+		req.Header.Set("X-Request-Id", tc.id)
+		req.Header.Set("Traceparent", tc.traceparent)
+		rec := httptest.NewRecorder()
+		req = assignRequestID(rec, req, tc.ip)
+
+		id := RequestID(req.Context())
+		if tc.want != "" && id != tc.want || tc.want == "" && !generated.MatchString(id) {
+			t.Errorf("%s: got ID %q", tc.name, id)
+		}
+		if req.Header.Get("X-Request-Id") != id || rec.Header().Get("X-Request-Id") != id {
+			t.Errorf("%s: ID not set on the forwarded request and the response", tc.name)
+		}
+	}
+
+	if err := ConfigureRequestIDs(RequestIDConfig{TrustedIPs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
+
+// The backend sees the request ID, and the client gets it exactly once even
+// when the backend echoes it.
+func TestRequestIDForwarded(t *testing.T) {
+	var seen string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("X-Request-Id")
+		w.Header().Set("X-Request-Id", seen)
+	}))
+	defer backend.Close()
+
+	h, err := createHandlerForRoute(&ProxyRoute{Url: "x:80", Target: strings.TrimPrefix(backend.URL, "http://"), Type: "proxy"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := &listenServer{Port: "80"}
+	ls.handlers.Store(map[string]http.Handler{"x": h})
+	p := &Proxy{servers: map[string]*listenServer{"80": ls}}
+	rec := httptest.NewRecorder()
+	p.route(rec, httptest.NewRequest(http.MethodGet, "http://x/", nil))
+
+	got := rec.Header().Values("X-Request-Id")
+	if seen == "" || len(got) != 1 || got[0] != seen {
+		t.Fatalf("backend saw %q, client got %q", seen, got)
+	}
+}
+
+func TestRequestIDLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(NewLogHandler(slog.NewTextHandler(&buf, nil))).With("component", "test")
+	log.InfoContext(withRequestID(t.Context(), "abc123"), "hello")
+	log.Info("no request")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !strings.Contains(lines[0], "request_id=abc123") || strings.Contains(lines[1], "request_id") {
+		t.Fatalf("got %q", lines)
+	}
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"reMazarin/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Retry and failover for proxy routes. A route's target may list several
+// backends ("10.0.0.1:8080,10.0.0.2:8080"); requests are spread round-robin and
+// every retry moves on to the next backend, so one dead backend costs a retry
+// instead of a 502. Retries are capped by a per-route budget so a struggling
+// backend pool is not hit with a multiplied load (retry storm).
+
+const (
+	defaultRetryBackoff   = 50 * time.Millisecond
+	maxRetryBackoff       = 2 * time.Second
+	defaultRetryBudgetPct = 20
+	// retryBudgetWindow is the rolling window the budget ratio is measured over.
+	retryBudgetWindow = 10 * time.Second
+	// retryBudgetMin retries are always allowed per window, so a quiet route can
+	// still fail over when its only recent request hits a dead backend.
+	retryBudgetMin = 3
+	// maxRetryBody bounds how much of a request body is buffered for replay.
+	// Larger bodies are streamed once and never retried.
+	maxRetryBody = 64 << 10
+)
+
+// upstreams is a route's backend pool, walked round-robin.
+type upstreams struct {
+	targets []*url.URL
+	next    atomic.Uint64
+}
+
+// parseUpstreams parses a comma-separated target list. Entries without a
+// scheme default to http://.
+func parseUpstreams(target string) (*upstreams, error) {
+	u := &upstreams{}
+	for _, t := range strings.Split(target, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !strings.HasPrefix(t, "http://") && !strings.HasPrefix(t, "https://") {
+			t = "http://" + t
+		}
+		parsed, err := url.Parse(t)
+		if err != nil {
+			return nil, xerrors.Newf("invalid target URL %s: %w", t, err)
+		}
+		u.targets = append(u.targets, parsed)
+	}
+	if len(u.targets) == 0 {
+		return nil, xerrors.New("no target configured")
+	}
+	return u, nil
+}
+
+// start returns the pool index the next request begins at.
+func (u *upstreams) start() int { return int(u.next.Add(1) - 1) }
+
+// at returns the backend for attempt i of a request that began at start.
+func (u *upstreams) at(start, i int) *url.URL {
+	return u.targets[(start+i)%len(u.targets)]
+}
+
+// retryPolicy is the parsed form of a storage.RouteRetry.
+type retryPolicy struct {
+	attempts      int
+	onConnect     bool
+	onStatus      map[int]bool
+	backoff       time.Duration
+	nonIdempotent bool
+	budget        *retryBudget
+}
+
+func newRetryPolicy(r storage.RouteRetry) *retryPolicy {
+	p := &retryPolicy{
+		attempts:      max(r.Attempts, 1),
+		onStatus:      make(map[int]bool),
+		backoff:       defaultRetryBackoff,
+		nonIdempotent: r.NonIdempotent,
+		budget:        &retryBudget{pct: defaultRetryBudgetPct},
+	}
+	if r.BackoffMs > 0 {
+		p.backoff = time.Duration(r.BackoffMs) * time.Millisecond
+	}
+	if r.BudgetPct > 0 {
+		p.budget.pct = r.BudgetPct
+	}
+	on := strings.TrimSpace(r.On)
+	if on == "" {
+		on = "connect,502,503,504"
+	}
+	for _, c := range strings.Split(on, ",") {
+		c = strings.TrimSpace(c)
+		if c == "connect" {
+			p.onConnect = true
+		} else if code, err := strconv.Atoi(c); err == nil {
+			p.onStatus[code] = true
+		}
+	}
+	return p
+}
+
+// methodAllowed reports whether req may be retried at all under the policy.
+func (p *retryPolicy) methodAllowed(req *http.Request) bool {
+	if p.nonIdempotent {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether the outcome of one attempt is worth another try.
+func (p *retryPolicy) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return p.onConnect && isConnectError(err)
+	}
+	return p.onStatus[resp.StatusCode]
+}
+
+// delay returns the jittered backoff before retry number n (1-based): the base
+// doubles per retry up to maxRetryBackoff and the actual wait is drawn from
+// [d/2, d] so synchronized clients spread out.
+func (p *retryPolicy) delay(n int) time.Duration {
+	d := p.backoff << (n - 1)
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// isConnectError reports whether err means the request never reached (or was
+// reset by) the backend: dial failures, refused and reset connections.
+func isConnectError(err error) bool {
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryBudget caps retries to a percentage of the requests seen in the current
+// window (with a small floor), so retries cannot multiply the load on a backend
+// pool that is already failing.
+type retryBudget struct {
+	mu          sync.Mutex
+	pct         int
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+func (b *retryBudget) roll(now time.Time) {
+	if now.Sub(b.windowStart) > retryBudgetWindow {
+		b.windowStart = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+// request counts one incoming request toward the budget.
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	b.roll(time.Now())
+	b.requests++
+	b.mu.Unlock()
+}
+
+// allow consumes one retry from the budget, reporting whether it was available.
+func (b *retryBudget) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(time.Now())
+	if b.retries >= max(retryBudgetMin, b.requests*b.pct/100) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// errCircuitOpen is returned when every backend of a route has an open circuit.
+var errCircuitOpen = errors.New("all upstream circuits open")
+
+// retryTransport sends each proxied request to the route's backend pool,
+// retrying retryable failures on the next backend under the route's policy and
+// skipping backends whose circuit breaker is open.
+type retryTransport struct {
+	next     http.RoundTripper
+	ups      *upstreams
+	policy   *retryPolicy
+	breakers map[string]*breaker // by backend host; nil when the route has no breaker
+	routeUrl string
+}
+
+// pick returns the next backend, from pool offset *off onward, whose circuit
+// admits a call, advancing *off past it. It returns nil if every circuit is open.
+func (t *retryTransport) pick(start int, off *int) (*url.URL, *breaker) {
+	for range t.ups.targets {
+		target := t.ups.at(start, *off)
+		*off++
+		cb := t.breakers[target.Host]
+		if cb == nil || cb.allow() {
+			return target, cb
+		}
+	}
+	return nil, nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := t.ups.start()
+	t.policy.budget.request()
+
+	off := 0
+	target, cb := t.pick(start, &off)
+	if target == nil {
+		return nil, errCircuitOpen
+	}
+
+	attempts := t.policy.attempts
+	if !t.policy.methodAllowed(req) {
+		attempts = 1
+	}
+
+	// Buffer a small body so it can be replayed; anything larger is streamed once.
+	var body []byte
+	if attempts > 1 && req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(io.LimitReader(req.Body, maxRetryBody+1))
+		if err != nil {
+			return nil, xerrors.Newf("buffer request body: %w", err)
+		}
+		if len(b) > maxRetryBody {
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+			attempts = 1
+		} else {
+			req.Body.Close()
+			body = b
+		}
+	}
+
+	for i := 0; ; i++ {
+		out := req
+		if attempts > 1 {
+			out = req.Clone(req.Context())
+			if body != nil {
+				out.Body = io.NopCloser(bytes.NewReader(body))
+				out.ContentLength = int64(len(body))
+			}
+		}
+		out.URL.Scheme = target.Scheme
+		out.URL.Host = target.Host
+		out.Header.Set("X-Origin-Host", target.Host)
+
+		began := time.Now()
+		resp, err := t.next.RoundTrip(out)
+		if cb != nil {
+			// A client that went away says nothing about the backend's health.
+			cb.done(req.Context().Err() != nil || (err == nil && resp.StatusCode < 500), time.Since(began))
+		}
+		if i+1 >= attempts || req.Context().Err() != nil || !t.policy.retryable(resp, err) {
+			return resp, err
+		}
+		// Pick before spending budget: no target means no retry to pay for.
+		next, nextCb := t.pick(start, &off)
+		if next == nil {
+			return resp, err
+		}
+		if !t.policy.budget.allow() {
+			if nextCb != nil {
+				nextCb.release()
+			}
+			return resp, err
+		}
+		target, cb = next, nextCb
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		RecordEventContext(req.Context(), extractClientIP(req), t.routeUrl, OutcomeRetry)
+
+		select {
+		case <-time.After(t.policy.delay(i + 1)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// readCloser pairs a replacement reader with the original body's Close.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
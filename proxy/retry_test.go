@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deadAddr returns a loopback address nothing is listening on.
+func deadAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// With two backends and retries enabled, a request that lands on a dead backend
+// must fail over to the live one instead of surfacing a 502.
+func TestRetryFailsOverToNextBackend(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer live.Close()
+
+	route := &ProxyRoute{
+		Url:    "x:80",
+		Target: deadAddr(t) + "," + strings.TrimPrefix(live.URL, "http://"),
+		Type:   "proxy",
+		Retry:  storage.RouteRetry{Attempts: 2, BackoffMs: 1},
+	}
+	h, err := createHandlerForRoute(route, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Round-robin alternates the first backend; every request must still succeed.
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://x/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: want 200 via failover, got %d", i, rec.Code)
+		}
+	}
+}
+
+// POST is not idempotent, so by default it must not be retried.
+func TestRetrySkipsNonIdempotent(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	route := &ProxyRoute{
+		Url:    "x:80",
+		Target: strings.TrimPrefix(backend.URL, "http://"),
+		Type:   "proxy",
+		Retry:  storage.RouteRetry{Attempts: 3, BackoffMs: 1},
+	}
+	h, err := createHandlerForRoute(route, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://x/", strings.NewReader("a=1")))
+	if calls != 1 {
+		t.Fatalf("POST must be sent once, backend saw %d calls", calls)
+	}
+	calls = 0
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/", nil))
+	if calls != 3 {
+		t.Fatalf("GET on 503 should use all 3 attempts, backend saw %d calls", calls)
+	}
+}
+
+func TestRetryBudgetCapsRetries(t *testing.T) {
+	b := &retryBudget{pct: 10}
+	for i := 0; i < 10; i++ {
+		b.request()
+	}
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if b.allow() {
+			allowed++
+		}
+	}
+	if allowed != retryBudgetMin {
+		t.Fatalf("10%% of 10 requests is below the floor; want %d retries, got %d", retryBudgetMin, allowed)
+	}
+	b.windowStart = time.Now().Add(-2 * retryBudgetWindow)
+	if !b.allow() {
+		t.Fatal("a new window must restore the budget")
+	}
+}
+
+// A retry with no backend to go to must not spend the retry budget.
+func TestRetryBudgetUntouchedWhenCircuitsOpen(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	ups, err := parseUpstreams(strings.TrimPrefix(backend.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := newRetryPolicy(storage.RouteRetry{Attempts: 3, BackoffMs: 1})
+	rt := &retryTransport{
+		next:     http.DefaultTransport,
+		ups:      ups,
+		policy:   policy,
+		breakers: newBreakers("x:80", []string{ups.targets[0].Host}, storage.RouteBreaker{ErrorPct: 50, MinRequests: 1}),
+		routeUrl: "x:80",
+	}
+	defer dropBreakers("x:80")
+
+	resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://x/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if policy.budget.retries != 0 {
+		t.Fatalf("the only circuit opened, yet %d retries were charged to the budget", policy.budget.retries)
+	}
+}
@@ -1,73 +1,211 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"reMazarin/storage"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mdobak/go-xerrors"
 )
 
-func createReverseProxy(route *ProxyRoute) (*httputil.ReverseProxy, error) {
-	targetAddr := route.Target
+// Built-in backend transport defaults, used wherever a route leaves the
+// corresponding storage.RouteTransport field at zero. A negative field disables
+// the limit instead.
+const (
+	defaultDialTimeout           = 10 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 60 * time.Second
+	defaultRequestTimeout        = 0 // no overall limit: streaming and websockets stay open
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultMaxIdleConns          = 100
+	defaultMaxIdleConnsPerHost   = 10
+)
+
+// seconds resolves a configured timeout: 0 → def, negative → 0 (no limit).
+func seconds(v int, def time.Duration) time.Duration {
+	switch {
+	case v == 0:
+		return def
+	case v < 0:
+		return 0
+	}
+	return time.Duration(v) * time.Second
+}
+
+// count resolves a configured connection count: 0 → def, negative → 0 (no limit).
+func count(v, def int) int {
+	switch {
+	case v == 0:
+		return def
+	case v < 0:
+		return 0
+	}
+	return v
+}
+
+// newTransport builds the backend transport for a proxy route from its tuning.
+func newTransport(t storage.RouteTransport) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   seconds(t.DialTimeout, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   seconds(t.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: seconds(t.ResponseHeaderTimeout, defaultResponseHeaderTimeout),
+		IdleConnTimeout:       seconds(t.IdleConnTimeout, defaultIdleConnTimeout),
+		MaxIdleConns:          count(t.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   count(t.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     false,
+	}
+}
 
-	if !strings.HasPrefix(targetAddr, "http://") && !strings.HasPrefix(targetAddr, "https://") {
-		targetAddr = "http://" + targetAddr
+// withRequestTimeout bounds the whole proxied exchange — dial, headers and body
+// — by cancelling the request context. Unlike http.TimeoutHandler it does not
+// buffer the response, so streaming responses still flush as they arrive.
+func withRequestTimeout(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
 	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-	target, err := url.Parse(targetAddr)
+// createFallback builds the handler served while every backend of a route has
+// an open circuit: a static directory or file when the fallback is a path, a
+// plain reverse proxy (without breakers or retries) otherwise. It returns nil
+// when the route has no fallback.
+func createFallback(route *ProxyRoute) (http.Handler, error) {
+	fb := strings.TrimSpace(route.Breaker.Fallback)
+	if fb == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(fb, "/") || strings.HasPrefix(fb, ".") {
+		return createStaticHandler(&ProxyRoute{Url: route.Url, Target: fb, Type: "static"})
+	}
+	return createReverseProxy(&ProxyRoute{Url: route.Url, Target: fb, Type: "proxy", Transport: route.Transport})
+}
+
+func createReverseProxy(route *ProxyRoute) (*httputil.ReverseProxy, error) {
+	ups, err := parseUpstreams(route.Target)
 	if err != nil {
-		return nil, xerrors.Newf("invalid target URL %s: %w", targetAddr, err)
+		return nil, err
 	}
+	// The first backend fixes the path rewriting; with several backends the
+	// retry transport swaps in each attempt's scheme and host.
+	target := ups.targets[0]
 
 	// Create reverse proxy
 	proxy := httputil.NewSingleHostReverseProxy(target)
-	transport := &http.Transport{ // WARNING: Testing what config we need and if we need to add this to the config.toml
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
-		DisableKeepAlives:   false,
-	}
-
-	// Allow insecure HTTPS (not used yet)
-	if strings.HasPrefix(targetAddr, "https://") {
-		transport.TLSClientConfig = &tls.Config{
-			ServerName: target.Hostname(),
-			MinVersion: tls.VersionTLS12,
+	transport := newTransport(route.Transport)
+
+	for _, t := range ups.targets {
+		if t.Scheme == "https" {
+			// ServerName is left empty so each backend is verified against its own host.
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+			break
 		}
 	}
 
 	proxy.Transport = transport
 
+	// Built before newBreakers: the fallback proxy has no breaker of its own, and
+	// registering it under the same route would otherwise drop the route's.
+	fallback, err := createFallback(route)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := newRetryPolicy(route.Retry)
+	hosts := make([]string, len(ups.targets))
+	for i, t := range ups.targets {
+		hosts[i] = t.Host
+	}
+	cbs := newBreakers(route.Url, hosts, route.Breaker)
+	if len(ups.targets) > 1 || policy.attempts > 1 || cbs != nil {
+		proxy.Transport = &retryTransport{next: transport, ups: ups, policy: policy, breakers: cbs, routeUrl: route.Url}
+	}
+
+	reqRules, err := parseHeaderRules(route.Headers.Request)
+	if err != nil {
+		return nil, xerrors.Newf("request_headers: %w", err)
+	}
+	rewrites, err := parseRewriteRules(route.Rewrite)
+	if err != nil {
+		return nil, xerrors.Newf("rewrite: %w", err)
+	}
+
 	// Customize Director
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
+		// Rewrite the client's path before the target's base path is joined on.
+		rewrites.apply(req)
 		originalDirector(req)
+		var env *headerEnv
+		if len(reqRules) > 0 {
+			env = newHeaderEnv(req, route.Url) // before X-Forwarded-For is overwritten
+		}
 		req.Header.Set("X-Forwarded-Host", req.Host)
 		req.Header.Set("X-Origin-Host", target.Host)
 		req.Header.Set("X-Proxy", "reMazarin")
 
-		clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err == nil {
-			req.Header.Set("X-Forwarded-For", clientIP)
-		} else {
-			req.Header.Del("X-Forwarded-For")
+		// Drop any client-supplied chain: ReverseProxy appends the client IP to
+		// whatever X-Forwarded-For is left here, so this sends the client IP alone.
+		req.Header.Del("X-Forwarded-For")
+		// Route rules run last so they can override the headers above.
+		if env != nil {
+			reqRules.apply(req.Header, env, req)
 		}
+		upstreamStarted(req.Context())
 	}
 
 	// Error handler
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		slog.Error("proxy error",
-			"target", targetAddr,
+		upstreamDone(r.Context())
+		if errors.Is(err, errCircuitOpen) {
+			RecordEventContext(r.Context(), extractClientIP(r), route.Url, OutcomeCircuitOpen)
+			if fallback != nil {
+				// Never let the stand-in page be cached as the route's content.
+				w.Header().Set("Cache-Control", "no-store")
+				fallback.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(seconds(route.Breaker.OpenSec, defaultBreakerOpen)/time.Second)))
+			writeError(w, r, route.Url, http.StatusServiceUnavailable)
+			return
+		}
+		slog.ErrorContext(r.Context(), "proxy error",
+			"target", route.Target,
 			"path", r.URL.Path,
 			"error", err,
 		)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		// One of the route's transport timeouts (or its request_timeout) expired.
+		var ne net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+			writeError(w, r, route.Url, http.StatusGatewayTimeout)
+			return
+		}
+		writeError(w, r, route.Url, http.StatusBadGateway)
+	}
+
+	// The client gets the request ID the router set, not a second one echoed
+	// back by the backend. Response headers also end the backend wait.
+	proxy.ModifyResponse = func(res *http.Response) error {
+		upstreamDone(res.Request.Context())
+		res.Header.Del(requestIDHeader)
+		return nil
 	}
 
 	return proxy, nil
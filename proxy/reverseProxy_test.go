@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A backend slower than the route's response_header_timeout must be cut off and
+// answered with 504 rather than left to hang (or reported as a generic 502).
+func TestResponseHeaderTimeoutGives504(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(3 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	route := &ProxyRoute{
+		Url:       "x:80",
+		Target:    strings.TrimPrefix(backend.URL, "http://"),
+		Type:      "proxy",
+		Transport: storage.RouteTransport{ResponseHeaderTimeout: 1},
+	}
+	h, err := createHandlerForRoute(route, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://x/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("want 504, got %d", rec.Code)
+	}
+	if el := time.Since(start); el > 2500*time.Millisecond {
+		t.Fatalf("timeout not enforced: took %s", el)
+	}
+}
+
+func TestSecondsResolvesDefaults(t *testing.T) {
+	if got := seconds(0, 7*time.Second); got != 7*time.Second {
+		t.Fatalf("zero must use the default, got %s", got)
+	}
+	if got := seconds(-1, 7*time.Second); got != 0 {
+		t.Fatalf("negative must disable the limit, got %s", got)
+	}
+	if got := seconds(3, 7*time.Second); got != 3*time.Second {
+		t.Fatalf("explicit value must win, got %s", got)
+	}
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"net/http"
+	"reMazarin/storage"
+	"regexp"
+	"strings"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Redirect routes and rewrite rules.
+//
+// A redirect route answers every request with a redirect to its target, a URL
+// template that may use the header-rule placeholders ({uri}, {host}, {path},
+// …) and, when the route has a match pattern, the pattern's capture groups
+// ($1, ${name}) taken from the request path:
+//
+//	target = "https://new.example.com{uri}"           path and query kept
+//	match  = "^/blog/(\d+)/(.*)$"
+//	target = "https://blog.example.com/posts/$1/$2"   capture groups
+//
+// Rewrite rules change the path a proxy route sends upstream. One rule per
+// line, "<pattern> <replacement>"; the first matching rule wins:
+//
+//	^/api/v1/(.*)  /v1/$1
+//	^/old$         /new?from=old
+
+const defaultRouteRedirectCode = http.StatusFound
+
+// validRedirectCode reports whether code is a redirect status a route may send.
+func validRedirectCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectRoute is a parsed redirect route.
+type redirectRoute struct {
+	code   int
+	match  *regexp.Regexp // nil = every path
+	target headerValue
+}
+
+// ValidateRedirect checks a redirect route's target template, status code and
+// match pattern.
+func ValidateRedirect(target string, rd storage.RouteRedirect) error {
+	_, err := parseRedirect(target, rd)
+	return err
+}
+
+func parseRedirect(target string, rd storage.RouteRedirect) (*redirectRoute, error) {
+	rr := &redirectRoute{code: rd.Code}
+	if rr.code == 0 {
+		rr.code = defaultRouteRedirectCode
+	}
+	if !validRedirectCode(rr.code) {
+		return nil, xerrors.Newf("redirect code %d: want 301, 302, 303, 307 or 308", rd.Code)
+	}
+	if rd.Match != "" {
+		re, err := regexp.Compile(rd.Match)
+		if err != nil {
+			return nil, xerrors.Newf("redirect match: %w", err)
+		}
+		rr.match = re
+	}
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, xerrors.Newf("redirect target is empty")
+	}
+	v, err := parseRedirectTarget(target)
+	if err != nil {
+		return nil, xerrors.Newf("redirect target: %w", err)
+	}
+	rr.target = v
+	return rr, nil
+}
+
+// parseRedirectTarget parses a target template, keeping "${name}" group
+// references as literal text for the match pattern to expand.
+func parseRedirectTarget(s string) (headerValue, error) {
+	var v headerValue
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			rest, err := parseHeaderValue(s)
+			return append(v, rest...), err
+		}
+		head, err := parseHeaderValue(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, xerrors.Newf("unclosed group reference in %q", s)
+		}
+		v = append(append(v, head...), headerPart{text: s[i : i+end+1]})
+		s = s[i+end+1:]
+	}
+}
+
+func createRedirectHandler(route *ProxyRoute) (http.Handler, error) {
+	rr, err := parseRedirect(route.Target, route.Redirect)
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m []int
+		if rr.match != nil {
+			if m = rr.match.FindStringSubmatchIndex(r.URL.Path); m == nil {
+				writeError(w, r, route.Url, http.StatusNotFound)
+				RecordEventContext(r.Context(), extractClientIP(r), route.Url, OutcomeNotFound)
+				return
+			}
+		}
+		env := newHeaderEnv(r, route.Url)
+		var b strings.Builder
+		for _, part := range rr.target {
+			switch {
+			case part.vr != nil:
+				b.WriteString(part.vr(env))
+			case m != nil:
+				// Only literal text is expanded, so a "$" in the request can
+				// never reference a group.
+				b.Write(rr.match.ExpandString(nil, part.text, r.URL.Path, m))
+			default:
+				b.WriteString(part.text)
+			}
+		}
+		RecordEventContext(r.Context(), extractClientIP(r), route.Url, OutcomeRedirected)
+		http.Redirect(w, r, b.String(), rr.code)
+	}), nil
+}
+
+type rewriteRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// rewriteRules is an ordered list; the first rule whose pattern matches applies.
+type rewriteRules []rewriteRule
+
+// ValidateRewriteRules reports the first invalid line of a rewrite rule text.
+func ValidateRewriteRules(text string) error {
+	_, err := parseRewriteRules(text)
+	return err
+}
+
+func parseRewriteRules(text string) (rewriteRules, error) {
+	var rules rewriteRules
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, xerrors.Newf("rewrite line %d: want \"<pattern> <replacement>\"", i+1)
+		}
+		re, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, xerrors.Newf("rewrite line %d: %w", i+1, err)
+		}
+		if !strings.HasPrefix(fields[1], "/") && !strings.HasPrefix(fields[1], "$") {
+			return nil, xerrors.Newf("rewrite line %d: replacement must start with / or a group", i+1)
+		}
+		rules = append(rules, rewriteRule{re, fields[1]})
+	}
+	return rules, nil
+}
+
+// apply replaces the matched part of req's path using the first matching rule.
+// A "?" in the replacement sets query parameters, which come before the
+// original ones.
+func (rs rewriteRules) apply(req *http.Request) {
+	path := req.URL.Path
+	for _, rule := range rs {
+		m := rule.pattern.FindStringSubmatchIndex(path)
+		if m == nil {
+			continue
+		}
+		out, query, hasQuery := strings.Cut(string(rule.pattern.ExpandString(nil, rule.replacement, path, m)), "?")
+		newPath := path[:m[0]] + out + path[m[1]:]
+		if !strings.HasPrefix(newPath, "/") {
+			newPath = "/" + newPath
+		}
+		req.URL.Path, req.URL.RawPath = newPath, ""
+		if hasQuery && query != "" {
+			if req.URL.RawQuery != "" {
+				query += "&" + req.URL.RawQuery
+			}
+			req.URL.RawQuery = query
+		}
+		return
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"testing"
+)
+
+func TestRedirectRoute(t *testing.T) {
+	for _, tc := range []struct {
+		target   string
+		rd       storage.RouteRedirect
+		url      string
+		code     int
+		location string
+	}{
+		{"https://new.example.com{uri}", storage.RouteRedirect{}, "http://old.example.com/a/b?x=1", 302, "https://new.example.com/a/b?x=1"},
+		{"https://new.example.com/", storage.RouteRedirect{Code: 301}, "http://old.example.com/a", 301, "https://new.example.com/"},
+		{"https://blog.example.com/posts/$1/${slug}", storage.RouteRedirect{Code: 308, Match: `^/blog/(\d+)/(?P<slug>.*)$`},
+			"http://old.example.com/blog/42/hello", 308, "https://blog.example.com/posts/42/hello"},
+		// A "$" in the request path is never expanded as a group.
+		{"https://x.example.com/$1{path}", storage.RouteRedirect{Match: `^/(\w+)`}, "http://old.example.com/a$1", 302, "https://x.example.com/a/a$1"},
+		{"https://x.example.com/", storage.RouteRedirect{Match: `^/only$`}, "http://old.example.com/other", 404, ""},
+	} {
+		h, err := createHandlerForRoute(&ProxyRoute{Url: "old.example.com:80", Type: "redirect", Target: tc.target, Redirect: tc.rd}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		if rec.Code != tc.code || rec.Header().Get("Location") != tc.location {
+			t.Errorf("%s → %s: got %d %q, want %d %q", tc.url, tc.target, rec.Code, rec.Header().Get("Location"), tc.code, tc.location)
+		}
+	}
+
+	for _, rd := range []storage.RouteRedirect{{Code: 200}, {Match: "("}} {
+		if ValidateRedirect("https://x/", rd) == nil {
+			t.Errorf("%+v: want an error", rd)
+		}
+	}
+	if ValidateRedirect("https://x/{nope}", storage.RouteRedirect{}) == nil {
+		t.Error("unknown placeholder must be rejected")
+	}
+}
+
+// Rewrites change the path sent upstream before the target's base path is
+// joined on; the first matching rule wins and replacement queries come first.
+func TestRewriteRules(t *testing.T) {
+	var gotURI string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+	}))
+	defer backend.Close()
+
+	h, err := createHandlerForRoute(&ProxyRoute{
+		Url:     "app:80",
+		Type:    "proxy",
+		Target:  backend.URL + "/base",
+		Rewrite: "# api versions\n^/api/v1/(.*) /v1/$1\n^/api/(.*) /v2/$1\n^/old$ /new?from=old",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, want := range map[string]string{
+		"/api/v1/users?x=1": "/base/v1/users?x=1",
+		"/api/users":        "/base/v2/users",
+		"/old?y=2":          "/base/new?from=old&y=2",
+		"/static/app.js":    "/base/static/app.js",
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://app"+in, nil))
+		if gotURI != want {
+			t.Errorf("%s: upstream got %s, want %s", in, gotURI, want)
+		}
+	}
+
+	for _, rules := range []string{"^/a", "^/a /b extra", "( /b", "^/a b"} {
+		if ValidateRewriteRules(rules) == nil {
+			t.Errorf("%q: want an error", rules)
+		}
+	}
+}
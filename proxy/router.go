@@ -8,7 +8,17 @@ import (
 )
 
 func (p *Proxy) route(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("connection to router", "host", r.Host)
+	clientIP := extractClientIP(r)
+	r = assignRequestID(w, r, clientIP)
+	withAccessLog(w, r, clientIP, func(w http.ResponseWriter, r *http.Request) {
+		p.dispatch(w, r, clientIP)
+	})
+}
+
+// dispatch hands r to the handler of its port and host.
+func (p *Proxy) dispatch(w http.ResponseWriter, r *http.Request, clientIP string) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "connection to router", "host", r.Host)
 
 	host, port, err := net.SplitHostPort(r.Host)
 	if err != nil {
@@ -20,35 +30,41 @@ func (p *Proxy) route(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	host = strings.ToLower(host)
-
-	clientIP := extractClientIP(r)
+	noteRoute(ctx, host+":"+port)
 
 	// Drop banned IPs before any routing work, even on junk Hosts/ports.
 	if IsBanned(clientIP) {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		RecordEvent(clientIP, host+":"+port, OutcomeBanned)
+		writeError(w, r, host+":"+port, http.StatusForbidden)
+		RecordEventContext(ctx, clientIP, host+":"+port, OutcomeBanned)
 		return
 	}
 
 	ls, ok := p.servers[port]
 	if !ok {
-		slog.Debug("requested port does not exist", "port", port)
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-		RecordEvent(clientIP, host+":"+port, OutcomeNoListener)
+		slog.DebugContext(ctx, "requested port does not exist", "port", port)
+		writeError(w, r, host+":"+port, http.StatusServiceUnavailable)
+		RecordEventContext(ctx, clientIP, host+":"+port, OutcomeNoListener)
 		RecordFailure(clientIP)
 		return
 	}
 
+	// Challenges are answered for any host: the certificate may be for a host
+	// that has no route yet.
+	if ls.acme != nil && strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+		ls.acme.ServeHTTP(w, r)
+		return
+	}
+
 	handlers := ls.handlers.Load().(map[string]http.Handler)
 	handler, ok := handlers[host]
 	if !ok {
-		slog.Debug("requested url does not exist", "url", host)
-		http.Error(w, "Not Found", http.StatusNotFound)
-		RecordEvent(clientIP, host+":"+port, OutcomeNotFound)
+		slog.DebugContext(ctx, "requested url does not exist", "url", host)
+		writeError(w, r, host+":"+port, http.StatusNotFound)
+		RecordEventContext(ctx, clientIP, host+":"+port, OutcomeNotFound)
 		RecordFailure(clientIP)
 		return
 	}
 
-	slog.Debug("routing", "host", r.Host)
+	slog.DebugContext(ctx, "routing", "host", r.Host)
 	handler.ServeHTTP(w, r)
 }
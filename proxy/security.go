@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"net/http"
+	"reMazarin/storage"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Security-header profiles: named sets of response headers (HSTS, CSP, frame
+// options, …) applied to every response of a route. Profile headers are only
+// added when the response does not already carry them, so an application that
+// sends its own Content-Security-Policy keeps it.
+
+// SecurityProfile is a profile as configured. Headers maps each header to its
+// value; an empty value drops a header inherited from Extends.
+type SecurityProfile struct {
+	Extends string
+	Headers map[string]string
+}
+
+// builtinSecurityProfiles are always available; config may extend them but not
+// redefine them.
+var builtinSecurityProfiles = map[string]map[string]string{
+	"none": {},
+	// basic suits arbitrary proxied apps: nothing that can break page loads.
+	"basic": {
+		"Strict-Transport-Security": "max-age=31536000",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+	},
+	// strict locks a page to its own origin; the [web] and [admin] sites use it.
+	"strict": {
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"Content-Security-Policy": "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+			"img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+		"X-Content-Type-Options":     "nosniff",
+		"X-Frame-Options":            "DENY",
+		"Referrer-Policy":            "same-origin",
+		"Permissions-Policy":         "camera=(), microphone=(), geolocation=()",
+		"Cross-Origin-Opener-Policy": "same-origin",
+	},
+}
+
+var (
+	securityMu       sync.RWMutex
+	securityProfiles = builtinSecurityProfiles
+)
+
+// ConfigureSecurityProfiles resolves the config-defined profiles against the
+// built-in ones and makes them available to routes.
+func ConfigureSecurityProfiles(custom map[string]SecurityProfile) error {
+	resolved := make(map[string]map[string]string, len(builtinSecurityProfiles)+len(custom))
+	for name, h := range builtinSecurityProfiles {
+		resolved[name] = h
+	}
+	var resolve func(name string, seen []string) (map[string]string, error)
+	resolve = func(name string, seen []string) (map[string]string, error) {
+		if h, ok := resolved[name]; ok {
+			return h, nil
+		}
+		p, ok := custom[name]
+		if !ok {
+			return nil, xerrors.Newf("unknown security profile %q", name)
+		}
+		for _, s := range seen {
+			if s == name {
+				return nil, xerrors.Newf("security profile %q extends itself", name)
+			}
+		}
+		h := make(map[string]string)
+		if p.Extends != "" {
+			base, err := resolve(p.Extends, append(seen, name))
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range base {
+				h[k] = v
+			}
+		}
+		for k, v := range p.Headers {
+			if !validHeaderName(k) {
+				return nil, xerrors.Newf("security profile %q: invalid header name %q", name, k)
+			}
+			k = http.CanonicalHeaderKey(k)
+			if v == "" {
+				delete(h, k)
+			} else {
+				h[k] = v
+			}
+		}
+		resolved[name] = h
+		return h, nil
+	}
+	for name := range custom {
+		if _, ok := builtinSecurityProfiles[name]; ok {
+			return xerrors.Newf("security profile %q is built in and cannot be redefined", name)
+		}
+		if _, err := resolve(name, nil); err != nil {
+			return err
+		}
+	}
+	securityMu.Lock()
+	securityProfiles = resolved
+	securityMu.Unlock()
+	return nil
+}
+
+// SecurityProfileNames lists the available profiles, for the admin UI.
+func SecurityProfileNames() []string {
+	securityMu.RLock()
+	defer securityMu.RUnlock()
+	names := make([]string, 0, len(securityProfiles))
+	for name := range securityProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateRouteSecurity checks a route's profile name and override lines.
+func ValidateRouteSecurity(s storage.RouteSecurity) error {
+	_, err := newSecurityHeaders(s)
+	return err
+}
+
+// securityHeader is one header a route adds to its responses.
+type securityHeader struct {
+	name, value string
+}
+
+// newSecurityHeaders resolves a route's profile and overrides into the headers
+// to add. Overrides are "Name: value" lines; "Name:" drops a profile header.
+func newSecurityHeaders(s storage.RouteSecurity) ([]securityHeader, error) {
+	headers := make(map[string]string)
+	if s.Profile != "" {
+		securityMu.RLock()
+		p, ok := securityProfiles[s.Profile]
+		securityMu.RUnlock()
+		if !ok {
+			return nil, xerrors.Newf("unknown security profile %q", s.Profile)
+		}
+		for k, v := range p {
+			headers[k] = v
+		}
+	}
+	for i, line := range strings.Split(s.Headers, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !validHeaderName(name) {
+			return nil, xerrors.Newf("security header line %d: want \"Name: value\"", i+1)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if value = strings.TrimSpace(value); value == "" {
+			delete(headers, name)
+		} else {
+			headers[name] = value
+		}
+	}
+	out := make([]securityHeader, 0, len(headers))
+	for k, v := range headers {
+		out = append(out, securityHeader{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// withSecurityHeaders adds a route's security headers to every response that
+// does not set them itself.
+func withSecurityHeaders(headers []securityHeader, next http.Handler) http.Handler {
+	if len(headers) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &securityWriter{ResponseWriter: w, headers: headers, tls: r.TLS != nil}
+		next.ServeHTTP(sw, r)
+		sw.apply() // the handler may return without writing; the server then sends 200
+	})
+}
+
+type securityWriter struct {
+	http.ResponseWriter
+	headers []securityHeader
+	tls     bool
+	applied bool
+}
+
+func (sw *securityWriter) apply() {
+	if sw.applied {
+		return
+	}
+	sw.applied = true
+	h := sw.Header()
+	for _, sh := range sw.headers {
+		// HSTS is ignored by browsers over plain HTTP; don't advertise it there.
+		if sh.name == "Strict-Transport-Security" && !sw.tls {
+			continue
+		}
+		if _, set := h[sh.name]; !set {
+			h[sh.name] = []string{sh.value}
+		}
+	}
+}
+
+func (sw *securityWriter) WriteHeader(code int) {
+	if code >= 200 {
+		sw.apply()
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *securityWriter) Write(p []byte) (int, error) {
+	if !sw.applied {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush keeps streamed responses flowing through the wrapper.
+func (sw *securityWriter) Flush() {
+	if !sw.applied {
+		sw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *securityWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
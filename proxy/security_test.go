@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reMazarin/storage"
+	"testing"
+)
+
+func securedGet(t *testing.T, sec storage.RouteSecurity, app http.HandlerFunc, useTLS bool) http.Header {
+	t.Helper()
+	headers, err := newSecurityHeaders(sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://x/", nil)
+	if useTLS {
+		req.TLS = &tls.ConnectionState{}
+	}
+	rec := httptest.NewRecorder()
+	withSecurityHeaders(headers, app).ServeHTTP(rec, req)
+	return rec.Result().Header
+}
+
+// Profile headers fill in what the app leaves out; headers the app sets itself
+// are kept, and overrides change or drop single profile headers.
+func TestSecurityHeadersYieldToApp(t *testing.T) {
+	app := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Write([]byte("ok"))
+	}
+	h := securedGet(t, storage.RouteSecurity{
+		Profile: "strict",
+		Headers: "Referrer-Policy: no-referrer\nPermissions-Policy:",
+	}, app, true)
+	for k, want := range map[string]string{
+		"X-Frame-Options":           "SAMEORIGIN",
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "no-referrer",
+		"Permissions-Policy":        "",
+		"Strict-Transport-Security": builtinSecurityProfiles["strict"]["Strict-Transport-Security"],
+	} {
+		if v := h.Get(k); v != want {
+			t.Errorf("%s = %q, want %q", k, v, want)
+		}
+	}
+	if h := securedGet(t, storage.RouteSecurity{Profile: "strict"}, app, false); h.Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS must not be sent over plain HTTP")
+	}
+}
+
+func TestConfigureSecurityProfiles(t *testing.T) {
+	defer ConfigureSecurityProfiles(nil)
+
+	err := ConfigureSecurityProfiles(map[string]SecurityProfile{
+		"app":  {Extends: "basic", Headers: map[string]string{"content-security-policy": "default-src 'self'", "X-Frame-Options": ""}},
+		"app2": {Extends: "app", Headers: map[string]string{"X-Extra": "1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := securedGet(t, storage.RouteSecurity{Profile: "app2"}, func(http.ResponseWriter, *http.Request) {}, false)
+	if h.Get("Content-Security-Policy") != "default-src 'self'" || h.Get("X-Frame-Options") != "" ||
+		h.Get("X-Content-Type-Options") != "nosniff" || h.Get("X-Extra") != "1" {
+		t.Errorf("inherited profile resolved wrong: %v", h)
+	}
+
+	for name, profiles := range map[string]map[string]SecurityProfile{
+		"cycle":    {"a": {Extends: "b"}, "b": {Extends: "a"}},
+		"unknown":  {"a": {Extends: "missing"}},
+		"builtin":  {"strict": {}},
+		"bad name": {"a": {Headers: map[string]string{"X Bad": "1"}}},
+	} {
+		if ConfigureSecurityProfiles(profiles) == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+	if ValidateRouteSecurity(storage.RouteSecurity{Profile: "nope"}) == nil {
+		t.Error("unknown route profile must be rejected")
+	}
+}
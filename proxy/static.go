@@ -1,10 +1,16 @@
 package proxy
 
 import (
+	"io"
+	"io/fs"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/mdobak/go-xerrors"
 )
@@ -34,7 +40,10 @@ func fileHandler(route *ProxyRoute) (http.Handler, error) {
 	}
 	fsys := root.FS()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slog.Debug("serving static file", "file", filename, "path", r.URL.Path)
+		slog.DebugContext(r.Context(), "serving static file", "file", filename, "path", r.URL.Path)
+		if servePrecompressed(w, r, fsys, filename) {
+			return
+		}
 		http.ServeFileFS(w, r, fsys, filename)
 	})
 	slog.Info("static file handler created", "file", route.Target)
@@ -47,5 +56,60 @@ func folderHandler(route *ProxyRoute) (http.Handler, error) {
 		return nil, xerrors.Newf("open root %s: %w", route.Target, err)
 	}
 	slog.Info("static folder handler created", "folder", route.Target)
-	return http.FileServerFS(root.FS()), nil
+	fsys := root.FS()
+	files := http.FileServerFS(fsys)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Resolve the file the way FileServer does: directory URLs ending in "/"
+		// serve their index.html. Anything else (redirects, listings) is left to it.
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+		}
+		if name != "" && servePrecompressed(w, r, fsys, name) {
+			return
+		}
+		files.ServeHTTP(w, r)
+	}), nil
+}
+
+// precompressedExts maps each encoding to the sibling-file extension holding a
+// precompressed copy (app.js → app.js.br, app.js.gz).
+var precompressedExts = map[string]string{"br": ".br", "gzip": ".gz"}
+
+// servePrecompressed serves a precompressed sibling of name in the encoding
+// the client prefers among those present, reporting whether it did. The
+// Content-Type is that of the original file.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) bool {
+	ae := r.Header.Get("Accept-Encoding")
+	if ae == "" {
+		return false
+	}
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		return false // would have to sniff the compressed bytes
+	}
+	orig, err := fs.Stat(fsys, name)
+	if err != nil || !orig.Mode().IsRegular() {
+		return false
+	}
+	offers := []string{"br", "gzip"}
+	for {
+		enc := chooseEncoding(ae, offers)
+		if enc == "" {
+			return false
+		}
+		if f, err := fsys.Open(name + precompressedExts[enc]); err == nil {
+			defer f.Close()
+			fi, err := f.Stat()
+			if rs, ok := f.(io.ReadSeeker); ok && err == nil && fi.Mode().IsRegular() {
+				h := w.Header()
+				h.Set("Content-Type", ctype)
+				h.Set("Content-Encoding", enc)
+				h.Add("Vary", "Accept-Encoding")
+				http.ServeContent(w, r, name, orig.ModTime(), rs)
+				return true
+			}
+		}
+		offers = slices.DeleteFunc(offers, func(o string) bool { return o == enc })
+	}
 }
@@ -2,22 +2,26 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
 	"reMazarin/storage"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mdobak/go-xerrors"
 )
 
 // startTCPProxy launches a raw TCP listener for the given port→target mapping.
 // If a listener already exists on that port it is stopped first.
-func (p *Proxy) startTCPProxy(port, target, routeUrl string) {
+func (p *Proxy) startTCPProxy(port string, route ProxyRoute) {
 	if p.ctx == nil {
 		slog.Error("tcp proxy: context not initialized", "port", port)
 		return
 	}
+	target := newTCPTarget(route)
 
 	p.tcpMu.Lock()
 	if old, exists := p.tcpCancels[port]; exists {
@@ -30,7 +34,7 @@ func (p *Proxy) startTCPProxy(port, target, routeUrl string) {
 	p.Wg.Add(1)
 	go func() {
 		defer p.Wg.Done()
-		runTCPProxy(ctx, port, target, routeUrl, p.ErrChan)
+		runTCPProxy(ctx, port, target, route.Url, p.ErrChan)
 	}()
 }
 
@@ -47,7 +51,49 @@ func (p *Proxy) stopTCPProxy(port string) {
 	}
 }
 
-func runTCPProxy(ctx context.Context, port, target, routeUrl string, errChan chan error) {
+// tcpTarget is the backend of a TCP route together with its circuit breaker
+// (nil when not configured), the address dialled while the circuit is open and
+// the dialer bounding each connection attempt by the route's dial_timeout.
+type tcpTarget struct {
+	addr     string
+	cb       *breaker
+	fallback string
+	dialer   *net.Dialer
+}
+
+// newTCPTarget builds the backend of a TCP route, registering its breaker.
+func newTCPTarget(route ProxyRoute) tcpTarget {
+	fallback := route.Breaker.Fallback
+	if strings.HasPrefix(fallback, "/") || strings.HasPrefix(fallback, ".") {
+		fallback = "" // static fallbacks only apply to HTTP routes
+	}
+	return tcpTarget{
+		addr:     route.Target,
+		cb:       newBreakers(route.Url, []string{route.Target}, route.Breaker)[route.Target],
+		fallback: fallback,
+		dialer:   &net.Dialer{Timeout: seconds(route.Transport.DialTimeout, defaultDialTimeout)},
+	}
+}
+
+// dial connects to the backend, or to the fallback (if any) while the circuit
+// is open. It reports errCircuitOpen when neither may be used.
+func (t tcpTarget) dial(ctx context.Context) (net.Conn, error) {
+	if t.cb == nil {
+		return t.dialer.DialContext(ctx, "tcp", t.addr)
+	}
+	if !t.cb.allow() {
+		if t.fallback == "" {
+			return nil, errCircuitOpen
+		}
+		return t.dialer.DialContext(ctx, "tcp", t.fallback)
+	}
+	began := time.Now()
+	conn, err := t.dialer.DialContext(ctx, "tcp", t.addr)
+	t.cb.done(err == nil, time.Since(began))
+	return conn, err
+}
+
+func runTCPProxy(ctx context.Context, port string, target tcpTarget, routeUrl string, errChan chan error) {
 	ln, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		errChan <- xerrors.Newf("tcp listen on port %s: %w", port, err)
@@ -55,7 +101,7 @@ func runTCPProxy(ctx context.Context, port, target, routeUrl string, errChan cha
 	}
 	defer ln.Close()
 
-	slog.Info("tcp proxy started", "port", port, "target", target)
+	slog.Info("tcp proxy started", "port", port, "target", target.addr)
 
 	go func() {
 		<-ctx.Done()
@@ -82,64 +128,86 @@ func runTCPProxy(ctx context.Context, port, target, routeUrl string, errChan cha
 	slog.Info("tcp proxy stopped", "port", port)
 }
 
-func handleTCPConn(ctx context.Context, clientConn net.Conn, targetAddr, routeUrl string) {
+func handleTCPConn(ctx context.Context, clientConn net.Conn, target tcpTarget, routeUrl string) {
 	defer clientConn.Close()
 	clientIP, _, _ := net.SplitHostPort(clientConn.RemoteAddr().String())
+	// Each connection is one flow with its own request ID.
+	flowCtx := withRequestID(ctx, newRequestID())
 
 	// Banned IPs are dropped before any auth or backend work.
 	if IsBanned(clientIP) {
-		RecordEvent(clientIP, routeUrl, OutcomeBanned)
-		slog.Warn("tcp: connection rejected, banned", "client", clientIP, "route", routeUrl)
+		RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeBanned)
+		slog.WarnContext(flowCtx, "tcp: connection rejected, banned", "client", clientIP, "route", routeUrl)
+		return
+	}
+
+	if inMaintenance(flowCtx, routeUrl, clientIP) {
+		slog.InfoContext(flowCtx, "tcp: connection rejected, route in maintenance", "client", clientIP, "route", routeUrl)
 		return
 	}
 
-	authorized, accessUser := authorizeIP(routeUrl, clientIP)
+	authorized, accessUser, authMethod := authorizeIP(flowCtx, routeUrl, clientIP)
+	recordFlowAuth(routeUrl, "tcp", authorized, authMethod)
 	if !authorized {
-		logAccess(clientIP, "Unauthorized User", routeUrl)
-		RecordEvent(clientIP, routeUrl, OutcomeTCPRejected)
+		logAccess(flowCtx, "tcp", clientIP, "Unauthorized User", routeUrl, authMethod)
+		RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeTCPRejected)
 		RecordFailure(clientIP)
-		slog.Warn("tcp: connection rejected, not authorized", "client", clientIP, "route", routeUrl)
+		slog.WarnContext(flowCtx, "tcp: connection rejected, not authorized", "client", clientIP, "route", routeUrl)
 		return
 	}
 
 	if accessUser != "" {
 		SetTier(clientIP, storage.TierSignedIn)
 	}
-	RecordEvent(clientIP, routeUrl, OutcomeServed)
+	RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeServed)
 	if authStore != nil {
-		logAccess(clientIP, accessUser, routeUrl)
+		logAccess(flowCtx, "tcp", clientIP, accessUser, routeUrl, authMethod)
+	}
+	targetConn, err := target.dial(flowCtx)
+	if errors.Is(err, errCircuitOpen) {
+		RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeCircuitOpen)
+		slog.DebugContext(flowCtx, "tcp: connection dropped, circuit open", "target", target.addr, "client", clientIP)
+		return
 	}
-	targetConn, err := net.Dial("tcp", targetAddr)
 	if err != nil {
-		RecordEvent(clientIP, routeUrl, OutcomeDialError)
-		slog.Error("tcp: failed to connect to target", "target", targetAddr, "client", clientIP, "error", err)
+		RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeDialError)
+		slog.ErrorContext(flowCtx, "tcp: failed to connect to target", "target", target.addr, "client", clientIP, "error", err)
 		return
 	}
 	defer targetConn.Close()
 
-	copyCtx, cancelCopy := context.WithCancel(ctx)
+	fl := newFlow(flowCtx, "tcp", routeUrl, clientIP, accessUser)
+	defer fl.finish()
+
+	copyCtx, cancelCopy := context.WithCancel(flowCtx)
 	defer cancelCopy()
+	fl.track(cancelCopy)
 
 	// Close both connections when copy context is done (shutdown or half-close).
 	go func() {
 		<-copyCtx.Done()
+		if ctx.Err() != nil {
+			fl.setReason(FlowShutdown)
+		}
 		clientConn.Close()
 		targetConn.Close()
 	}()
 
+	// Whichever side stops first decides the close reason.
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
 		defer cancelCopy()
-		io.Copy(targetConn, clientConn)
+		io.Copy(countingWriter{targetConn, &fl.bytesIn}, clientConn)
+		fl.setReason(FlowClientEOF)
 	}()
 	go func() {
 		defer wg.Done()
 		defer cancelCopy()
-		io.Copy(clientConn, targetConn)
+		io.Copy(countingWriter{clientConn, &fl.bytesOut}, targetConn)
+		fl.setReason(FlowBackendEOF)
 	}()
 
 	wg.Wait()
-	slog.Debug("tcp: connection closed", "client", clientIP, "target", targetAddr)
 }
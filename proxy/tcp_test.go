@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reMazarin/storage"
+	"testing"
+	"time"
+)
+
+// TCP backends are dialled under the route's dial_timeout and the flow's
+// context, for the fallback as much as for the backend itself.
+func TestTCPTargetDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	if d := newTCPTarget(ProxyRoute{Url: "db:5432", Target: addr}).dialer.Timeout; d != defaultDialTimeout {
+		t.Fatalf("want the default dial timeout %s, got %s", defaultDialTimeout, d)
+	}
+	route := ProxyRoute{Url: "db:5432", Target: addr, Transport: storage.RouteTransport{DialTimeout: 3}}
+	target := newTCPTarget(route)
+	if target.dialer.Timeout != 3*time.Second {
+		t.Fatalf("want dial_timeout 3s, got %s", target.dialer.Timeout)
+	}
+
+	conn, err := target.dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := target.dial(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("a cancelled flow must not dial the backend, got %v", err)
+	}
+
+	route.Breaker = storage.RouteBreaker{ErrorPct: 50, MinRequests: 1, Fallback: addr}
+	target = newTCPTarget(route)
+	defer dropBreakers("db:5432")
+	target.cb.allow()
+	target.cb.done(false, 0)
+	if _, err := target.dial(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("a cancelled flow must not dial the fallback, got %v", err)
+	}
+}
@@ -61,6 +61,7 @@ func (p *Proxy) stopUDPProxy(port string) {
 type udpSession struct {
 	targetConn net.Conn
 	lastActive atomic.Int64 // unixnano; bumped on traffic in either direction
+	flow       *flow        // traffic and close reason, stored when the session ends
 }
 
 // runUDPProxy listens on a UDP port and relays datagrams to the target. Because
@@ -113,30 +114,37 @@ func runUDPProxy(ctx context.Context, port, target, routeUrl string, errChan cha
 		mu.Unlock()
 
 		if sess == nil {
+			flowCtx := withRequestID(ctx, newRequestID())
 			// First packet of a new flow — authorise the source IP once. For raw
 			// UDP there is no cookie/HTTP login, so IP session auth (or the static
 			// allowlist) is the only gate; this mirrors the TCP path.
 			if IsBanned(clientIP) {
-				RecordEvent(clientIP, routeUrl, OutcomeBanned)
-				slog.Warn("udp: packet dropped, banned", "client", clientIP, "route", routeUrl)
+				RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeBanned)
+				slog.WarnContext(flowCtx, "udp: packet dropped, banned", "client", clientIP, "route", routeUrl)
 				continue
 			}
-			authorized, accessUser := authorizeIP(routeUrl, clientIP)
+			if inMaintenance(flowCtx, routeUrl, clientIP) {
+				slog.DebugContext(flowCtx, "udp: packet dropped, route in maintenance", "client", clientIP, "route", routeUrl)
+				continue
+			}
+			authorized, accessUser, authMethod := authorizeIP(flowCtx, routeUrl, clientIP)
+			recordFlowAuth(routeUrl, "udp", authorized, authMethod)
 			if !authorized {
-				logAccess(clientIP, "Unauthorized User", routeUrl)
-				RecordEvent(clientIP, routeUrl, OutcomeTCPRejected)
+				logAccess(flowCtx, "udp", clientIP, "Unauthorized User", routeUrl, authMethod)
+				RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeTCPRejected)
 				RecordFailure(clientIP)
-				slog.Warn("udp: packet dropped, not authorized", "client", clientIP, "route", routeUrl)
+				slog.WarnContext(flowCtx, "udp: packet dropped, not authorized", "client", clientIP, "route", routeUrl)
 				continue
 			}
 			targetConn, err := net.Dial("udp", target)
 			if err != nil {
-				RecordEvent(clientIP, routeUrl, OutcomeDialError)
-				slog.Error("udp: failed to connect to target", "target", target, "client", clientIP, "error", err)
+				RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeDialError)
+				slog.ErrorContext(flowCtx, "udp: failed to connect to target", "target", target, "client", clientIP, "error", err)
 				continue
 			}
-			sess = &udpSession{targetConn: targetConn}
+			sess = &udpSession{targetConn: targetConn, flow: newFlow(flowCtx, "udp", routeUrl, clientIP, accessUser)}
 			sess.lastActive.Store(time.Now().UnixNano())
+			sess.flow.track(func() { targetConn.Close() })
 			mu.Lock()
 			sessions[clientKey] = sess
 			mu.Unlock()
@@ -144,14 +152,16 @@ func runUDPProxy(ctx context.Context, port, target, routeUrl string, errChan cha
 			if accessUser != "" {
 				SetTier(clientIP, storage.TierSignedIn)
 			}
-			RecordEvent(clientIP, routeUrl, OutcomeServed)
-			logAccess(clientIP, accessUser, routeUrl)
+			RecordEventContext(flowCtx, clientIP, routeUrl, OutcomeServed)
+			logAccess(flowCtx, "udp", clientIP, accessUser, routeUrl, authMethod)
 
 			wg.Add(1)
 			go func(s *udpSession, caddr net.Addr) {
 				defer wg.Done()
 				pumpUDPReplies(s, caddr, listenConn)
 				s.targetConn.Close()
+				s.flow.setReason(FlowBackendEOF) // unless reaped or shut down
+				s.flow.finish()
 				mu.Lock()
 				if sessions[caddr.String()] == s {
 					delete(sessions, caddr.String())
@@ -162,13 +172,16 @@ func runUDPProxy(ctx context.Context, port, target, routeUrl string, errChan cha
 
 		sess.lastActive.Store(time.Now().UnixNano())
 		if _, err := sess.targetConn.Write(buf[:n]); err != nil {
-			slog.Debug("udp: target write failed", "client", clientIP, "error", err)
+			slog.DebugContext(withRequestID(ctx, sess.flow.requestID), "udp: target write failed", "client", clientIP, "error", err)
+		} else {
+			sess.flow.bytesIn.Add(int64(n))
 		}
 	}
 
 	// Shutdown: close every target socket so the reply pumps unblock and exit.
 	mu.Lock()
 	for _, s := range sessions {
+		s.flow.setReason(FlowShutdown)
 		s.targetConn.Close()
 	}
 	mu.Unlock()
@@ -189,6 +202,7 @@ func pumpUDPReplies(s *udpSession, clientAddr net.Addr, listenConn net.PacketCon
 		if _, err := listenConn.WriteTo(buf[:n], clientAddr); err != nil {
 			return
 		}
+		s.flow.bytesOut.Add(int64(n))
 	}
 }
 
@@ -207,6 +221,7 @@ func reapUDPSessions(ctx context.Context, mu *sync.Mutex, sessions map[string]*u
 			mu.Lock()
 			for k, s := range sessions {
 				if s.lastActive.Load() < cutoff {
+					s.flow.setReason(FlowIdle)
 					s.targetConn.Close()
 					delete(sessions, k)
 				}
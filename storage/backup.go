@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Backups are SQLite files written with VACUUM INTO, which copies one
+// consistent snapshot while other connections keep reading and writing, so
+// the proxy does not have to stop. Scheduled and on-demand snapshots go into a
+// backup directory as remazarin-<UTC time>.db; restoring one is an offline
+// operation, and Restore refuses to run while anything has the database open.
+
+// ErrBackupUnsupported is returned by the backup functions on PostgreSQL,
+// which is backed up with its own tools (pg_dump, base backups).
+var ErrBackupUnsupported = errors.New("backups are only supported for SQLite; back up PostgreSQL with pg_dump")
+
+const (
+	backupPrefix = "remazarin-"
+	backupSuffix = ".db"
+	backupLayout = "20060102-150405.000000"
+	// Backups taken before names had microseconds.
+	backupLayoutSeconds = "20060102-150405"
+)
+
+// BackupFile is one snapshot in a backup directory.
+type BackupFile struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist yet.
+func (s *Storage) Backup(ctx context.Context, path string) error {
+	if s.db.dialect != sqliteDialect {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return xerrors.Newf("backup %s already exists", path)
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return xerrors.Newf("vacuum into %s: %w", path, err)
+	}
+	return nil
+}
+
+// Snapshot writes a new timestamped backup into dir, creating it if needed.
+// The file only appears under its final name once complete.
+func (s *Storage) Snapshot(ctx context.Context, dir string) (*BackupFile, error) {
+	if s.db.dialect != sqliteDialect {
+		return nil, ErrBackupUnsupported
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, xerrors.Newf("create backup dir: %w", err)
+	}
+	// Two snapshots within the same microsecond still get their own names.
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	name, path := "", ""
+	for {
+		name = backupPrefix + now.Format(backupLayout) + backupSuffix
+		path = filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			break
+		}
+		now = now.Add(time.Microsecond)
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp) // left over from an interrupted run
+	if err := s.Backup(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, xerrors.Newf("finish backup: %w", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, xerrors.Newf("stat backup: %w", err)
+	}
+	slog.Info("database backed up", "path", path, "bytes", fi.Size())
+	return &BackupFile{Name: name, Path: path, Size: fi.Size(), CreatedAt: now}, nil
+}
+
+// ListBackups returns the snapshots in dir, newest first. A missing directory
+// has none.
+func ListBackups(dir string) ([]BackupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Newf("read backup dir: %w", err)
+	}
+	var out []BackupFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix)
+		created, err := time.Parse(backupLayout, ts)
+		if err != nil {
+			if created, err = time.Parse(backupLayoutSeconds, ts); err != nil {
+				continue
+			}
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, BackupFile{Name: name, Path: filepath.Join(dir, name), Size: info.Size(), CreatedAt: created})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+// PruneBackups deletes all but the newest keep snapshots in dir and returns
+// how many it deleted. keep <= 0 keeps everything.
+func PruneBackups(dir string, keep int) (int, error) {
+	if keep <= 0 {
+		return 0, nil
+	}
+	files, err := ListBackups(dir)
+	if err != nil || len(files) <= keep {
+		return 0, err
+	}
+	n := 0
+	for _, f := range files[keep:] {
+		if err := os.Remove(f.Path); err != nil {
+			return n, xerrors.Newf("delete old backup: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// LatestMigration is the schema version this build migrates SQLite databases to.
+func LatestMigration() int {
+	files, _ := migrationFiles(sqliteDialect)
+	if len(files) == 0 {
+		return 0
+	}
+	return files[len(files)-1].Version
+}
+
+// CheckBackup opens a backup read-only and returns its schema version. It
+// fails unless the file passes SQLite's integrity check and is a reMazarin
+// database no newer than this build; older ones are migrated on next start.
+func CheckBackup(ctx context.Context, path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, xerrors.Newf("open backup: %w", err)
+	}
+	db, err := sql.Open(sqliteDialect.driver, "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, xerrors.Newf("open backup: %w", err)
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		return 0, xerrors.Newf("%s is not a SQLite database: %w", path, err)
+	}
+	if integrity != "ok" {
+		return 0, xerrors.Newf("%s failed the integrity check: %s", path, integrity)
+	}
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, xerrors.Newf("%s has no schema_migrations table; not a reMazarin database: %w", path, err)
+	}
+	if !version.Valid {
+		return 0, xerrors.Newf("%s has no applied migrations", path)
+	}
+	if latest := LatestMigration(); int(version.Int64) > latest {
+		return 0, xerrors.Newf("%s is at schema version %d, newer than this build's %d", path, version.Int64, latest)
+	}
+	return int(version.Int64), nil
+}
+
+// Restore replaces the SQLite database at dbPath with the backup at path,
+// after checking it with CheckBackup. The current database, if any, is first
+// saved next to it as <dbPath>.pre-restore-<UTC time>, whose path is returned.
+// Nothing may have the database open: Restore holds an exclusive lock on it
+// until the backup is in place, and fails if a running proxy prevents that.
+func Restore(ctx context.Context, path, dbPath string) (string, error) {
+	if dialectFor(dbPath) != sqliteDialect {
+		return "", ErrBackupUnsupported
+	}
+	if strings.HasPrefix(dbPath, "file:") || strings.Contains(dbPath, "?") {
+		return "", xerrors.Newf("restore needs the database as a plain file path, not %q", dbPath)
+	}
+	version, err := CheckBackup(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	saved := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		lock, err := lockSQLite(ctx, dbPath)
+		if err != nil {
+			return "", err
+		}
+		// Closed only once the backup has replaced the file: the lock is on
+		// the old one, so closing it then touches nothing restored.
+		defer lock.Close()
+		saved = dbPath + ".pre-restore-" + time.Now().UTC().Format(backupLayoutSeconds)
+		if _, err := lock.ExecContext(ctx, `VACUUM INTO ?`, saved); err != nil {
+			return "", xerrors.Newf("save current database: %w", err)
+		}
+	}
+
+	tmp := dbPath + ".restoring"
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return saved, xerrors.Newf("copy backup: %w", err)
+	}
+	// The old database's WAL and shared-memory files must not be replayed
+	// onto the restored one.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp)
+			return saved, xerrors.Newf("remove %s: %w", dbPath+suffix, err)
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		os.Remove(tmp)
+		return saved, xerrors.Newf("swap in backup: %w", err)
+	}
+	slog.Info("database restored", "from", path, "to", dbPath, "schema_version", version, "previous", saved)
+	return saved, nil
+}
+
+// lockSQLite opens the database on a single connection holding an exclusive
+// lock, which SQLite keeps until the connection closes. It fails at once if
+// any other connection has the database open, such as a running proxy's.
+func lockSQLite(ctx context.Context, dbPath string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDialect.driver, dbPath+"?_pragma=busy_timeout(0)&_pragma=locking_mode(EXCLUSIVE)")
+	if err != nil {
+		return nil, xerrors.Newf("open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	// In exclusive locking mode the lock taken by this write transaction
+	// outlives it.
+	if _, err := db.ExecContext(ctx, `BEGIN EXCLUSIVE`); err != nil {
+		db.Close()
+		return nil, xerrors.Newf("%s is in use; stop the proxy before restoring: %w", dbPath, err)
+	}
+	if _, err := db.ExecContext(ctx, `COMMIT`); err != nil {
+		db.Close()
+		return nil, xerrors.Newf("lock %s: %w", dbPath, err)
+	}
+	return db, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
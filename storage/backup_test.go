@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "state.db")
+	s, err := New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(ctx, "alice", "pw"); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := filepath.Join(dir, "backups")
+	b, err := s.Snapshot(ctx, backups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := CheckBackup(ctx, b.Path); err != nil || v != LatestMigration() {
+		t.Fatalf("check backup: version %d, %v", v, err)
+	}
+
+	// Changes after the snapshot are undone by the restore.
+	if _, err := s.CreateUser(ctx, "bob", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	saved, err := Restore(ctx, b.Path, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(saved); err != nil {
+		t.Errorf("previous database not saved: %v", err)
+	}
+
+	s, err = New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Authenticate(ctx, "alice", "pw"); err != nil {
+		t.Errorf("alice missing after restore: %v", err)
+	}
+	if _, err := s.Authenticate(ctx, "bob", "pw"); err == nil {
+		t.Error("bob survived the restore")
+	}
+}
+
+// Snapshots taken back to back, within the same second, are kept apart.
+func TestSnapshotsInSameSecond(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := New(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	backups := filepath.Join(dir, "backups")
+	var names []string
+	for range 3 {
+		b, err := s.Snapshot(ctx, backups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, b.Name)
+	}
+	files, err := ListBackups(backups)
+	if err != nil || len(files) != 3 {
+		t.Fatalf("listed %+v, %v; took %v", files, err, names)
+	}
+	if files[0].Name != names[2] || files[2].Name != names[0] {
+		t.Errorf("want newest first, got %+v", files)
+	}
+}
+
+// Restore refuses while the database is open, as by a running proxy, and
+// leaves it untouched.
+func TestRestoreRefusesOpenDatabase(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "state.db")
+	s, err := New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b, err := s.Snapshot(ctx, filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(ctx, "alice", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(ctx, b.Path, dbPath); err == nil {
+		t.Fatal("restore succeeded under an open database")
+	}
+	if _, err := s.Authenticate(ctx, "alice", "pw"); err != nil {
+		t.Errorf("database changed by the refused restore: %v", err)
+	}
+	if m, _ := filepath.Glob(dbPath + ".pre-restore-*"); len(m) != 0 {
+		t.Errorf("refused restore left %v", m)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"remazarin-20260101-000000.db", "remazarin-20260102-000000.db", "remazarin-20260102-000000.500000.db",
+		"remazarin-20260104-000000.db.tmp", "notes.txt",
+	} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o600)
+	}
+	if n, err := PruneBackups(dir, 2); err != nil || n != 1 {
+		t.Fatalf("pruned %d, %v", n, err)
+	}
+	files, _ := ListBackups(dir)
+	if len(files) != 2 || files[0].Name != "remazarin-20260102-000000.500000.db" || files[1].Name != "remazarin-20260102-000000.db" {
+		t.Errorf("left %+v", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Error("unrelated file deleted")
+	}
+}
+
+func TestCheckBackupRejects(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	junk := filepath.Join(dir, "junk.db")
+	os.WriteFile(junk, []byte("not a database at all, just some text"), 0o600)
+	if _, err := CheckBackup(ctx, junk); err == nil {
+		t.Error("junk file accepted")
+	}
+
+	empty := filepath.Join(dir, "empty.db")
+	db, _ := sql.Open(sqliteDialect.driver, empty)
+	db.Exec(`CREATE TABLE t (x INTEGER)`)
+	db.Close()
+	if _, err := CheckBackup(ctx, empty); err == nil {
+		t.Error("database without schema_migrations accepted")
+	}
+
+	newer := filepath.Join(dir, "newer.db")
+	s, err := New(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'from_the_future.sql')`, LatestMigration()+1)
+	s.Close()
+	if _, err := CheckBackup(ctx, newer); err == nil {
+		t.Error("newer schema accepted")
+	}
+	if _, err := Restore(ctx, newer, filepath.Join(dir, "target.db")); err == nil {
+		t.Error("restore of a newer schema went ahead")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "target.db")); err == nil {
+		t.Error("rejected restore created the target")
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dialect covers what differs between the SQL databases Storage runs on.
+// Queries are written for SQLite with ? placeholders; timedDB rebinds them for
+// PostgreSQL, and the few functions with no common spelling come from the
+// methods below.
+type dialect struct {
+	name       string // "sqlite" or "postgres"
+	driver     string // database/sql driver name
+	migrations string // embedded migrations directory
+}
+
+var (
+	sqliteDialect   = &dialect{name: "sqlite", driver: "sqlite", migrations: "migrations"}
+	postgresDialect = &dialect{name: "postgres", driver: "pgx", migrations: "migrations/postgres"}
+)
+
+// dialectFor picks the database from the database setting: postgres:// and
+// postgresql:// URLs select PostgreSQL, anything else is a SQLite file path.
+func dialectFor(dsn string) *dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgresDialect
+	}
+	return sqliteDialect
+}
+
+// rebind rewrites ? placeholders to PostgreSQL's $1, $2, ... Question marks
+// inside quoted strings, quoted identifiers and -- comments are left alone.
+func (d *dialect) rebind(query string) string {
+	if d != postgresDialect || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte // ', " or - while inside a string, identifier or comment
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote == '-':
+			if c == '\n' {
+				quote = 0
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			quote = '-'
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// now is the current time in SQL.
+func (d *dialect) now() string {
+	if d == postgresDialect {
+		return "now()"
+	}
+	return "datetime('now')"
+}
+
+// daysAgo is the time a number of days back; it takes the days as its one
+// placeholder.
+func (d *dialect) daysAgo() string {
+	if d == postgresDialect {
+		return "now() - make_interval(days => ?)"
+	}
+	return "datetime('now', '-' || ? || ' days')"
+}
+
+// groupConcat joins expr over a group with commas.
+func (d *dialect) groupConcat(expr string) string {
+	if d == postgresDialect {
+		return "string_agg(" + expr + "::text, ',')"
+	}
+	return "GROUP_CONCAT(" + expr + ")"
+}
+
+// typeOf is the storage type of expr, for diagnostics.
+func (d *dialect) typeOf(expr string) string {
+	if d == postgresDialect {
+		return "pg_typeof(" + expr + ")::text"
+	}
+	return "typeof(" + expr + ")"
+}
@@ -96,7 +96,7 @@ func (s *Storage) GetUserGroups(ctx context.Context, userID int) ([]Group, error
 
 func (s *Storage) AddUserToGroup(ctx context.Context, userID, groupID int) error {
 	_, err := s.db.ExecContext(ctx,
-		`INSERT OR IGNORE INTO user_groups (user_id, group_id) VALUES (?, ?)`, userID, groupID)
+		`INSERT INTO user_groups (user_id, group_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, userID, groupID)
 	if err != nil {
 		return xerrors.Newf("add user to group: %w", err)
 	}
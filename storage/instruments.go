@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// timedDB is the database handle every Storage method goes through. It rebinds
+// each statement for the dialect and records how long it takes in the
+// remazarin.db.duration histogram, labelled with the Storage method that ran
+// it. For queries that is the time to the first row; reading the rows is not
+// included.
+type timedDB struct {
+	*sql.DB
+	dialect  *dialect
+	duration metric.Float64Histogram
+}
+
+func newTimedDB(db *sql.DB, d *dialect) *timedDB {
+	// The global meter forwards to the real provider once main installs one and
+	// is a no-op otherwise.
+	h, _ := otel.Meter("reMazarin/storage").Float64Histogram("remazarin.db.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Database statement latency, by Storage method and statement kind"),
+		metric.WithExplicitBucketBoundaries(0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5))
+	return &timedDB{DB: db, dialect: d, duration: h}
+}
+
+func (d *timedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer d.observe(ctx, query, time.Now())
+	return d.DB.ExecContext(ctx, d.dialect.rebind(query), args...)
+}
+
+func (d *timedDB) Exec(query string, args ...any) (sql.Result, error) {
+	defer d.observe(context.Background(), query, time.Now())
+	return d.DB.Exec(d.dialect.rebind(query), args...)
+}
+
+func (d *timedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer d.observe(ctx, query, time.Now())
+	return d.DB.QueryContext(ctx, d.dialect.rebind(query), args...)
+}
+
+func (d *timedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	defer d.observe(context.Background(), query, time.Now())
+	return d.DB.Query(d.dialect.rebind(query), args...)
+}
+
+func (d *timedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer d.observe(ctx, query, time.Now())
+	return d.DB.QueryRowContext(ctx, d.dialect.rebind(query), args...)
+}
+
+func (d *timedDB) QueryRow(query string, args ...any) *sql.Row {
+	defer d.observe(context.Background(), query, time.Now())
+	return d.DB.QueryRow(d.dialect.rebind(query), args...)
+}
+
+// Begin starts a transaction whose statements are rebound and timed like the
+// handle's own.
+func (d *timedDB) Begin() (*timedTx, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &timedTx{Tx: tx, db: d}, nil
+}
+
+// timedTx is a transaction on a timedDB.
+type timedTx struct {
+	*sql.Tx
+	db *timedDB
+}
+
+func (t *timedTx) Exec(query string, args ...any) (sql.Result, error) {
+	defer t.db.observe(context.Background(), query, time.Now())
+	return t.Tx.Exec(t.db.dialect.rebind(query), args...)
+}
+
+func (t *timedTx) QueryRow(query string, args ...any) *sql.Row {
+	defer t.db.observe(context.Background(), query, time.Now())
+	return t.Tx.QueryRow(t.db.dialect.rebind(query), args...)
+}
+
+// observe records one statement. It runs deferred from the wrappers above, so
+// the Storage method is two frames up.
+func (d *timedDB) observe(ctx context.Context, query string, start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	method := "unknown"
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			method = funcName(fn.Name())
+		}
+	}
+	d.duration.Record(ctx, elapsed, metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("statement", statementKind(query)),
+	))
+}
+
+// funcName reduces a symbol like "reMazarin/storage.(*Storage).GetUser.func1"
+// to the function or method name, "GetUser".
+func funcName(symbol string) string {
+	symbol = symbol[strings.LastIndexByte(symbol, '/')+1:]
+	parts := strings.Split(symbol, ".")
+	for _, p := range parts[1:] {
+		if !strings.HasPrefix(p, "(") {
+			return p
+		}
+	}
+	return symbol
+}
+
+// statementKind returns the lower-cased first keyword of query, e.g. "select".
+func statementKind(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.ToLower(fields[0])
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestTimedDBRecordsCallingMethod(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir() + "/timed.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(ctx)
+	s.db.duration, _ = mp.Meter("test").Float64Histogram("remazarin.db.duration")
+
+	s.CountActiveSessions(ctx)
+	s.CountActiveSessions(ctx)
+	s.AdminDeleteSession(ctx, 42)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[[2]string]uint64{}
+	for _, dp := range rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64]).DataPoints {
+		m, _ := dp.Attributes.Value(attribute.Key("method"))
+		st, _ := dp.Attributes.Value(attribute.Key("statement"))
+		counts[[2]string{m.AsString(), st.AsString()}] = dp.Count
+	}
+	for k, want := range map[[2]string]uint64{
+		{"CountActiveSessions", "select"}: 2,
+		{"AdminDeleteSession", "select"}:  1,
+		{"AdminDeleteSession", "delete"}:  1,
+	} {
+		if counts[k] != want {
+			t.Errorf("%v: %d statements recorded, want %d (all: %v)", k, counts[k], want, counts)
+		}
+	}
+}
+
+func TestFuncName(t *testing.T) {
+	for symbol, want := range map[string]string{
+		"reMazarin/storage.(*Storage).GetUser":       "GetUser",
+		"reMazarin/storage.(*Storage).GetUser.func1": "GetUser",
+		"reMazarin/storage.statementKind":            "statementKind",
+	} {
+		if got := funcName(symbol); got != want {
+			t.Errorf("funcName(%q) = %q, want %q", symbol, got, want)
+		}
+	}
+}
@@ -47,7 +47,7 @@ func (s *Storage) GetActiveSessions(ctx context.Context) ([]SessionInfo, error)
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT s.id, s.user_id, u.username, s.client_ip, s.created_at, s.expires_at
 		FROM sessions s JOIN users u ON u.id = s.user_id
-		WHERE s.expires_at > datetime('now')
+		WHERE s.expires_at > `+s.db.dialect.now()+`
 		ORDER BY s.created_at DESC`)
 	if err != nil {
 		return nil, xerrors.Newf("query sessions: %w", err)
@@ -62,11 +62,21 @@ func (s *Storage) GetActiveSessions(ctx context.Context) ([]SessionInfo, error)
 	return out, rows.Err()
 }
 
+// CountActiveSessions returns how many sessions have not expired.
+func (s *Storage) CountActiveSessions(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions WHERE expires_at > `+s.db.dialect.now()).Scan(&n)
+	if err != nil {
+		return 0, xerrors.Newf("count sessions: %w", err)
+	}
+	return n, nil
+}
+
 func (s *Storage) GetUserSessions(ctx context.Context, userID int) ([]SessionInfo, error) {
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT s.id, s.user_id, u.username, s.client_ip, s.created_at, s.expires_at
 		FROM sessions s JOIN users u ON u.id = s.user_id
-		WHERE s.user_id = ? AND s.expires_at > datetime('now')
+		WHERE s.user_id = ? AND s.expires_at > `+s.db.dialect.now()+`
 		ORDER BY s.created_at DESC`, userID)
 	if err != nil {
 		return nil, xerrors.Newf("query user sessions: %w", err)
@@ -95,31 +105,57 @@ func (s *Storage) DeleteSessionByID(ctx context.Context, sessionID, ownerUserID
 	return nil
 }
 
-// AdminDeleteSession force-deletes any session by ID. Used by admins.
-func (s *Storage) AdminDeleteSession(ctx context.Context, sessionID int) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, sessionID)
-	return err
+// AdminDeleteSession force-deletes any session by ID and returns the username
+// it belonged to ("" if there was no such session). Used by admins.
+func (s *Storage) AdminDeleteSession(ctx context.Context, sessionID int) (string, error) {
+	var username string
+	s.db.QueryRowContext(ctx, `
+		SELECT u.username FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = ?`, sessionID).Scan(&username) // stays "" for an unknown ID
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, sessionID); err != nil {
+		return "", xerrors.Newf("delete session: %w", err)
+	}
+	return username, nil
 }
 
 // ── access log ────────────────────────────────────────────────────────────────
 
+// AccessEvent is one access-log row: an HTTP request, or a TCP/UDP flow
+// (which leaves the HTTP fields empty).
 type AccessEvent struct {
-	ID        int       `json:"id"`
-	IP        string    `json:"ip"`
-	Username  string    `json:"username"`
-	RouteUrl  string    `json:"route_url"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int       `json:"id,omitempty"`
+	IP         string    `json:"ip"`
+	Username   string    `json:"username"`
+	RouteUrl   string    `json:"route_url"`
+	RequestID  string    `json:"request_id"`
+	Protocol   string    `json:"protocol"` // "http", "tcp" or "udp"
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"` // with the query string
+	Proto      string    `json:"proto,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	UpstreamMs float64   `json:"upstream_ms"` // time waiting on the backend; 0 = not contacted
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+	AuthMethod string    `json:"auth_method,omitempty"` // how access was decided, e.g. "cookie"
+	CreatedAt  time.Time `json:"created_at"`
 }
 
-func (s *Storage) LogAccess(ctx context.Context, ip, username, routeUrl string) {
+func (s *Storage) LogAccess(ctx context.Context, e AccessEvent) {
 	s.db.ExecContext(ctx,
-		`INSERT INTO access_log (ip, username, route_url) VALUES (?, ?, ?)`,
-		ip, username, routeUrl)
+		`INSERT INTO access_log (ip, username, route_url, request_id, protocol, method, path, proto, status,
+			bytes, duration_ms, upstream_ms, user_agent, referer, auth_method)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.IP, e.Username, e.RouteUrl, e.RequestID, e.Protocol, e.Method, e.Path, e.Proto, e.Status,
+		e.Bytes, e.DurationMs, e.UpstreamMs, e.UserAgent, e.Referer, e.AuthMethod)
 }
 
 func (s *Storage) GetRecentAccess(ctx context.Context, limit int) ([]AccessEvent, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, ip, username, route_url, created_at FROM access_log ORDER BY created_at DESC LIMIT ?`, limit)
+		`SELECT id, ip, username, route_url, request_id, protocol, method, path, proto, status, bytes,
+			duration_ms, upstream_ms, user_agent, referer, auth_method, created_at
+		FROM access_log ORDER BY created_at DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, xerrors.Newf("query access_log: %w", err)
 	}
@@ -127,13 +163,104 @@ func (s *Storage) GetRecentAccess(ctx context.Context, limit int) ([]AccessEvent
 	var out []AccessEvent
 	for rows.Next() {
 		var e AccessEvent
-		rows.Scan(&e.ID, &e.IP, &e.Username, &e.RouteUrl, &e.CreatedAt)
+		rows.Scan(&e.ID, &e.IP, &e.Username, &e.RouteUrl, &e.RequestID, &e.Protocol, &e.Method, &e.Path, &e.Proto, &e.Status, &e.Bytes,
+			&e.DurationMs, &e.UpstreamMs, &e.UserAgent, &e.Referer, &e.AuthMethod, &e.CreatedAt)
 		out = append(out, e)
 	}
 	return out, rows.Err()
 }
 
-func (s *Storage) CleanupOldAccessLog(ctx context.Context) {
+// CleanupOldAccessLog keeps the newest maxRows access-log rows and drops rows
+// older than maxAgeDays. Zero leaves that limit off.
+func (s *Storage) CleanupOldAccessLog(ctx context.Context, maxRows, maxAgeDays int) {
+	if maxAgeDays > 0 {
+		s.db.ExecContext(ctx,
+			`DELETE FROM access_log WHERE created_at < `+s.db.dialect.daysAgo(), maxAgeDays)
+	}
+	if maxRows > 0 {
+		s.db.ExecContext(ctx,
+			`DELETE FROM access_log WHERE id NOT IN (SELECT id FROM access_log ORDER BY created_at DESC LIMIT ?)`, maxRows)
+	}
+}
+
+// ── flows ─────────────────────────────────────────────────────────────────────
+
+// Flow is one finished TCP connection or UDP session. Bytes are counted from
+// the client's side: in = client → backend, out = backend → client.
+type Flow struct {
+	ID          int       `json:"id,omitempty"`
+	RequestID   string    `json:"request_id"`
+	RouteUrl    string    `json:"route_url"`
+	Protocol    string    `json:"protocol"` // "tcp" or "udp"
+	IP          string    `json:"ip"`
+	Username    string    `json:"username"` // "" unless authorized by an IP session
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	CloseReason string    `json:"close_reason"`
+}
+
+// FlowTotal sums the stored flows of one user on one route.
+type FlowTotal struct {
+	RouteUrl   string `json:"route_url"`
+	Username   string `json:"username"`
+	Flows      int64  `json:"flows"`
+	DurationMs int64  `json:"duration_ms"`
+	BytesIn    int64  `json:"bytes_in"`
+	BytesOut   int64  `json:"bytes_out"`
+}
+
+func (s *Storage) RecordFlow(ctx context.Context, f Flow) {
+	s.db.ExecContext(ctx,
+		`INSERT INTO flows (request_id, route_url, protocol, ip, username, started_at, ended_at,
+			duration_ms, bytes_in, bytes_out, close_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.RequestID, f.RouteUrl, f.Protocol, f.IP, f.Username, f.StartedAt, f.EndedAt,
+		f.DurationMs, f.BytesIn, f.BytesOut, f.CloseReason)
+}
+
+func (s *Storage) GetRecentFlows(ctx context.Context, limit int) ([]Flow, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, request_id, route_url, protocol, ip, username, started_at, ended_at,
+			duration_ms, bytes_in, bytes_out, close_reason
+		FROM flows ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, xerrors.Newf("query flows: %w", err)
+	}
+	defer rows.Close()
+	var out []Flow
+	for rows.Next() {
+		var f Flow
+		rows.Scan(&f.ID, &f.RequestID, &f.RouteUrl, &f.Protocol, &f.IP, &f.Username, &f.StartedAt, &f.EndedAt,
+			&f.DurationMs, &f.BytesIn, &f.BytesOut, &f.CloseReason)
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// GetFlowTotals sums the stored flows per route and user, busiest first.
+func (s *Storage) GetFlowTotals(ctx context.Context) ([]FlowTotal, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT route_url, username, COUNT(*), SUM(duration_ms), SUM(bytes_in), SUM(bytes_out)
+		FROM flows GROUP BY route_url, username
+		ORDER BY SUM(bytes_in) + SUM(bytes_out) DESC`)
+	if err != nil {
+		return nil, xerrors.Newf("query flow totals: %w", err)
+	}
+	defer rows.Close()
+	var out []FlowTotal
+	for rows.Next() {
+		var t FlowTotal
+		rows.Scan(&t.RouteUrl, &t.Username, &t.Flows, &t.DurationMs, &t.BytesIn, &t.BytesOut)
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// CleanupOldFlows keeps the newest maxRows flows.
+func (s *Storage) CleanupOldFlows(ctx context.Context, maxRows int) {
 	s.db.ExecContext(ctx,
-		`DELETE FROM access_log WHERE id NOT IN (SELECT id FROM access_log ORDER BY created_at DESC LIMIT 5000)`)
+		`DELETE FROM flows WHERE id NOT IN (SELECT id FROM flows ORDER BY id DESC LIMIT ?)`, maxRows)
 }
@@ -0,0 +1,11 @@
+-- Per-route backend transport tuning for proxy routes. Every value is in seconds
+-- (or a connection count) and 0 means "use the built-in default", so existing
+-- routes keep their current behaviour. Config routes take these from
+-- config.toml on every sync; UI routes are edited in the admin panel.
+ALTER TABLE proxy_routes ADD COLUMN dial_timeout            INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN tls_handshake_timeout   INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN response_header_timeout INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN request_timeout         INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN idle_conn_timeout       INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN max_idle_conns          INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN max_idle_conns_per_host INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,10 @@
+-- Per-route retry/failover policy for proxy routes. retry_attempts is the total
+-- number of tries per request; 0 or 1 disables retries, so existing routes keep
+-- their single-attempt behaviour. retry_on is a comma-separated list of
+-- retryable conditions ("connect", "502", "503", "504"); empty means all of
+-- them. Only idempotent methods are retried unless retry_non_idempotent is set.
+ALTER TABLE proxy_routes ADD COLUMN retry_attempts       INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN retry_on             TEXT    NOT NULL DEFAULT '';
+ALTER TABLE proxy_routes ADD COLUMN retry_backoff_ms     INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN retry_budget_pct     INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN retry_non_idempotent BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,11 @@
+-- Per-route circuit breaker, applied to each backend of the route separately.
+-- breaker_error_pct is the share of failed (or slower than breaker_latency_ms)
+-- calls in the rolling window that opens the circuit; the breaker is off while
+-- both are 0, so existing routes are unaffected. breaker_fallback is an
+-- optional backend address or static path served while the circuit is open.
+ALTER TABLE proxy_routes ADD COLUMN breaker_error_pct    INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN breaker_latency_ms   INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN breaker_window_sec   INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN breaker_min_requests INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN breaker_open_sec     INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN breaker_fallback     TEXT    NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- Opt-in per-route response cache for proxy routes. cache_default_ttl (seconds)
+-- is the freshness of responses whose backend sends no Cache-Control/Expires;
+-- 0 stores them only for revalidation.
+ALTER TABLE proxy_routes ADD COLUMN cache_enabled     BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE proxy_routes ADD COLUMN cache_default_ttl INTEGER NOT NULL DEFAULT 0;
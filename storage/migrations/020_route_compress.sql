@@ -0,0 +1,6 @@
+-- Per-route response compression for proxy and static routes. compress_types is
+-- a comma-separated MIME allowlist ('' = built-in list) and compress_min_size
+-- the smallest body, in bytes, worth compressing (0 = built-in default).
+ALTER TABLE proxy_routes ADD COLUMN compress_enabled  BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE proxy_routes ADD COLUMN compress_types    TEXT    NOT NULL DEFAULT '';
+ALTER TABLE proxy_routes ADD COLUMN compress_min_size INTEGER NOT NULL DEFAULT 0;
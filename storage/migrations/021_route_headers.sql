@@ -0,0 +1,5 @@
+-- Per-route header rewrite rules for HTTP routes, one rule per line:
+-- "<set|add|append|remove> <Header-Name> [value]". request_headers apply to the
+-- request sent to the backend, response_headers to the response sent back.
+ALTER TABLE proxy_routes ADD COLUMN request_headers  TEXT NOT NULL DEFAULT '';
+ALTER TABLE proxy_routes ADD COLUMN response_headers TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- Per-route security-header profile for HTTP routes. security_profile names a
+-- built-in or config-defined profile ('' = none); security_headers holds
+-- "Name: value" override lines, where an empty value drops a profile header.
+ALTER TABLE proxy_routes ADD COLUMN security_profile TEXT NOT NULL DEFAULT '';
+ALTER TABLE proxy_routes ADD COLUMN security_headers TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Canonical-host redirects: a comma-separated list of extra hosts (e.g.
+-- www.example.com) that answer on the route's listener with a redirect to the
+-- route's own host.
+ALTER TABLE proxy_routes ADD COLUMN redirect_hosts TEXT NOT NULL DEFAULT '';
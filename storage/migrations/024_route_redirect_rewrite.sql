@@ -0,0 +1,8 @@
+-- Redirect routes (type 'redirect'): the status code and an optional path
+-- pattern whose capture groups the target URL template may use.
+ALTER TABLE proxy_routes ADD COLUMN redirect_code INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN redirect_match TEXT NOT NULL DEFAULT '';
+
+-- Upstream path rewrite rules of proxy routes, one "<pattern> <replacement>"
+-- per line.
+ALTER TABLE proxy_routes ADD COLUMN rewrite TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- Per-route maintenance mode. Runtime state like the access settings: set from
+-- the admin panel, never overwritten by config sync.
+ALTER TABLE proxy_routes ADD COLUMN maintenance_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE proxy_routes ADD COLUMN maintenance_start TEXT NOT NULL DEFAULT '';   -- RFC 3339; '' = now
+ALTER TABLE proxy_routes ADD COLUMN maintenance_end TEXT NOT NULL DEFAULT '';     -- RFC 3339; '' = until disabled
+ALTER TABLE proxy_routes ADD COLUMN maintenance_page TEXT NOT NULL DEFAULT '';    -- inline HTML or a file/directory path
+ALTER TABLE proxy_routes ADD COLUMN maintenance_retry_after INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxy_routes ADD COLUMN maintenance_bypass_ips TEXT NOT NULL DEFAULT '';
+ALTER TABLE proxy_routes ADD COLUMN maintenance_bypass_groups TEXT NOT NULL DEFAULT '';
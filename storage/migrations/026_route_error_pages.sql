@@ -0,0 +1,3 @@
+-- Per-route error-page directory: <code>.html / default.html templates that
+-- override the global [error_pages] for the route's denials and failures.
+ALTER TABLE proxy_routes ADD COLUMN error_pages TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Request ID of the HTTP request or TCP/UDP flow an access-log row belongs to,
+-- matching the X-Request-Id header, the slog request_id and the event ring.
+ALTER TABLE access_log ADD COLUMN request_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- Request details of each access_log row. Raw TCP/UDP flows leave the HTTP
+-- fields empty.
+ALTER TABLE access_log ADD COLUMN protocol    TEXT    NOT NULL DEFAULT 'http';
+ALTER TABLE access_log ADD COLUMN method      TEXT    NOT NULL DEFAULT '';
+ALTER TABLE access_log ADD COLUMN path        TEXT    NOT NULL DEFAULT '';
+ALTER TABLE access_log ADD COLUMN proto       TEXT    NOT NULL DEFAULT '';
+ALTER TABLE access_log ADD COLUMN status      INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE access_log ADD COLUMN bytes       INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE access_log ADD COLUMN duration_ms REAL    NOT NULL DEFAULT 0;
+ALTER TABLE access_log ADD COLUMN upstream_ms REAL    NOT NULL DEFAULT 0;
+ALTER TABLE access_log ADD COLUMN user_agent  TEXT    NOT NULL DEFAULT '';
+ALTER TABLE access_log ADD COLUMN referer     TEXT    NOT NULL DEFAULT '';
+ALTER TABLE access_log ADD COLUMN auth_method TEXT    NOT NULL DEFAULT '';
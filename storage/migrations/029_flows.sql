@@ -0,0 +1,17 @@
+-- One row per finished TCP connection or UDP session: who used which raw
+-- route, for how long, how much traffic it carried and why it ended.
+CREATE TABLE flows (
+    id           INTEGER  PRIMARY KEY AUTOINCREMENT,
+    request_id   TEXT     NOT NULL DEFAULT '',
+    route_url    TEXT     NOT NULL,
+    protocol     TEXT     NOT NULL,
+    ip           TEXT     NOT NULL,
+    username     TEXT     NOT NULL DEFAULT '',
+    started_at   DATETIME NOT NULL,
+    ended_at     DATETIME NOT NULL,
+    duration_ms  INTEGER  NOT NULL DEFAULT 0,
+    bytes_in     INTEGER  NOT NULL DEFAULT 0,
+    bytes_out    INTEGER  NOT NULL DEFAULT 0,
+    close_reason TEXT     NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_flows_route_user ON flows(route_url, username);
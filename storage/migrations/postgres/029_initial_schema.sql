@@ -0,0 +1,199 @@
+-- PostgreSQL schema, equivalent to the SQLite schema after migrations 001–029.
+-- PostgreSQL databases start here; later migrations get a file with the same
+-- number in both directories.
+
+CREATE TABLE users (
+    id            SERIAL      PRIMARY KEY,
+    username      TEXT        NOT NULL UNIQUE,
+    password_hash TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE TABLE groups (
+    id          SERIAL      PRIMARY KEY,
+    name        TEXT        NOT NULL UNIQUE,
+    description TEXT        DEFAULT '',
+    created_at  TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE TABLE user_groups (
+    user_id  INTEGER NOT NULL REFERENCES users(id)  ON DELETE CASCADE,
+    group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, group_id)
+);
+
+CREATE TABLE sessions (
+    id         SERIAL      PRIMARY KEY,
+    token_hash TEXT        NOT NULL UNIQUE,
+    user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    client_ip  TEXT        NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_sessions_token ON sessions(token_hash);
+CREATE INDEX idx_sessions_ip    ON sessions(client_ip);
+
+CREATE TABLE invites (
+    id          SERIAL      PRIMARY KEY,
+    code_hash   TEXT        NOT NULL UNIQUE,
+    used        BOOLEAN     DEFAULT FALSE,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ DEFAULT now(),
+    description TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE TABLE proxy_routes (
+    id            SERIAL      PRIMARY KEY,
+    url           TEXT        NOT NULL UNIQUE,
+    target        TEXT        NOT NULL,
+    type          TEXT        DEFAULT 'proxy',
+    tls           BOOLEAN     DEFAULT FALSE,
+    cert          TEXT        DEFAULT '',
+    key           TEXT        DEFAULT '',
+    enabled       BOOLEAN     DEFAULT TRUE,
+    source        TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ DEFAULT now(),
+    updated_at    TIMESTAMPTZ DEFAULT now(),
+
+    allowed_groups   TEXT    DEFAULT '',
+    allowed_ips      TEXT    DEFAULT '',
+    ip_auth          BOOLEAN DEFAULT FALSE,
+    persistent_login BOOLEAN NOT NULL DEFAULT TRUE,
+    range_group      TEXT    DEFAULT '',
+    require_login    BOOLEAN NOT NULL DEFAULT FALSE,
+
+    dial_timeout            INTEGER NOT NULL DEFAULT 0,
+    tls_handshake_timeout   INTEGER NOT NULL DEFAULT 0,
+    response_header_timeout INTEGER NOT NULL DEFAULT 0,
+    request_timeout         INTEGER NOT NULL DEFAULT 0,
+    idle_conn_timeout       INTEGER NOT NULL DEFAULT 0,
+    max_idle_conns          INTEGER NOT NULL DEFAULT 0,
+    max_idle_conns_per_host INTEGER NOT NULL DEFAULT 0,
+
+    retry_attempts       INTEGER NOT NULL DEFAULT 0,
+    retry_on             TEXT    NOT NULL DEFAULT '',
+    retry_backoff_ms     INTEGER NOT NULL DEFAULT 0,
+    retry_budget_pct     INTEGER NOT NULL DEFAULT 0,
+    retry_non_idempotent BOOLEAN NOT NULL DEFAULT FALSE,
+
+    breaker_error_pct    INTEGER NOT NULL DEFAULT 0,
+    breaker_latency_ms   INTEGER NOT NULL DEFAULT 0,
+    breaker_window_sec   INTEGER NOT NULL DEFAULT 0,
+    breaker_min_requests INTEGER NOT NULL DEFAULT 0,
+    breaker_open_sec     INTEGER NOT NULL DEFAULT 0,
+    breaker_fallback     TEXT    NOT NULL DEFAULT '',
+
+    cache_enabled     BOOLEAN NOT NULL DEFAULT FALSE,
+    cache_default_ttl INTEGER NOT NULL DEFAULT 0,
+
+    compress_enabled  BOOLEAN NOT NULL DEFAULT FALSE,
+    compress_types    TEXT    NOT NULL DEFAULT '',
+    compress_min_size INTEGER NOT NULL DEFAULT 0,
+
+    request_headers  TEXT NOT NULL DEFAULT '',
+    response_headers TEXT NOT NULL DEFAULT '',
+    security_profile TEXT NOT NULL DEFAULT '',
+    security_headers TEXT NOT NULL DEFAULT '',
+
+    redirect_hosts TEXT    NOT NULL DEFAULT '',
+    redirect_code  INTEGER NOT NULL DEFAULT 0,
+    redirect_match TEXT    NOT NULL DEFAULT '',
+    rewrite        TEXT    NOT NULL DEFAULT '',
+
+    maintenance_enabled       BOOLEAN NOT NULL DEFAULT FALSE,
+    maintenance_start         TEXT    NOT NULL DEFAULT '',
+    maintenance_end           TEXT    NOT NULL DEFAULT '',
+    maintenance_page          TEXT    NOT NULL DEFAULT '',
+    maintenance_retry_after   INTEGER NOT NULL DEFAULT 0,
+    maintenance_bypass_ips    TEXT    NOT NULL DEFAULT '',
+    maintenance_bypass_groups TEXT    NOT NULL DEFAULT '',
+
+    error_pages TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_routes_url     ON proxy_routes(url);
+CREATE INDEX idx_routes_enabled ON proxy_routes(enabled);
+
+CREATE FUNCTION proxy_routes_touch() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at := now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER proxy_routes_updated
+BEFORE UPDATE ON proxy_routes FOR EACH ROW
+EXECUTE FUNCTION proxy_routes_touch();
+
+CREATE TABLE auth_failures (
+    id         SERIAL      PRIMARY KEY,
+    ip         TEXT        NOT NULL,
+    username   TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+CREATE INDEX idx_auth_failures_created ON auth_failures(created_at);
+
+CREATE TABLE access_log (
+    id          BIGSERIAL   PRIMARY KEY,
+    ip          TEXT        NOT NULL,
+    username    TEXT        NOT NULL DEFAULT '',
+    route_url   TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ DEFAULT now(),
+    request_id  TEXT        NOT NULL DEFAULT '',
+    protocol    TEXT        NOT NULL DEFAULT 'http',
+    method      TEXT        NOT NULL DEFAULT '',
+    path        TEXT        NOT NULL DEFAULT '',
+    proto       TEXT        NOT NULL DEFAULT '',
+    status      INTEGER     NOT NULL DEFAULT 0,
+    bytes       BIGINT      NOT NULL DEFAULT 0,
+    duration_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    upstream_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    user_agent  TEXT        NOT NULL DEFAULT '',
+    referer     TEXT        NOT NULL DEFAULT '',
+    auth_method TEXT        NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_access_log_created ON access_log(created_at);
+
+CREATE TABLE settings (
+    id                     INTEGER PRIMARY KEY CHECK (id = 1),
+    session_duration_hours INTEGER NOT NULL DEFAULT 168,
+    renew_on_access        BOOLEAN NOT NULL DEFAULT TRUE
+);
+INSERT INTO settings (id, session_duration_hours, renew_on_access) VALUES (1, 168, TRUE);
+
+CREATE TABLE throttle_policies (
+    tier             TEXT    PRIMARY KEY,        -- 'anonymous' | 'signed-in' | 'group:<id>'
+    enabled          BOOLEAN NOT NULL DEFAULT FALSE,
+    rate_per_sec     DOUBLE PRECISION NOT NULL DEFAULT 0, -- token refill rate per IP
+    burst            INTEGER NOT NULL DEFAULT 0, -- bucket capacity per IP
+    ban_enabled      BOOLEAN NOT NULL DEFAULT FALSE,
+    ban_threshold    INTEGER NOT NULL DEFAULT 0, -- failures within the window
+    ban_window_sec   INTEGER NOT NULL DEFAULT 0, -- sliding failure window
+    ban_duration_sec INTEGER NOT NULL DEFAULT 0  -- 0 = ban until manually cleared
+);
+INSERT INTO throttle_policies (tier, enabled) VALUES ('anonymous', FALSE), ('signed-in', FALSE);
+
+CREATE TABLE banned_ips (
+    ip         TEXT        PRIMARY KEY,
+    reason     TEXT        DEFAULT '',
+    tier       TEXT        DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT now(),
+    expires_at TIMESTAMPTZ
+);
+CREATE INDEX idx_banned_ips_expires ON banned_ips(expires_at);
+
+-- One row per finished TCP connection or UDP session.
+CREATE TABLE flows (
+    id           BIGSERIAL   PRIMARY KEY,
+    request_id   TEXT        NOT NULL DEFAULT '',
+    route_url    TEXT        NOT NULL,
+    protocol     TEXT        NOT NULL,
+    ip           TEXT        NOT NULL,
+    username     TEXT        NOT NULL DEFAULT '',
+    started_at   TIMESTAMPTZ NOT NULL,
+    ended_at     TIMESTAMPTZ NOT NULL,
+    duration_ms  BIGINT      NOT NULL DEFAULT 0,
+    bytes_in     BIGINT      NOT NULL DEFAULT 0,
+    bytes_out    BIGINT      NOT NULL DEFAULT 0,
+    close_reason TEXT        NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_flows_route_user ON flows(route_url, username);
@@ -0,0 +1,851 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mdobak/go-xerrors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A policy document is the declarative form of what admins set up in the
+// database: groups, users and their memberships, routes and their access
+// control, throttle policies and session settings. Groups are named, never
+// numbered, so a document means the same in every database it is applied to.
+// Routes from config.toml appear with their access settings only; config.toml
+// stays the source of truth for the rest of them.
+
+// PolicyVersion is the document version ExportPolicy writes and ApplyPolicy
+// reads.
+const PolicyVersion = 1
+
+// Policy is a policy document. A nil section is left alone when applied; an
+// empty one means "none".
+type Policy struct {
+	Version  int              `json:"version"`
+	Settings *Settings        `json:"settings"`
+	Groups   []PolicyGroup    `json:"groups"`
+	Users    []PolicyUser     `json:"users"`
+	Routes   []PolicyRoute    `json:"routes"`
+	Throttle []ThrottlePolicy `json:"throttle"` // per-group tiers as "group:<name>"
+}
+
+type PolicyGroup struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PolicyUser is a user and the complete list of its groups.
+type PolicyUser struct {
+	Username     string   `json:"username"`
+	Groups       []string `json:"groups,omitempty"`
+	PasswordHash string   `json:"password_hash,omitempty"` // bcrypt; "" = keep the current password
+}
+
+// PolicyRoute is a route's access control and, for a UI route, the route
+// itself. Group lists hold names; Maintenance.BypassGroups is comma-separated
+// names too.
+type PolicyRoute struct {
+	Url    string `json:"url"`
+	Source string `json:"source"` // "config" or "ui"
+
+	AllowedGroups   []string          `json:"allowed_groups,omitempty"`
+	AllowedIPs      []string          `json:"allowed_ips,omitempty"`
+	IPAuth          bool              `json:"ip_auth"`
+	PersistentLogin bool              `json:"persistent_login"`
+	RequireLogin    bool              `json:"require_login"`
+	Maintenance     *RouteMaintenance `json:"maintenance,omitempty"`
+
+	Target        string          `json:"target,omitempty"`
+	Type          string          `json:"type,omitempty"`
+	Tls           bool            `json:"tls,omitempty"`
+	Cert          string          `json:"cert,omitempty"`
+	Key           string          `json:"key,omitempty"`
+	RangeGroup    string          `json:"range_group,omitempty"`
+	Transport     *RouteTransport `json:"transport,omitempty"`
+	Retry         *RouteRetry     `json:"retry,omitempty"`
+	Breaker       *RouteBreaker   `json:"breaker,omitempty"`
+	Cache         *RouteCache     `json:"cache,omitempty"`
+	Compress      *RouteCompress  `json:"compress,omitempty"`
+	Headers       *RouteHeaders   `json:"headers,omitempty"`
+	Security      *RouteSecurity  `json:"security,omitempty"`
+	RedirectHosts string          `json:"redirect_hosts,omitempty"`
+	Redirect      *RouteRedirect  `json:"redirect,omitempty"`
+	Rewrite       string          `json:"rewrite,omitempty"`
+	ErrorPages    string          `json:"error_pages,omitempty"`
+}
+
+// routing reports whether r carries any field config.toml owns for a config
+// route.
+func (r PolicyRoute) routing() bool {
+	access := PolicyRoute{Url: r.Url, Source: r.Source, AllowedGroups: r.AllowedGroups, AllowedIPs: r.AllowedIPs,
+		IPAuth: r.IPAuth, PersistentLogin: r.PersistentLogin, RequireLogin: r.RequireLogin, Maintenance: r.Maintenance}
+	return !reflect.DeepEqual(r, access)
+}
+
+// normalize drops empty sections and sorts group names, so equal routes
+// compare equal however they were written.
+func (r *PolicyRoute) normalize() {
+	r.AllowedGroups = sortedNames(r.AllowedGroups)
+	if len(r.AllowedIPs) == 0 {
+		r.AllowedIPs = nil
+	}
+	if r.Maintenance != nil {
+		r.Maintenance.BypassGroups = strings.Join(sortedNames(splitList(r.Maintenance.BypassGroups)), ",")
+	}
+	r.Maintenance = nonZero(orZero(r.Maintenance))
+	r.Transport = nonZero(orZero(r.Transport))
+	r.Retry = nonZero(orZero(r.Retry))
+	r.Breaker = nonZero(orZero(r.Breaker))
+	r.Cache = nonZero(orZero(r.Cache))
+	r.Compress = nonZero(orZero(r.Compress))
+	r.Headers = nonZero(orZero(r.Headers))
+	r.Security = nonZero(orZero(r.Security))
+	r.Redirect = nonZero(orZero(r.Redirect))
+}
+
+// PolicyChange is one step of applying a policy document.
+type PolicyChange struct {
+	Action string   `json:"action"` // "create", "update" or "delete"
+	Kind   string   `json:"kind"`   // "settings", "group", "user", "route" or "throttle"
+	Name   string   `json:"name"`
+	Fields []string `json:"fields,omitempty"` // "field: old → new"; "field: value" for a create
+	// Reregister is set on a UI route the live proxy has to register again,
+	// because more than its access settings changed.
+	Reregister bool `json:"reregister,omitempty"`
+}
+
+func (c PolicyChange) String() string {
+	sign := map[string]string{"create": "+", "update": "~", "delete": "-"}[c.Action]
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s", sign, c.Kind, c.Name)
+	for _, f := range c.Fields {
+		b.WriteString("\n      " + f)
+	}
+	return b.String()
+}
+
+// ExportPolicy returns the database's policy document. Password hashes are
+// only included when withPasswords is set.
+func (s *Storage) ExportPolicy(ctx context.Context, withPasswords bool) (*Policy, error) {
+	p := &Policy{
+		Version:  PolicyVersion,
+		Groups:   []PolicyGroup{},
+		Users:    []PolicyUser{},
+		Routes:   []PolicyRoute{},
+		Throttle: []ThrottlePolicy{},
+	}
+	st, err := s.GetSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.Settings = &st
+
+	groups, err := s.GetAllGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(groups)) // id → name
+	for _, g := range groups {
+		names[strconv.Itoa(g.ID)] = g.Name
+		p.Groups = append(p.Groups, PolicyGroup{Name: g.Name, Description: g.Description})
+	}
+	groupNames := func(ids []string) []string {
+		var out []string
+		for _, id := range ids {
+			if n, ok := names[id]; ok {
+				out = append(out, n)
+			}
+		}
+		return sortedNames(out)
+	}
+
+	members := make(map[int][]string)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT ug.user_id, g.name FROM user_groups ug JOIN groups g ON g.id = ug.group_id`)
+	if err != nil {
+		return nil, xerrors.Newf("query memberships: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, xerrors.Newf("scan membership: %w", err)
+		}
+		members[id] = append(members[id], name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Newf("read memberships: %w", err)
+	}
+
+	rows, err = s.db.QueryContext(ctx, `SELECT id, username, password_hash FROM users ORDER BY username`)
+	if err != nil {
+		return nil, xerrors.Newf("query users: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		var u PolicyUser
+		if err := rows.Scan(&id, &u.Username, &u.PasswordHash); err != nil {
+			rows.Close()
+			return nil, xerrors.Newf("scan user: %w", err)
+		}
+		if !withPasswords {
+			u.PasswordHash = ""
+		}
+		u.Groups = sortedNames(members[id])
+		p.Users = append(p.Users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Newf("read users: %w", err)
+	}
+
+	routes, err := s.GetAllRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		pr := PolicyRoute{
+			Url:             r.Url,
+			Source:          r.Source,
+			AllowedGroups:   groupNames(splitList(r.AllowedGroups)),
+			AllowedIPs:      splitList(r.AllowedIPs),
+			IPAuth:          r.IPAuth,
+			PersistentLogin: r.PersistentLogin,
+			RequireLogin:    r.RequireLogin,
+		}
+		m := r.Maintenance
+		m.BypassGroups = strings.Join(groupNames(splitList(m.BypassGroups)), ",")
+		pr.Maintenance = &m
+		if r.Source == "ui" {
+			pr.Target, pr.Type, pr.Tls, pr.Cert, pr.Key, pr.RangeGroup = r.Target, r.Type, r.Tls, r.Cert, r.Key, r.RangeGroup
+			pr.Transport, pr.Retry, pr.Breaker = &r.Transport, &r.Retry, &r.Breaker
+			pr.Cache, pr.Compress, pr.Headers, pr.Security = &r.Cache, &r.Compress, &r.Headers, &r.Security
+			pr.RedirectHosts, pr.Redirect, pr.Rewrite, pr.ErrorPages = r.RedirectHosts, &r.Redirect, r.Rewrite, r.ErrorPages
+		}
+		pr.normalize()
+		p.Routes = append(p.Routes, pr)
+	}
+
+	policies, err := s.GetThrottlePolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, tp := range policies {
+		if id, ok := strings.CutPrefix(tp.Tier, "group:"); ok {
+			name, known := names[id]
+			if !known {
+				continue // override of a deleted group; it never applies
+			}
+			tp.Tier = "group:" + name
+		}
+		p.Throttle = append(p.Throttle, tp)
+	}
+	return p, nil
+}
+
+// PlanPolicy returns the changes ApplyPolicy would make, without making them.
+// It fails, listing every problem, if the document cannot be applied.
+func (s *Storage) PlanPolicy(ctx context.Context, doc *Policy, prune bool) ([]PolicyChange, error) {
+	changes, _, err := s.planPolicy(ctx, doc, prune)
+	return changes, err
+}
+
+// ApplyPolicy makes the database match doc in one transaction and returns the
+// changes made. Objects the document does not mention are kept, unless prune
+// is set: then every section the document has is owned by it, and users,
+// groups, UI routes and group throttle tiers it leaves out are deleted.
+// Config routes it leaves out keep their access settings, and the admin group
+// and the built-in throttle tiers are never deleted. A document that would
+// leave nobody in the admin group is refused.
+func (s *Storage) ApplyPolicy(ctx context.Context, doc *Policy, prune bool) ([]PolicyChange, error) {
+	changes, cur, err := s.planPolicy(ctx, doc, prune)
+	if err != nil || len(changes) == 0 {
+		return changes, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, xerrors.Newf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	groupIDs := maps.Clone(cur.groupIDs)
+	ids := func(names []string) string {
+		out := make([]string, 0, len(names))
+		for _, n := range names {
+			out = append(out, strconv.Itoa(groupIDs[n]))
+		}
+		return strings.Join(out, ",")
+	}
+
+	// Groups first, so that everything referring to a new one can find its id.
+	for _, c := range changes {
+		if c.Kind != "group" || c.Action == "delete" {
+			continue
+		}
+		g, _ := findGroup(doc.Groups, c.Name)
+		if c.Action == "create" {
+			var id int
+			if err := tx.QueryRow(`INSERT INTO groups (name, description) VALUES (?, ?) RETURNING id`,
+				g.Name, g.Description).Scan(&id); err != nil {
+				return nil, xerrors.Newf("create group %s: %w", g.Name, err)
+			}
+			groupIDs[g.Name] = id
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE groups SET description = ? WHERE name = ?`, g.Description, g.Name); err != nil {
+			return nil, xerrors.Newf("update group %s: %w", g.Name, err)
+		}
+	}
+
+	for _, c := range changes {
+		var err error
+		switch c.Kind {
+		case "settings":
+			_, err = tx.Exec(`UPDATE settings SET session_duration_hours = ?, renew_on_access = ? WHERE id = 1`,
+				doc.Settings.SessionDurationHours, doc.Settings.RenewOnAccess)
+		case "user":
+			u, _ := findUser(doc.Users, c.Name)
+			err = applyPolicyUser(tx, c, u, cur.userIDs[c.Name], ids)
+		case "route":
+			r, ok := findRoute(doc.Routes, c.Name)
+			if !ok {
+				r = PolicyRoute{Url: c.Name} // deleted
+			}
+			err = applyPolicyRoute(tx, c, r, ids)
+		case "throttle":
+			tier := c.Name
+			if name, ok := strings.CutPrefix(tier, "group:"); ok {
+				tier = "group:" + strconv.Itoa(groupIDs[name])
+			}
+			if c.Action == "delete" {
+				_, err = tx.Exec(`DELETE FROM throttle_policies WHERE tier = ?`, tier)
+				break
+			}
+			tp, _ := findThrottle(doc.Throttle, c.Name)
+			_, err = tx.Exec(`
+				INSERT INTO throttle_policies
+					(tier, enabled, rate_per_sec, burst, ban_enabled, ban_threshold, ban_window_sec, ban_duration_sec)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(tier) DO UPDATE SET
+					enabled          = excluded.enabled,
+					rate_per_sec     = excluded.rate_per_sec,
+					burst            = excluded.burst,
+					ban_enabled      = excluded.ban_enabled,
+					ban_threshold    = excluded.ban_threshold,
+					ban_window_sec   = excluded.ban_window_sec,
+					ban_duration_sec = excluded.ban_duration_sec`,
+				tier, tp.Enabled, tp.RatePerSec, tp.Burst,
+				tp.BanEnabled, tp.BanThreshold, tp.BanWindowSec, tp.BanDurationSec)
+		}
+		if err != nil {
+			return nil, xerrors.Newf("%s %s %s: %w", c.Action, c.Kind, c.Name, err)
+		}
+	}
+
+	// Groups last, once nothing in the document refers to them any more.
+	for _, c := range changes {
+		if c.Kind == "group" && c.Action == "delete" {
+			if _, err := tx.Exec(`DELETE FROM groups WHERE name = ?`, c.Name); err != nil {
+				return nil, xerrors.Newf("delete group %s: %w", c.Name, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, xerrors.Newf("commit: %w", err)
+	}
+	slog.Info("policy applied", "changes", len(changes), "prune", prune)
+	return changes, nil
+}
+
+func applyPolicyUser(tx *timedTx, c PolicyChange, u PolicyUser, id int, groupIDs func([]string) string) error {
+	switch c.Action {
+	case "delete":
+		_, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+		return err
+	case "create":
+		hash := u.PasswordHash
+		if hash == "" {
+			// Nobody knows this password: the account cannot sign in until
+			// an admin sets one.
+			b, err := bcrypt.GenerateFromPassword([]byte(randHex(32)), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			hash = string(b)
+		}
+		if err := tx.QueryRow(`INSERT INTO users (username, password_hash) VALUES (?, ?) RETURNING id`,
+			u.Username, hash).Scan(&id); err != nil {
+			return err
+		}
+	default:
+		if u.PasswordHash != "" {
+			if _, err := tx.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, u.PasswordHash, id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM user_groups WHERE user_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	for _, g := range strings.Split(groupIDs(u.Groups), ",") {
+		if g == "" {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO user_groups (user_id, group_id) VALUES (?, ?)`, id, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyPolicyRoute(tx *timedTx, c PolicyChange, r PolicyRoute, groupIDs func([]string) string) error {
+	if c.Action == "delete" {
+		_, err := tx.Exec(`DELETE FROM proxy_routes WHERE url = ? AND source = 'ui'`, r.Url)
+		return err
+	}
+	if c.Action == "create" {
+		if _, err := tx.Exec(`
+			INSERT INTO proxy_routes (url, target, type, tls, cert, key, source, enabled, range_group)
+			VALUES (?, ?, ?, ?, ?, ?, 'ui', TRUE, ?)`,
+			r.Url, r.Target, r.Type, r.Tls, r.Cert, r.Key, r.RangeGroup); err != nil {
+			return err
+		}
+	}
+	if r.Source == "ui" {
+		t, rt, cb, rc, cp := orZero(r.Transport), orZero(r.Retry), orZero(r.Breaker), orZero(r.Cache), orZero(r.Compress)
+		h, sec, rd := orZero(r.Headers), orZero(r.Security), orZero(r.Redirect)
+		if _, err := tx.Exec(`
+			UPDATE proxy_routes SET target = ?, type = ?, tls = ?, cert = ?, key = ?, range_group = ?,
+				dial_timeout = ?, tls_handshake_timeout = ?, response_header_timeout = ?, request_timeout = ?,
+				idle_conn_timeout = ?, max_idle_conns = ?, max_idle_conns_per_host = ?,
+				retry_attempts = ?, retry_on = ?, retry_backoff_ms = ?, retry_budget_pct = ?, retry_non_idempotent = ?,
+				breaker_error_pct = ?, breaker_latency_ms = ?, breaker_window_sec = ?, breaker_min_requests = ?,
+				breaker_open_sec = ?, breaker_fallback = ?, cache_enabled = ?, cache_default_ttl = ?,
+				compress_enabled = ?, compress_types = ?, compress_min_size = ?,
+				request_headers = ?, response_headers = ?, security_profile = ?, security_headers = ?,
+				redirect_hosts = ?, redirect_code = ?, redirect_match = ?, rewrite = ?, error_pages = ?
+			WHERE url = ? AND source = 'ui'`,
+			r.Target, r.Type, r.Tls, r.Cert, r.Key, r.RangeGroup,
+			t.DialTimeout, t.TLSHandshakeTimeout, t.ResponseHeaderTimeout, t.RequestTimeout,
+			t.IdleConnTimeout, t.MaxIdleConns, t.MaxIdleConnsPerHost,
+			rt.Attempts, rt.On, rt.BackoffMs, rt.BudgetPct, rt.NonIdempotent,
+			cb.ErrorPct, cb.LatencyMs, cb.WindowSec, cb.MinRequests, cb.OpenSec, cb.Fallback,
+			rc.Enabled, rc.DefaultTTL, cp.Enabled, cp.Types, cp.MinSize,
+			h.Request, h.Response, sec.Profile, sec.Headers,
+			r.RedirectHosts, rd.Code, rd.Match, r.Rewrite, r.ErrorPages, r.Url); err != nil {
+			return err
+		}
+	}
+	m := orZero(r.Maintenance)
+	_, err := tx.Exec(`
+		UPDATE proxy_routes SET allowed_groups = ?, allowed_ips = ?, ip_auth = ?, persistent_login = ?, require_login = ?,
+			maintenance_enabled = ?, maintenance_start = ?, maintenance_end = ?, maintenance_page = ?,
+			maintenance_retry_after = ?, maintenance_bypass_ips = ?, maintenance_bypass_groups = ?
+		WHERE url = ?`,
+		groupIDs(r.AllowedGroups), strings.Join(r.AllowedIPs, ","), r.IPAuth, r.PersistentLogin, r.RequireLogin,
+		m.Enabled, m.Start, m.End, m.Page, m.RetryAfter, m.BypassIPs, groupIDs(splitList(m.BypassGroups)), r.Url)
+	return err
+}
+
+// currentPolicy is the database's document plus the ids ApplyPolicy needs.
+type currentPolicy struct {
+	*Policy
+	groupIDs map[string]int // name → id
+	userIDs  map[string]int
+}
+
+func (s *Storage) loadCurrentPolicy(ctx context.Context) (*currentPolicy, error) {
+	p, err := s.ExportPolicy(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	cur := &currentPolicy{Policy: p, groupIDs: make(map[string]int), userIDs: make(map[string]int)}
+	groups, err := s.GetAllGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		cur.groupIDs[g.Name] = g.ID
+	}
+	users, err := s.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		cur.userIDs[u.Username] = u.ID
+	}
+	return cur, nil
+}
+
+func (s *Storage) planPolicy(ctx context.Context, doc *Policy, prune bool) ([]PolicyChange, *currentPolicy, error) {
+	cur, err := s.loadCurrentPolicy(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var problems []error
+	problem := func(format string, args ...any) { problems = append(problems, xerrors.Newf(format, args...)) }
+	if doc.Version != PolicyVersion {
+		problem("unsupported policy version %d; this build reads version %d", doc.Version, PolicyVersion)
+		return nil, nil, errors.Join(problems...)
+	}
+	for i := range doc.Routes {
+		doc.Routes[i].normalize()
+	}
+	for i := range doc.Users {
+		doc.Users[i].Groups = sortedNames(doc.Users[i].Groups)
+	}
+
+	var changes []PolicyChange
+	change := func(action, kind, name string, old, new any) {
+		c := PolicyChange{Action: action, Kind: kind, Name: name}
+		switch action {
+		case "create":
+			c.Fields = diffFields(nil, new)
+		case "update":
+			c.Fields = diffFields(old, new)
+			if len(c.Fields) == 0 {
+				return
+			}
+		}
+		changes = append(changes, c)
+	}
+
+	if doc.Settings != nil && doc.Settings.SessionDurationHours <= 0 {
+		doc.Settings.SessionDurationHours = defaultSettings.SessionDurationHours
+	}
+	if doc.Settings != nil && *doc.Settings != *cur.Settings {
+		change("update", "settings", "session", cur.Settings, doc.Settings)
+	}
+
+	// Groups the document may refer to once applied.
+	groups := make(map[string]bool)
+	if doc.Groups == nil || !prune {
+		for _, g := range cur.Groups {
+			groups[g.Name] = true
+		}
+	}
+	groups["admin"] = true
+	var deletes []PolicyChange
+	if doc.Groups != nil {
+		want := make(map[string]bool)
+		for _, g := range doc.Groups {
+			if g.Name == "" {
+				problem("group without a name")
+				continue
+			}
+			if want[g.Name] {
+				problem("group %q listed more than once", g.Name)
+			}
+			want[g.Name] = true
+			groups[g.Name] = true
+			if old, ok := findGroup(cur.Groups, g.Name); ok {
+				change("update", "group", g.Name, old, g)
+			} else {
+				change("create", "group", g.Name, nil, g)
+			}
+		}
+		if prune {
+			for _, g := range cur.Groups {
+				if !want[g.Name] && g.Name != "admin" {
+					deletes = append(deletes, PolicyChange{Action: "delete", Kind: "group", Name: g.Name})
+				}
+			}
+		}
+	}
+	checkGroups := func(what string, names []string) {
+		for _, n := range names {
+			if !groups[n] {
+				problem("%s: unknown group %q", what, n)
+			}
+		}
+	}
+
+	if doc.Users != nil {
+		want := make(map[string]bool)
+		admins := 0
+		for _, u := range doc.Users {
+			if u.Username == "" {
+				problem("user without a username")
+				continue
+			}
+			if want[u.Username] {
+				problem("user %q listed more than once", u.Username)
+			}
+			want[u.Username] = true
+			checkGroups(fmt.Sprintf("user %q", u.Username), u.Groups)
+			if u.PasswordHash != "" {
+				if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+					problem("user %q: password_hash is not a bcrypt hash", u.Username)
+				}
+			}
+			if slices.Contains(u.Groups, "admin") {
+				admins++
+			}
+			old, ok := findUser(cur.Users, u.Username)
+			if !ok {
+				change("create", "user", u.Username, nil, u)
+				continue
+			}
+			if u.PasswordHash == "" {
+				u.PasswordHash = old.PasswordHash
+			}
+			change("update", "user", u.Username, old, u)
+		}
+		for _, u := range cur.Users {
+			if want[u.Username] {
+				continue
+			}
+			if prune {
+				deletes = append(deletes, PolicyChange{Action: "delete", Kind: "user", Name: u.Username})
+			} else if slices.Contains(u.Groups, "admin") {
+				admins++
+			}
+		}
+		if admins == 0 {
+			problem("nobody would be left in the admin group")
+		}
+	}
+
+	if doc.Routes != nil {
+		want := make(map[string]bool)
+		for _, r := range doc.Routes {
+			where := fmt.Sprintf("route %q", r.Url)
+			if r.Url == "" {
+				problem("route without a url")
+				continue
+			}
+			if want[r.Url] {
+				problem("%s listed more than once", where)
+			}
+			want[r.Url] = true
+			checkGroups(where+" allowed_groups", r.AllowedGroups)
+			if r.Maintenance != nil {
+				checkGroups(where+" maintenance bypass_groups", splitList(r.Maintenance.BypassGroups))
+			}
+			old, exists := findRoute(cur.Routes, r.Url)
+			switch r.Source {
+			case "config":
+				if !exists || old.Source != "config" {
+					problem("%s is not a config route here; add it to config.toml or make it a ui route", where)
+					continue
+				}
+				if r.routing() {
+					problem("%s: a config route's target and settings belong in config.toml", where)
+					continue
+				}
+			case "ui":
+				if exists && old.Source != "ui" {
+					problem("%s is a config route here", where)
+					continue
+				}
+				if r.Target == "" || r.Type == "" {
+					problem("%s: a ui route needs a target and a type", where)
+					continue
+				}
+			default:
+				problem("%s: source must be \"config\" or \"ui\", not %q", where, r.Source)
+				continue
+			}
+			if !exists {
+				c := len(changes)
+				change("create", "route", r.Url, nil, r)
+				changes[c].Reregister = true
+				continue
+			}
+			c := len(changes)
+			change("update", "route", r.Url, old, r)
+			if len(changes) > c && r.Source == "ui" {
+				changes[c].Reregister = !reflect.DeepEqual(routeOnly(old), routeOnly(r))
+			}
+		}
+		if prune {
+			for _, r := range cur.Routes {
+				if !want[r.Url] && r.Source == "ui" {
+					deletes = append(deletes, PolicyChange{Action: "delete", Kind: "route", Name: r.Url})
+				}
+			}
+		}
+	}
+
+	if doc.Throttle != nil {
+		want := make(map[string]bool)
+		for _, tp := range doc.Throttle {
+			if want[tp.Tier] {
+				problem("throttle tier %q listed more than once", tp.Tier)
+			}
+			want[tp.Tier] = true
+			if name, ok := strings.CutPrefix(tp.Tier, "group:"); ok {
+				checkGroups(fmt.Sprintf("throttle tier %q", tp.Tier), []string{name})
+			} else if tp.Tier != TierAnonymous && tp.Tier != TierSignedIn {
+				problem("throttle tier %q: must be %q, %q or \"group:<name>\"", tp.Tier, TierAnonymous, TierSignedIn)
+				continue
+			}
+			if old, ok := findThrottle(cur.Throttle, tp.Tier); ok {
+				change("update", "throttle", tp.Tier, old, tp)
+			} else {
+				change("create", "throttle", tp.Tier, nil, tp)
+			}
+		}
+		if prune {
+			for _, tp := range cur.Throttle {
+				if !want[tp.Tier] && strings.HasPrefix(tp.Tier, "group:") {
+					deletes = append(deletes, PolicyChange{Action: "delete", Kind: "throttle", Name: tp.Tier})
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, errors.Join(problems...)
+	}
+	return append(changes, deletes...), cur, nil
+}
+
+// routeOnly is r without its access settings.
+func routeOnly(r PolicyRoute) PolicyRoute {
+	r.AllowedGroups, r.AllowedIPs, r.IPAuth, r.PersistentLogin, r.RequireLogin, r.Maintenance = nil, nil, false, false, false, nil
+	return r
+}
+
+// diffFields lists the top-level fields that differ between old and new as
+// "field: old → new", or "field: new" when old is nil. Password hashes are
+// never shown.
+func diffFields(old, new any) []string {
+	a, b := fieldMap(old), fieldMap(new)
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var out []string
+	for _, k := range keys {
+		av, bv := a[k], b[k]
+		if reflect.DeepEqual(av, bv) {
+			continue
+		}
+		switch {
+		case k == "password_hash":
+			out = append(out, "password_hash: (changed)")
+		case old == nil:
+			if !identity[k] && bv != false && bv != 0.0 && bv != "" {
+				out = append(out, k+": "+showValue(bv))
+			}
+		default:
+			out = append(out, k+": "+showValue(av)+" → "+showValue(bv))
+		}
+	}
+	return out
+}
+
+// identity holds the fields that name an object, already in its change.
+var identity = map[string]bool{"name": true, "username": true, "url": true, "tier": true}
+
+func fieldMap(v any) map[string]any {
+	m := map[string]any{}
+	if v == nil {
+		return m
+	}
+	b, _ := json.Marshal(v)
+	json.Unmarshal(b, &m)
+	return m
+}
+
+func showValue(v any) string {
+	if v == nil {
+		return "-"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func findGroup(gs []PolicyGroup, name string) (PolicyGroup, bool) {
+	for _, g := range gs {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return PolicyGroup{}, false
+}
+
+func findUser(us []PolicyUser, name string) (PolicyUser, bool) {
+	for _, u := range us {
+		if u.Username == name {
+			return u, true
+		}
+	}
+	return PolicyUser{}, false
+}
+
+func findRoute(rs []PolicyRoute, url string) (PolicyRoute, bool) {
+	for _, r := range rs {
+		if r.Url == url {
+			return r, true
+		}
+	}
+	return PolicyRoute{}, false
+}
+
+func findThrottle(ts []ThrottlePolicy, tier string) (ThrottlePolicy, bool) {
+	for _, t := range ts {
+		if t.Tier == tier {
+			return t, true
+		}
+	}
+	return ThrottlePolicy{}, false
+}
+
+// splitList splits a comma-separated column into its trimmed, non-empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func sortedNames(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	out := slices.Clone(names)
+	sort.Strings(out)
+	return slices.Compact(out)
+}
+
+// nonZero returns a pointer to v, or nil if v is its type's zero value.
+func nonZero[T comparable](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return &v
+}
+
+func orZero[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
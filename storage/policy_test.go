@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newPolicyStore(t *testing.T) *Storage {
+	t.Helper()
+	s, err := New(t.TempDir() + "/state.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := s.SyncRoutes([]ConfigRoute{{Url: "app.example.com:443", Target: "127.0.0.1:8080", Type: "proxy"}}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPolicyRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newPolicyStore(t)
+	ops, _ := src.CreateGroup(ctx, "ops", "Operations")
+	u, _ := src.CreateUser(ctx, "alice", "pw")
+	src.AddUserToGroup(ctx, u.ID, ops.ID)
+	app, _ := src.GetRouteByUrl(ctx, "app.example.com:443")
+	src.UpdateRouteAccess(ctx, app.ID, strconv.Itoa(ops.ID), "10.0.0.0/8", false, false, true)
+	r, _ := src.CreateRoute(ctx, "ssh.example.com:2222", "10.0.0.5:22", "tcp", false, "", "", "")
+	src.UpdateRouteAccess(ctx, r.ID, strconv.Itoa(ops.ID), "", true, false, false)
+	src.UpsertThrottlePolicy(ctx, ThrottlePolicy{Tier: "group:" + strconv.Itoa(ops.ID), Enabled: true, RatePerSec: 5, Burst: 10})
+
+	doc, err := src.ExportPolicy(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := src.PlanPolicy(ctx, doc, true); err != nil || len(changes) != 0 {
+		t.Fatalf("exported document does not match its own database: %v %v", changes, err)
+	}
+
+	// A second instance with an extra user and group, in which ops gets a
+	// different id.
+	dst := newPolicyStore(t)
+	dst.CreateGroup(ctx, "stale", "")
+	dst.CreateUser(ctx, "mallory", "pw")
+	changes, err := dst.ApplyPolicy(ctx, doc, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary []string
+	for _, c := range changes {
+		summary = append(summary, c.Action+" "+c.Kind+" "+c.Name)
+	}
+	for _, want := range []string{
+		"create group ops", "create user alice", "update route app.example.com:443",
+		"create route ssh.example.com:2222", "create throttle group:ops", "delete user mallory", "delete group stale",
+	} {
+		if !strings.Contains(strings.Join(summary, "\n"), want) {
+			t.Errorf("missing change %q in %v", want, summary)
+		}
+	}
+
+	got, err := dst.ExportPolicy(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, doc) {
+		t.Errorf("imported policy differs:\n got %+v\nwant %+v", got, doc)
+	}
+	if _, err := dst.Authenticate(ctx, "alice", "pw"); err != nil {
+		t.Errorf("password hash not carried over: %v", err)
+	}
+	if changes, err := dst.ApplyPolicy(ctx, doc, true); err != nil || len(changes) != 0 {
+		t.Errorf("second apply changed %v, %v", changes, err)
+	}
+}
+
+func TestPolicyMergeKeepsUnlisted(t *testing.T) {
+	ctx := context.Background()
+	s := newPolicyStore(t)
+	s.CreateUser(ctx, "bob", "pw")
+	doc := &Policy{Version: PolicyVersion, Users: []PolicyUser{{Username: "carol", Groups: []string{"admin"}}}}
+	if _, err := s.ApplyPolicy(ctx, doc, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUserByUsername(ctx, "bob"); err != nil {
+		t.Error("merge deleted an unlisted user")
+	}
+	if ok, _ := s.UserInGroup(ctx, mustUser(t, s, "carol"), "admin"); !ok {
+		t.Error("carol not in admin")
+	}
+}
+
+func TestPolicyRejects(t *testing.T) {
+	ctx := context.Background()
+	s := newPolicyStore(t)
+	for name, doc := range map[string]*Policy{
+		"version":       {Version: 99},
+		"unknown group": {Version: PolicyVersion, Users: []PolicyUser{{Username: "admin", Groups: []string{"admin", "nope"}}}},
+		"lockout":       {Version: PolicyVersion, Users: []PolicyUser{{Username: "admin"}}},
+		"config route":  {Version: PolicyVersion, Routes: []PolicyRoute{{Url: "other.example.com:443", Source: "config"}}},
+		"config target": {Version: PolicyVersion, Routes: []PolicyRoute{{Url: "app.example.com:443", Source: "config", Target: "x:1"}}},
+		"bad tier":      {Version: PolicyVersion, Throttle: []ThrottlePolicy{{Tier: "everyone"}}},
+	} {
+		if _, err := s.ApplyPolicy(ctx, doc, true); err == nil {
+			t.Errorf("%s: document accepted", name)
+		}
+	}
+	if _, err := s.GetUserByUsername(ctx, "admin"); err != nil {
+		t.Error("a rejected document changed the database")
+	}
+}
+
+func mustUser(t *testing.T, s *Storage, name string) int {
+	t.Helper()
+	u, err := s.GetUserByUsername(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.ID
+}
@@ -11,32 +11,183 @@ import (
 )
 
 type Route struct {
-	ID              int       `json:"id"`
-	Url             string    `json:"url"`
-	Target          string    `json:"target"`
-	Type            string    `json:"type"`
-	Tls             bool      `json:"tls"`
-	Cert            string    `json:"-"`
-	Key             string    `json:"-"`
-	Enabled         bool      `json:"enabled"`
-	Source          string    `json:"source"`
-	AllowedGroups   string    `json:"allowed_groups"`
-	AllowedIPs      string    `json:"allowed_ips"`
-	IPAuth          bool      `json:"ip_auth"`
-	PersistentLogin bool      `json:"persistent_login"`
-	RequireLogin    bool      `json:"require_login"`
-	RangeGroup      string    `json:"range_group"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID              int              `json:"id"`
+	Url             string           `json:"url"`
+	Target          string           `json:"target"`
+	Type            string           `json:"type"`
+	Tls             bool             `json:"tls"`
+	Cert            string           `json:"-"`
+	Key             string           `json:"-"`
+	Enabled         bool             `json:"enabled"`
+	Source          string           `json:"source"`
+	AllowedGroups   string           `json:"allowed_groups"`
+	AllowedIPs      string           `json:"allowed_ips"`
+	IPAuth          bool             `json:"ip_auth"`
+	PersistentLogin bool             `json:"persistent_login"`
+	RequireLogin    bool             `json:"require_login"`
+	RangeGroup      string           `json:"range_group"`
+	Transport       RouteTransport   `json:"transport"`
+	Retry           RouteRetry       `json:"retry"`
+	Breaker         RouteBreaker     `json:"breaker"`
+	Cache           RouteCache       `json:"cache"`
+	Compress        RouteCompress    `json:"compress"`
+	Headers         RouteHeaders     `json:"headers"`
+	Security        RouteSecurity    `json:"security"`
+	RedirectHosts   string           `json:"redirect_hosts"` // comma-separated aliases redirected to Url's host
+	Redirect        RouteRedirect    `json:"redirect"`
+	Rewrite         string           `json:"rewrite"` // upstream path rewrite rules, one per line
+	Maintenance     RouteMaintenance `json:"maintenance"`
+	ErrorPages      string           `json:"error_pages"` // directory of error-page templates; "" = global pages
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+// RouteTransport tunes the backend transport of a proxy route. Timeouts are in
+// seconds; a zero field means "use the proxy's built-in default".
+type RouteTransport struct {
+	DialTimeout           int `json:"dial_timeout"`
+	TLSHandshakeTimeout   int `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int `json:"response_header_timeout"`
+	RequestTimeout        int `json:"request_timeout"` // whole request, including streaming the body
+	IdleConnTimeout       int `json:"idle_conn_timeout"`
+	MaxIdleConns          int `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int `json:"max_idle_conns_per_host"`
+}
+
+// RouteRetry is the retry/failover policy of a proxy route. Attempts counts
+// every try, so 0 or 1 means "never retry". Zero BackoffMs and BudgetPct use the
+// proxy's built-in defaults; an empty On retries on every supported condition.
+type RouteRetry struct {
+	Attempts      int    `json:"attempts"`
+	On            string `json:"on"`         // comma-separated: connect, 502, 503, 504
+	BackoffMs     int    `json:"backoff_ms"` // base delay before the first retry
+	BudgetPct     int    `json:"budget_pct"` // retries allowed as a % of recent requests
+	NonIdempotent bool   `json:"non_idempotent"`
+}
+
+// RouteBreaker is the circuit-breaker policy applied to each backend of a route.
+// The breaker is disabled while both ErrorPct and LatencyMs are zero; other zero
+// fields use the proxy's built-in defaults.
+type RouteBreaker struct {
+	ErrorPct    int    `json:"error_pct"`    // % of bad calls in the window that opens the circuit
+	LatencyMs   int    `json:"latency_ms"`   // calls slower than this count as bad; 0 = ignore latency
+	WindowSec   int    `json:"window_sec"`   // rolling window length
+	MinRequests int    `json:"min_requests"` // calls needed in the window before it may open
+	OpenSec     int    `json:"open_sec"`     // how long the circuit stays open before probing
+	Fallback    string `json:"fallback"`     // backend address or static path served while open
+}
+
+// Enabled reports whether the breaker is configured at all.
+func (b RouteBreaker) Enabled() bool { return b.ErrorPct > 0 || b.LatencyMs > 0 }
+
+// RouteCache is the response-cache policy of a proxy route. DefaultTTL is the
+// freshness (seconds) of responses that carry no Cache-Control/Expires.
+type RouteCache struct {
+	Enabled    bool `json:"enabled"`
+	DefaultTTL int  `json:"default_ttl"`
+}
+
+// RouteCompress is the response-compression policy of a proxy or static route.
+// An empty Types uses the proxy's built-in MIME allowlist and a zero MinSize its
+// default minimum.
+type RouteCompress struct {
+	Enabled bool   `json:"enabled"`
+	Types   string `json:"types"`    // comma-separated MIME types; "text/*" matches a family
+	MinSize int    `json:"min_size"` // bytes
+}
+
+// RouteHeaders holds the header rewrite rules of an HTTP route, one rule per
+// line: "<set|add|append|remove> <Header-Name> [value]". The proxy parses and
+// validates them; storage keeps the text as entered.
+type RouteHeaders struct {
+	Request  string `json:"request"`  // applied to the request sent to the backend
+	Response string `json:"response"` // applied to the response sent to the client
+}
+
+// RouteSecurity selects the security-header profile of an HTTP route. Headers
+// holds "Name: value" override lines; "Name:" drops a header of the profile.
+type RouteSecurity struct {
+	Profile string `json:"profile"` // "" = none
+	Headers string `json:"headers"`
+}
+
+// RouteRedirect configures a redirect route; its Target is the URL template.
+type RouteRedirect struct {
+	Code  int    `json:"code"`  // 0 = 302
+	Match string `json:"match"` // path regexp whose groups Target may use; "" = every path
+}
+
+// RouteMaintenance takes a route offline with a maintenance page (HTTP) or by
+// refusing new connections (TCP/UDP), optionally within a scheduled window.
+type RouteMaintenance struct {
+	Enabled      bool   `json:"enabled"`
+	Start        string `json:"start"`         // RFC 3339; "" = as soon as enabled
+	End          string `json:"end"`           // RFC 3339; "" = until disabled
+	Page         string `json:"page"`          // inline HTML, or a file or directory path; "" = built-in page
+	RetryAfter   int    `json:"retry_after"`   // Retry-After seconds; 0 = time left in the window, if scheduled
+	BypassIPs    string `json:"bypass_ips"`    // comma-separated IPs/CIDRs that still reach the backend
+	BypassGroups string `json:"bypass_groups"` // comma-separated group IDs whose users still reach the backend
 }
 
 type ConfigRoute struct {
-	Url    string
-	Target string
-	Type   string
-	Tls    bool
-	Cert   string
-	Key    string
+	Url       string
+	Target    string
+	Type      string
+	Tls       bool
+	Cert      string
+	Key       string
+	Transport RouteTransport
+	Retry     RouteRetry
+	Breaker   RouteBreaker
+	Cache     RouteCache
+	Compress  RouteCompress
+	Headers   RouteHeaders
+	Security  RouteSecurity
+
+	RedirectHosts string
+	Redirect      RouteRedirect
+	Rewrite       string
+	ErrorPages    string
+}
+
+// routeColumns is the SELECT/RETURNING column list matching scanRoute.
+const routeColumns = `id, url, target, type, tls, cert, key, enabled, source,
+	allowed_groups, allowed_ips, ip_auth, persistent_login, require_login, range_group,
+	dial_timeout, tls_handshake_timeout, response_header_timeout, request_timeout,
+	idle_conn_timeout, max_idle_conns, max_idle_conns_per_host,
+	retry_attempts, retry_on, retry_backoff_ms, retry_budget_pct, retry_non_idempotent,
+	breaker_error_pct, breaker_latency_ms, breaker_window_sec, breaker_min_requests,
+	breaker_open_sec, breaker_fallback, cache_enabled, cache_default_ttl,
+	compress_enabled, compress_types, compress_min_size,
+	request_headers, response_headers, security_profile, security_headers,
+	redirect_hosts, redirect_code, redirect_match, rewrite,
+	maintenance_enabled, maintenance_start, maintenance_end, maintenance_page,
+	maintenance_retry_after, maintenance_bypass_ips, maintenance_bypass_groups, error_pages, created_at, updated_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRoute scans one row selected with routeColumns.
+func scanRoute(sc rowScanner) (Route, error) {
+	var r Route
+	t, rt, cb, rc, cp := &r.Transport, &r.Retry, &r.Breaker, &r.Cache, &r.Compress
+	err := sc.Scan(
+		&r.ID, &r.Url, &r.Target, &r.Type, &r.Tls, &r.Cert, &r.Key,
+		&r.Enabled, &r.Source,
+		&r.AllowedGroups, &r.AllowedIPs, &r.IPAuth, &r.PersistentLogin, &r.RequireLogin, &r.RangeGroup,
+		&t.DialTimeout, &t.TLSHandshakeTimeout, &t.ResponseHeaderTimeout, &t.RequestTimeout,
+		&t.IdleConnTimeout, &t.MaxIdleConns, &t.MaxIdleConnsPerHost,
+		&rt.Attempts, &rt.On, &rt.BackoffMs, &rt.BudgetPct, &rt.NonIdempotent,
+		&cb.ErrorPct, &cb.LatencyMs, &cb.WindowSec, &cb.MinRequests, &cb.OpenSec, &cb.Fallback,
+		&rc.Enabled, &rc.DefaultTTL, &cp.Enabled, &cp.Types, &cp.MinSize,
+		&r.Headers.Request, &r.Headers.Response, &r.Security.Profile, &r.Security.Headers,
+		&r.RedirectHosts, &r.Redirect.Code, &r.Redirect.Match, &r.Rewrite,
+		&r.Maintenance.Enabled, &r.Maintenance.Start, &r.Maintenance.End, &r.Maintenance.Page,
+		&r.Maintenance.RetryAfter, &r.Maintenance.BypassIPs, &r.Maintenance.BypassGroups, &r.ErrorPages, &r.CreatedAt, &r.UpdatedAt,
+	)
+	return r, err
 }
 
 // SyncRoutes reconciles DB routes with the config file. Config routes are
@@ -52,9 +203,18 @@ func (s *Storage) SyncRoutes(routes []ConfigRoute) error {
 	defer tx.Rollback()
 
 	for _, r := range routes {
+		t, rt, cb, rc, cp := r.Transport, r.Retry, r.Breaker, r.Cache, r.Compress
 		_, err := tx.Exec(`
-			INSERT INTO proxy_routes (url, target, type, tls, cert, key, source, enabled)
-			VALUES (?, ?, ?, ?, ?, ?, 'config', TRUE)
+			INSERT INTO proxy_routes (url, target, type, tls, cert, key, source, enabled,
+				dial_timeout, tls_handshake_timeout, response_header_timeout, request_timeout,
+				idle_conn_timeout, max_idle_conns, max_idle_conns_per_host,
+				retry_attempts, retry_on, retry_backoff_ms, retry_budget_pct, retry_non_idempotent,
+				breaker_error_pct, breaker_latency_ms, breaker_window_sec, breaker_min_requests,
+				breaker_open_sec, breaker_fallback, cache_enabled, cache_default_ttl,
+				compress_enabled, compress_types, compress_min_size,
+				request_headers, response_headers, security_profile, security_headers,
+				redirect_hosts, redirect_code, redirect_match, rewrite, error_pages)
+			VALUES (?, ?, ?, ?, ?, ?, 'config', TRUE, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON CONFLICT(url) DO UPDATE SET
 				target  = excluded.target,
 				type    = excluded.type,
@@ -62,8 +222,47 @@ func (s *Storage) SyncRoutes(routes []ConfigRoute) error {
 				cert    = excluded.cert,
 				key     = excluded.key,
 				source  = excluded.source,
-				enabled = TRUE
-		`, r.Url, r.Target, r.Type, r.Tls, r.Cert, r.Key)
+				enabled = TRUE,
+				dial_timeout            = excluded.dial_timeout,
+				tls_handshake_timeout   = excluded.tls_handshake_timeout,
+				response_header_timeout = excluded.response_header_timeout,
+				request_timeout         = excluded.request_timeout,
+				idle_conn_timeout       = excluded.idle_conn_timeout,
+				max_idle_conns          = excluded.max_idle_conns,
+				max_idle_conns_per_host = excluded.max_idle_conns_per_host,
+				retry_attempts          = excluded.retry_attempts,
+				retry_on                = excluded.retry_on,
+				retry_backoff_ms        = excluded.retry_backoff_ms,
+				retry_budget_pct        = excluded.retry_budget_pct,
+				retry_non_idempotent    = excluded.retry_non_idempotent,
+				breaker_error_pct       = excluded.breaker_error_pct,
+				breaker_latency_ms      = excluded.breaker_latency_ms,
+				breaker_window_sec      = excluded.breaker_window_sec,
+				breaker_min_requests    = excluded.breaker_min_requests,
+				breaker_open_sec        = excluded.breaker_open_sec,
+				breaker_fallback        = excluded.breaker_fallback,
+				cache_enabled           = excluded.cache_enabled,
+				cache_default_ttl       = excluded.cache_default_ttl,
+				compress_enabled        = excluded.compress_enabled,
+				compress_types          = excluded.compress_types,
+				compress_min_size       = excluded.compress_min_size,
+				request_headers         = excluded.request_headers,
+				response_headers        = excluded.response_headers,
+				security_profile        = excluded.security_profile,
+				security_headers        = excluded.security_headers,
+				redirect_hosts          = excluded.redirect_hosts,
+				redirect_code           = excluded.redirect_code,
+				redirect_match          = excluded.redirect_match,
+				rewrite                 = excluded.rewrite,
+				error_pages             = excluded.error_pages
+		`, r.Url, r.Target, r.Type, r.Tls, r.Cert, r.Key,
+			t.DialTimeout, t.TLSHandshakeTimeout, t.ResponseHeaderTimeout, t.RequestTimeout,
+			t.IdleConnTimeout, t.MaxIdleConns, t.MaxIdleConnsPerHost,
+			rt.Attempts, rt.On, rt.BackoffMs, rt.BudgetPct, rt.NonIdempotent,
+			cb.ErrorPct, cb.LatencyMs, cb.WindowSec, cb.MinRequests, cb.OpenSec, cb.Fallback,
+			rc.Enabled, rc.DefaultTTL, cp.Enabled, cp.Types, cp.MinSize,
+			r.Headers.Request, r.Headers.Response, r.Security.Profile, r.Security.Headers,
+			r.RedirectHosts, r.Redirect.Code, r.Redirect.Match, r.Rewrite, r.ErrorPages)
 		if err != nil {
 			return xerrors.Newf("upsert route %s: %w", r.Url, err)
 		}
@@ -96,8 +295,7 @@ func (s *Storage) SyncRoutes(routes []ConfigRoute) error {
 
 func (s *Storage) GetAllRoutes(ctx context.Context) ([]Route, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, url, target, type, tls, cert, key, enabled, source,
-		       allowed_groups, allowed_ips, ip_auth, persistent_login, require_login, range_group, created_at, updated_at
+		SELECT `+routeColumns+`
 		FROM proxy_routes
 		WHERE enabled = TRUE
 		ORDER BY url`)
@@ -108,13 +306,8 @@ func (s *Storage) GetAllRoutes(ctx context.Context) ([]Route, error) {
 
 	var routes []Route
 	for rows.Next() {
-		var r Route
-		if err := rows.Scan(
-			&r.ID, &r.Url, &r.Target, &r.Type, &r.Tls, &r.Cert, &r.Key,
-			&r.Enabled, &r.Source,
-			&r.AllowedGroups, &r.AllowedIPs, &r.IPAuth, &r.PersistentLogin, &r.RequireLogin, &r.RangeGroup,
-			&r.CreatedAt, &r.UpdatedAt,
-		); err != nil {
+		r, err := scanRoute(rows)
+		if err != nil {
 			return nil, xerrors.Newf("scan route: %w", err)
 		}
 		routes = append(routes, r)
@@ -123,17 +316,9 @@ func (s *Storage) GetAllRoutes(ctx context.Context) ([]Route, error) {
 }
 
 func (s *Storage) GetRouteByUrl(ctx context.Context, url string) (*Route, error) {
-	var r Route
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, url, target, type, tls, cert, key, enabled, source,
-		       allowed_groups, allowed_ips, ip_auth, persistent_login, require_login, range_group, created_at, updated_at
-		FROM proxy_routes WHERE url = ? AND enabled = TRUE`, url,
-	).Scan(
-		&r.ID, &r.Url, &r.Target, &r.Type, &r.Tls, &r.Cert, &r.Key,
-		&r.Enabled, &r.Source,
-		&r.AllowedGroups, &r.AllowedIPs, &r.IPAuth, &r.PersistentLogin, &r.RequireLogin, &r.RangeGroup,
-		&r.CreatedAt, &r.UpdatedAt,
-	)
+	r, err := scanRoute(s.db.QueryRowContext(ctx, `
+		SELECT `+routeColumns+`
+		FROM proxy_routes WHERE url = ? AND enabled = TRUE`, url))
 	if err != nil {
 		return nil, xerrors.Newf("get route: %w", err)
 	}
@@ -161,16 +346,11 @@ func (s *Storage) UpdateRouteAccess(ctx context.Context, id int, allowedGroups,
 // single route rangeGroup is empty; for one port of an expanded port range it
 // carries the shared range id linking all ports of that range together.
 func (s *Storage) CreateRoute(ctx context.Context, url, target, routeType string, tls bool, cert, key, rangeGroup string) (*Route, error) {
-	var r Route
-	err := s.db.QueryRowContext(ctx, `
+	r, err := scanRoute(s.db.QueryRowContext(ctx, `
 		INSERT INTO proxy_routes (url, target, type, tls, cert, key, source, enabled, range_group)
 		VALUES (?, ?, ?, ?, ?, ?, 'ui', TRUE, ?)
-		RETURNING id, url, target, type, tls, cert, key, enabled, source,
-		          allowed_groups, allowed_ips, ip_auth, persistent_login, require_login, range_group, created_at, updated_at`,
-		url, target, routeType, tls, cert, key, rangeGroup,
-	).Scan(&r.ID, &r.Url, &r.Target, &r.Type, &r.Tls, &r.Cert, &r.Key,
-		&r.Enabled, &r.Source, &r.AllowedGroups, &r.AllowedIPs, &r.IPAuth, &r.PersistentLogin, &r.RequireLogin, &r.RangeGroup,
-		&r.CreatedAt, &r.UpdatedAt)
+		RETURNING `+routeColumns,
+		url, target, routeType, tls, cert, key, rangeGroup))
 	if err != nil {
 		return nil, xerrors.Newf("create route: %w", err)
 	}
@@ -213,6 +393,62 @@ func (s *Storage) UpdateRouteAccessByGroup(ctx context.Context, rangeGroup, allo
 	return int(n), nil
 }
 
+// UpdateRouteErrorPages replaces the error-page directory of a UI-sourced route.
+func (s *Storage) UpdateRouteErrorPages(ctx context.Context, id int, dir string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE proxy_routes SET error_pages = ? WHERE id = ? AND source = 'ui'`, dir, id)
+	if err != nil {
+		return xerrors.Newf("update route error pages: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route error pages updated", "id", id, "dir", dir)
+	return nil
+}
+
+// UpdateRouteMaintenance replaces the maintenance settings of a route. Like the
+// access settings it applies to config-sourced routes too.
+func (s *Storage) UpdateRouteMaintenance(ctx context.Context, id int, m RouteMaintenance) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET maintenance_enabled = ?, maintenance_start = ?, maintenance_end = ?,
+			maintenance_page = ?, maintenance_retry_after = ?, maintenance_bypass_ips = ?, maintenance_bypass_groups = ?
+		WHERE id = ?`,
+		m.Enabled, m.Start, m.End, m.Page, m.RetryAfter, m.BypassIPs, m.BypassGroups, id)
+	if err != nil {
+		return xerrors.Newf("update route maintenance: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found")
+	}
+	slog.Info("route maintenance updated", "id", id, "enabled", m.Enabled, "start", m.Start, "end", m.End)
+	return nil
+}
+
+// UpdateRouteMaintenanceByGroup applies maintenance settings to every route
+// in a port-range group.
+func (s *Storage) UpdateRouteMaintenanceByGroup(ctx context.Context, rangeGroup string, m RouteMaintenance) (int, error) {
+	if rangeGroup == "" {
+		return 0, xerrors.Newf("empty range group")
+	}
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET maintenance_enabled = ?, maintenance_start = ?, maintenance_end = ?,
+			maintenance_page = ?, maintenance_retry_after = ?, maintenance_bypass_ips = ?, maintenance_bypass_groups = ?
+		WHERE range_group = ?`,
+		m.Enabled, m.Start, m.End, m.Page, m.RetryAfter, m.BypassIPs, m.BypassGroups, rangeGroup)
+	if err != nil {
+		return 0, xerrors.Newf("update route maintenance by group: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return 0, xerrors.Newf("range group not found")
+	}
+	slog.Info("route range maintenance updated", "range_group", rangeGroup, "count", n, "enabled", m.Enabled)
+	return int(n), nil
+}
+
 // DeleteRouteGroup deletes every UI-sourced route in a port-range group and
 // returns their URLs for proxy cleanup.
 func (s *Storage) DeleteRouteGroup(ctx context.Context, rangeGroup string) ([]string, error) {
@@ -243,14 +479,9 @@ func (s *Storage) DeleteRouteGroup(ctx context.Context, rangeGroup string) ([]st
 // GetRouteByGroup returns one representative route from a port-range group, used
 // to inspect shared properties (type, source) without loading the whole range.
 func (s *Storage) GetRouteByGroup(ctx context.Context, rangeGroup string) (*Route, error) {
-	var r Route
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, url, target, type, tls, cert, key, enabled, source,
-		       allowed_groups, allowed_ips, ip_auth, persistent_login, require_login, range_group, created_at, updated_at
-		FROM proxy_routes WHERE range_group = ? ORDER BY url LIMIT 1`, rangeGroup,
-	).Scan(&r.ID, &r.Url, &r.Target, &r.Type, &r.Tls, &r.Cert, &r.Key,
-		&r.Enabled, &r.Source, &r.AllowedGroups, &r.AllowedIPs, &r.IPAuth, &r.PersistentLogin, &r.RequireLogin, &r.RangeGroup,
-		&r.CreatedAt, &r.UpdatedAt)
+	r, err := scanRoute(s.db.QueryRowContext(ctx, `
+		SELECT `+routeColumns+`
+		FROM proxy_routes WHERE range_group = ? ORDER BY url LIMIT 1`, rangeGroup))
 	if err != nil {
 		return nil, xerrors.Newf("get route by group: %w", err)
 	}
@@ -272,16 +503,188 @@ func (s *Storage) UpdateRouteEndpoint(ctx context.Context, id int, target string
 	return nil
 }
 
+// UpdateRouteTransport replaces the backend transport tuning of a UI-sourced
+// route. Config routes take theirs from config.toml on every sync.
+func (s *Storage) UpdateRouteTransport(ctx context.Context, id int, t RouteTransport) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET
+			dial_timeout = ?, tls_handshake_timeout = ?, response_header_timeout = ?,
+			request_timeout = ?, idle_conn_timeout = ?, max_idle_conns = ?, max_idle_conns_per_host = ?
+		WHERE id = ? AND source = 'ui'`,
+		t.DialTimeout, t.TLSHandshakeTimeout, t.ResponseHeaderTimeout,
+		t.RequestTimeout, t.IdleConnTimeout, t.MaxIdleConns, t.MaxIdleConnsPerHost, id)
+	if err != nil {
+		return xerrors.Newf("update route transport: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route transport updated", "id", id)
+	return nil
+}
+
+// UpdateRouteRetry replaces the retry/failover policy of a UI-sourced route.
+func (s *Storage) UpdateRouteRetry(ctx context.Context, id int, rt RouteRetry) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET
+			retry_attempts = ?, retry_on = ?, retry_backoff_ms = ?, retry_budget_pct = ?, retry_non_idempotent = ?
+		WHERE id = ? AND source = 'ui'`,
+		rt.Attempts, rt.On, rt.BackoffMs, rt.BudgetPct, rt.NonIdempotent, id)
+	if err != nil {
+		return xerrors.Newf("update route retry: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route retry policy updated", "id", id, "attempts", rt.Attempts)
+	return nil
+}
+
+// UpdateRouteBreaker replaces the circuit-breaker policy of a UI-sourced route.
+func (s *Storage) UpdateRouteBreaker(ctx context.Context, id int, cb RouteBreaker) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET
+			breaker_error_pct = ?, breaker_latency_ms = ?, breaker_window_sec = ?,
+			breaker_min_requests = ?, breaker_open_sec = ?, breaker_fallback = ?
+		WHERE id = ? AND source = 'ui'`,
+		cb.ErrorPct, cb.LatencyMs, cb.WindowSec, cb.MinRequests, cb.OpenSec, cb.Fallback, id)
+	if err != nil {
+		return xerrors.Newf("update route breaker: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route breaker updated", "id", id, "error_pct", cb.ErrorPct, "latency_ms", cb.LatencyMs)
+	return nil
+}
+
+// UpdateRouteCache replaces the response-cache policy of a UI-sourced route.
+func (s *Storage) UpdateRouteCache(ctx context.Context, id int, c RouteCache) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET cache_enabled = ?, cache_default_ttl = ?
+		WHERE id = ? AND source = 'ui'`,
+		c.Enabled, c.DefaultTTL, id)
+	if err != nil {
+		return xerrors.Newf("update route cache: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route cache updated", "id", id, "enabled", c.Enabled, "default_ttl", c.DefaultTTL)
+	return nil
+}
+
+// UpdateRouteCompress replaces the compression policy of a UI-sourced route.
+func (s *Storage) UpdateRouteCompress(ctx context.Context, id int, c RouteCompress) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET compress_enabled = ?, compress_types = ?, compress_min_size = ?
+		WHERE id = ? AND source = 'ui'`,
+		c.Enabled, c.Types, c.MinSize, id)
+	if err != nil {
+		return xerrors.Newf("update route compress: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route compression updated", "id", id, "enabled", c.Enabled)
+	return nil
+}
+
+// UpdateRouteHeaders replaces the header rewrite rules of a UI-sourced route.
+func (s *Storage) UpdateRouteHeaders(ctx context.Context, id int, h RouteHeaders) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET request_headers = ?, response_headers = ?
+		WHERE id = ? AND source = 'ui'`,
+		h.Request, h.Response, id)
+	if err != nil {
+		return xerrors.Newf("update route headers: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route header rules updated", "id", id)
+	return nil
+}
+
+// UpdateRouteSecurity replaces the security-header profile and overrides of a
+// UI-sourced route.
+func (s *Storage) UpdateRouteSecurity(ctx context.Context, id int, sec RouteSecurity) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET security_profile = ?, security_headers = ?
+		WHERE id = ? AND source = 'ui'`,
+		sec.Profile, sec.Headers, id)
+	if err != nil {
+		return xerrors.Newf("update route security: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route security profile updated", "id", id, "profile", sec.Profile)
+	return nil
+}
+
+// UpdateRouteRedirectHosts replaces the canonical-host aliases of a UI-sourced
+// route.
+func (s *Storage) UpdateRouteRedirectHosts(ctx context.Context, id int, hosts string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE proxy_routes SET redirect_hosts = ? WHERE id = ? AND source = 'ui'`, hosts, id)
+	if err != nil {
+		return xerrors.Newf("update route redirect hosts: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route redirect hosts updated", "id", id, "hosts", hosts)
+	return nil
+}
+
+// UpdateRouteRedirect replaces the status code and match pattern of a
+// UI-sourced redirect route.
+func (s *Storage) UpdateRouteRedirect(ctx context.Context, id int, rd RouteRedirect) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE proxy_routes SET redirect_code = ?, redirect_match = ?
+		WHERE id = ? AND source = 'ui'`,
+		rd.Code, rd.Match, id)
+	if err != nil {
+		return xerrors.Newf("update route redirect: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route redirect updated", "id", id, "code", rd.Code, "match", rd.Match)
+	return nil
+}
+
+// UpdateRouteRewrite replaces the upstream path rewrite rules of a UI-sourced
+// route.
+func (s *Storage) UpdateRouteRewrite(ctx context.Context, id int, rules string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE proxy_routes SET rewrite = ? WHERE id = ? AND source = 'ui'`, rules, id)
+	if err != nil {
+		return xerrors.Newf("update route rewrite: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return xerrors.Newf("route not found or not editable")
+	}
+	slog.Info("route rewrite rules updated", "id", id)
+	return nil
+}
+
 // GetRouteByID fetches a single route by its primary key.
 func (s *Storage) GetRouteByID(ctx context.Context, id int) (*Route, error) {
-	var r Route
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, url, target, type, tls, cert, key, enabled, source,
-		       allowed_groups, allowed_ips, ip_auth, persistent_login, require_login, range_group, created_at, updated_at
-		FROM proxy_routes WHERE id = ?`, id,
-	).Scan(&r.ID, &r.Url, &r.Target, &r.Type, &r.Tls, &r.Cert, &r.Key,
-		&r.Enabled, &r.Source, &r.AllowedGroups, &r.AllowedIPs, &r.IPAuth, &r.PersistentLogin, &r.RequireLogin, &r.RangeGroup,
-		&r.CreatedAt, &r.UpdatedAt)
+	r, err := scanRoute(s.db.QueryRowContext(ctx, `
+		SELECT `+routeColumns+`
+		FROM proxy_routes WHERE id = ?`, id))
 	if err != nil {
 		return nil, xerrors.Newf("get route by id: %w", err)
 	}
@@ -102,7 +102,7 @@ func (s *Storage) ValidateSessionAndGroups(ctx context.Context, tok string) (*Se
 	var groupStr string
 	err := s.db.QueryRowContext(ctx, `
 		SELECT s.id, s.user_id, u.username, s.expires_at, s.created_at,
-		       COALESCE(GROUP_CONCAT(ug.group_id), '') AS group_ids
+		       COALESCE(`+s.db.dialect.groupConcat("ug.group_id")+`, '') AS group_ids
 		FROM sessions s
 		JOIN users u ON u.id = s.user_id
 		LEFT JOIN user_groups ug ON ug.user_id = s.user_id
@@ -151,7 +151,7 @@ func (s *Storage) ValidateSessionByIPInGroups(ctx context.Context, ip string, al
 
 	query := `
 		SELECT s.id, s.user_id, u.username, s.expires_at, s.created_at,
-		       COALESCE(GROUP_CONCAT(ug.group_id), '') AS group_ids
+		       COALESCE(` + s.db.dialect.groupConcat("ug.group_id") + `, '') AS group_ids
 		FROM sessions s
 		JOIN users u ON u.id = s.user_id
 		LEFT JOIN user_groups ug ON ug.user_id = s.user_id
@@ -180,8 +180,8 @@ func (s *Storage) ValidateSessionByIPInGroups(ctx context.Context, ip string, al
 // Intended only for verbose auth debugging.
 func (s *Storage) DebugDumpSessions(ctx context.Context, limit int) []string {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, user_id, client_ip, typeof(client_ip), typeof(expires_at),
-		        expires_at, expires_at > ? AS active
+		`SELECT id, user_id, client_ip, `+s.db.dialect.typeOf("client_ip")+`, `+s.db.dialect.typeOf("expires_at")+`,
+		        expires_at, CASE WHEN expires_at > ? THEN 1 ELSE 0 END AS active
 		 FROM sessions ORDER BY id DESC LIMIT ?`,
 		time.Now(), limit)
 	if err != nil {
@@ -222,6 +222,29 @@ func (s *Storage) DeleteSession(ctx context.Context, tok string) error {
 	return err
 }
 
+// DeleteUserSessions revokes every session of a user and returns how many
+// there were.
+func (s *Storage) DeleteUserSessions(ctx context.Context, userID int) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, xerrors.Newf("delete user sessions: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	slog.Info("user sessions revoked", "user_id", userID, "count", n)
+	return int(n), nil
+}
+
+// DeleteAllSessions revokes every session, signing everyone out.
+func (s *Storage) DeleteAllSessions(ctx context.Context) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions`)
+	if err != nil {
+		return 0, xerrors.Newf("delete sessions: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	slog.Info("all sessions revoked", "count", n)
+	return int(n), nil
+}
+
 func (s *Storage) ExtendSession(ctx context.Context, tok string, dur time.Duration) {
 	hash := sha256hex(tok)
 	exp := time.Now().Add(dur)
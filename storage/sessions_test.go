@@ -160,3 +160,31 @@ func TestDeletingUserCascadesSessions(t *testing.T) {
 		t.Fatalf("expected sessions to cascade-delete, found %d", n)
 	}
 }
+
+func TestRevokeSessions(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir() + "/sessions.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	alice, _ := s.CreateUser(ctx, "alice", "pw")
+	bob, _ := s.CreateUser(ctx, "bob", "pw")
+	a1, _ := s.CreateSession(ctx, alice.ID, time.Hour, "10.0.0.1")
+	s.CreateSession(ctx, alice.ID, time.Hour, "10.0.0.2")
+	b1, _ := s.CreateSession(ctx, bob.ID, time.Hour, "10.0.0.3")
+
+	if n, err := s.DeleteUserSessions(ctx, alice.ID); err != nil || n != 2 {
+		t.Fatalf("revoked %d of alice's sessions, %v", n, err)
+	}
+	if _, err := s.ValidateSession(ctx, a1); err == nil {
+		t.Error("alice's session still valid")
+	}
+	if _, err := s.ValidateSession(ctx, b1); err != nil {
+		t.Errorf("bob's session revoked too: %v", err)
+	}
+	if n, err := s.DeleteAllSessions(ctx); err != nil || n != 1 {
+		t.Fatalf("revoked %d sessions, %v", n, err)
+	}
+}
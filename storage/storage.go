@@ -9,21 +9,68 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/mdobak/go-xerrors"
 	_ "modernc.org/sqlite"
 )
 
-//go:embed migrations/*.sql
+//go:embed migrations/*.sql migrations/postgres/*.sql
 var migrationFS embed.FS
 
 type Storage struct {
-	db *sql.DB
+	db *timedDB
 }
 
-func New(path string) (*Storage, error) {
+// New opens the database named by dsn and brings its schema up to date. A
+// postgres:// or postgresql:// URL opens PostgreSQL, so several instances can
+// share one state store; anything else is the path of a SQLite file.
+func New(dsn string) (*Storage, error) {
+	s, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Migrate(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Open opens the database named by dsn like New, but leaves the schema as it
+// is, for inspecting migrations before applying them.
+func Open(dsn string) (*Storage, error) {
+	d := dialectFor(dsn)
+	var db *sql.DB
+	var err error
+	if d == postgresDialect {
+		db, err = openPostgres(dsn)
+	} else {
+		db, err = openSQLite(dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Storage{db: newTimedDB(db, d)}, nil
+}
+
+// Migrate applies the pending migrations and, on an empty database, creates
+// the default admin.
+func (s *Storage) Migrate() error {
+	if err := s.runMigrations(); err != nil {
+		return xerrors.Newf("run migrations: %w", err)
+	}
+	s.seedAdmin()
+	return nil
+}
+
+func openSQLite(path string) (*sql.DB, error) {
 	slog.Info("opening database", "path", path)
 
 	// Apply pragmas through the DSN so every connection in the pool gets them.
@@ -40,7 +87,7 @@ func New(path string) (*Storage, error) {
 	}
 	dsn := path + sep + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
 
-	db, err := sql.Open("sqlite", dsn)
+	db, err := sql.Open(sqliteDialect.driver, dsn)
 	if err != nil {
 		return nil, xerrors.Newf("open database: %w", err)
 	}
@@ -48,82 +95,224 @@ func New(path string) (*Storage, error) {
 	db.SetMaxOpenConns(4)
 	db.SetMaxIdleConns(4)
 	db.SetConnMaxLifetime(0)
+	return db, nil
+}
 
-	s := &Storage{db: db}
+func openPostgres(dsn string) (*sql.DB, error) {
+	if u, err := url.Parse(dsn); err == nil {
+		slog.Info("opening database", "url", u.Redacted())
+	}
+	db, err := sql.Open(postgresDialect.driver, dsn)
+	if err != nil {
+		return nil, xerrors.Newf("open database: %w", err)
+	}
 
-	if err := s.runMigrations(); err != nil {
+	// Unlike SQLite, the server handles concurrent writers, so the pool is only
+	// bounded to be polite to a database other instances share.
+	db.SetMaxOpenConns(16)
+	db.SetMaxIdleConns(4)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
 		db.Close()
-		return nil, xerrors.Newf("run migrations: %w", err)
+		return nil, xerrors.Newf("connect to database: %w", err)
 	}
-
-	s.seedAdmin()
-	return s, nil
+	return db, nil
 }
 
 // runMigrations applies any unapplied numbered SQL migrations from the
-// embedded migrations/ directory.
+// dialect's embedded migrations directory.
 func (s *Storage) runMigrations() error {
 	// The tracking table is the only thing we create outside of a migration file.
-	if _, err := s.db.Exec(`
+	ddl := `
 		CREATE TABLE IF NOT EXISTS schema_migrations (
 			version    INTEGER  PRIMARY KEY,
 			name       TEXT     NOT NULL,
 			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`); err != nil {
+		)`
+	if s.db.dialect == postgresDialect {
+		ddl = strings.Replace(ddl, "DATETIME", "TIMESTAMPTZ", 1)
+	}
+	if _, err := s.db.Exec(ddl); err != nil {
 		return xerrors.Newf("create schema_migrations: %w", err)
 	}
 
 	// For databases created before the migration system existed, detect which
-	// migrations are already applied by inspecting column presence.
-	if err := s.bootstrapMigrations(); err != nil {
-		return xerrors.Newf("bootstrap migrations: %w", err)
+	// migrations are already applied by inspecting column presence. Those are
+	// all SQLite.
+	if s.db.dialect == sqliteDialect {
+		if err := s.bootstrapMigrations(); err != nil {
+			return xerrors.Newf("bootstrap migrations: %w", err)
+		}
 	}
 
-	entries, err := migrationFS.ReadDir("migrations")
+	files, err := migrationFiles(s.db.dialect)
 	if err != nil {
-		return xerrors.Newf("read migrations dir: %w", err)
+		return err
+	}
+	for _, m := range files {
+		version, name := m.Version, m.Name
+		sql, err := migrationFS.ReadFile(s.db.dialect.migrations + "/" + name)
+		if err != nil {
+			return xerrors.Newf("read migration %s: %w", name, err)
+		}
+
+		var applied bool
+		if s.db.dialect == postgresDialect {
+			applied, err = s.applyPostgresMigration(version, name, string(sql))
+		} else {
+			applied, err = s.applySQLiteMigration(version, name, string(sql))
+		}
+		if err != nil {
+			return err
+		}
+		if applied {
+			slog.Info("applied migration", "version", version, "name", name)
+		}
 	}
 
+	return nil
+}
+
+// Migration is one schema migration and whether this database has it.
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"` // nil = pending
+	Unknown   bool       `json:"unknown"`    // applied, but not in this build (a newer one wrote it)
+}
+
+// migrationFiles lists the dialect's embedded migrations in version order.
+func migrationFiles(d *dialect) ([]Migration, error) {
+	entries, err := migrationFS.ReadDir(d.migrations)
+	if err != nil {
+		return nil, xerrors.Newf("read migrations dir: %w", err)
+	}
+	var out []Migration
 	for _, entry := range entries {
 		name := entry.Name()
 		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
 			continue
 		}
-
 		// Filename format: NNN_description.sql
 		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
 		if err != nil {
 			slog.Warn("migration skipped: unparseable version", "file", name)
 			continue
 		}
+		out = append(out, Migration{Version: version, Name: name})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
 
-		var applied bool
-		s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = ?)`, version).Scan(&applied)
-		if applied {
+// MigrationStatus lists this build's migrations with when each was applied,
+// followed by any applied ones this build does not know. It changes nothing;
+// a database that predates the migration system shows everything pending
+// until Migrate marks what it already has.
+func (s *Storage) MigrationStatus(ctx context.Context) ([]Migration, error) {
+	files, err := migrationFiles(s.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	exists := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`
+	if s.db.dialect == postgresDialect {
+		exists = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'`
+	}
+	var n int
+	if err := s.db.QueryRowContext(ctx, exists).Scan(&n); err != nil {
+		return nil, xerrors.Newf("look for schema_migrations: %w", err)
+	}
+	if n == 0 {
+		return files, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, xerrors.Newf("query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	known := make(map[int]int, len(files)) // version → index in files
+	for i, m := range files {
+		known[m.Version] = i
+	}
+	var unknown []Migration
+	for rows.Next() {
+		var m Migration
+		var at time.Time
+		if err := rows.Scan(&m.Version, &m.Name, &at); err != nil {
+			return nil, xerrors.Newf("scan migration: %w", err)
+		}
+		if i, ok := known[m.Version]; ok {
+			files[i].AppliedAt = &at
 			continue
 		}
+		m.AppliedAt, m.Unknown = &at, true
+		unknown = append(unknown, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Newf("read schema_migrations: %w", err)
+	}
+	return append(files, unknown...), nil
+}
 
-		sql, err := migrationFS.ReadFile("migrations/" + name)
-		if err != nil {
-			return xerrors.Newf("read migration %s: %w", name, err)
-		}
+// applySQLiteMigration applies one migration unless it already has been.
+func (s *Storage) applySQLiteMigration(version int, name, script string) (bool, error) {
+	var applied bool
+	s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = ?)`, version).Scan(&applied)
+	if applied {
+		return false, nil
+	}
 
-		// Run each statement in the file individually so SQLite can handle them.
-		for _, stmt := range splitSQL(string(sql)) {
-			if _, err := s.db.Exec(stmt); err != nil {
-				return xerrors.Newf("apply migration %s: %w", name, err)
-			}
+	// Run each statement in the file individually so SQLite can handle them.
+	for _, stmt := range splitSQL(script) {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return false, xerrors.Newf("apply migration %s: %w", name, err)
 		}
+	}
 
-		if _, err := s.db.Exec(
-			`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, version, name,
-		); err != nil {
-			return xerrors.Newf("record migration %s: %w", name, err)
-		}
-		slog.Info("applied migration", "version", version, "name", name)
+	if _, err := s.db.Exec(
+		`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, version, name,
+	); err != nil {
+		return false, xerrors.Newf("record migration %s: %w", name, err)
 	}
+	return true, nil
+}
 
-	return nil
+// migrationLockKey is the advisory lock PostgreSQL migrations hold, so
+// instances starting together against one database apply each file once.
+const migrationLockKey = 0x72654d617a // "reMaz"
+
+// applyPostgresMigration applies one migration, whole file at once, in a
+// transaction holding the migration lock. DDL is transactional in PostgreSQL,
+// so a failed migration leaves nothing behind.
+func (s *Storage) applyPostgresMigration(version int, name, script string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, xerrors.Newf("apply migration %s: %w", name, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLockKey); err != nil {
+		return false, xerrors.Newf("lock migrations: %w", err)
+	}
+	var applied bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = ?)`, version).Scan(&applied); err != nil {
+		return false, xerrors.Newf("check migration %s: %w", name, err)
+	}
+	if applied {
+		return false, nil
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return false, xerrors.Newf("apply migration %s: %w", name, err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, version, name,
+	); err != nil {
+		return false, xerrors.Newf("record migration %s: %w", name, err)
+	}
+	return true, tx.Commit()
 }
 
 // bootstrapMigrations marks migrations as applied for databases that were
@@ -161,7 +350,7 @@ func (s *Storage) bootstrapMigrations() error {
 			continue
 		}
 		if _, err := s.db.Exec(
-			`INSERT OR IGNORE INTO schema_migrations (version, name) VALUES (?, ?)`,
+			`INSERT INTO schema_migrations (version, name) VALUES (?, ?) ON CONFLICT DO NOTHING`,
 			c.version, c.name,
 		); err != nil {
 			return xerrors.Newf("bootstrap v%d: %w", c.version, err)
@@ -193,8 +382,8 @@ func (s *Storage) columnExists(table, column string) bool {
 	return false
 }
 
-// splitSQL splits a SQL script into individual statements, correctly handling
-// SQLite trigger bodies (BEGIN...END blocks) that contain semicolons.
+// splitSQL splits a SQLite script into individual statements, correctly handling
+// trigger bodies (BEGIN...END blocks) that contain semicolons.
 func splitSQL(s string) []string {
 	var stmts []string
 	var buf strings.Builder
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMigrationStatus(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(t.TempDir() + "/state.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ms, err := s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 || ms[len(ms)-1].Version != LatestMigration() {
+		t.Fatalf("fresh database lists %+v", ms)
+	}
+	for _, m := range ms {
+		if m.AppliedAt != nil {
+			t.Errorf("%s applied before Migrate", m.Name)
+		}
+	}
+
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'from_the_future.sql')`, LatestMigration()+1)
+	ms, err = s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := ms[len(ms)-1]
+	if !last.Unknown || last.Name != "from_the_future.sql" {
+		t.Errorf("unknown migration not listed last: %+v", last)
+	}
+	for _, m := range ms[:len(ms)-1] {
+		if m.AppliedAt == nil || m.Unknown {
+			t.Errorf("%s not applied after Migrate", m.Name)
+		}
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir() + "/state.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	u, err := s.GetUserByUsername(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPassword(ctx, u.ID, "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ctx, "admin", "new secret"); err != nil {
+		t.Errorf("new password rejected: %v", err)
+	}
+	if _, err := s.GetUserByUsername(ctx, "nobody"); err == nil {
+		t.Error("unknown user found")
+	}
+}
@@ -96,7 +96,7 @@ func (s *Storage) GetActiveBans(ctx context.Context) ([]BannedIP, error) {
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT ip, reason, tier, created_at, expires_at
 		FROM banned_ips
-		WHERE expires_at IS NULL OR expires_at > datetime('now')
+		WHERE expires_at IS NULL OR expires_at > `+s.db.dialect.now()+`
 		ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, xerrors.Newf("query bans: %w", err)
@@ -143,5 +143,5 @@ func (s *Storage) DeleteBan(ctx context.Context, ip string) error {
 // CleanupExpiredBans removes bans whose expiry has passed.
 func (s *Storage) CleanupExpiredBans(ctx context.Context) {
 	s.db.ExecContext(ctx,
-		`DELETE FROM banned_ips WHERE expires_at IS NOT NULL AND expires_at <= datetime('now')`)
+		`DELETE FROM banned_ips WHERE expires_at IS NOT NULL AND expires_at <= `+s.db.dialect.now())
 }
@@ -61,6 +61,34 @@ func (s *Storage) GetUserByID(ctx context.Context, id int) (*User, error) {
 	return &u, nil
 }
 
+func (s *Storage) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, username, created_at FROM users WHERE username = ?`, username,
+	).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err != nil {
+		return nil, xerrors.Newf("user not found: %w", err)
+	}
+	return &u, nil
+}
+
+// SetPassword replaces a user's password. Existing sessions stay valid.
+func (s *Storage) SetPassword(ctx context.Context, userID int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return xerrors.Newf("hash password: %w", err)
+	}
+	result, err := s.db.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ?`, string(hash), userID)
+	if err != nil {
+		return xerrors.Newf("set password: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return xerrors.Newf("user not found")
+	}
+	slog.Info("password changed", "id", userID)
+	return nil
+}
+
 func (s *Storage) GetAllUsers(ctx context.Context) ([]User, error) {
 	rows, err := s.db.QueryContext(ctx,
 		`SELECT id, username, created_at FROM users ORDER BY username`)
@@ -27,6 +27,16 @@ async function api(method, path, body) {
     return res.json().catch(() => null); 
 }
 
+// Inline on* handlers are blocked by the Content-Security-Policy, so controls
+// name their handler in data-click / data-change / data-input (plus an optional
+// data-arg) and these listeners dispatch to it.
+['click', 'change', 'input'].forEach(type => {
+    document.addEventListener(type, e => {
+        const el = e.target.closest(`[data-${type}]`);
+        if (el) window[el.dataset[type]](...(el.dataset.arg !== undefined ? [el.dataset.arg] : []));
+    });
+});
+
 // ── menu view switching ───────────────────────────────────────────────────
 document.querySelectorAll('.menuContainer button').forEach(btn => {
     btn.addEventListener('click', () => {
@@ -241,6 +251,10 @@ async function deleteGroup(id, name) {
 }
 
 // ── routes ────────────────────────────────────────────────────────────────
+// securityProfiles are the security-header profile names a route may select,
+// refreshed with the route list.
+let securityProfiles = [];
+
 async function loadRoutes() {
     const [routeData, groupData] = await Promise.all([
         api('GET', 'admin/routes'),
@@ -250,6 +264,8 @@ async function loadRoutes() {
     const groups = groupData?.groups || [];
     const list = document.getElementById('routeItems');
     list.innerHTML = '';
+    renderListeners(routeData.listeners || []);
+    securityProfiles = routeData.security_profiles || [];
 
     // Collapse port-range routes: every port shares a range_group and is rendered
     // as a single logical row. Non-range routes render one row each.
@@ -268,6 +284,49 @@ async function loadRoutes() {
     });
 }
 
+// TRANSPORT_FIELDS are the per-route backend transport knobs (seconds or
+// connection counts). 0 keeps the built-in default, -1 disables the limit.
+// BREAKER_FIELDS are the per-route circuit-breaker knobs. The breaker is off
+// while both the error threshold and the latency threshold are 0.
+const BREAKER_FIELDS = [
+    ['error_pct',    'Error threshold', '% bad calls in the window (default 50 if only latency is set)'],
+    ['latency_ms',   'Slow call',       'ms, slower calls count as bad (0 = ignore latency)'],
+    ['window_sec',   'Window',          's (default 30)'],
+    ['min_requests', 'Min requests',    'calls in the window before it may open (default 20)'],
+    ['open_sec',     'Open for',        's before probing the backend again (default 30)'],
+];
+
+const TRANSPORT_FIELDS = [
+    ['dial_timeout',            'Dial timeout',     's',     10],
+    ['tls_handshake_timeout',   'TLS handshake',    's',     10],
+    ['response_header_timeout', 'Response headers', 's',     60],
+    ['request_timeout',         'Request timeout',  's',     'none'],
+    ['idle_conn_timeout',       'Idle conn',        's',     90],
+    ['max_idle_conns',          'Max idle conns',   'conns', 100],
+    ['max_idle_conns_per_host', 'Max idle / host',  'conns', 10],
+];
+
+// renderListeners shows the live HTTP listeners and their effective timeouts.
+// These come from [[listeners]] in config.toml and are read-only here.
+function renderListeners(listeners) {
+    const list = document.getElementById('listenerItems');
+    if (!list) return;
+    const fmt = v => v ? v + 's' : 'none';
+    list.innerHTML = listeners.length ? '' : '<em style="font-size:11px;color:#888">No HTTP listeners</em>';
+    listeners.forEach(l => {
+        const t = l.timeouts || {};
+        const el = document.createElement('div');
+        el.className = 'item';
+        el.style.cursor = 'default';
+        el.innerHTML = `
+            <div style="flex:1;min-width:0">
+                <div class="itemMain">:${l.port}${l.tls ? ' <span class="badge badge-config">tls</span>' : ''}</div>
+                <div class="itemSub">header ${fmt(t.read_header_timeout)} · read ${fmt(t.read_timeout)} · write ${fmt(t.write_timeout)} · idle ${fmt(t.idle_timeout)}</div>
+            </div>`;
+        list.appendChild(el);
+    });
+}
+
 // portOf extracts the numeric port from a "host:port" url.
 function portOf(url) { return parseInt(url.slice(url.lastIndexOf(':') + 1), 10); }
 
@@ -307,6 +366,10 @@ function renderRouteItem(members, groups, list) {
     const typeName    = rep.type || 'proxy';
     const typeBadge   = `<span class="badge badge-${typeName.replace('+', '')}">${typeName}</span>`;
     const sourceBadge = `<span class="badge badge-${rep.source}">${rep.source}</span>`;
+    const mt = rep.maintenance || {};
+    const maintBadge = mt.enabled
+        ? `<span class="badge badge-maint" title="${mt.start || mt.end ? `${mt.start || 'now'} → ${mt.end || 'until disabled'}` : 'until disabled'}">maintenance</span>`
+        : '';
     const delBtn = rep.source === 'ui'
         ? `<button class="delBtn" title="Delete route">×</button>`
         : '';
@@ -319,7 +382,7 @@ function renderRouteItem(members, groups, list) {
             <div class="itemSub">${displaySub}</div>
         </div>
         <div class="tags">${groupHint}</div>
-        ${typeBadge}${rangeBadge}${sourceBadge}
+        ${typeBadge}${rangeBadge}${maintBadge}${sourceBadge}
         ${delBtn}
         <button class="editBtn" style="flex-shrink:0;font-size:11px;padding:0 10px;height:24px">Edit</button>
     `;
@@ -351,10 +414,27 @@ function buildRouteEditPanel(route, groups, isGroup) {
 
     // Per-port backend edits are not offered for a port range — the offset makes a
     // single target ambiguous. Access control (below) applies to every port.
+    const isRedirect = route.type === 'redirect';
     const targetRow = (route.source === 'ui' && !isGroup) ? `
         <div class="routeEditRow">
-            <label>Backend</label>
-            <input type="text" class="targetInput" value="${route.target}" placeholder="host:port">
+            <label>${isRedirect ? 'Location' : 'Backend'}</label>
+            <input type="text" class="targetInput" value="${route.target}" placeholder="${isRedirect ? 'https://new.example.com{uri}' : 'host:port'}">
+        </div>
+    ` : '';
+
+    // Redirect routes — status code and an optional path pattern whose groups
+    // ($1, ${name}) the Location may use.
+    const rd = route.redirect || {};
+    const redirectRouteRows = (route.source === 'ui' && isRedirect) ? `
+        <div class="routeEditRow">
+            <label>Status</label>
+            <select class="redirectCode">
+                ${[302, 301, 303, 307, 308].map(c => `<option value="${c}" ${(rd.code || 302) === c ? 'selected' : ''}>${c}</option>`).join('')}
+            </select>
+        </div>
+        <div class="routeEditRow">
+            <label>Match</label>
+            <input type="text" class="redirectMatch" placeholder="^/blog/(\\d+)$ — empty matches every path">
         </div>
     ` : '';
 
@@ -401,16 +481,244 @@ function buildRouteEditPanel(route, groups, isGroup) {
             <span style="font-size:11px;color:#888">any signed-in user may access (no specific group needed)</span>
         </div>`;
 
+    // Maintenance — every route, ranges included. HTTP routes get a 503 page;
+    // raw routes refuse new connections. Bypass IPs/groups still reach the backend.
+    const mt = route.maintenance || {};
+    const isRawRoute = route.type === 'tcp' || route.type === 'udp' || route.type === 'tcp+udp';
+    const maintGroups = new Set((mt.bypass_groups || '').split(',').map(s => s.trim()).filter(Boolean));
+    const maintenanceRows = `
+        <div class="sectionLabel" style="margin-top:8px">Maintenance</div>
+        <div class="routeEditRow">
+            <label>Maintenance</label>
+            <input type="checkbox" class="maintEnabled" ${mt.enabled ? 'checked' : ''}>
+            <span style="font-size:11px;color:#888">${isRawRoute ? 'refuse new connections' : 'serve a 503 maintenance page'}</span>
+        </div>
+        <div class="routeEditRow">
+            <label>Window</label>
+            <input type="datetime-local" class="maintStart" value="${toLocalInput(mt.start)}">
+            <span style="font-size:11px;color:#888">to</span>
+            <input type="datetime-local" class="maintEnd" value="${toLocalInput(mt.end)}">
+        </div>
+        ${isRawRoute ? '' : `
+        <div class="routeEditRow">
+            <label>Page</label>
+            <textarea class="maintPage" rows="2" placeholder="inline HTML, or a file/directory path such as ./www/maintenance — empty uses the built-in page"></textarea>
+        </div>
+        <div class="routeEditRow">
+            <label>Retry-After</label>
+            <input type="number" class="maintRetry" value="${mt.retry_after || 0}" min="0" style="width:80px">
+            <span style="font-size:11px;color:#888">s (0 = time left in the window, if it has an end)</span>
+        </div>`}
+        <div class="routeEditRow">
+            <label>Bypass IPs</label>
+            <input type="text" class="maintBypassIps" value="${mt.bypass_ips || ''}" placeholder="10.0.0.0/8, 192.168.1.5">
+        </div>
+        <div class="routeEditRow">
+            <label>Bypass groups</label>
+            <div class="maintGroupList">${groups.map(g => `
+                <label class="groupCheck">
+                    <input type="checkbox" value="${g.id}" ${maintGroups.has(String(g.id)) ? 'checked' : ''}>
+                    ${g.name}
+                </label>`).join('') || '<em style="font-size:11px;color:#888">No groups yet</em>'}</div>
+        </div>
+    `;
+
+    // Backend transport tuning — UI-sourced HTTP proxy routes only (config routes
+    // take theirs from config.toml; raw routes have no HTTP transport).
+    const isProxy = !route.type || route.type === 'proxy';
+    const tr = route.transport || {};
+    const transportRows = (route.source === 'ui' && !isGroup && isProxy) ? `
+        <div class="sectionLabel" style="margin-top:8px">Transport <span style="font-size:11px;color:#888;font-weight:normal">0 = default, -1 = no limit</span></div>
+        ${TRANSPORT_FIELDS.map(([key, label, unit, def]) => `
+        <div class="routeEditRow">
+            <label>${label}</label>
+            <input type="number" class="transportInput" data-key="${key}" value="${tr[key] || 0}" min="-1" style="width:80px">
+            <span style="font-size:11px;color:#888">${unit} (default ${def})</span>
+        </div>`).join('')}
+    ` : '';
+
+    // Retry/failover policy — same scope as transport. Attempts counts every try,
+    // so 0 or 1 disables retries; each retry moves to the next backend in the
+    // comma-separated target list.
+    const rt = route.retry || {};
+    const retryRows = transportRows ? `
+        <div class="sectionLabel" style="margin-top:8px">Retries <span style="font-size:11px;color:#888;font-weight:normal">list several backends in Backend, comma-separated, to fail over</span></div>
+        <div class="routeEditRow">
+            <label>Attempts</label>
+            <input type="number" class="retryAttempts" value="${rt.attempts || 0}" min="0" style="width:80px">
+            <span style="font-size:11px;color:#888">total tries (0/1 = no retries)</span>
+        </div>
+        <div class="routeEditRow">
+            <label>Retry on</label>
+            <input type="text" class="retryOn" value="${rt.on || ''}" placeholder="connect,502,503,504">
+        </div>
+        <div class="routeEditRow">
+            <label>Backoff</label>
+            <input type="number" class="retryBackoff" value="${rt.backoff_ms || 0}" min="0" style="width:80px">
+            <span style="font-size:11px;color:#888">ms, doubled per retry with jitter (default 50)</span>
+        </div>
+        <div class="routeEditRow">
+            <label>Retry budget</label>
+            <input type="number" class="retryBudget" value="${rt.budget_pct || 0}" min="0" max="100" style="width:80px">
+            <span style="font-size:11px;color:#888">% of recent requests (default 20)</span>
+        </div>
+        <div class="routeEditRow">
+            <label>Non-idempotent</label>
+            <input type="checkbox" class="retryNonIdem" ${rt.non_idempotent ? 'checked' : ''}>
+            <span style="font-size:11px;color:#888">also retry POST/PATCH (unsafe unless the backend dedupes)</span>
+        </div>
+    ` : '';
+
+    // Circuit breaker — UI-sourced proxy and TCP routes, one breaker per backend.
+    const cb = route.breaker || {};
+    const breakerRows = (route.source === 'ui' && !isGroup && (isProxy || route.type === 'tcp' || route.type === 'tcp+udp')) ? `
+        <div class="sectionLabel" style="margin-top:8px">Circuit breaker <span style="font-size:11px;color:#888;font-weight:normal">off while both thresholds are 0</span></div>
+        ${BREAKER_FIELDS.map(([key, label, hint]) => `
+        <div class="routeEditRow">
+            <label>${label}</label>
+            <input type="number" class="breakerInput" data-key="${key}" value="${cb[key] || 0}" min="0" style="width:80px">
+            <span style="font-size:11px;color:#888">${hint}</span>
+        </div>`).join('')}
+        <div class="routeEditRow">
+            <label>Fallback</label>
+            <input type="text" class="breakerFallback" value="${cb.fallback || ''}" placeholder="backup:8080 or ./www/maintenance">
+        </div>
+    ` : '';
+
+    // Response cache — UI-sourced proxy routes only, sized globally by [cache].
+    const rc = route.cache || {};
+    const cacheRows = transportRows ? `
+        <div class="sectionLabel" style="margin-top:8px">Response cache</div>
+        <div class="routeEditRow">
+            <label>Cache</label>
+            <input type="checkbox" class="cacheEnabled" ${rc.enabled ? 'checked' : ''}>
+            <span style="font-size:11px;color:#888">cache GET responses, honouring Cache-Control</span>
+        </div>
+        <div class="routeEditRow">
+            <label>Default TTL</label>
+            <input type="number" class="cacheTTL" value="${rc.default_ttl || 0}" min="0" style="width:80px">
+            <span style="font-size:11px;color:#888">s, when the backend sends no Cache-Control/Expires (0 = revalidate)</span>
+        </div>
+    ` : '';
+
+    // Compression — UI-sourced proxy and static routes.
+    const cp = route.compress || {};
+    const compressRows = (route.source === 'ui' && !isGroup && (isProxy || route.type === 'static')) ? `
+        <div class="sectionLabel" style="margin-top:8px">Compression</div>
+        <div class="routeEditRow">
+            <label>Compress</label>
+            <input type="checkbox" class="compressEnabled" ${cp.enabled ? 'checked' : ''}>
+            <span style="font-size:11px;color:#888">gzip / brotli / zstd, as the client accepts</span>
+        </div>
+        <div class="routeEditRow">
+            <label>MIME types</label>
+            <input type="text" class="compressTypes" value="${cp.types || ''}" placeholder="text/*, application/javascript, application/json, image/svg+xml …">
+        </div>
+        <div class="routeEditRow">
+            <label>Min size</label>
+            <input type="number" class="compressMinSize" value="${cp.min_size || 0}" min="0" style="width:80px">
+            <span style="font-size:11px;color:#888">bytes (default 1024)</span>
+        </div>
+    ` : '';
+
+    // Header rewrite rules — UI-sourced HTTP routes; request rules reach the
+    // backend, so only proxy routes get them. One rule per line.
+    const isHttpUI = route.source === 'ui' && !isGroup && (isProxy || isRedirect || route.type === 'static' || route.type === 'api');
+    const headerRows = isHttpUI ? `
+        <div class="sectionLabel" style="margin-top:8px">Headers <span style="font-size:11px;color:#888;font-weight:normal">set | add | append | remove Name value — {client_ip} {username} {route} {request_id} {host} {path}</span></div>
+        ${isProxy ? `
+        <div class="routeEditRow">
+            <label>Request</label>
+            <textarea class="headerRules" data-key="request" rows="3" placeholder="set X-Remote-User {username}"></textarea>
+        </div>` : ''}
+        <div class="routeEditRow">
+            <label>Response</label>
+            <textarea class="headerRules" data-key="response" rows="3" placeholder="remove Server"></textarea>
+        </div>
+    ` : '';
+
+    // Upstream path rewrites — UI-sourced proxy routes. "<pattern> <replacement>"
+    // per line; the first matching rule wins.
+    const rewriteRows = transportRows ? `
+        <div class="sectionLabel" style="margin-top:8px">Rewrite <span style="font-size:11px;color:#888;font-weight:normal">pattern replacement — first match wins, $1 for groups</span></div>
+        <div class="routeEditRow">
+            <label>Rules</label>
+            <textarea class="rewriteRules" rows="2" placeholder="^/api/v1/(.*) /v1/$1"></textarea>
+        </div>
+    ` : '';
+
+    // Security headers — same scope. Profile headers are only added when the
+    // app doesn't send its own; overrides are "Name: value" lines.
+    const sec = route.security || {};
+    const securityRows = isHttpUI ? `
+        <div class="sectionLabel" style="margin-top:8px">Security headers</div>
+        <div class="routeEditRow">
+            <label>Profile</label>
+            <select class="securityProfile">
+                <option value="">none</option>
+                ${securityProfiles.filter(p => p !== 'none').map(p => `<option value="${p}" ${sec.profile === p ? 'selected' : ''}>${p}</option>`).join('')}
+            </select>
+        </div>
+        <div class="routeEditRow">
+            <label>Overrides</label>
+            <textarea class="securityHeaders" rows="2" placeholder="Content-Security-Policy: default-src 'self' cdn.example.com"></textarea>
+        </div>
+        <div class="routeEditRow"><label></label><span style="font-size:11px;color:#888">"Name:" with no value drops that header from the profile</span></div>
+    ` : '';
+
+    // Canonical hosts — aliases answered on this route's listener with a
+    // redirect to the route's own host.
+    const redirectRows = isHttpUI ? `
+        <div class="sectionLabel" style="margin-top:8px">Canonical host</div>
+        <div class="routeEditRow">
+            <label>Redirect hosts</label>
+            <input type="text" class="redirectHosts" placeholder="www.example.com, example.org">
+        </div>
+        <div class="sectionLabel" style="margin-top:8px">Error pages</div>
+        <div class="routeEditRow">
+            <label>Directory</label>
+            <input type="text" class="errorPagesDir" placeholder="./www/errors/app — 404.html, 503.html, default.html">
+        </div>
+        <div class="routeEditRow routeEditMsg" style="display:none;color:#c0392b"></div>
+    ` : '';
+
     panel.innerHTML = `
         ${targetRow}
+        ${redirectRouteRows}
         ${ipAuthRows}
         ${cookieRows}
+        ${maintenanceRows}
+        ${transportRows}
+        ${retryRows}
+        ${breakerRows}
+        ${cacheRows}
+        ${compressRows}
+        ${headerRows}
+        ${rewriteRows}
+        ${securityRows}
+        ${redirectRows}
         <div class="routeEditActions">
-            <button onclick="this.closest('.routeEdit').style.display='none'">Cancel</button>
+            <button class="cancelBtn">Cancel</button>
             <button class="saveBtn">Save</button>
         </div>
     `;
 
+    // Set as values rather than markup: rules may contain < or &.
+    const hd = route.headers || {};
+    panel.querySelectorAll('.headerRules').forEach(el => { el.value = hd[el.dataset.key] || ''; });
+    const secHeaders = panel.querySelector('.securityHeaders');
+    if (secHeaders) secHeaders.value = sec.headers || '';
+    const maintPage = panel.querySelector('.maintPage');
+    if (maintPage) maintPage.value = mt.page || '';
+    const redirectMatch = panel.querySelector('.redirectMatch');
+    if (redirectMatch) redirectMatch.value = rd.match || '';
+    const rewriteRules = panel.querySelector('.rewriteRules');
+    if (rewriteRules) rewriteRules.value = route.rewrite || '';
+    const redirectHosts = panel.querySelector('.redirectHosts');
+    if (redirectHosts) redirectHosts.value = route.redirect_hosts || '';
+    const errorPagesDir = panel.querySelector('.errorPagesDir');
+    if (errorPagesDir) errorPagesDir.value = route.error_pages || '';
+
     // TCP routes have no cookie/HTTP login, so group membership can only be enforced
     // via IP session auth. Selecting a group therefore forces ip_auth on — keep the
     // checkbox checked and locked so the UI matches what the backend enforces.
@@ -429,6 +737,7 @@ function buildRouteEditPanel(route, groups, isGroup) {
         syncIpAuth();
     }
 
+    panel.querySelector('.cancelBtn').addEventListener('click', () => { panel.style.display = 'none'; });
     panel.querySelector('.saveBtn').addEventListener('click', async () => {
         const checked = [...panel.querySelectorAll('.groupCheckList input:checked')].map(el => el.value);
         const body = {
@@ -439,19 +748,104 @@ function buildRouteEditPanel(route, groups, isGroup) {
             persistent_login: panel.querySelector('.persistentLoginCheck')?.checked ?? true,
             require_login:    panel.querySelector('.requireLoginCheck')?.checked ?? false,
         };
+        body.maintenance = {
+            enabled:       panel.querySelector('.maintEnabled').checked,
+            start:         fromLocalInput(panel.querySelector('.maintStart').value),
+            end:           fromLocalInput(panel.querySelector('.maintEnd').value),
+            page:          maintPage ? maintPage.value.trim() : (mt.page || ''),
+            retry_after:   parseInt(panel.querySelector('.maintRetry')?.value, 10) || 0,
+            bypass_ips:    panel.querySelector('.maintBypassIps').value.trim(),
+            bypass_groups: [...panel.querySelectorAll('.maintGroupList input:checked')].map(el => el.value).join(','),
+        };
         const ti = panel.querySelector('.targetInput');
         if (ti) body.target = ti.value;
+        const tInputs = panel.querySelectorAll('.transportInput');
+        if (tInputs.length) {
+            body.transport = {};
+            tInputs.forEach(el => { body.transport[el.dataset.key] = parseInt(el.value, 10) || 0; });
+        }
+        if (panel.querySelector('.retryAttempts')) {
+            body.retry = {
+                attempts:       parseInt(panel.querySelector('.retryAttempts').value, 10) || 0,
+                on:             panel.querySelector('.retryOn').value.trim(),
+                backoff_ms:     parseInt(panel.querySelector('.retryBackoff').value, 10) || 0,
+                budget_pct:     parseInt(panel.querySelector('.retryBudget').value, 10) || 0,
+                non_idempotent: panel.querySelector('.retryNonIdem').checked,
+            };
+        }
+        const bInputs = panel.querySelectorAll('.breakerInput');
+        if (bInputs.length) {
+            body.breaker = { fallback: panel.querySelector('.breakerFallback').value.trim() };
+            bInputs.forEach(el => { body.breaker[el.dataset.key] = parseInt(el.value, 10) || 0; });
+        }
+        if (panel.querySelector('.cacheEnabled')) {
+            body.cache = {
+                enabled:     panel.querySelector('.cacheEnabled').checked,
+                default_ttl: parseInt(panel.querySelector('.cacheTTL').value, 10) || 0,
+            };
+        }
+        if (panel.querySelector('.compressEnabled')) {
+            body.compress = {
+                enabled:  panel.querySelector('.compressEnabled').checked,
+                types:    panel.querySelector('.compressTypes').value.trim(),
+                min_size: parseInt(panel.querySelector('.compressMinSize').value, 10) || 0,
+            };
+        }
+        const hInputs = panel.querySelectorAll('.headerRules');
+        if (hInputs.length) {
+            body.headers = { request: hd.request || '' };
+            hInputs.forEach(el => { body.headers[el.dataset.key] = el.value.trim(); });
+        }
+        if (secHeaders) {
+            body.security = {
+                profile: panel.querySelector('.securityProfile').value,
+                headers: secHeaders.value.trim(),
+            };
+        }
+        if (redirectMatch) {
+            body.redirect = {
+                code:  parseInt(panel.querySelector('.redirectCode').value, 10),
+                match: redirectMatch.value.trim(),
+            };
+        }
+        if (rewriteRules) body.rewrite = rewriteRules.value.trim();
+        if (errorPagesDir) body.error_pages = errorPagesDir.value.trim();
+        if (redirectHosts) {
+            body.redirect_hosts = redirectHosts.value.split(',').map(h => h.trim()).filter(Boolean).join(',');
+        }
         const query = isGroup ? 'group=' + encodeURIComponent(route.range_group) : 'id=' + route.id;
-        await api('PUT', 'admin/routes?' + query, body);
+        const data = await api('PUT', 'admin/routes?' + query, body);
+        if (data && data.error) {
+            const msg = panel.querySelector('.routeEditMsg');
+            if (msg) {
+                msg.style.display = '';
+                msg.textContent = `✗ ${data.error}`;
+                return; // keep the panel open so the input can be fixed
+            }
+        }
         loadRoutes();
     });
 
     return panel;
 }
 
+// toLocalInput turns an RFC 3339 time into a datetime-local input value.
+function toLocalInput(iso) {
+    const d = iso ? new Date(iso) : null;
+    if (!d || isNaN(d)) return '';
+    return new Date(d.getTime() - d.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
+}
+
+// fromLocalInput turns a datetime-local input value into RFC 3339 (UTC).
+function fromLocalInput(v) {
+    return v ? new Date(v).toISOString().replace(/\.\d{3}Z$/, 'Z') : '';
+}
+
 function onRouteTypeChange() {
     // Raw routes (tcp/udp/tcp+udp) don't terminate TLS — hide the TLS toggle.
     const t = document.getElementById('newRouteType').value;
+    document.getElementById('newRouteTarget').placeholder =
+        t === 'redirect' ? 'location (https://new.example.com{uri})' : 'target (ip:port)';
     const isRaw = t === 'tcp' || t === 'udp' || t === 'tcp+udp';
     document.getElementById('newRouteTlsRow').style.display = isRaw ? 'none' : '';
     if (isRaw) document.getElementById('newRouteTls').checked = false;
@@ -536,12 +930,16 @@ async function deleteRouteGroup(rangeGroup, url, count) {
 // ── metrics ───────────────────────────────────────────────────────────────
 let metricsAccessLogData = [];
 let metricsFailureData   = [];
+let metricsFlowData      = [];
+let metricsFlowTotals    = [];
+let metricsLiveData      = [];
 
 function getMetricsFilters() {
     return {
         username: document.getElementById('filterUsername')?.value.trim().toLowerCase() || '',
         ip:       document.getElementById('filterIP')?.value.trim().toLowerCase() || '',
         route:    document.getElementById('filterRoute')?.value.trim().toLowerCase() || '',
+        requestId: document.getElementById('filterRequestId')?.value.trim().toLowerCase() || '',
         status:   document.getElementById('filterStatus')?.value || '',
     };
 }
@@ -550,17 +948,35 @@ function applyMetricsFilters() {
     const f = getMetricsFilters();
     renderAccessLog(f);
     renderFailures(f);
+    renderFlows(f);
+    renderLive(f);
+    renderRecentEvents();
 }
 
 function clearMetricsFilters() {
     document.getElementById('filterUsername').value = '';
     document.getElementById('filterIP').value = '';
     document.getElementById('filterRoute').value = '';
+    document.getElementById('filterRequestId').value = '';
     document.getElementById('filterStatus').value = '';
     document.querySelectorAll('#routeStatItems .item').forEach(i => i.classList.remove('selected'));
     applyMetricsFilters();
 }
 
+// accessEventDetails is the hover text of an access-log row.
+function accessEventDetails(e) {
+    const lines = [];
+    if (e.request_id) lines.push(`Request ID ${e.request_id}`);
+    if (e.auth_method) lines.push(`Auth: ${e.auth_method}`);
+    if (e.method) {
+        let timing = `${e.duration_ms} ms`;
+        if (e.upstream_ms) timing += ` (backend ${e.upstream_ms} ms)`;
+        lines.push(`${timing}, ${e.bytes} bytes`);
+    }
+    if (e.user_agent) lines.push(e.user_agent);
+    return lines.join('\n');
+}
+
 function accessEventBadge(username) {
     if (!username) return '<span class="evtBadge anon" data-status="anon">anon</span>';
     if (isDenied(username)) return '<span class="evtBadge denied" data-status="denied">denied</span>';
@@ -572,11 +988,12 @@ function isAnon(u)   { return !u; }
 function isOk(u)     { return !!u && !isDenied(u); }
 
 function renderAccessLog(filters) {
-    const { username, ip, route, status } = filters || {};
+    const { username, ip, route, requestId, status } = filters || {};
     let entries = metricsAccessLogData;
     if (username) entries = entries.filter(e => (e.username || '').toLowerCase().includes(username));
     if (ip)       entries = entries.filter(e => e.ip.toLowerCase().includes(ip));
     if (route)    entries = entries.filter(e => e.route_url.toLowerCase().includes(route));
+    if (requestId) entries = entries.filter(e => (e.request_id || '').toLowerCase().includes(requestId));
     if (status === 'denied') entries = entries.filter(e => isDenied(e.username));
     if (status === 'anon')   entries = entries.filter(e => isAnon(e.username));
     if (status === 'ok')     entries = entries.filter(e => isOk(e.username));
@@ -592,12 +1009,18 @@ function renderAccessLog(filters) {
         const el = document.createElement('div');
         el.className = 'item';
         el.style.cursor = 'default';
+        el.title = accessEventDetails(e);
         el.innerHTML = `
             <span class="failureIp">${e.ip}</span>
             ${accessEventBadge(e.username)}
-            <span class="itemSub" style="flex:1;overflow:hidden;text-overflow:ellipsis;white-space:nowrap" title="${e.route_url}">${e.route_url}</span>
+            ${e.status ? `<span class="itemSub accessStatus ${e.status >= 400 ? 'bad' : ''}">${e.status}</span>` : ''}
+            <span class="itemSub accessTarget" style="flex:1;overflow:hidden;text-overflow:ellipsis;white-space:nowrap"></span>
             <span class="itemSub" style="flex-shrink:0">${relTime(e.created_at)}</span>
         `;
+        // The path is client-supplied, so it is set as text.
+        el.querySelector('.accessTarget').textContent = e.method
+            ? `${e.method} ${e.route_url}${e.path}`
+            : `${(e.protocol || 'http').toUpperCase()} ${e.route_url}`;
         el.addEventListener('click', ev => {
             const badge = ev.target.closest('[data-status]');
             if (badge) {
@@ -663,6 +1086,138 @@ function renderFailures(filters) {
     }
 }
 
+// renderFlows shows finished TCP/UDP flows and per-route, per-user totals.
+function renderFlows(filters) {
+    const { username, ip, route, requestId } = filters || {};
+    const match = f =>
+        (!username || (f.username || '').toLowerCase().includes(username)) &&
+        (!route || f.route_url.toLowerCase().includes(route));
+    let flows = metricsFlowData.filter(match);
+    if (ip)        flows = flows.filter(f => f.ip.toLowerCase().includes(ip));
+    if (requestId) flows = flows.filter(f => f.request_id.toLowerCase().includes(requestId));
+
+    document.getElementById('flowCount').textContent =
+        flows.length < metricsFlowData.length
+            ? `${flows.length} / ${metricsFlowData.length}`
+            : `${metricsFlowData.length} finished`;
+
+    // Totals cover every stored flow, so only the user and route filters apply.
+    const totals = document.getElementById('flowTotalItems');
+    totals.innerHTML = '';
+    metricsFlowTotals.filter(match).slice(0, 12).forEach(t => {
+        const b = document.createElement('span');
+        b.className = 'evtBadge clickable';
+        b.textContent = `${t.route_url} · ${t.username || 'anon'}: ${t.flows} × ↑${fmtBytes(t.bytes_in)} ↓${fmtBytes(t.bytes_out)}`;
+        b.title = `${fmtDuration(t.duration_ms)} connected in total — click to filter`;
+        b.addEventListener('click', () => {
+            document.getElementById('filterRoute').value = t.route_url;
+            document.getElementById('filterUsername').value = t.username;
+            applyMetricsFilters();
+        });
+        totals.appendChild(b);
+    });
+
+    const list = document.getElementById('flowItems');
+    list.innerHTML = '';
+    flows.slice(0, 500).forEach(f => {
+        const el = document.createElement('div');
+        el.className = 'item';
+        el.style.cursor = 'default';
+        el.title = `Request ID ${f.request_id}\nStarted ${new Date(f.started_at).toLocaleString()}`;
+        el.innerHTML = `
+            <span class="failureIp">${f.ip}</span>
+            ${f.username ? '<span class="evtBadge ok"></span>' : '<span class="evtBadge anon">anon</span>'}
+            <span class="itemSub" style="flex:1;overflow:hidden;text-overflow:ellipsis;white-space:nowrap">${f.protocol.toUpperCase()} ${f.route_url}</span>
+            <span class="itemSub" style="flex-shrink:0">${fmtDuration(f.duration_ms)} · ↑${fmtBytes(f.bytes_in)} ↓${fmtBytes(f.bytes_out)}</span>
+            <span class="evtBadge ${f.close_reason === 'idle' || f.close_reason === 'shutdown' ? 'warn' : 'anon'}">${f.close_reason.replace('_', ' ')}</span>
+            <span class="itemSub" style="flex-shrink:0">${relTime(f.ended_at)}</span>
+        `;
+        if (f.username) el.querySelector('.evtBadge.ok').textContent = f.username;
+        list.appendChild(el);
+    });
+    if (!flows.length) {
+        list.innerHTML = '<p style="font-size:12px;color:#aaa;margin:10px 0 0 4px">No matching flows.</p>';
+    }
+}
+
+async function loadLive() {
+    const data = await api('GET', 'admin/connections');
+    metricsLiveData = data?.connections || [];
+}
+
+// renderLive shows open TCP/UDP flows and in-flight HTTP requests, each with a
+// kill button.
+function renderLive(filters) {
+    const { username, ip, route, requestId } = filters || {};
+    const conns = metricsLiveData.filter(c =>
+        (!username || (c.username || '').toLowerCase().includes(username)) &&
+        (!ip || c.ip.toLowerCase().includes(ip)) &&
+        (!route || c.route_url.toLowerCase().includes(route)) &&
+        (!requestId || c.id.toLowerCase().includes(requestId)));
+
+    document.getElementById('liveCount').textContent =
+        conns.length < metricsLiveData.length
+            ? `${conns.length} / ${metricsLiveData.length}`
+            : `${metricsLiveData.length} open`;
+
+    const list = document.getElementById('liveItems');
+    list.innerHTML = '';
+    conns.forEach(c => {
+        const el = document.createElement('div');
+        el.className = 'item';
+        el.style.cursor = 'default';
+        el.title = `Request ID ${c.id}\nStarted ${new Date(c.started_at).toLocaleString()}`;
+        el.innerHTML = `
+            <span class="failureIp">${c.ip}</span>
+            ${c.username ? '<span class="evtBadge ok"></span>' : '<span class="evtBadge anon">anon</span>'}
+            <span class="itemSub liveWhat" style="flex:1;overflow:hidden;text-overflow:ellipsis;white-space:nowrap"></span>
+            <span class="itemSub" style="flex-shrink:0">${fmtDuration(Date.now() - new Date(c.started_at))} · ↑${fmtBytes(c.bytes_in)} ↓${fmtBytes(c.bytes_out)}</span>
+            <button class="delBtn" title="Kill this connection">×</button>
+        `;
+        if (c.username) el.querySelector('.evtBadge.ok').textContent = c.username;
+        el.querySelector('.liveWhat').textContent = c.protocol === 'http'
+            ? `${c.method} ${c.route_url} ${c.path}`
+            : `${c.protocol.toUpperCase()} ${c.route_url}`;
+        el.querySelector('.delBtn').addEventListener('click', () => killConns('id=' + encodeURIComponent(c.id)));
+        list.appendChild(el);
+    });
+    if (!conns.length) {
+        list.innerHTML = '<p style="font-size:12px;color:#aaa;margin:10px 0 0 4px">No open connections.</p>';
+    }
+}
+
+async function killConns(query) {
+    await api('DELETE', 'admin/connections?' + query);
+    await loadLive();
+    applyMetricsFilters();
+}
+
+// killFilteredConns closes every connection of the IP and/or username typed
+// into the filters, matched exactly.
+async function killFilteredConns() {
+    const ip = document.getElementById('filterIP').value.trim();
+    const user = document.getElementById('filterUsername').value.trim();
+    if (!ip && !user) {
+        alert('Enter an IP or username filter first.');
+        return;
+    }
+    const who = [ip, user].filter(Boolean).join(' / ');
+    if (!confirm(`Close every open connection of ${who}?`)) return;
+    const q = new URLSearchParams();
+    if (ip) q.set('ip', ip);
+    if (user) q.set('user', user);
+    killConns(q.toString());
+}
+
+// fmtDuration renders milliseconds as the two largest units, e.g. "2h 5m".
+function fmtDuration(ms) {
+    const s = Math.floor(ms / 1000);
+    if (s < 60) return s + 's';
+    if (s < 3600) return `${Math.floor(s / 60)}m ${s % 60}s`;
+    if (s < 86400) return `${Math.floor(s / 3600)}h ${Math.floor(s % 3600 / 60)}m`;
+    return `${Math.floor(s / 86400)}d ${Math.floor(s % 86400 / 3600)}h`;
+}
+
 function relTime(dateStr) {
     const diff = Date.now() - new Date(dateStr).getTime();
     if (diff < 60000) return 'just now';
@@ -702,7 +1257,8 @@ async function loadMetrics() {
             <button class="delBtn" title="Revoke session">×</button>
         `;
         el.querySelector('.delBtn').addEventListener('click', async () => {
-            await api('DELETE', 'admin/metrics?id=' + s.id);
+            const kill = confirm(`Also close ${s.username}'s open connections?`);
+            await api('DELETE', 'admin/metrics?id=' + s.id + (kill ? '&kill=1' : ''));
             loadMetrics();
         });
         sessionList.appendChild(el);
@@ -714,6 +1270,8 @@ async function loadMetrics() {
     // Store raw data for filtering
     metricsAccessLogData = data.access_log || [];
     metricsFailureData   = data.auth_failures || [];
+    metricsFlowData      = data.flows || [];
+    metricsFlowTotals    = data.flow_totals || [];
 
     // Route stats — clicking sets the route filter input
     const routeList = document.getElementById('routeStatItems');
@@ -753,19 +1311,26 @@ async function loadMetrics() {
     }
 
     renderEvents(data.event_stats || {}, data.recent_events || []);
+    renderBreakers(data.breakers || []);
     renderBans(data.banned_ips || []);
+    loadCache();
+    await loadLive();
 
     applyMetricsFilters();
 }
 
 // outcomeClass maps an event outcome to one of the existing badge styles.
 function outcomeClass(o) {
-    if (o === 'served') return 'ok';
-    if (o === 'rate_limited' || o === 'not_found' || o === 'no_listener') return 'warn';
-    return 'denied'; // denied, banned, tls_error, tcp_rejected, dial_error
+    if (o === 'served' || o === 'redirected') return 'ok';
+    if (o === 'maintenance') return 'warn';
+    if (o === 'rate_limited' || o === 'not_found' || o === 'no_listener' || o === 'retry' ||
+        o === 'breaker_half_open') return 'warn';
+    if (o === 'breaker_closed') return 'ok';
+    return 'denied'; // denied, banned, tls_error, tcp_rejected, dial_error, circuit_open, breaker_open
 }
 
-const EVENT_ORDER = ['served', 'denied', 'rate_limited', 'banned', 'not_found', 'no_listener', 'tls_error', 'tcp_rejected', 'dial_error'];
+const EVENT_ORDER = ['served', 'redirected', 'maintenance', 'denied', 'rate_limited', 'banned', 'not_found', 'no_listener', 'tls_error', 'tcp_rejected', 'dial_error', 'retry',
+    'circuit_open', 'breaker_open', 'breaker_half_open', 'breaker_closed'];
 
 let metricsEventStats   = {};
 let metricsRecentEvents = [];
@@ -797,13 +1362,15 @@ function renderEvents(stats, recent) {
 }
 
 function renderRecentEvents() {
-    const recent = eventOutcomeFilter
-        ? metricsRecentEvents.filter(e => e.outcome === eventOutcomeFilter)
-        : metricsRecentEvents;
+    const { requestId } = getMetricsFilters();
+    const recent = metricsRecentEvents.filter(e =>
+        (!eventOutcomeFilter || e.outcome === eventOutcomeFilter) &&
+        (!requestId || (e.request_id || '').toLowerCase().includes(requestId)));
 
     document.getElementById('eventStatsSummary').textContent =
         eventOutcomeFilter
             ? `${recent.length} ${eventOutcomeFilter} — click badge to clear`
+            : requestId ? `${recent.length} / ${metricsRecentEvents.length}`
             : (metricsRecentEvents.length ? `${metricsRecentEvents.length} recent` : 'every connection — in-memory, since restart');
 
     const list = document.getElementById('recentEventItems');
@@ -812,8 +1379,9 @@ function renderRecentEvents() {
         const el = document.createElement('div');
         el.className = 'item';
         el.style.cursor = 'default';
+        if (e.request_id) el.title = `Request ID ${e.request_id}`;
         el.innerHTML = `
-            <span class="failureIp">${e.ip || '—'}</span>
+            <span class="failureIp">${e.ip || e.upstream || '—'}</span>
             <span class="evtBadge ${outcomeClass(e.outcome)}">${e.outcome}</span>
             <span class="itemSub" style="flex:1;overflow:hidden;text-overflow:ellipsis;white-space:nowrap" title="${e.route}">${e.route}</span>
             <span class="itemSub" style="flex-shrink:0">${relTime(e.time)}</span>
@@ -826,6 +1394,67 @@ function renderRecentEvents() {
     }
 }
 
+// renderBreakers shows one badge per backend with a circuit breaker configured.
+function renderBreakers(breakers) {
+    const row = document.getElementById('breakerItems');
+    row.innerHTML = '';
+    breakers
+        .sort((a, b) => (a.route + a.upstream).localeCompare(b.route + b.upstream))
+        .forEach(b => {
+            const el = document.createElement('span');
+            el.className = 'evtBadge ' + (b.state === 'closed' ? 'ok' : b.state === 'open' ? 'denied' : 'warn');
+            el.textContent = `${b.upstream}: ${b.state.replace('_', '-')}`;
+            el.title = 'Circuit breaker for ' + b.route;
+            row.appendChild(el);
+        });
+}
+
+// fmtBytes renders a byte count with a binary unit.
+function fmtBytes(n) {
+    const units = ['B', 'KiB', 'MiB', 'GiB'];
+    let i = 0;
+    while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+    return (i ? n.toFixed(1) : n) + ' ' + units[i];
+}
+
+// loadCache shows response-cache usage and per-route hit rates.
+async function loadCache() {
+    const data = await api('GET', 'admin/cache');
+    if (!data) return;
+    let summary = `${data.memory_entries} in memory (${fmtBytes(data.memory_bytes)})`;
+    if (data.disk_enabled) summary += `, ${data.disk_entries} on disk (${fmtBytes(data.disk_bytes)})`;
+    document.getElementById('cacheSummary').textContent = summary;
+
+    const list = document.getElementById('cacheItems');
+    list.innerHTML = '';
+    const routes = Object.entries(data.routes || {}).sort((a, b) => a[0].localeCompare(b[0]));
+    routes.forEach(([url, st]) => {
+        const lookups = st.hits + st.revalidated + st.misses;
+        const rate = lookups ? Math.round(100 * (st.hits + st.revalidated) / lookups) + '%' : '—';
+        const el = document.createElement('div');
+        el.className = 'item';
+        el.style.cursor = 'default';
+        el.innerHTML = `
+            <span style="flex:1;overflow:hidden;text-overflow:ellipsis;white-space:nowrap" title="${url}">${url}</span>
+            <span class="itemSub" title="hits / revalidated / misses / bypassed">${st.hits} / ${st.revalidated} / ${st.misses} / ${st.bypass}</span>
+            <span class="evtBadge ok" title="served from cache">${rate}</span>
+            <button class="delBtn" title="Purge this route">×</button>
+        `;
+        el.querySelector('.delBtn').addEventListener('click', () => purgeCache(url));
+        list.appendChild(el);
+    });
+    if (!routes.length) {
+        list.innerHTML = '<p style="font-size:12px;color:#aaa;margin:10px 0 0 4px">No cached routes yet.</p>';
+    }
+}
+
+async function purgeCache(route) {
+    const what = route ? `cached responses of ${route}` : 'every cached response';
+    if (!confirm(`Purge ${what}?`)) return;
+    await api('DELETE', 'admin/cache' + (route ? '?route=' + encodeURIComponent(route) : ''));
+    loadCache();
+}
+
 function renderBans(bans) {
     document.getElementById('banCount').textContent = bans.length ? String(bans.length) : '';
     const list = document.getElementById('banItems');
@@ -853,9 +1482,11 @@ async function manualBan() {
     const ip = document.getElementById('banIpInput').value.trim();
     if (!ip) return;
     const dur = parseInt(document.getElementById('banDurInput').value) || 0;
-    await api('POST', 'admin/throttle', { ip, duration_sec: dur });
+    const kill = document.getElementById('banKillInput').checked;
+    await api('POST', 'admin/throttle', { ip, duration_sec: dur, kill });
     document.getElementById('banIpInput').value = '';
     document.getElementById('banDurInput').value = '';
+    document.getElementById('banKillInput').checked = false;
     loadMetrics();
 }
 
@@ -1,3 +1,10 @@
+// Inline on* handlers are blocked by the Content-Security-Policy, so buttons
+// name their handler in data-click instead.
+document.addEventListener('click', e => {
+    const el = e.target.closest('[data-click]');
+    if (el) window[el.dataset.click]();
+});
+
 // ── state ─────────────────────────────────────────────────────────────────────
 let currentSessionId = null;
 